@@ -0,0 +1,159 @@
+package dbgen
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// address-index.bin is a compact replacement for address-mapping.bin that
+// clients download to turn an address into its database index (~5.4 bytes
+// per account instead of 24). Addresses are keyed by the first 8 bytes of
+// SHA-256(address) as a big-endian uint64 k; the top P bits of k pick a
+// bucket and the next F bits are the fingerprint.
+//
+//	[0:8]   Magic "PLKAIDX1"
+//	[8:16]  Account count (uint64 LE)
+//	[16]    P, bucket prefix bits
+//	[17]    F, fingerprint bits
+//	[18]    X, index bits
+//	[19]    Reserved (0)
+//	[20:24] Table entries M (uint32 LE)
+//	[24:28] Overflow entries O (uint32 LE)
+//	[28:32] Reserved (0)
+//	then 2^P+1 uint32 LE bucket starts (the last is M),
+//	then M entries of F+X bits, packed LSB-first: fingerprint | index<<F,
+//	     sorted by (bucket, fingerprint); ceil(M*(F+X)/8) bytes,
+//	then O × 24-byte (address, uint32 LE index) records sorted by address.
+//
+// Accounts whose bucket and fingerprint collide with another account go to
+// the overflow list, so every account in the database resolves to its own
+// index. An address that is not in the database matches some fingerprint
+// with probability about (accounts per bucket) / 2^F and then yields a wrong
+// index; the balance read for it is meaningless, not private data of
+// another user.
+
+const (
+	AddressIndexMagic      = "PLKAIDX1"
+	AddressIndexHeaderSize = 32
+	AddressIndexFPBits     = 16
+)
+
+// AddressIndexKey is the 64-bit hash an address is filed under
+func AddressIndexKey(addr common.Address) uint64 {
+	sum := sha256.Sum256(addr[:])
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// addressIndexParams picks P (about 8 accounts per bucket) and X for count
+func addressIndexParams(count int) (prefixBits, indexBits uint) {
+	if n := bits.Len(uint(count)); n > 4 {
+		prefixBits = uint(min(n-4, 28))
+	}
+	indexBits = 1
+	if count > 2 {
+		indexBits = uint(bits.Len(uint(count - 1)))
+	}
+	return prefixBits, indexBits
+}
+
+// WriteAddressIndex writes address-index.bin for accounts in database order
+func WriteAddressIndex(w io.Writer, accounts []AccountData) error {
+	if len(accounts) > 1<<32 {
+		return fmt.Errorf("%d accounts do not fit uint32 indices", len(accounts))
+	}
+	p, x := addressIndexParams(len(accounts))
+	const f = AddressIndexFPBits
+
+	type keyed struct {
+		key   uint64
+		index uint32
+	}
+	keys := make([]keyed, len(accounts))
+	for i, acc := range accounts {
+		keys[i] = keyed{AddressIndexKey(acc.Address), uint32(i)}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	// Split off every run sharing bucket and fingerprint
+	slot := func(k uint64) uint64 { return k >> (64 - p - f) }
+	table := make([]keyed, 0, len(keys))
+	var overflow []int
+	for start := 0; start < len(keys); {
+		end := start + 1
+		for end < len(keys) && slot(keys[end].key) == slot(keys[start].key) {
+			end++
+		}
+		if end-start == 1 {
+			table = append(table, keys[start])
+		} else {
+			for _, k := range keys[start:end] {
+				overflow = append(overflow, int(k.index))
+			}
+		}
+		start = end
+	}
+	sort.Slice(overflow, func(i, j int) bool {
+		a, b := accounts[overflow[i]].Address, accounts[overflow[j]].Address
+		return string(a[:]) < string(b[:])
+	})
+
+	bw := bufio.NewWriter(w)
+	var header [AddressIndexHeaderSize]byte
+	copy(header[0:8], AddressIndexMagic)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(accounts)))
+	header[16], header[17], header[18] = byte(p), f, byte(x)
+	binary.LittleEndian.PutUint32(header[20:24], uint32(len(table)))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(len(overflow)))
+	bw.Write(header[:])
+
+	// Bucket starts
+	var buf [8]byte
+	next := 0
+	for b := uint64(0); b <= 1<<p; b++ {
+		for next < len(table) && table[next].key>>(64-p) < b {
+			next++
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(next))
+		bw.Write(buf[:4])
+	}
+
+	// Packed entries; fewer than 8 bits are pending between entries, so a
+	// word of up to 48 bits always fits in acc
+	width := f + x
+	var acc uint64
+	var nbits uint
+	for _, k := range table {
+		fp := slot(k.key) & (1<<f - 1)
+		word := fp | uint64(k.index)<<f
+		acc |= word << nbits
+		nbits += width
+		for nbits >= 8 {
+			bw.WriteByte(byte(acc))
+			acc >>= 8
+			nbits -= 8
+		}
+	}
+	if nbits > 0 {
+		bw.WriteByte(byte(acc))
+	}
+
+	// Overflow records
+	for _, i := range overflow {
+		bw.Write(accounts[i].Address[:])
+		binary.LittleEndian.PutUint32(buf[:4], uint32(i))
+		bw.Write(buf[:4])
+	}
+	return bw.Flush()
+}
+
+// WriteAddressIndexFile writes address-index.bin to path
+func WriteAddressIndexFile(path string, accounts []AccountData) error {
+	return writeFile(path, accounts, WriteAddressIndex)
+}
@@ -0,0 +1,51 @@
+package dbgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAddressIndexLayout(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 1000, 1 << 15} {
+		accounts := make([]AccountData, n)
+		for i := range accounts {
+			accounts[i].Address = common.BigToAddress(big.NewInt(int64(i)))
+		}
+		var buf bytes.Buffer
+		if err := WriteAddressIndex(&buf, accounts); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		if string(data[:8]) != AddressIndexMagic || binary.LittleEndian.Uint64(data[8:]) != uint64(n) {
+			t.Fatalf("n=%d: bad header % x", n, data[:16])
+		}
+		p, f, x := uint64(data[16]), uint64(data[17]), uint64(data[18])
+		m := uint64(binary.LittleEndian.Uint32(data[20:]))
+		o := uint64(binary.LittleEndian.Uint32(data[24:]))
+		if f != AddressIndexFPBits || n > 0 && uint64(n-1)>>x != 0 || m+o != uint64(n) {
+			t.Fatalf("n=%d: P=%d F=%d X=%d M=%d O=%d", n, p, f, x, m, o)
+		}
+		want := AddressIndexHeaderSize + 4*(1<<p+1) + (m*(f+x)+7)/8 + o*AddressMappingEntrySize
+		if uint64(len(data)) != want {
+			t.Fatalf("n=%d: %d bytes, want %d", n, len(data), want)
+		}
+
+		// Bucket starts are non-decreasing and end at M
+		starts := data[AddressIndexHeaderSize:]
+		for b := uint64(1); b <= 1<<p; b++ {
+			if binary.LittleEndian.Uint32(starts[4*b:]) < binary.LittleEndian.Uint32(starts[4*(b-1):]) {
+				t.Fatalf("n=%d: bucket %d starts before bucket %d", n, b, b-1)
+			}
+		}
+		if got := binary.LittleEndian.Uint32(starts[4<<p:]); uint64(got) != m {
+			t.Fatalf("n=%d: last bucket start %d, want %d", n, got, m)
+		}
+		if n == 1<<15 && float64(len(data))/float64(n) > 6 {
+			t.Errorf("%.2f bytes per account", float64(len(data))/float64(n))
+		}
+	}
+}
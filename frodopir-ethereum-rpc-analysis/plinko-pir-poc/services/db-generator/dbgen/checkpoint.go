@@ -0,0 +1,226 @@
+package dbgen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Checkpoint files let an interrupted FetchBalances run resume. The file is
+// append-only:
+//
+//	[0:8]   Magic "PLKCKPT1"
+//	[8:16]  Pinned block (uint64 LE)
+//	[16:24] Address count (uint64 LE)
+//	[24:56] Keccak-256 of the address list
+//	then one record per completed batch:
+//	        [count uint32 LE] then count × ([index uint32 LE][balance 32 bytes BE])
+//
+// A torn record at the end (crash mid-write) is ignored and overwritten.
+
+const (
+	checkpointMagic      = "PLKCKPT1"
+	checkpointHeaderSize = 56
+	checkpointEntrySize  = 4 + 32
+)
+
+// ErrCheckpointMismatch is returned when a checkpoint belongs to a different
+// address list
+var ErrCheckpointMismatch = errors.New("checkpoint was written for a different address list")
+
+// Checkpoint records fetched balances as batches complete
+type Checkpoint struct {
+	mu       sync.Mutex
+	f        *os.File
+	block    uint64
+	digest   common.Hash
+	balances []*big.Int // nil = not fetched yet
+	done     int
+}
+
+// CreateCheckpoint starts a new checkpoint at path, replacing any old one
+func CreateCheckpoint(path string, addresses []common.Address, block uint64) (*Checkpoint, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{
+		f:        f,
+		block:    block,
+		digest:   addressDigest(addresses),
+		balances: make([]*big.Int, len(addresses)),
+	}
+
+	var header [checkpointHeaderSize]byte
+	copy(header[0:8], checkpointMagic)
+	binary.LittleEndian.PutUint64(header[8:16], block)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(len(addresses)))
+	copy(header[24:56], cp.digest[:])
+	if _, err := f.Write(header[:]); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// OpenCheckpoint loads the checkpoint at path for appending. It fails with
+// ErrCheckpointMismatch if the checkpoint was taken for other addresses.
+func OpenCheckpoint(path string, addresses []common.Address) (*Checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	cp, valid, err := readCheckpoint(f, addresses)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Drop a torn trailing record so new records follow complete ones
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	cp.f = f
+	return cp, nil
+}
+
+func readCheckpoint(r io.Reader, addresses []common.Address) (*Checkpoint, int64, error) {
+	br := bufio.NewReader(r)
+	var header [checkpointHeaderSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, 0, fmt.Errorf("read checkpoint header: %w", err)
+	}
+	if string(header[0:8]) != checkpointMagic {
+		return nil, 0, errors.New("not a checkpoint file")
+	}
+	cp := &Checkpoint{
+		block:    binary.LittleEndian.Uint64(header[8:16]),
+		balances: make([]*big.Int, len(addresses)),
+	}
+	copy(cp.digest[:], header[24:56])
+	if binary.LittleEndian.Uint64(header[16:24]) != uint64(len(addresses)) || cp.digest != addressDigest(addresses) {
+		return nil, 0, ErrCheckpointMismatch
+	}
+
+	valid := int64(checkpointHeaderSize)
+	var countBuf [4]byte
+	entry := make([]byte, checkpointEntrySize)
+	for {
+		if _, err := io.ReadFull(br, countBuf[:]); err != nil {
+			return cp, valid, nil // EOF or torn count
+		}
+		count := binary.LittleEndian.Uint32(countBuf[:])
+		record := make([]*big.Int, 0, count)
+		indices := make([]uint32, 0, count)
+		for i := uint32(0); i < count; i++ {
+			if _, err := io.ReadFull(br, entry); err != nil {
+				return cp, valid, nil // Torn record
+			}
+			idx := binary.LittleEndian.Uint32(entry[0:4])
+			if int(idx) >= len(addresses) {
+				return nil, 0, fmt.Errorf("checkpoint index %d out of range", idx)
+			}
+			indices = append(indices, idx)
+			record = append(record, new(big.Int).SetBytes(entry[4:]))
+		}
+		for i, idx := range indices {
+			if cp.balances[idx] == nil {
+				cp.done++
+			}
+			cp.balances[idx] = record[i]
+		}
+		valid += int64(4 + int(count)*checkpointEntrySize)
+	}
+}
+
+// Block is the block the checkpointed balances were read at
+func (cp *Checkpoint) Block() uint64 { return cp.block }
+
+// Done returns the number of accounts already fetched
+func (cp *Checkpoint) Done() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.done
+}
+
+// Balance returns the checkpointed balance of account i, or nil
+func (cp *Checkpoint) Balance(i int) *big.Int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.balances[i]
+}
+
+// Record appends the balances of accounts[i] for every i in indices
+func (cp *Checkpoint) Record(accounts []AccountData, indices []int) error {
+	if len(indices) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(4 + len(indices)*checkpointEntrySize)
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(indices)))
+	buf.Write(tmp[:])
+	var balance [32]byte
+	for _, i := range indices {
+		binary.LittleEndian.PutUint32(tmp[:], uint32(i))
+		buf.Write(tmp[:])
+		accounts[i].Balance.FillBytes(balance[:])
+		buf.Write(balance[:])
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if _, err := cp.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, i := range indices {
+		if cp.balances[i] == nil {
+			cp.done++
+		}
+		cp.balances[i] = accounts[i].Balance
+	}
+	return nil
+}
+
+// Close flushes and closes the checkpoint file
+func (cp *Checkpoint) Close() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if err := cp.f.Sync(); err != nil {
+		cp.f.Close()
+		return err
+	}
+	return cp.f.Close()
+}
+
+func (cp *Checkpoint) matches(addresses []common.Address, block uint64) bool {
+	return cp.block == block && len(cp.balances) == len(addresses) && cp.digest == addressDigest(addresses)
+}
+
+// addressDigest identifies an address list in checkpoint headers
+func addressDigest(addresses []common.Address) common.Hash {
+	h := crypto.NewKeccakState()
+	for i := range addresses {
+		h.Write(addresses[i][:])
+	}
+	var d common.Hash
+	h.Read(d[:])
+	return d
+}
@@ -0,0 +1,148 @@
+package dbgen
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// countingNode records which addresses were queried
+type countingNode struct {
+	flakyNode
+	queried map[common.Address]int
+}
+
+func (n *countingNode) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	n.mu.Lock()
+	for i := range b {
+		n.queried[b[i].Args[0].(common.Address)]++
+	}
+	n.mu.Unlock()
+	return n.flakyNode.BatchCallContext(ctx, b)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ckpt")
+	addrs := testAddresses(40)
+
+	// First run: address 30 never answers, so its balance is not recorded
+	cp, err := CreateCheckpoint(path, addrs, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testFetchConfig()
+	cfg.Checkpoint = cp
+	node := &flakyNode{broken: map[common.Address]bool{addrs[30]: true}, blockArgs: map[string]bool{}}
+	_, report, err := FetchBalances(context.Background(), node, addrs, 7, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) != 1 || cp.Done() != 39 {
+		t.Fatalf("%d failures, %d checkpointed; want 1 and 39", len(report.Failures), cp.Done())
+	}
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run queries only the missing account
+	if cp, err = OpenCheckpoint(path, addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if cp.Block() != 7 || cp.Done() != 39 {
+		t.Fatalf("reopened checkpoint at block %d with %d done, want 7 and 39", cp.Block(), cp.Done())
+	}
+	cfg.Checkpoint = cp
+	node2 := &countingNode{flakyNode: flakyNode{blockArgs: map[string]bool{}}, queried: map[common.Address]int{}}
+	node2.calls = 1 // Skip the failing first batch
+	accounts, report, err := FetchBalances(context.Background(), node2, addrs, 7, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(node2.queried) != 1 || node2.queried[addrs[30]] != 1 || report.Resumed != 39 || report.Fetched != 40 {
+		t.Fatalf("queried %v, resumed %d, fetched %d", node2.queried, report.Resumed, report.Fetched)
+	}
+	for i, acc := range accounts {
+		if acc.Balance.Int64() != int64(i) {
+			t.Fatalf("account %d balance %v", i, acc.Balance)
+		}
+	}
+
+	// The checkpoint is pinned to its block
+	if _, _, err := FetchBalances(context.Background(), node2, addrs, 8, cfg); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("fetch at another block: err = %v, want ErrCheckpointMismatch", err)
+	}
+}
+
+func TestCheckpointTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ckpt")
+	addrs := testAddresses(10)
+	accounts := make([]AccountData, len(addrs))
+	for i := range accounts {
+		accounts[i] = AccountData{Address: addrs[i], Balance: big.NewInt(int64(i) * 1e18)}
+	}
+
+	cp, err := CreateCheckpoint(path, addrs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Record(accounts, []int{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Record(accounts, []int{5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	// Simulate a crash halfway through the second record
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+	cp, err = OpenCheckpoint(path, addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Done() != 3 || cp.Balance(2).Cmp(accounts[2].Balance) != 0 || cp.Balance(5) != nil {
+		t.Fatalf("after torn record: %d done, balance(2) = %v, balance(5) = %v", cp.Done(), cp.Balance(2), cp.Balance(5))
+	}
+	// New records follow the last complete one
+	if err := cp.Record(accounts, []int{9}); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+	if cp, err = OpenCheckpoint(path, addrs); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if cp.Done() != 4 || cp.Balance(9).Cmp(accounts[9].Balance) != 0 {
+		t.Fatalf("after append: %d done, balance(9) = %v", cp.Done(), cp.Balance(9))
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ckpt")
+	cp, err := CreateCheckpoint(path, testAddresses(10), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	other := testAddresses(10)
+	other[4][0] = 1
+	if _, err := OpenCheckpoint(path, other); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("other addresses: err = %v, want ErrCheckpointMismatch", err)
+	}
+	if _, err := OpenCheckpoint(path, testAddresses(11)); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Fatalf("other count: err = %v, want ErrCheckpointMismatch", err)
+	}
+}
@@ -0,0 +1,216 @@
+package dbgen
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// BIP-39 seed derivation and BIP-32/BIP-44 key derivation, enough to
+// reproduce the accounts Anvil (and any standard wallet) funds from a
+// mnemonic: account i lives at <base path>/i, e.g. m/44'/60'/0'/0/i.
+
+const (
+	// DefaultDerivationPath is the BIP-44 Ethereum path accounts are
+	// numbered under (Anvil, MetaMask, geth's DefaultRootDerivationPath)
+	DefaultDerivationPath = "m/44'/60'/0'/0"
+
+	HardenedOffset = 0x80000000
+
+	seedIterations = 2048 // BIP-39 PBKDF2-HMAC-SHA512 rounds
+)
+
+var curveOrder = crypto.S256().Params().N
+
+// ErrInvalidChild is returned for the (probability < 2^-127) indices where
+// BIP-32 derivation yields an invalid key
+var ErrInvalidChild = errors.New("derived key is invalid for this index")
+
+// MnemonicToSeed turns a BIP-39 mnemonic and optional passphrase into the
+// 64-byte wallet seed. The word checksum is not verified (that needs the
+// wordlist); only ASCII mnemonics are accepted, since other languages need
+// NFKD normalization first.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("mnemonic has %d words, want 12, 15, 18, 21 or 24", len(words))
+	}
+	normalized := strings.Join(words, " ")
+	for _, s := range []string{normalized, passphrase} {
+		for i := 0; i < len(s); i++ {
+			if s[i] >= 0x80 {
+				return nil, errors.New("non-ASCII mnemonics and passphrases are not supported")
+			}
+		}
+	}
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), seedIterations, 64, sha512.New), nil
+}
+
+// HDKey is a BIP-32 extended private key
+type HDKey struct {
+	key       [32]byte
+	chainCode [32]byte
+	pub       []byte // Compressed public key, computed on first non-hardened child
+}
+
+// NewMasterKey derives the BIP-32 master key from a seed
+func NewMasterKey(seed []byte) (*HDKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Sign() == 0 || il.Cmp(curveOrder) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	k := &HDKey{}
+	copy(k.key[:], sum[:32])
+	copy(k.chainCode[:], sum[32:])
+	return k, nil
+}
+
+// Child derives child index; indices >= HardenedOffset are hardened
+func (k *HDKey) Child(index uint32) (*HDKey, error) {
+	data := make([]byte, 37)
+	if index >= HardenedOffset {
+		copy(data[1:33], k.key[:]) // 0x00 || ser256(k)
+	} else {
+		if k.pub == nil {
+			priv, err := crypto.ToECDSA(k.key[:])
+			if err != nil {
+				return nil, err
+			}
+			k.pub = crypto.CompressPubkey(&priv.PublicKey)
+		}
+		copy(data[:33], k.pub) // serP(K)
+	}
+	binary.BigEndian.PutUint32(data[33:], index)
+
+	mac := hmac.New(sha512.New, k.chainCode[:])
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(curveOrder) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	il.Add(il, new(big.Int).SetBytes(k.key[:]))
+	il.Mod(il, curveOrder)
+	if il.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := &HDKey{}
+	il.FillBytes(child.key[:])
+	copy(child.chainCode[:], sum[32:])
+	return child, nil
+}
+
+// Derive follows path (e.g. "m/44'/60'/0'/0") from k
+func (k *HDKey) Derive(path string) (*HDKey, error) {
+	dp, err := accounts.ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range dp {
+		if k, err = k.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Address returns the Ethereum address of the key
+func (k *HDKey) Address() (common.Address, error) {
+	priv, err := crypto.ToECDSA(k.key[:])
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(priv.PublicKey), nil
+}
+
+// DeriveAddresses returns the addresses of accounts 0..count-1 under
+// basePath, derived on workers goroutines. The base key is derived once;
+// each account then costs one HMAC and one scalar multiplication.
+func DeriveAddresses(mnemonic, passphrase, basePath string, count, workers int) ([]common.Address, error) {
+	if count < 0 || count > HardenedOffset {
+		return nil, fmt.Errorf("cannot derive %d non-hardened accounts", count)
+	}
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	base, err := master.Derive(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := base.Child(0); err != nil && !errors.Is(err, ErrInvalidChild) {
+		return nil, err // Computes base.pub before the workers share it
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	addresses := make([]common.Address, count)
+	var (
+		next     atomic.Int64
+		done     atomic.Int64
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	const batch = 4096
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(batch)) - batch
+				if start >= count {
+					return
+				}
+				end := min(start+batch, count)
+				for i := start; i < end; i++ {
+					child, err := base.Child(uint32(i))
+					if err == nil {
+						addresses[i], err = child.Address()
+					}
+					if err != nil {
+						errOnce.Do(func() { firstErr = fmt.Errorf("account %d: %w", i, err) })
+						return
+					}
+				}
+
+				// Progress reporting
+				before := int(done.Add(int64(end-start))) - (end - start)
+				if before/1000000 != (before+end-start)/1000000 {
+					log.Printf("  Derived %d/%d addresses...\n", before+end-start, count)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return addresses, nil
+}
@@ -0,0 +1,78 @@
+package dbgen
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const anvilMnemonic = "test test test test test test test test test test test junk"
+
+// Accounts 0-9 that `anvil` prints on startup for its default mnemonic
+var anvilAccounts = []string{
+	"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+	"0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+	"0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC",
+	"0x90F79bf6EB2c4f870365E785982E1f101E93b906",
+	"0x15d34AAf54267DB7D7c367839AAf71A00a2C6A65",
+	"0x9965507D1a55bcC2695C58ba16FB37d819B0A4dc",
+	"0x976EA74026E726554dB657fA54763abd0C3a0aa9",
+	"0x14dC79964da2C08b23698B3D3cc7Ca32193d9955",
+	"0x23618e81E3f5cdF7f54C3d65f7FBc0aBf5B21E8f",
+	"0xa0Ee7A142d267C1f36714E4a8F75612F20a79720",
+}
+
+func TestDeriveAnvilAccounts(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		got, err := DeriveAddresses(anvilMnemonic, "", DefaultDerivationPath, len(anvilAccounts), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range anvilAccounts {
+			if got[i] != common.HexToAddress(want) {
+				t.Errorf("workers=%d: account %d = %s, want %s", workers, i, got[i].Hex(), want)
+			}
+		}
+	}
+}
+
+func TestDeriveAnvilPrivateKey(t *testing.T) {
+	seed, err := MnemonicToSeed("  test test test test test test\ttest test test test test junk\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := master.Derive(DefaultDerivationPath + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+	if got := hex.EncodeToString(k.key[:]); got != want {
+		t.Fatalf("account 0 private key = %s, want %s", got, want)
+	}
+}
+
+func TestDerivePassphraseChangesAccounts(t *testing.T) {
+	got, err := DeriveAddresses(anvilMnemonic, "TREZOR", DefaultDerivationPath, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] == common.HexToAddress(anvilAccounts[0]) {
+		t.Fatal("passphrase was ignored")
+	}
+}
+
+func TestMnemonicToSeedRejectsBadInput(t *testing.T) {
+	for _, m := range []string{
+		"test test test",
+		"tést test test test test test test test test test test junk",
+	} {
+		if _, err := MnemonicToSeed(m, ""); err == nil {
+			t.Errorf("MnemonicToSeed(%q) succeeded", m)
+		}
+	}
+}
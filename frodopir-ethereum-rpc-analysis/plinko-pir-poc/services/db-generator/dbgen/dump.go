@@ -0,0 +1,386 @@
+package dbgen
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// State dump import. Accepted inputs, detected from their content:
+//
+//   - geth `dump` / debug_dumpBlock: {"root": ..., "accounts": {"0x<addr>": {"balance": "<dec>", ...}}}
+//     (also wrapped in a JSON-RPC response under "result")
+//   - geth `dump --iterative` and JSONL: one object per line, either
+//     {"root": ...} / {"block": N} metadata or {"address": ..., "balance": ...}
+//   - Anvil --dump-state / anvil_dumpState: {"block": {"number": "0x.."}, "accounts": {...}},
+//     plain, gzipped, or as the hex string anvil_dumpState returns
+//   - CSV: address,balance per line; a header row and '#' comments are skipped
+//
+// Balances may be decimal or 0x-prefixed hex.
+
+// ErrNoSourceBlock is returned when the dump does not say which block it was
+// taken at and the caller did not supply one
+var ErrNoSourceBlock = errors.New("state dump does not record its block number")
+
+// DumpInfo describes an imported state dump
+type DumpInfo struct {
+	Format    string      // "json", "jsonl" or "csv", prefixed "gzip+" / "hex+" when wrapped
+	Block     uint64      // Block the state was taken at
+	HasBlock  bool        // Block came from the dump itself
+	StateRoot common.Hash // Zero when the dump has none
+	Accounts  int         // Distinct accounts imported
+	Skipped   int         // Entries without a usable address (e.g. geth accounts missing preimages)
+}
+
+// ImportStateDump reads every account of a state dump and returns them in
+// database order. block overrides (or supplies) the source block; pass nil to
+// require the dump to carry one. Later entries for the same address win.
+func ImportStateDump(r io.Reader, block *uint64) ([]AccountData, DumpInfo, error) {
+	var info DumpInfo
+	var accounts []AccountData
+	err := readDump(bufio.NewReaderSize(r, 1<<20), &info, func(addr common.Address, balance *big.Int) {
+		accounts = append(accounts, AccountData{Address: addr, Balance: balance})
+	})
+	if err != nil {
+		return nil, info, err
+	}
+
+	if block != nil {
+		info.Block = *block
+	} else if !info.HasBlock {
+		return nil, info, ErrNoSourceBlock
+	}
+
+	// Stable sort keeps file order among duplicates; keep the last of each run
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].Address.Hex() < accounts[j].Address.Hex()
+	})
+	out := accounts[:0]
+	for i, acc := range accounts {
+		if i+1 < len(accounts) && accounts[i+1].Address == acc.Address {
+			continue
+		}
+		out = append(out, acc)
+	}
+	info.Accounts = len(out)
+	return out, info, nil
+}
+
+func readDump(br *bufio.Reader, info *DumpInfo, emit func(common.Address, *big.Int)) error {
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return fmt.Errorf("empty state dump: %w", err)
+	}
+
+	switch {
+	case first[0] == 0x1f && len(first) > 1 && first[1] == 0x8b:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		info.Format += "gzip+"
+		return readDump(bufio.NewReaderSize(zr, 1<<20), info, emit)
+
+	case bytes.HasPrefix(first, []byte("0x")) && isHexDump(first), bytes.HasPrefix(first, []byte(`"0x`)):
+		// anvil_dumpState result: hex of a gzipped JSON state
+		text, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		text = bytes.Trim(bytes.TrimSpace(text), `"`)
+		raw, err := hex.DecodeString(strings.TrimPrefix(string(text), "0x"))
+		if err != nil {
+			return fmt.Errorf("hex state dump: %w", err)
+		}
+		info.Format += "hex+"
+		return readDump(bufio.NewReader(bytes.NewReader(raw)), info, emit)
+
+	case first[0] == '{':
+		return readJSONDump(br, info, emit)
+
+	default:
+		info.Format += "csv"
+		return readCSVDump(br, emit)
+	}
+}
+
+// peekNonSpace skips leading whitespace and returns the next bytes unread
+func peekNonSpace(br *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	b, err := br.Peek(64)
+	if len(b) > 0 {
+		err = nil
+	}
+	return b, err
+}
+
+// isHexDump tells a hex-encoded dump from a CSV line starting with an address
+func isHexDump(b []byte) bool {
+	for _, c := range b[2:] {
+		if c == ',' || c == '\n' || c == ';' || c == '\t' {
+			return false
+		}
+	}
+	return len(b) >= 64 // Addresses are 42 characters, then a separator
+}
+
+func readJSONDump(br *bufio.Reader, info *DumpInfo, emit func(common.Address, *big.Int)) error {
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	values := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("state dump value %d: %w", values+1, err)
+		}
+		if tok != json.Delim('{') {
+			return fmt.Errorf("state dump value %d is %v, want an object", values+1, tok)
+		}
+		if err := readDumpObject(dec, info, emit); err != nil {
+			return fmt.Errorf("state dump value %d: %w", values+1, err)
+		}
+		values++
+	}
+	if values > 1 {
+		info.Format += "jsonl"
+	} else {
+		info.Format += "json"
+	}
+	return nil
+}
+
+// readDumpObject walks one top-level object whose '{' has been consumed
+func readDumpObject(dec *json.Decoder, info *DumpInfo, emit func(common.Address, *big.Int)) error {
+	var address, balance json.RawMessage
+	var root *common.Hash // State root, or an account's storage root
+	isAccount := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "accounts":
+			if err := readAccounts(dec, info, emit); err != nil {
+				return fmt.Errorf("accounts: %w", err)
+			}
+		case "result": // Saved JSON-RPC response
+			if tok, err := dec.Token(); err != nil {
+				return err
+			} else if tok != json.Delim('{') {
+				return fmt.Errorf("result is %v, want an object", tok)
+			}
+			if err := readDumpObject(dec, info, emit); err != nil {
+				return err
+			}
+		case "root", "stateRoot":
+			if err := dec.Decode(&root); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		case "block", "blockNumber", "best_block_number":
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			n, ok, err := parseDumpBlock(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if ok && (!info.HasBlock || key != "best_block_number") {
+				info.Block, info.HasBlock = n, true
+			}
+		case "address":
+			if err := dec.Decode(&address); err != nil {
+				return err
+			}
+		case "balance":
+			isAccount = true
+			if err := dec.Decode(&balance); err != nil {
+				return err
+			}
+		default:
+			isAccount = isAccount || key == "key"
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := dec.Token(); err != nil { // '}'
+		return err
+	}
+
+	if address == nil && isAccount {
+		info.Skipped++ // geth --iterative account without a preimage
+		return nil
+	}
+	if address == nil {
+		// Metadata (geth dump root, geth --iterative first line, {"block": N})
+		if root != nil {
+			info.StateRoot = *root
+		}
+		return nil
+	}
+	var addr string
+	if err := json.Unmarshal(address, &addr); err != nil || !common.IsHexAddress(addr) {
+		info.Skipped++
+		return nil
+	}
+	bal, err := parseDumpBalance(balance)
+	if err != nil {
+		return fmt.Errorf("account %s: %w", addr, err)
+	}
+	emit(common.HexToAddress(addr), bal)
+	return nil
+}
+
+// readAccounts reads an {"0x<addr>": {"balance": ...}, ...} object
+func readAccounts(dec *json.Decoder, info *DumpInfo, emit func(common.Address, *big.Int)) error {
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("got %v, want an object", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var acc struct {
+			Address string          `json:"address"`
+			Balance json.RawMessage `json:"balance"`
+		}
+		if err := dec.Decode(&acc); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		// geth keys accounts without a known preimage by hash ("pre(0x..)")
+		addr := key
+		if !common.IsHexAddress(addr) {
+			addr = acc.Address
+		}
+		if !common.IsHexAddress(addr) {
+			info.Skipped++
+			continue
+		}
+		bal, err := parseDumpBalance(acc.Balance)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		emit(common.HexToAddress(addr), bal)
+	}
+	_, err := dec.Token() // '}'
+	return err
+}
+
+func readCSVDump(r io.Reader, emit func(common.Address, *big.Int)) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < 2 {
+			return fmt.Errorf("csv line %d: want address,balance", line)
+		}
+		addr := strings.TrimSpace(rec[0])
+		if !common.IsHexAddress(addr) {
+			if line == 1 {
+				continue // Header
+			}
+			return fmt.Errorf("csv line %d: invalid address %q", line, addr)
+		}
+		bal, err := parseBalance(strings.TrimSpace(rec[1]))
+		if err != nil {
+			return fmt.Errorf("csv line %d: %w", line, err)
+		}
+		emit(common.HexToAddress(addr), bal)
+	}
+}
+
+func parseDumpBalance(raw json.RawMessage) (*big.Int, error) {
+	if raw == nil {
+		return new(big.Int), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		s = string(raw) // Bare JSON number
+	}
+	return parseBalance(s)
+}
+
+// parseBalance accepts decimal or 0x-prefixed hex
+func parseBalance(s string) (*big.Int, error) {
+	v, ok := new(big.Int), false
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		if s == "0x" || s == "0X" {
+			return v, nil
+		}
+		_, ok = v.SetString(s[2:], 16)
+	} else {
+		_, ok = v.SetString(s, 10)
+	}
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid balance %q", s)
+	}
+	return v, nil
+}
+
+// parseDumpBlock reads a block number, or the "number" of a block object
+func parseDumpBlock(raw json.RawMessage) (uint64, bool, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, false, nil
+	}
+	if raw[0] == '{' {
+		var b struct {
+			Number json.RawMessage `json:"number"`
+		}
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return 0, false, err
+		}
+		return parseDumpBlock(b.Number)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		s = string(raw)
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid block number %q", s)
+	}
+	return n, true, nil
+}
@@ -0,0 +1,156 @@
+package dbgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	dumpAddrA = common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266")
+	dumpAddrB = common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8")
+)
+
+const gethDump = `{
+  "root": "0x1111111111111111111111111111111111111111111111111111111111111111",
+  "accounts": {
+    "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266": {
+      "balance": "10000000000000000000", "nonce": 0,
+      "root": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
+      "codeHash": "0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+      "storage": {"0x00": "0x01"}
+    },
+    "0x70997970C51812dc3A010C7d01b50e0d17dc79C8": {"balance": "5"},
+    "pre(0x2222222222222222222222222222222222222222222222222222222222222222)": {"balance": "7"}
+  }
+}`
+
+const gethIterative = `{"root": "0x1111111111111111111111111111111111111111111111111111111111111111"}
+{"balance": "10000000000000000000", "nonce": 0, "root": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421", "address": "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "key": "0x00"}
+{"balance": "7", "root": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421", "key": "0x01"}
+{"balance": "5", "address": "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", "key": "0x02"}
+`
+
+const anvilState = `{
+  "block": {"number": "0x2a", "coinbase": "0x0000000000000000000000000000000000000000"},
+  "accounts": {
+    "0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266": {"nonce": 0, "balance": "0x8ac7230489e80000", "code": "0x", "storage": {}},
+    "0x70997970c51812dc3a010c7d01b50e0d17dc79c8": {"nonce": 1, "balance": "0x5", "code": "0x", "storage": {}}
+  },
+  "best_block_number": "0x2b",
+  "blocks": [{"header": {"number": "0x2a"}}]
+}`
+
+func checkDump(t *testing.T, accounts []AccountData, info DumpInfo, wantBlock uint64) {
+	t.Helper()
+	if len(accounts) != 2 || info.Accounts != 2 {
+		t.Fatalf("imported %d accounts (info %d), want 2", len(accounts), info.Accounts)
+	}
+	// Database order: 0x7099... < 0xf39F...
+	if accounts[0].Address != dumpAddrB || accounts[0].Balance.Uint64() != 5 {
+		t.Errorf("accounts[0] = %s %s", accounts[0].Address.Hex(), accounts[0].Balance)
+	}
+	if accounts[1].Address != dumpAddrA || accounts[1].Balance.String() != "10000000000000000000" {
+		t.Errorf("accounts[1] = %s %s", accounts[1].Address.Hex(), accounts[1].Balance)
+	}
+	if info.Block != wantBlock {
+		t.Errorf("block = %d, want %d", info.Block, wantBlock)
+	}
+}
+
+func TestImportGethDump(t *testing.T) {
+	for name, dump := range map[string]string{
+		"dump":      gethDump,
+		"rpc":       `{"jsonrpc": "2.0", "id": 1, "result": ` + gethDump + `}`,
+		"iterative": gethIterative,
+	} {
+		t.Run(name, func(t *testing.T) {
+			block := uint64(100)
+			accounts, info, err := ImportStateDump(strings.NewReader(dump), &block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDump(t, accounts, info, 100)
+			if info.Skipped != 1 {
+				t.Errorf("skipped %d entries, want the one without a preimage", info.Skipped)
+			}
+			if info.StateRoot != common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111") {
+				t.Errorf("state root %s", info.StateRoot.Hex())
+			}
+		})
+	}
+}
+
+func TestImportAnvilState(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(anvilState))
+	zw.Close()
+
+	for name, dump := range map[string]string{
+		"json":       anvilState,
+		"gzip":       gz.String(),
+		"dumpState":  `"0x` + hex.EncodeToString(gz.Bytes()) + `"`,
+		"castOutput": "0x" + hex.EncodeToString(gz.Bytes()) + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			accounts, info, err := ImportStateDump(strings.NewReader(dump), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDump(t, accounts, info, 0x2a)
+			if !strings.HasSuffix(info.Format, "json") || (name == "dumpState" && info.Format != "hex+gzip+json") {
+				t.Errorf("format %q", info.Format)
+			}
+			if !info.HasBlock {
+				t.Error("block not taken from the dump")
+			}
+		})
+	}
+}
+
+func TestImportCSVAndJSONL(t *testing.T) {
+	for name, dump := range map[string]string{
+		"csv": "# exported at block 9\naddress,balance\n" +
+			"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266,1\n" +
+			"0x70997970C51812dc3A010C7d01b50e0d17dc79C8, 0x5\n" +
+			"0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266,10000000000000000000\n",
+		"jsonl": `{"block": 9}` + "\n" +
+			`{"address": "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "balance": "1"}` + "\n" +
+			`{"address": "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", "balance": 5}` + "\n" +
+			`{"address": "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "balance": "0x8ac7230489e80000"}` + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			block := uint64(9)
+			accounts, info, err := ImportStateDump(strings.NewReader(dump), &block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Duplicates resolve to the last entry
+			checkDump(t, accounts, info, 9)
+			if info.Format != name {
+				t.Errorf("format %q, want %q", info.Format, name)
+			}
+		})
+	}
+}
+
+func TestImportRequiresSourceBlock(t *testing.T) {
+	_, _, err := ImportStateDump(strings.NewReader(gethDump), nil)
+	if !errors.Is(err, ErrNoSourceBlock) {
+		t.Fatalf("err = %v, want ErrNoSourceBlock", err)
+	}
+}
+
+func TestImportRejectsBadBalance(t *testing.T) {
+	dump := "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266,-1\n"
+	block := uint64(1)
+	if _, _, err := ImportStateDump(strings.NewReader(dump), &block); err == nil {
+		t.Fatal("negative balance accepted")
+	}
+}
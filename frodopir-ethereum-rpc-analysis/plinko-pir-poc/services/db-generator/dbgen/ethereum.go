@@ -0,0 +1,294 @@
+package dbgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// HeadReader is the ethclient.Client call used to wait for the node and pick
+// the block to pin queries to. Tests substitute an in-memory chain.
+type HeadReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// BatchCaller sends JSON-RPC batches; *rpc.Client implements it
+type BatchCaller interface {
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+// WaitForNode polls client until it answers eth_blockNumber, trying
+// attempts times with interval in between
+func WaitForNode(ctx context.Context, client HeadReader, attempts int, interval time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if _, err = client.BlockNumber(ctx); err == nil {
+			return nil
+		}
+		log.Printf("Waiting for Anvil to be ready... (%d/%d)\n", i+1, attempts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return fmt.Errorf("node not ready after %d attempts: %w", attempts, err)
+}
+
+// FetchConfig controls batching and retries for FetchBalances
+type FetchConfig struct {
+	BatchSize      int           // eth_getBalance calls per batch request
+	Concurrency    int           // Batch requests in flight
+	MaxAttempts    int           // Tries per address before it is reported as failed
+	InitialBackoff time.Duration // Wait before the first retry; doubles per attempt
+	MaxBackoff     time.Duration
+	ProgressEvery  int // Log every this many accounts (0 = never)
+
+	// Checkpoint, if set, supplies balances fetched by an earlier run and
+	// records every completed batch
+	Checkpoint *Checkpoint
+}
+
+// DefaultFetchConfig is sized for a local Anvil node
+func DefaultFetchConfig() FetchConfig {
+	return FetchConfig{
+		BatchSize:      500,
+		Concurrency:    16,
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		ProgressEvery:  100000,
+	}
+}
+
+// FetchFailure is an address whose balance could not be fetched
+type FetchFailure struct {
+	Index    int            `json:"index"` // Position in the requested address list
+	Address  common.Address `json:"address"`
+	Attempts int            `json:"attempts"`
+	Error    string         `json:"error"` // Last error seen
+}
+
+// FetchReport summarizes a FetchBalances run
+type FetchReport struct {
+	Block     uint64         `json:"block"`
+	Requested int            `json:"requested"`
+	Fetched   int            `json:"fetched"`
+	Resumed   int            `json:"resumed"` // Taken from the checkpoint, included in Fetched
+	Batches   int64          `json:"batches"` // Batch requests sent, including retries
+	Retries   int64          `json:"retries"` // Address lookups retried
+	Failures  []FetchFailure `json:"failures"`
+}
+
+// ErrFetchIncomplete is returned (wrapped) when some balances are missing
+var ErrFetchIncomplete = errors.New("balances missing")
+
+// Err reports whether any address failed
+func (r *FetchReport) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	f := r.Failures[0]
+	return fmt.Errorf("%w: %d of %d accounts at block %d (first: %s after %d attempts: %s)",
+		ErrFetchIncomplete, len(r.Failures), r.Requested, r.Block, f.Address.Hex(), f.Attempts, f.Error)
+}
+
+// WriteFile writes the report as indented JSON
+func (r *FetchReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// FetchBalances reads the balance of every address at block with batched
+// eth_getBalance requests. Failed lookups, whether the whole batch or single
+// entries, are retried with exponential backoff up to cfg.MaxAttempts. The
+// returned accounts are in address-list order; those that failed have a nil
+// Balance and are listed in the report, whose Err is then non-nil. The error
+// return is for ctx cancellation and checkpoint failures.
+func FetchBalances(ctx context.Context, client BatchCaller, addresses []common.Address, block uint64, cfg FetchConfig) ([]AccountData, *FetchReport, error) {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	cp := cfg.Checkpoint
+	if cp != nil && !cp.matches(addresses, block) {
+		return nil, nil, fmt.Errorf("checkpoint for block %d: %w", cp.Block(), ErrCheckpointMismatch)
+	}
+
+	report := &FetchReport{Block: block, Requested: len(addresses)}
+	accounts := make([]AccountData, len(addresses))
+	var pending []int
+	for i, addr := range addresses {
+		accounts[i].Address = addr
+		if cp != nil {
+			accounts[i].Balance = cp.Balance(i)
+		}
+		if accounts[i].Balance == nil {
+			pending = append(pending, i)
+		}
+	}
+	report.Resumed = len(addresses) - len(pending)
+	blockArg := hexutil.EncodeUint64(block)
+
+	var (
+		mu        sync.Mutex
+		processed = report.Resumed
+		cpErr     error
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan []int)
+
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				failed := fetchBatch(ctx, client, accounts, append([]int(nil), batch...), blockArg, cfg, report)
+
+				var err error
+				if cp != nil && ctx.Err() == nil {
+					err = cp.Record(accounts, succeeded(batch, failed))
+				}
+
+				mu.Lock()
+				if err != nil && cpErr == nil {
+					cpErr = fmt.Errorf("checkpoint: %w", err)
+				}
+				report.Failures = append(report.Failures, failed...)
+				before := processed
+				processed += len(batch)
+				if cfg.ProgressEvery > 0 && before/cfg.ProgressEvery != processed/cfg.ProgressEvery {
+					log.Printf("  Processed %d/%d accounts (%.1f%%)\n",
+						processed, len(addresses), float64(processed)/float64(len(addresses))*100)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for start := 0; start < len(pending) && ctx.Err() == nil; start += cfg.BatchSize {
+		batch := pending[start:min(start+cfg.BatchSize, len(pending))]
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, report, err
+	}
+	if cpErr != nil {
+		return nil, report, cpErr
+	}
+	report.Fetched = len(addresses) - len(report.Failures)
+	sort.Slice(report.Failures, func(i, j int) bool {
+		return report.Failures[i].Index < report.Failures[j].Index
+	})
+	return accounts, report, nil
+}
+
+// succeeded returns the indices of batch that are not in failed
+func succeeded(batch []int, failed []FetchFailure) []int {
+	if len(failed) == 0 {
+		return batch
+	}
+	bad := make(map[int]bool, len(failed))
+	for _, f := range failed {
+		bad[f.Index] = true
+	}
+	ok := make([]int, 0, len(batch)-len(failed))
+	for _, i := range batch {
+		if !bad[i] {
+			ok = append(ok, i)
+		}
+	}
+	return ok
+}
+
+// fetchBatch fetches accounts[i] for every i in pending, retrying failures,
+// and returns the ones that never succeeded
+func fetchBatch(ctx context.Context, client BatchCaller, accounts []AccountData, pending []int, blockArg string, cfg FetchConfig, report *FetchReport) []FetchFailure {
+	lastErr := make(map[int]error)
+	backoff := cfg.InitialBackoff
+
+	for attempt := 1; attempt <= cfg.MaxAttempts && len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			atomic.AddInt64(&report.Retries, int64(len(pending)))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, cfg.MaxBackoff)
+		}
+
+		results := make([]*hexutil.Big, len(pending))
+		elems := make([]rpc.BatchElem, len(pending))
+		for j, i := range pending {
+			elems[j] = rpc.BatchElem{
+				Method: "eth_getBalance",
+				Args:   []interface{}{accounts[i].Address, blockArg},
+				Result: &results[j],
+			}
+		}
+		atomic.AddInt64(&report.Batches, 1)
+		if err := client.BatchCallContext(ctx, elems); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			for _, i := range pending {
+				lastErr[i] = err
+			}
+			continue // Whole batch again
+		}
+
+		retry := pending[:0]
+		for j, i := range pending {
+			switch {
+			case elems[j].Error != nil:
+				lastErr[i] = elems[j].Error
+			case results[j] == nil:
+				lastErr[i] = errors.New("null balance")
+			default:
+				accounts[i].Balance = (*big.Int)(results[j])
+				continue
+			}
+			retry = append(retry, i)
+		}
+		pending = retry
+	}
+
+	failed := make([]FetchFailure, len(pending))
+	for j, i := range pending {
+		failed[j] = FetchFailure{
+			Index:    i,
+			Address:  accounts[i].Address,
+			Attempts: cfg.MaxAttempts,
+			Error:    lastErr[i].Error(),
+		}
+	}
+	return failed
+}
@@ -0,0 +1,130 @@
+package dbgen
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// flakyNode answers eth_getBalance with the address's last byte as balance.
+// The first batch fails outright, some addresses fail a number of times,
+// and broken addresses always fail.
+type flakyNode struct {
+	mu        sync.Mutex
+	calls     int
+	flaky     map[common.Address]int // Remaining failures
+	broken    map[common.Address]bool
+	blockArgs map[string]bool
+}
+
+func (n *flakyNode) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls++
+	if n.calls == 1 {
+		return errors.New("connection reset")
+	}
+	for i := range b {
+		addr := b[i].Args[0].(common.Address)
+		n.blockArgs[b[i].Args[1].(string)] = true
+		switch {
+		case n.broken[addr]:
+			b[i].Error = errors.New("header not found")
+		case n.flaky[addr] > 0:
+			n.flaky[addr]--
+			b[i].Error = errors.New("rate limited")
+		default:
+			*(b[i].Result.(**hexutil.Big)) = (*hexutil.Big)(big.NewInt(int64(addr[19])))
+		}
+	}
+	return nil
+}
+
+func testAddresses(n int) []common.Address {
+	addrs := make([]common.Address, n)
+	for i := range addrs {
+		addrs[i][19] = byte(i)
+	}
+	return addrs
+}
+
+func testFetchConfig() FetchConfig {
+	cfg := DefaultFetchConfig()
+	cfg.BatchSize = 7
+	cfg.Concurrency = 3
+	cfg.MaxAttempts = 4
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = 2 * time.Millisecond
+	cfg.ProgressEvery = 0
+	return cfg
+}
+
+func TestFetchBalancesRetries(t *testing.T) {
+	addrs := testAddresses(50)
+	node := &flakyNode{
+		flaky:     map[common.Address]int{addrs[3]: 2, addrs[40]: 3},
+		blockArgs: map[string]bool{},
+	}
+
+	accounts, report, err := FetchBalances(context.Background(), node, addrs, 1234, testFetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	for i, acc := range accounts {
+		if acc.Address != addrs[i] || acc.Balance == nil || acc.Balance.Int64() != int64(i) {
+			t.Fatalf("account %d = %s %v", i, acc.Address.Hex(), acc.Balance)
+		}
+	}
+	if report.Fetched != 50 || report.Retries == 0 {
+		t.Errorf("report %+v", report)
+	}
+	if len(node.blockArgs) != 1 || !node.blockArgs["0x4d2"] {
+		t.Errorf("queries used blocks %v, want only 0x4d2", node.blockArgs)
+	}
+}
+
+func TestFetchBalancesReportsFailures(t *testing.T) {
+	addrs := testAddresses(20)
+	node := &flakyNode{
+		flaky:     map[common.Address]int{addrs[1]: 100}, // More failures than attempts
+		broken:    map[common.Address]bool{addrs[15]: true},
+		blockArgs: map[string]bool{},
+	}
+
+	accounts, report, err := FetchBalances(context.Background(), node, addrs, 1, testFetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(report.Err(), ErrFetchIncomplete) {
+		t.Fatalf("report.Err() = %v, want ErrFetchIncomplete", report.Err())
+	}
+	if report.Fetched != 18 || len(report.Failures) != 2 {
+		t.Fatalf("fetched %d with %d failures, want 18 and 2", report.Fetched, len(report.Failures))
+	}
+	f := report.Failures
+	if f[0].Index != 1 || f[0].Error != "rate limited" || f[1].Index != 15 || f[1].Error != "header not found" || f[1].Attempts != 4 {
+		t.Errorf("failures %+v", f)
+	}
+	if accounts[1].Balance != nil || accounts[2].Balance.Int64() != 2 {
+		t.Errorf("accounts[1] = %v, accounts[2] = %v", accounts[1].Balance, accounts[2].Balance)
+	}
+}
+
+func TestFetchBalancesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	node := &flakyNode{blockArgs: map[string]bool{}}
+	if _, _, err := FetchBalances(ctx, node, testAddresses(10), 1, testFetchConfig()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
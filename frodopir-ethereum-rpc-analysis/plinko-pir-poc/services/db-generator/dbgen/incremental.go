@@ -0,0 +1,276 @@
+package dbgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Incremental refresh: instead of re-reading every balance, collect the
+// accounts blocks (from, to] touched, fetch only those at block to, and
+// rewrite the database.bin entries that changed.
+//
+// Touched accounts come from block bodies: transaction senders (gas) and
+// recipients, the fee recipient, and withdrawal addresses. Value moved by
+// internal calls or SELFDESTRUCT is not visible there; a full run (or a
+// state dump) picks those up.
+
+// BlockReader is the ethclient.Client call used to read block bodies
+type BlockReader interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+// Update is one changed database entry
+type Update struct {
+	Index    uint64
+	Address  common.Address
+	OldValue uint64
+	NewValue uint64
+}
+
+// UpdateSet is the result of an incremental refresh. Written to disk it is
+//
+//	[0:8]   Update count (uint64 LE)
+//	[8:16]  From block (uint64 LE, exclusive)
+//	[16:24] To block (uint64 LE, inclusive)
+//	[24:32] Format version (1)
+//	then per update: [Index][OldValue][NewValue] (uint64 LE each)
+//
+// matching plinko-update-service's DBUpdate.
+type UpdateSet struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Updates   []Update
+}
+
+const (
+	UpdateSetHeaderSize    = 32
+	UpdateSetEntrySize     = 24
+	UpdateSetFormatVersion = 1
+)
+
+// RefreshReport summarizes an incremental refresh
+type RefreshReport struct {
+	Blocks  int              // Block bodies scanned
+	Touched int              // Distinct accounts touched
+	Unknown []common.Address // Touched accounts not in the database (new accounts)
+	Fetch   *FetchReport
+}
+
+// TouchedAccounts returns the distinct accounts blocks (from, to] touched,
+// sorted in database order
+func TouchedAccounts(ctx context.Context, client BlockReader, from, to uint64) ([]common.Address, error) {
+	seen := make(map[common.Address]bool)
+	for n := from + 1; n <= to; n++ {
+		block, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(n))
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", n, err)
+		}
+		seen[block.Coinbase()] = true
+		for _, tx := range block.Transactions() {
+			sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+			if err != nil {
+				return nil, fmt.Errorf("block %d tx %s: %w", n, tx.Hash().Hex(), err)
+			}
+			seen[sender] = true
+			if to := tx.To(); to != nil {
+				seen[*to] = true
+			}
+		}
+		for _, w := range block.Withdrawals() {
+			seen[w.Address] = true
+		}
+	}
+	delete(seen, common.Address{}) // Coinbase of dev chains
+
+	addrs := make([]common.Address, 0, len(seen))
+	for addr := range seen {
+		addrs = append(addrs, addr)
+	}
+	sortAddresses(addrs)
+	return addrs, nil
+}
+
+// LookupIndices finds the database index of each address (sorted in
+// database order) with one pass over address-mapping.bin. Addresses not in
+// the mapping are returned as unknown.
+func LookupIndices(mapping io.Reader, addrs []common.Address) (map[common.Address]uint64, []common.Address, error) {
+	found := make(map[common.Address]uint64, len(addrs))
+	var unknown []common.Address
+
+	br := bufio.NewReaderSize(mapping, 1<<20)
+	var rec [AddressMappingEntrySize]byte
+	var cur common.Address
+	var curHex string
+	haveCur := false
+	for _, addr := range addrs {
+		want := addr.Hex()
+		for !haveCur || curHex < want {
+			if _, err := io.ReadFull(br, rec[:]); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, nil, err
+			}
+			copy(cur[:], rec[:20])
+			curHex, haveCur = cur.Hex(), true
+		}
+		if haveCur && cur == addr {
+			found[addr] = uint64(binary.LittleEndian.Uint32(rec[20:24]))
+		} else {
+			unknown = append(unknown, addr)
+		}
+	}
+	return found, unknown, nil
+}
+
+// Refresh brings database.bin from block `from` to block `to`: it fetches
+// the accounts touched in between and rewrites the entries that changed. The
+// returned update set lists those entries with their old and new values.
+// database.bin is left untouched if any balance could not be fetched.
+func Refresh(ctx context.Context, blocks BlockReader, client BatchCaller, dbPath, mappingPath string, from, to uint64, cfg FetchConfig) (*UpdateSet, *RefreshReport, error) {
+	if to < from {
+		return nil, nil, fmt.Errorf("target block %d is before database block %d", to, from)
+	}
+	report := &RefreshReport{Blocks: int(to - from)}
+
+	touched, err := TouchedAccounts(ctx, blocks, from, to)
+	if err != nil {
+		return nil, report, err
+	}
+	report.Touched = len(touched)
+
+	mf, err := os.Open(mappingPath)
+	if err != nil {
+		return nil, report, err
+	}
+	indices, unknown, err := LookupIndices(mf, touched)
+	mf.Close()
+	if err != nil {
+		return nil, report, fmt.Errorf("read %s: %w", mappingPath, err)
+	}
+	report.Unknown = unknown
+
+	known := make([]common.Address, 0, len(indices))
+	for _, addr := range touched {
+		if _, ok := indices[addr]; ok {
+			known = append(known, addr)
+		}
+	}
+	accounts, fetch, err := FetchBalances(ctx, client, known, to, cfg)
+	report.Fetch = fetch
+	if err != nil {
+		return nil, report, err
+	}
+	if err := fetch.Err(); err != nil {
+		return nil, report, err
+	}
+
+	db, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, report, err
+	}
+	defer db.Close()
+
+	set := &UpdateSet{FromBlock: from, ToBlock: to}
+	var buf [DBEntrySize]byte
+	for _, acc := range accounts {
+		idx := indices[acc.Address]
+		if _, err := db.ReadAt(buf[:], int64(idx)*DBEntrySize); err != nil {
+			return nil, report, fmt.Errorf("read entry %d: %w", idx, err)
+		}
+		old, cur := binary.LittleEndian.Uint64(buf[:]), acc.Balance.Uint64()
+		if old == cur {
+			continue
+		}
+		set.Updates = append(set.Updates, Update{Index: idx, Address: acc.Address, OldValue: old, NewValue: cur})
+	}
+	sort.Slice(set.Updates, func(i, j int) bool { return set.Updates[i].Index < set.Updates[j].Index })
+
+	for _, u := range set.Updates {
+		binary.LittleEndian.PutUint64(buf[:], u.NewValue)
+		if _, err := db.WriteAt(buf[:], int64(u.Index)*DBEntrySize); err != nil {
+			return nil, report, fmt.Errorf("write entry %d: %w", u.Index, err)
+		}
+	}
+	if err := db.Sync(); err != nil {
+		return nil, report, err
+	}
+	return set, report, db.Close()
+}
+
+// WriteUpdateSetFile writes set to path in the format described on UpdateSet
+func WriteUpdateSetFile(path string, set *UpdateSet) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	var header [UpdateSetHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:8], uint64(len(set.Updates)))
+	binary.LittleEndian.PutUint64(header[8:16], set.FromBlock)
+	binary.LittleEndian.PutUint64(header[16:24], set.ToBlock)
+	binary.LittleEndian.PutUint64(header[24:32], UpdateSetFormatVersion)
+	bw.Write(header[:])
+
+	var entry [UpdateSetEntrySize]byte
+	for _, u := range set.Updates {
+		binary.LittleEndian.PutUint64(entry[0:8], u.Index)
+		binary.LittleEndian.PutUint64(entry[8:16], u.OldValue)
+		binary.LittleEndian.PutUint64(entry[16:24], u.NewValue)
+		bw.Write(entry[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadUpdateSet parses a file written by WriteUpdateSetFile. Addresses are
+// not stored and come back zero.
+func ReadUpdateSet(r io.Reader) (*UpdateSet, error) {
+	var header [UpdateSetHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read update set header: %w", err)
+	}
+	if v := binary.LittleEndian.Uint64(header[24:32]); v != UpdateSetFormatVersion {
+		return nil, fmt.Errorf("unsupported update set version %d", v)
+	}
+	count := binary.LittleEndian.Uint64(header[0:8])
+	if count > 1<<32 {
+		return nil, fmt.Errorf("update set claims %d entries", count)
+	}
+	set := &UpdateSet{
+		FromBlock: binary.LittleEndian.Uint64(header[8:16]),
+		ToBlock:   binary.LittleEndian.Uint64(header[16:24]),
+		Updates:   make([]Update, count),
+	}
+	br := bufio.NewReader(r)
+	var entry [UpdateSetEntrySize]byte
+	for i := range set.Updates {
+		if _, err := io.ReadFull(br, entry[:]); err != nil {
+			return nil, fmt.Errorf("read update %d of %d: %w", i, count, err)
+		}
+		set.Updates[i] = Update{
+			Index:    binary.LittleEndian.Uint64(entry[0:8]),
+			OldValue: binary.LittleEndian.Uint64(entry[8:16]),
+			NewValue: binary.LittleEndian.Uint64(entry[16:24]),
+		}
+	}
+	return set, nil
+}
+
+func sortAddresses(addrs []common.Address) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Hex() < addrs[j].Hex()
+	})
+}
@@ -0,0 +1,69 @@
+package dbgen
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLookupIndices(t *testing.T) {
+	var accounts []AccountData
+	for i := 0; i < 100; i += 2 {
+		accounts = append(accounts, AccountData{Address: common.BigToAddress(big.NewInt(int64(0x100 + i))), Balance: new(big.Int)})
+	}
+	SortAccounts(accounts)
+	var mapping bytes.Buffer
+	if err := WriteAddressMapping(&mapping, accounts); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := common.BigToAddress(big.NewInt(0x101))
+	after := common.BigToAddress(big.NewInt(0xffff))
+	query := []common.Address{accounts[0].Address, missing, accounts[17].Address, accounts[49].Address, after}
+	sortAddresses(query)
+
+	found, unknown, err := LookupIndices(&mapping, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[common.Address]uint64{accounts[0].Address: 0, accounts[17].Address: 17, accounts[49].Address: 49}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+	if len(unknown) != 2 || !(unknown[0] == missing && unknown[1] == after || unknown[0] == after && unknown[1] == missing) {
+		t.Errorf("unknown %v, want %s and %s", unknown, missing.Hex(), after.Hex())
+	}
+}
+
+func TestUpdateSetRoundTrip(t *testing.T) {
+	set := &UpdateSet{FromBlock: 10, ToBlock: 15, Updates: []Update{
+		{Index: 3, OldValue: 100, NewValue: 90},
+		{Index: 1 << 20, OldValue: 0, NewValue: 1e18},
+	}}
+	path := filepath.Join(t.TempDir(), "update.bin")
+	if err := WriteUpdateSetFile(path, set); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != UpdateSetHeaderSize+2*UpdateSetEntrySize {
+		t.Fatalf("update set is %d bytes", len(data))
+	}
+	got, err := ReadUpdateSet(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, set) {
+		t.Fatalf("read %+v, want %+v", got, set)
+	}
+	if _, err := ReadUpdateSet(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("truncated update set accepted")
+	}
+}
@@ -0,0 +1,224 @@
+package dbgen
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Keyword layout: database.bin as a cuckoo hash table, so clients query by
+// address instead of downloading an address-to-index map.
+//
+// The table has Buckets buckets of two entries: [2b] the account's tag and
+// [2b+1] its balance. Empty buckets are all zero. An address can live in any
+// of Hashes buckets,
+//
+//	bucket_i = BE64(SHA-256(seed || i || address)[:8]) mod Buckets   (i one byte)
+//	tag      = BE64(SHA-256(seed || 0xff || address)[:8]), 0 mapped to 1
+//
+// A client reads both entries of every candidate bucket privately, always
+// all of them, and keeps the balance whose tag matches. All it needs are the
+// parameters below (keyword-params.json); which candidate an account ended
+// up in is never revealed by the queries.
+
+const (
+	KeywordFormatVersion = 1
+	KeywordHashes        = 3
+	KeywordMaxLoad       = 0.9 // 3-way cuckoo tables fill up around 0.91
+
+	keywordMaxKicks  = 1000 // Evictions per insert before trying a new seed
+	keywordMaxSeeds  = 8
+	keywordTagDomain = 0xff
+)
+
+// ErrKeywordTableFull is returned when accounts do not fit the table
+var ErrKeywordTableFull = errors.New("keyword table is full")
+
+// KeywordParams are the public parameters of a keyword table, published as
+// keyword-params.json
+type KeywordParams struct {
+	Version  int           `json:"version"`
+	Buckets  uint64        `json:"buckets"`
+	Hashes   int           `json:"hashes"`
+	Seed     hexutil.Bytes `json:"seed"`
+	Accounts int           `json:"accounts"`
+}
+
+// Bucket returns candidate bucket i of addr
+func (p *KeywordParams) Bucket(addr common.Address, i int) uint64 {
+	return keywordHash(p.Seed, byte(i), addr) % p.Buckets
+}
+
+// Tag returns the tag stored next to addr's balance
+func (p *KeywordParams) Tag(addr common.Address) uint64 {
+	if tag := keywordHash(p.Seed, keywordTagDomain, addr); tag != 0 {
+		return tag
+	}
+	return 1
+}
+
+func keywordHash(seed []byte, domain byte, addr common.Address) uint64 {
+	h := sha256.New()
+	h.Write(seed)
+	h.Write([]byte{domain})
+	h.Write(addr[:])
+	var sum [sha256.Size]byte
+	return binary.BigEndian.Uint64(h.Sum(sum[:0])[:8])
+}
+
+// KeywordTable is a filled cuckoo table
+type KeywordTable struct {
+	Params  KeywordParams
+	Entries []uint64 // 2 × Buckets database entries
+	Bucket  []uint64 // Bucket of each account, in input order
+}
+
+// BuildKeywordTable places accounts in a table of the given number of
+// buckets. Seeds are derived deterministically, so the same accounts always
+// give the same table.
+func BuildKeywordTable(accounts []AccountData, buckets uint64) (*KeywordTable, error) {
+	if buckets == 0 || buckets > 1<<31 {
+		return nil, fmt.Errorf("keyword table needs 1 to 2^31 buckets, got %d", buckets)
+	}
+	if float64(len(accounts)) > KeywordMaxLoad*float64(buckets) {
+		return nil, fmt.Errorf("%w: %d accounts exceed %.0f%% of %d buckets",
+			ErrKeywordTableFull, len(accounts), KeywordMaxLoad*100, buckets)
+	}
+
+	for attempt := 0; attempt < keywordMaxSeeds; attempt++ {
+		seed := sha256.Sum256(binary.BigEndian.AppendUint64([]byte("plinko-keyword-seed"), uint64(attempt)))
+		p := KeywordParams{
+			Version:  KeywordFormatVersion,
+			Buckets:  buckets,
+			Hashes:   KeywordHashes,
+			Seed:     seed[:16],
+			Accounts: len(accounts),
+		}
+		owner, ok := cuckooInsert(accounts, &p, int64(attempt))
+		if !ok {
+			continue
+		}
+
+		t := &KeywordTable{
+			Params:  p,
+			Entries: make([]uint64, 2*buckets),
+			Bucket:  make([]uint64, len(accounts)),
+		}
+		for b, acc := range owner {
+			if acc < 0 {
+				continue
+			}
+			t.Entries[2*b] = p.Tag(accounts[acc].Address)
+			t.Entries[2*b+1] = accounts[acc].Balance.Uint64()
+			t.Bucket[acc] = uint64(b)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w: no placement found for %d accounts in %d buckets after %d seeds",
+		ErrKeywordTableFull, len(accounts), buckets, keywordMaxSeeds)
+}
+
+// cuckooInsert runs random-walk cuckoo insertion and returns the account in
+// each bucket (-1 for empty)
+func cuckooInsert(accounts []AccountData, p *KeywordParams, rngSeed int64) ([]int32, bool) {
+	k := p.Hashes
+	candidates := make([]uint32, len(accounts)*k)
+	for i, acc := range accounts {
+		for j := 0; j < k; j++ {
+			candidates[i*k+j] = uint32(p.Bucket(acc.Address, j))
+		}
+	}
+	owner := make([]int32, p.Buckets)
+	for b := range owner {
+		owner[b] = -1
+	}
+
+	rng := rand.New(rand.NewSource(rngSeed))
+	for i := range accounts {
+		if !cuckooPlace(owner, candidates, k, int32(i), rng) {
+			return nil, false
+		}
+	}
+	return owner, true
+}
+
+// cuckooPlace inserts account cur, evicting along a random walk
+func cuckooPlace(owner []int32, candidates []uint32, k int, cur int32, rng *rand.Rand) bool {
+	from := uint32(len(owner)) // No bucket yet
+	for kick := 0; kick <= keywordMaxKicks; kick++ {
+		mine := candidates[int(cur)*k : int(cur)*k+k]
+		for _, b := range mine {
+			if owner[b] < 0 {
+				owner[b] = cur
+				return true
+			}
+		}
+		// Evict from a random candidate, not the one cur was just evicted from
+		j := rng.Intn(k)
+		if mine[j] == from {
+			j = (j + 1) % k
+		}
+		b := mine[j]
+		owner[b], cur, from = cur, owner[b], b
+	}
+	return false
+}
+
+// WriteDatabaseFile writes the table as database.bin (8 bytes per entry)
+func (t *KeywordTable) WriteDatabaseFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	var buf [DBEntrySize]byte
+	for _, e := range t.Entries {
+		binary.LittleEndian.PutUint64(buf[:], e)
+		bw.Write(buf[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteAddressMapping writes address-mapping.bin for accounts (the slice
+// the table was built from) with the database index of each balance entry,
+// so incremental refreshes can update keyword databases too. It is for
+// db-generator's own use and need not be published.
+func (t *KeywordTable) WriteAddressMapping(w io.Writer, accounts []AccountData) error {
+	bw := bufio.NewWriter(w)
+	var buf [4]byte
+	for i, acc := range accounts {
+		bw.Write(acc.Address[:])
+		binary.LittleEndian.PutUint32(buf[:], uint32(2*t.Bucket[i]+1))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteAddressMappingFile writes address-mapping.bin to path
+func (t *KeywordTable) WriteAddressMappingFile(path string, accounts []AccountData) error {
+	return writeFile(path, accounts, t.WriteAddressMapping)
+}
+
+// WriteKeywordParamsFile writes keyword-params.json
+func WriteKeywordParamsFile(path string, p KeywordParams) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
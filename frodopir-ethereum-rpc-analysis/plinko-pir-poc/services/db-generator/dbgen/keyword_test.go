@@ -0,0 +1,88 @@
+package dbgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func keywordTestAccounts(n int) []AccountData {
+	rng := rand.New(rand.NewSource(1))
+	accounts := make([]AccountData, n)
+	for i := range accounts {
+		rng.Read(accounts[i].Address[:])
+		accounts[i].Balance = big.NewInt(int64(i) + 1)
+	}
+	return accounts
+}
+
+func TestKeywordTable(t *testing.T) {
+	accounts := keywordTestAccounts(3600)
+	table, err := BuildKeywordTable(accounts, 4000) // 90% load
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &table.Params
+	if len(table.Entries) != 8000 || p.Hashes != KeywordHashes || len(p.Seed) != 16 || p.Accounts != 3600 {
+		t.Fatalf("table of %d entries with params %+v", len(table.Entries), p)
+	}
+
+	used := 0
+	for b := 0; b < 4000; b++ {
+		if table.Entries[2*b] != 0 {
+			used++
+		}
+	}
+	if used != len(accounts) {
+		t.Fatalf("%d buckets used, want %d", used, len(accounts))
+	}
+
+	// Every account sits in one of its candidates with its tag; the client
+	// logic (scan all candidates for the tag) finds exactly its balance
+	for i, acc := range accounts {
+		tag := p.Tag(acc.Address)
+		found := 0
+		for j := 0; j < p.Hashes; j++ {
+			b := p.Bucket(acc.Address, j)
+			if table.Entries[2*b] == tag {
+				found++
+				if table.Entries[2*b+1] != acc.Balance.Uint64() || b != table.Bucket[i] {
+					t.Fatalf("account %d: bucket %d holds %d, want %d in bucket %d",
+						i, b, table.Entries[2*b+1], acc.Balance, table.Bucket[i])
+				}
+			}
+		}
+		if found == 0 {
+			t.Fatalf("account %d not in any candidate bucket", i)
+		}
+	}
+
+	// Deterministic
+	again, err := BuildKeywordTable(accounts, 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Entries, table.Entries) || !bytes.Equal(again.Params.Seed, p.Seed) {
+		t.Fatal("rebuilding gave a different table")
+	}
+
+	// The mapping points at balance entries
+	var mapping bytes.Buffer
+	if err := table.WriteAddressMapping(&mapping, accounts); err != nil {
+		t.Fatal(err)
+	}
+	rec := mapping.Bytes()[17*AddressMappingEntrySize:]
+	if idx := binary.LittleEndian.Uint32(rec[20:]); uint64(idx) != 2*table.Bucket[17]+1 {
+		t.Fatalf("mapping index %d, want %d", idx, 2*table.Bucket[17]+1)
+	}
+}
+
+func TestKeywordTableFull(t *testing.T) {
+	if _, err := BuildKeywordTable(keywordTestAccounts(950), 1000); !errors.Is(err, ErrKeywordTableFull) {
+		t.Fatalf("err = %v, want ErrKeywordTableFull", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Package dbgen writes the account database files consumed by the hint
+// generator (database.bin) and by clients (address-mapping.bin).
+package dbgen
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	DBEntrySize             = 8  // uint64 balance in wei, truncated
+	AddressMappingEntrySize = 24 // 20-byte address + uint32 index
+)
+
+type AccountData struct {
+	Address common.Address
+	Balance *big.Int
+}
+
+// SortAccounts puts accounts in database order (by address, deterministic)
+func SortAccounts(accounts []AccountData) {
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Address.Hex() < accounts[j].Address.Hex()
+	})
+}
+
+// WriteDatabase writes 8 bytes per account (uint64 balance in wei, truncated)
+func WriteDatabase(w io.Writer, accounts []AccountData) error {
+	bw := bufio.NewWriter(w)
+	var buf [DBEntrySize]byte
+	for _, acc := range accounts {
+		// Convert big.Int balance to uint64 (sufficient for PoC)
+		binary.LittleEndian.PutUint64(buf[:], acc.Balance.Uint64())
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteAddressMapping writes 24 bytes per account (20 bytes address + 4
+// bytes little-endian index), in database order
+func WriteAddressMapping(w io.Writer, accounts []AccountData) error {
+	bw := bufio.NewWriter(w)
+	var buf [4]byte
+	for i, acc := range accounts {
+		if _, err := bw.Write(acc.Address.Bytes()); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(buf[:], uint32(i))
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteDatabaseFile writes database.bin to path
+func WriteDatabaseFile(path string, accounts []AccountData) error {
+	return writeFile(path, accounts, WriteDatabase)
+}
+
+// WriteAddressMappingFile writes address-mapping.bin to path
+func WriteAddressMappingFile(path string, accounts []AccountData) error {
+	return writeFile(path, accounts, WriteAddressMapping)
+}
+
+func writeFile[T any](path string, records []T, write func(io.Writer, []T) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := write(f, records); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// SourceInfo records where database.bin came from, written next to it as
+// database-source.json
+type SourceInfo struct {
+	Source    string       `json:"source"`           // "rpc" or "state-dump"
+	Format    string       `json:"format,omitempty"` // State dump format (DumpInfo.Format)
+	Block     uint64       `json:"block"`            // Block the balances were read at
+	StateRoot *common.Hash `json:"stateRoot,omitempty"`
+	Accounts  int          `json:"accounts"`
+	Skipped   int          `json:"skipped,omitempty"` // Dump entries without an address
+}
+
+// WriteSourceFile writes info as indented JSON to path
+func WriteSourceFile(path string, info SourceInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadSourceFile reads a database-source.json written by WriteSourceFile
+func ReadSourceFile(path string) (SourceInfo, error) {
+	var info SourceInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
@@ -0,0 +1,264 @@
+package dbgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// State proofs: a PIR table that ties each account of database.bin to the
+// stateRoot of the block it was read at. Record i is the eth_getProof
+// account proof of account i, the Merkle-Patricia trie nodes from the
+// state root down to the leaf holding the account's RLP (nonce, balance,
+// storageRoot, codeHash), so a client that reads record i privately can
+// check the balance against a block header it trusts.
+//
+// The table lives in tables/state-proofs/:
+//
+//	database.bin  Accounts x RecordWords 8-byte entries; record i is
+//	              entries [i*RecordWords, (i+1)*RecordWords):
+//	                [0:2]  node count (uint16 LE)
+//	                then per node [length (uint16 LE)][RLP node]
+//	                zero padding to RecordWords*8 bytes
+//	proofs.json   block, state root, record width, account count
+//	epoch.json    {"epoch": 0, "last_block": B}
+//
+// Every record has the width of the longest proof. A proof is about
+// log16(accounts) nodes of up to 532 bytes, so the table is tens of times
+// the size of database.bin: fine for test chains, while 2^23 accounts take
+// tens of GB. The record layout must match plinko-client/stateproof.go.
+
+const (
+	ProofTableName = "state-proofs"
+	ProofInfoFile  = "proofs.json"
+)
+
+// ProofTableInfo is proofs.json
+type ProofTableInfo struct {
+	Block       uint64      `json:"block"`        // Block the proofs are for
+	StateRoot   common.Hash `json:"state_root"`   // Root every proof starts at
+	RecordWords int         `json:"record_words"` // 8-byte entries per account
+	Accounts    int         `json:"accounts"`
+}
+
+// accountProofResult is the part of an eth_getProof result that is kept
+type accountProofResult struct {
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+}
+
+// FetchAccountProofs reads the account proof of every address at block
+// with batched eth_getProof requests, retried like FetchBalances (the
+// checkpoint in cfg is not used). Proofs are returned in address order.
+// All of them must start at the same root, which is returned; any address
+// still failing after cfg.MaxAttempts fails the whole call.
+func FetchAccountProofs(ctx context.Context, client BatchCaller, addresses []common.Address, block uint64, cfg FetchConfig) ([][][]byte, common.Hash, error) {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	blockArg := hexutil.EncodeUint64(block)
+	proofs := make([][][]byte, len(addresses))
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan [2]int)
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if err := fetchProofBatch(ctx, client, addresses, proofs, r[0], r[1], blockArg, cfg); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for start := 0; start < len(addresses) && ctx.Err() == nil; start += cfg.BatchSize {
+		select {
+		case jobs <- [2]int{start, min(start+cfg.BatchSize, len(addresses))}:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, common.Hash{}, err
+	}
+	if firstErr != nil {
+		return nil, common.Hash{}, firstErr
+	}
+
+	var root common.Hash
+	for i, p := range proofs {
+		if len(p) == 0 {
+			return nil, common.Hash{}, fmt.Errorf("%s: empty account proof", addresses[i].Hex())
+		}
+		h := crypto.Keccak256Hash(p[0])
+		if i == 0 {
+			root = h
+		} else if h != root {
+			return nil, common.Hash{}, fmt.Errorf("%s: proof starts at %s, others at %s", addresses[i].Hex(), h.Hex(), root.Hex())
+		}
+	}
+	return proofs, root, nil
+}
+
+// fetchProofBatch fills proofs[from:to], retrying failed lookups with
+// backoff
+func fetchProofBatch(ctx context.Context, client BatchCaller, addresses []common.Address, proofs [][][]byte, from, to int, blockArg string, cfg FetchConfig) error {
+	pending := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		pending = append(pending, i)
+	}
+	backoff := cfg.InitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= cfg.MaxAttempts && len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, cfg.MaxBackoff)
+		}
+
+		results := make([]accountProofResult, len(pending))
+		elems := make([]rpc.BatchElem, len(pending))
+		for j, i := range pending {
+			elems[j] = rpc.BatchElem{
+				Method: "eth_getProof",
+				Args:   []interface{}{addresses[i], []string{}, blockArg},
+				Result: &results[j],
+			}
+		}
+		if err := client.BatchCallContext(ctx, elems); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue // Whole batch again
+		}
+
+		retry := pending[:0]
+		for j, i := range pending {
+			if elems[j].Error != nil {
+				lastErr = fmt.Errorf("%s: %w", addresses[i].Hex(), elems[j].Error)
+				retry = append(retry, i)
+				continue
+			}
+			nodes := make([][]byte, len(results[j].AccountProof))
+			for k, n := range results[j].AccountProof {
+				nodes[k] = n
+			}
+			proofs[i] = nodes
+		}
+		pending = retry
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("account proofs missing for %d accounts after %d attempts: %w", len(pending), cfg.MaxAttempts, lastErr)
+	}
+	return nil
+}
+
+// EncodeProofRecord lays proof nodes out as a record, unpadded
+func EncodeProofRecord(nodes [][]byte) ([]byte, error) {
+	if len(nodes) > 0xffff {
+		return nil, fmt.Errorf("proof of %d nodes", len(nodes))
+	}
+	rec := binary.LittleEndian.AppendUint16(nil, uint16(len(nodes)))
+	for _, n := range nodes {
+		if len(n) > 0xffff {
+			return nil, fmt.Errorf("proof node of %d bytes", len(n))
+		}
+		rec = binary.LittleEndian.AppendUint16(rec, uint16(len(n)))
+		rec = append(rec, n...)
+	}
+	return rec, nil
+}
+
+// WriteProofTable writes the state-proofs table for proofs (in database
+// order) into dir and returns its proofs.json. database.bin is written
+// last, so its presence marks a complete table.
+func WriteProofTable(dir string, proofs [][][]byte, block uint64, root common.Hash) (ProofTableInfo, error) {
+	info := ProofTableInfo{Block: block, StateRoot: root, Accounts: len(proofs)}
+	records := make([][]byte, len(proofs))
+	for i, p := range proofs {
+		rec, err := EncodeProofRecord(p)
+		if err != nil {
+			return info, fmt.Errorf("account %d: %w", i, err)
+		}
+		records[i] = rec
+		info.RecordWords = max(info.RecordWords, (len(rec)+DBEntrySize-1)/DBEntrySize)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return info, err
+	}
+	if err := writeJSONFile(filepath.Join(dir, ProofInfoFile), info); err != nil {
+		return info, err
+	}
+	if err := WriteTableEpochFile(filepath.Join(dir, "epoch.json"), TableEpoch{LastBlock: block}); err != nil {
+		return info, err
+	}
+	width := info.RecordWords * DBEntrySize
+	err := writeFile(filepath.Join(dir, "database.bin"), records, func(w io.Writer, records [][]byte) error {
+		bw := bufio.NewWriter(w)
+		pad := make([]byte, width)
+		for _, rec := range records {
+			if _, err := bw.Write(rec); err != nil {
+				return err
+			}
+			if _, err := bw.Write(pad[len(rec):]); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	})
+	return info, err
+}
+
+// ReadMappingAddresses returns the addresses of address-mapping.bin in
+// database order
+func ReadMappingAddresses(r io.Reader) ([]common.Address, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%AddressMappingEntrySize != 0 {
+		return nil, errors.New("address-mapping.bin is not a whole number of records")
+	}
+	addrs := make([]common.Address, len(data)/AddressMappingEntrySize)
+	for k := range addrs {
+		rec := data[k*AddressMappingEntrySize:]
+		i := binary.LittleEndian.Uint32(rec[20:24])
+		if int(i) >= len(addrs) {
+			return nil, fmt.Errorf("address-mapping.bin index %d out of range", i)
+		}
+		copy(addrs[i][:], rec[:20])
+	}
+	return addrs, nil
+}
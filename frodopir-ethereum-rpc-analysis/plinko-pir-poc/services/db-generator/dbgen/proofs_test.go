@@ -0,0 +1,177 @@
+package dbgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// proofList collects trie.Prove's nodes in order, root first
+type proofList [][]byte
+
+func (l *proofList) Put(key, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error { return nil }
+
+// proofNode answers eth_getProof from a state trie of its accounts; flaky
+// addresses fail a number of times first
+type proofNode struct {
+	mu    sync.Mutex
+	trie  *trie.Trie
+	flaky map[common.Address]int
+}
+
+func newProofNode(t *testing.T, addrs []common.Address) *proofNode {
+	t.Helper()
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i, addr := range addrs {
+		data, err := rlp.EncodeToBytes(&types.StateAccount{
+			Nonce:    uint64(i),
+			Balance:  big.NewInt(int64(1000 + i)),
+			Root:     types.EmptyRootHash,
+			CodeHash: types.EmptyCodeHash.Bytes(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.MustUpdate(crypto.Keccak256(addr[:]), data)
+	}
+	return &proofNode{trie: tr, flaky: map[common.Address]int{}}
+}
+
+func (n *proofNode) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := range b {
+		addr := b[i].Args[0].(common.Address)
+		if n.flaky[addr] > 0 {
+			n.flaky[addr]--
+			b[i].Error = errors.New("rate limited")
+			continue
+		}
+		var nodes proofList
+		if err := n.trie.Prove(crypto.Keccak256(addr[:]), &nodes); err != nil {
+			return err
+		}
+		res := b[i].Result.(*accountProofResult)
+		for _, node := range nodes {
+			res.AccountProof = append(res.AccountProof, node)
+		}
+	}
+	return nil
+}
+
+func TestProofTable(t *testing.T) {
+	addrs := testAddresses(40)
+	node := newProofNode(t, addrs)
+	node.flaky[addrs[9]] = 2
+
+	proofs, root, err := FetchAccountProofs(context.Background(), node, addrs, 77, testFetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != node.trie.Hash() {
+		t.Fatalf("root %s, trie has %s", root.Hex(), node.trie.Hash().Hex())
+	}
+
+	dir := filepath.Join(t.TempDir(), ProofTableName)
+	info, err := WriteProofTable(dir, proofs, 77, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "database.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := info.RecordWords * DBEntrySize
+	if info.Accounts != len(addrs) || len(data) != len(addrs)*width {
+		t.Fatalf("%d accounts, %d bytes for %d-word records", info.Accounts, len(data), info.RecordWords)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ProofInfoFile)); err != nil {
+		t.Error(err)
+	}
+
+	// Each record decodes back to a proof of its account under the root
+	for i, addr := range addrs {
+		rec := data[i*width : (i+1)*width]
+		count := int(rec[0]) | int(rec[1])<<8
+		db := memorydb.New()
+		off := 2
+		for k := 0; k < count; k++ {
+			l := int(rec[off]) | int(rec[off+1])<<8
+			node := rec[off+2 : off+2+l]
+			db.Put(crypto.Keccak256(node), node)
+			off += 2 + l
+		}
+		if !bytes.Equal(rec[off:], make([]byte, width-off)) {
+			t.Errorf("account %d: padding is not zero", i)
+		}
+		val, err := trie.VerifyProof(root, crypto.Keccak256(addr[:]), db)
+		if err != nil {
+			t.Fatalf("account %d: %v", i, err)
+		}
+		var acct types.StateAccount
+		if err := rlp.DecodeBytes(val, &acct); err != nil || acct.Balance.Uint64() != uint64(1000+i) {
+			t.Errorf("account %d: balance %v (%v)", i, acct.Balance, err)
+		}
+	}
+
+	// Proofs for different roots are refused
+	other := newProofNode(t, addrs[:3])
+	if _, _, err := FetchAccountProofs(context.Background(), mixedNodes{node, other}, addrs, 77, testFetchConfig()); err == nil {
+		t.Error("proofs for two roots were accepted")
+	}
+}
+
+// mixedNodes sends the first batch to one node and the rest to the other,
+// like a load balancer in front of nodes at different heads
+type mixedNodes [2]*proofNode
+
+func (m mixedNodes) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	if b[0].Args[0].(common.Address) == (common.Address{}) {
+		return m[0].BatchCallContext(ctx, b)
+	}
+	return m[1].BatchCallContext(ctx, b)
+}
+
+func TestReadMappingAddresses(t *testing.T) {
+	addrs := testAddresses(30)
+	accounts := make([]AccountData, len(addrs))
+	for i, addr := range addrs {
+		accounts[i] = AccountData{Address: addr, Balance: big.NewInt(int64(i))}
+	}
+	SortAccounts(accounts)
+	var buf bytes.Buffer
+	if err := WriteAddressMapping(&buf, accounts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadMappingAddresses(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range accounts {
+		if got[i] != accounts[i].Address {
+			t.Fatalf("index %d: %s, database has %s", i, got[i].Hex(), accounts[i].Address.Hex())
+		}
+	}
+	if _, err := ReadMappingAddresses(bytes.NewReader(make([]byte, 25))); err == nil {
+		t.Error("truncated mapping accepted")
+	}
+}
@@ -0,0 +1,252 @@
+package dbgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Token balances: a second PIR table with one entry per (holder, token)
+// pair holding a nonzero balance of a configured ERC-20 token. Balances are
+// rebuilt by replaying every Transfer event the tokens emitted up to the
+// source block; mints come from and burns go to the zero address. Tokens
+// whose balances change without a Transfer event (rebasing, fee-on-transfer)
+// are not tracked correctly.
+//
+// The table lives in its own directory (tables/token-balances/):
+//
+//	database.bin       8 bytes per pair: uint64 balance, truncated like ETH
+//	token-mapping.bin  44 bytes per pair: holder, token, uint32 LE index,
+//	                   sorted by holder bytes, then token bytes
+//	tokens.json        the token list
+//	epoch.json         {"epoch": 0, "last_block": B}: the table holds every
+//	                   Transfer up to block B, and deltas continue from B+1
+//	                   (the file plinko-update-service writes when it grows
+//	                   the account database, so clients sync both alike)
+
+const (
+	TokenMappingEntrySize = 44 // 20-byte holder + 20-byte token + uint32 index
+	DefaultLogRange       = 10000
+)
+
+// TransferEventTopic is topic 0 of Transfer(address,address,uint256)
+var TransferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// Token is one configured ERC-20 token
+type Token struct {
+	Address common.Address `json:"address"`
+	Symbol  string         `json:"symbol,omitempty"`
+}
+
+// TokenTableInfo is tokens.json
+type TokenTableInfo struct {
+	Tokens []Token `json:"tokens"`
+	Pairs  int     `json:"pairs"`
+}
+
+// TableEpoch is a table's epoch.json
+type TableEpoch struct {
+	Epoch     uint64 `json:"epoch"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// TokenBalance is one (holder, token) entry
+type TokenBalance struct {
+	Holder  common.Address
+	Token   common.Address
+	Balance *big.Int
+}
+
+// LogFilterer is the ethclient.Client call used to read Transfer events
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// ParseTokens parses a comma-separated token list. Each item is an address,
+// optionally prefixed with a symbol: "USDC:0xa0b8...,0x6b17...".
+func ParseTokens(s string) ([]Token, error) {
+	var tokens []Token
+	seen := make(map[common.Address]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var tok Token
+		addr := item
+		if i := strings.IndexByte(item, ':'); i >= 0 {
+			tok.Symbol, addr = item[:i], item[i+1:]
+		}
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid token address %q", addr)
+		}
+		tok.Address = common.HexToAddress(addr)
+		if seen[tok.Address] {
+			return nil, fmt.Errorf("token %s listed twice", tok.Address.Hex())
+		}
+		seen[tok.Address] = true
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// DecodeTransfer extracts an ERC-20 Transfer event. ERC-721 transfers share
+// the signature but index the token ID as a fourth topic, and are rejected.
+func DecodeTransfer(l types.Log) (from, to common.Address, value *big.Int, ok bool) {
+	if len(l.Topics) != 3 || l.Topics[0] != TransferEventTopic || len(l.Data) != 32 {
+		return common.Address{}, common.Address{}, nil, false
+	}
+	from = common.BytesToAddress(l.Topics[1].Bytes())
+	to = common.BytesToAddress(l.Topics[2].Bytes())
+	return from, to, new(big.Int).SetBytes(l.Data), true
+}
+
+// FetchTokenBalances replays the Transfer events of tokens in blocks
+// [0, toBlock], rangeSize blocks per eth_getLogs call, and returns the
+// nonzero balances in table order
+func FetchTokenBalances(ctx context.Context, client LogFilterer, tokens []Token, toBlock, rangeSize uint64) ([]TokenBalance, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	if rangeSize == 0 {
+		rangeSize = DefaultLogRange
+	}
+	addrs := make([]common.Address, len(tokens))
+	for i, tok := range tokens {
+		addrs[i] = tok.Address
+	}
+
+	type pair struct{ holder, token common.Address }
+	balances := make(map[pair]*big.Int)
+	credit := func(holder, token common.Address, v *big.Int) {
+		if holder == (common.Address{}) {
+			return
+		}
+		k := pair{holder, token}
+		if balances[k] == nil {
+			balances[k] = new(big.Int)
+		}
+		balances[k].Add(balances[k], v)
+	}
+
+	for from := uint64(0); from <= toBlock; from += rangeSize {
+		to := min(from+rangeSize-1, toBlock)
+		logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: addrs,
+			Topics:    [][]common.Hash{{TransferEventTopic}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("logs for blocks %d-%d: %w", from, to, err)
+		}
+		for _, l := range logs {
+			sender, receiver, value, ok := DecodeTransfer(l)
+			if !ok {
+				continue
+			}
+			credit(sender, l.Address, new(big.Int).Neg(value))
+			credit(receiver, l.Address, value)
+		}
+	}
+
+	out := make([]TokenBalance, 0, len(balances))
+	for k, v := range balances {
+		if v.Sign() > 0 {
+			out = append(out, TokenBalance{Holder: k.holder, Token: k.token, Balance: v})
+		}
+	}
+	SortTokenBalances(out)
+	return out, nil
+}
+
+// SortTokenBalances puts balances in table order: by holder, then token
+func SortTokenBalances(balances []TokenBalance) {
+	sort.Slice(balances, func(i, j int) bool {
+		if c := bytes.Compare(balances[i].Holder[:], balances[j].Holder[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(balances[i].Token[:], balances[j].Token[:]) < 0
+	})
+}
+
+// WriteTokenDatabase writes 8 bytes per pair (uint64 balance, truncated)
+func WriteTokenDatabase(w io.Writer, balances []TokenBalance) error {
+	bw := bufio.NewWriter(w)
+	var buf [DBEntrySize]byte
+	for _, b := range balances {
+		binary.LittleEndian.PutUint64(buf[:], b.Balance.Uint64())
+		if _, err := bw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteTokenMapping writes 44 bytes per pair (holder, token, uint32 LE
+// index), in table order
+func WriteTokenMapping(w io.Writer, balances []TokenBalance) error {
+	bw := bufio.NewWriter(w)
+	var rec [TokenMappingEntrySize]byte
+	for i, b := range balances {
+		copy(rec[:20], b.Holder[:])
+		copy(rec[20:40], b.Token[:])
+		binary.LittleEndian.PutUint32(rec[40:], uint32(i))
+		if _, err := bw.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteTokenDatabaseFile writes the table's database.bin to path
+func WriteTokenDatabaseFile(path string, balances []TokenBalance) error {
+	return writeFile(path, balances, WriteTokenDatabase)
+}
+
+// WriteTokenMappingFile writes token-mapping.bin to path
+func WriteTokenMappingFile(path string, balances []TokenBalance) error {
+	return writeFile(path, balances, WriteTokenMapping)
+}
+
+// WriteTokenInfoFile writes tokens.json to path
+func WriteTokenInfoFile(path string, info TokenTableInfo) error {
+	return writeJSONFile(path, info)
+}
+
+// WriteTableEpochFile writes epoch.json to path
+func WriteTableEpochFile(path string, epoch TableEpoch) error {
+	return writeJSONFile(path, epoch)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadTokenInfoFile reads a tokens.json written by WriteTokenInfoFile
+func ReadTokenInfoFile(path string) (TokenTableInfo, error) {
+	var info TokenTableInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
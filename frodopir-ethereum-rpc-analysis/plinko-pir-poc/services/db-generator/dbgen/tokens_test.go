@@ -0,0 +1,106 @@
+package dbgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeLogs serves logs by block number and records the ranges asked for
+type fakeLogs struct {
+	logs   []types.Log
+	ranges [][2]uint64
+}
+
+func (f *fakeLogs) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	f.ranges = append(f.ranges, [2]uint64{from, to})
+	var out []types.Log
+	for _, l := range f.logs {
+		if l.BlockNumber >= from && l.BlockNumber <= to {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+func transferLog(block uint64, token, from, to common.Address, value int64) types.Log {
+	return types.Log{
+		Address:     token,
+		Topics:      []common.Hash{TransferEventTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        common.BigToHash(big.NewInt(value)).Bytes(),
+		BlockNumber: block,
+	}
+}
+
+func TestFetchTokenBalances(t *testing.T) {
+	tokA, tokB := common.Address{0xaa}, common.Address{0xbb}
+	alice, bob, carol := common.Address{3}, common.Address{1}, common.Address{2}
+	nft := transferLog(4, tokA, alice, bob, 0)
+	nft.Topics = append(nft.Topics, common.Hash{}) // ERC-721: token ID as topic 3
+
+	client := &fakeLogs{logs: []types.Log{
+		transferLog(1, tokA, common.Address{}, alice, 1000),
+		transferLog(2, tokB, common.Address{}, bob, 50),
+		transferLog(3, tokA, alice, bob, 400),
+		nft,
+		transferLog(5, tokB, bob, carol, 50),               // bob's tokB balance drops to zero
+		transferLog(6, tokA, alice, common.Address{}, 100), // Burn
+		transferLog(9, tokA, common.Address{}, carol, 7),   // After the target block
+	}}
+	got, err := FetchTokenBalances(context.Background(), client, []Token{{Address: tokA}, {Address: tokB}}, 8, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]uint64{{0, 2}, {3, 5}, {6, 8}}; len(client.ranges) != len(want) || client.ranges[2] != want[2] {
+		t.Errorf("queried ranges %v, want %v", client.ranges, want)
+	}
+
+	want := []TokenBalance{
+		{Holder: bob, Token: tokA, Balance: big.NewInt(400)},
+		{Holder: carol, Token: tokB, Balance: big.NewInt(50)},
+		{Holder: alice, Token: tokA, Balance: big.NewInt(500)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d balances %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i].Holder != want[i].Holder || got[i].Token != want[i].Token || got[i].Balance.Cmp(want[i].Balance) != 0 {
+			t.Errorf("balance %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	var mapping bytes.Buffer
+	if err := WriteTokenMapping(&mapping, got); err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Len() != len(got)*TokenMappingEntrySize {
+		t.Fatalf("mapping is %d bytes", mapping.Len())
+	}
+	rec := mapping.Bytes()[2*TokenMappingEntrySize:]
+	if common.BytesToAddress(rec[:20]) != alice || common.BytesToAddress(rec[20:40]) != tokA || binary.LittleEndian.Uint32(rec[40:44]) != 2 {
+		t.Errorf("third mapping record %x", rec[:TokenMappingEntrySize])
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens("USDC:0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48, 0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].Symbol != "USDC" || tokens[1].Symbol != "" ||
+		tokens[1].Address != common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F") {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+	for _, bad := range []string{"USDC:0x12", "0x6B175474E89094C44Da98b954EedeAC495271d0F,0x6b175474e89094c44da98b954eedeac495271d0f"} {
+		if _, err := ParseTokens(bad); err == nil {
+			t.Errorf("ParseTokens(%q) succeeded", bad)
+		}
+	}
+}
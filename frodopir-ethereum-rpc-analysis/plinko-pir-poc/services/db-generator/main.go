@@ -2,257 +2,566 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
-	"math/big"
 	"os"
-	"sort"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"piano-pir-db-generator/dbgen"
 )
 
 const (
 	// Database configuration
-	TotalAccounts    = 8388608 // 2^23 accounts
-	DatabasePath     = "/data/database.bin"
+	TotalAccounts      = 8388608 // 2^23 accounts
+	DatabasePath       = "/data/database.bin"
 	AddressMappingPath = "/data/address-mapping.bin"
-
-	// Performance tuning
-	ConcurrentWorkers = 10000  // High concurrency for fast queries
-	BatchSize         = 1000   // Progress reporting interval
+	AddressIndexPath   = "/data/address-index.bin"
+	SourcePath         = "/data/database-source.json"
+
+	// Keyword layout (DB_LAYOUT=keyword): database.bin is a cuckoo table of
+	// (tag, balance) buckets that clients query by address
+	LayoutEnv         = "DB_LAYOUT"
+	KeywordParamsPath = "/data/keyword-params.json"
+	KeywordBuckets    = TotalAccounts / 2         // Same database.bin size
+	KeywordAccounts   = KeywordBuckets * 85 / 100 // Load that inserts quickly
+
+	// Balance queries
+	AnvilURL         = "http://eth-mock:8545"
+	RPCBatchSize     = 500    // eth_getBalance calls per JSON-RPC batch
+	RPCConcurrency   = 16     // Batches in flight
+	RPCMaxAttempts   = 5      // Tries per account before the run fails
+	ProgressInterval = 100000 // Progress reporting interval
+	FetchReportPath  = "/data/balance-fetch-report.json"
+	BalanceBlockEnv  = "BALANCE_BLOCK" // Block to read balances at (default: head at start)
+	CheckpointPath   = "/data/db-generator.checkpoint"
+
+	// Incremental mode (MODE=incremental): refresh database.bin to a newer block
+	ModeEnv    = "MODE"
+	UpdatesDir = "/data/updates"
+
+	// Token balances table (TOKENS="USDC:0x...,0x..."): (holder, token) pairs
+	// rebuilt from Transfer events up to the database's source block
+	TokensEnv         = "TOKENS"
+	TokenTableDir     = "/data/tables/token-balances"
+	TokenDatabasePath = TokenTableDir + "/database.bin"
+	TokenMappingPath  = TokenTableDir + "/token-mapping.bin"
+	TokenInfoPath     = TokenTableDir + "/tokens.json"
+	TokenEpochPath    = TokenTableDir + "/epoch.json"
+	LogRange          = 10000 // Blocks per eth_getLogs call
+
+	// State proofs table (STATE_PROOFS=true): the eth_getProof account proof
+	// of every database.bin account, so clients can check balances against a
+	// block header
+	StateProofsEnv    = "STATE_PROOFS"
+	ProofTableDir     = "/data/tables/" + dbgen.ProofTableName
+	ProofDatabasePath = ProofTableDir + "/database.bin"
+	ProofBatchSize    = 50 // eth_getProof calls per JSON-RPC batch
 
 	// Anvil default mnemonic (well-known test mnemonic)
 	AnvilMnemonic = "test test test test test test test test test test test junk"
-)
 
-type AccountData struct {
-	Address common.Address
-	Balance *big.Int
-}
+	// Environment overrides for address derivation
+	MnemonicEnv       = "MNEMONIC"
+	PassphraseEnv     = "MNEMONIC_PASSPHRASE"
+	DerivationPathEnv = "DERIVATION_PATH"
+
+	// State dump import (skips Anvil entirely when set)
+	StateDumpEnv      = "STATE_DUMP"       // Path to the dump file
+	StateDumpBlockEnv = "STATE_DUMP_BLOCK" // Source block, for dumps that do not record it
+)
 
 func main() {
 	log.Println("========================================")
 	log.Println("Plinko PIR Database Generator (Go)")
 	log.Println("========================================")
-	log.Printf("Accounts: %d (2^23)\n", TotalAccounts)
-	log.Printf("Concurrent workers: %d\n", ConcurrentWorkers)
+	keyword := false
+	switch layout := os.Getenv(LayoutEnv); layout {
+	case "", "index":
+	case "keyword":
+		keyword = true
+	default:
+		log.Fatalf("Unknown %s %q (want index or keyword)", LayoutEnv, layout)
+	}
+	maxAccounts := TotalAccounts
+	if keyword {
+		maxAccounts = KeywordAccounts
+		log.Printf("Layout: keyword (%d cuckoo buckets, up to %d accounts)\n", KeywordBuckets, KeywordAccounts)
+	} else {
+		log.Printf("Accounts: %d (2^23)\n", TotalAccounts)
+	}
+	log.Printf("RPC batches: %d calls, %d in flight\n", RPCBatchSize, RPCConcurrency)
 	log.Println()
 
+	// Cancelled on SIGINT/SIGTERM; balance queries stop and keep their checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch mode := os.Getenv(ModeEnv); mode {
+	case "", "full":
+	case "incremental":
+		runIncremental(ctx)
+		return
+	default:
+		log.Fatalf("Unknown %s %q (want full or incremental)", ModeEnv, mode)
+	}
+
 	// Check if database already exists
 	if _, err := os.Stat(DatabasePath); err == nil {
 		log.Println("✓ Database already exists at", DatabasePath)
-		log.Println("✓ Skipping generation (delete file to regenerate)")
+		log.Println("✓ Skipping generation (delete file to regenerate, or set MODE=incremental)")
+		generateTokenTable(ctx)
+		generateProofTable(ctx)
 		return
 	}
 
-	// Connect to Anvil
-	client, err := ethclient.Dial("http://eth-mock:8545")
-	if err != nil {
-		log.Fatalf("Failed to connect to Anvil: %v", err)
+	start := time.Now()
+	var accounts []dbgen.AccountData
+	var source dbgen.SourceInfo
+	if dumpPath := os.Getenv(StateDumpEnv); dumpPath != "" {
+		accounts, source = importStateDump(dumpPath)
+	} else {
+		accounts, source = queryAnvil(ctx, maxAccounts)
+	}
+	if len(accounts) > maxAccounts {
+		log.Fatalf("%d accounts do not fit: the %d-entry database the hint generator expects holds %d in this layout", len(accounts), TotalAccounts, maxAccounts)
 	}
-	defer client.Close()
 
-	log.Println("Connected to Anvil successfully")
+	// Sort accounts by address (deterministic ordering)
+	log.Println("Sorting accounts by address...")
+	dbgen.SortAccounts(accounts)
 
-	// Wait for Anvil to be ready
-	waitForAnvil(client)
+	entries := len(accounts)
+	if keyword {
+		writeKeywordDatabase(accounts)
+		entries = 2 * KeywordBuckets
+	} else {
+		writeIndexDatabase(accounts)
+	}
+
+	// Record the source block next to the database
+	if err := dbgen.WriteSourceFile(SourcePath, source); err != nil {
+		log.Fatalf("Failed to write %s: %v", SourcePath, err)
+	}
+	log.Printf("Source: %s at block %d\n", source.Source, source.Block)
+
+	// The database is complete; a later run must not resume into it
+	if err := os.Remove(CheckpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️  Could not remove checkpoint: %v\n", err)
+	}
+
+	// Verify output
+	verifyOutput(len(accounts), entries, !keyword)
+
+	generateTokenTable(ctx)
+	generateProofTable(ctx)
+
+	log.Println()
+	log.Println("✅ Database generation complete!")
+	log.Printf("Total time: %v\n", time.Since(start))
+}
+
+// queryAnvil derives the account addresses and asks Anvil for each balance
+func queryAnvil(ctx context.Context, count int) ([]dbgen.AccountData, dbgen.SourceInfo) {
+	rpcClient, client := connectToAnvil(ctx)
+	defer rpcClient.Close()
 
 	// Generate all account addresses deterministically
 	log.Println("Generating account addresses...")
 	startGen := time.Now()
-	addresses := generateAnvilAddresses(TotalAccounts)
+	addresses := generateAnvilAddresses(count)
 	log.Printf("Generated %d addresses in %v\n", len(addresses), time.Since(startGen))
 
-	// Query balances concurrently
-	log.Println("Querying account balances...")
+	// Pin every query to one block for a consistent snapshot. A checkpoint
+	// from an interrupted run fixes the block it was taken at.
+	block, pinned := targetBlock(ctx, client)
+	cp, err := dbgen.OpenCheckpoint(CheckpointPath, addresses)
+	switch {
+	case err == nil && (!pinned || cp.Block() == block):
+		block = cp.Block()
+		log.Printf("Resuming from checkpoint: %d/%d accounts already fetched at block %d\n",
+			cp.Done(), len(addresses), block)
+	case err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, dbgen.ErrCheckpointMismatch):
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("⚠️  Discarding checkpoint: %v\n", err)
+		} else if err == nil {
+			cp.Close()
+			log.Printf("⚠️  Discarding checkpoint for block %d (%s=%d)\n", cp.Block(), BalanceBlockEnv, block)
+		}
+		if cp, err = dbgen.CreateCheckpoint(CheckpointPath, addresses, block); err != nil {
+			log.Fatalf("Failed to create checkpoint: %v", err)
+		}
+	default:
+		log.Fatalf("Failed to open checkpoint: %v", err)
+	}
+	defer cp.Close()
+
+	// Query balances in batches
+	cfg := fetchConfig()
+	cfg.Checkpoint = cp
+	log.Printf("Querying account balances at block %d (%d per batch, %d batches in flight)...\n",
+		block, cfg.BatchSize, cfg.Concurrency)
 	startQuery := time.Now()
-	accounts := queryBalancesConcurrent(client, addresses)
-	log.Printf("Queried %d balances in %v\n", len(accounts), time.Since(startQuery))
+	accounts, report, err := dbgen.FetchBalances(ctx, rpcClient, addresses, block, cfg)
+	if err != nil {
+		cp.Close()
+		log.Fatalf("Balance queries aborted: %v (%d/%d accounts checkpointed; rerun to resume)",
+			err, cp.Done(), len(addresses))
+	}
+	log.Printf("Queried %d balances in %v (%d resumed, %d batch requests, %d retries)\n",
+		report.Fetched, time.Since(startQuery), report.Resumed, report.Batches, report.Retries)
+	checkFetchReport(report)
 
-	// Sort accounts by address (deterministic ordering)
-	log.Println("Sorting accounts by address...")
-	sort.Slice(accounts, func(i, j int) bool {
-		return accounts[i].Address.Hex() < accounts[j].Address.Hex()
-	})
+	return accounts, dbgen.SourceInfo{Source: "rpc", Block: block, Accounts: len(accounts)}
+}
 
+// writeIndexDatabase writes database.bin in address order with the maps
+// clients use to find an account's index
+func writeIndexDatabase(accounts []dbgen.AccountData) {
 	// Write database.bin (8 bytes per account)
 	log.Println("Writing database.bin...")
-	if err := writeDatabaseBin(accounts); err != nil {
+	if err := dbgen.WriteDatabaseFile(DatabasePath, accounts); err != nil {
 		log.Fatalf("Failed to write database.bin: %v", err)
 	}
 
 	// Write address-mapping.bin (20 bytes address + 4 bytes index)
 	log.Println("Writing address-mapping.bin...")
-	if err := writeAddressMapping(accounts); err != nil {
+	if err := dbgen.WriteAddressMappingFile(AddressMappingPath, accounts); err != nil {
 		log.Fatalf("Failed to write address-mapping.bin: %v", err)
 	}
 
-	// Verify output
-	verifyOutput()
-
-	log.Println()
-	log.Println("✅ Database generation complete!")
-	log.Printf("Total time: %v\n", time.Since(startGen))
+	// Write address-index.bin (compact lookup clients download instead)
+	log.Println("Writing address-index.bin...")
+	if err := dbgen.WriteAddressIndexFile(AddressIndexPath, accounts); err != nil {
+		log.Fatalf("Failed to write address-index.bin: %v", err)
+	}
+	// Clients switch to keyword lookups when keyword-params.json exists
+	if err := os.Remove(KeywordParamsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️  Could not remove stale keyword-params.json: %v\n", err)
+	}
 }
 
-// waitForAnvil waits for Anvil to be ready
-func waitForAnvil(client *ethclient.Client) {
-	ctx := context.Background()
-	for i := 0; i < 30; i++ {
-		_, err := client.BlockNumber(ctx)
-		if err == nil {
-			return
-		}
-		log.Printf("Waiting for Anvil to be ready... (%d/30)\n", i+1)
-		time.Sleep(2 * time.Second)
+// writeKeywordDatabase writes database.bin as a cuckoo table plus its public
+// parameters. address-mapping.bin still records where each balance went, for
+// incremental mode; it is not needed by clients and need not be published.
+func writeKeywordDatabase(accounts []dbgen.AccountData) {
+	log.Printf("Building cuckoo table (%d accounts, %d buckets)...\n", len(accounts), KeywordBuckets)
+	startBuild := time.Now()
+	table, err := dbgen.BuildKeywordTable(accounts, KeywordBuckets)
+	if err != nil {
+		log.Fatalf("Failed to build keyword table: %v", err)
+	}
+	log.Printf("Built cuckoo table in %v\n", time.Since(startBuild))
+
+	log.Println("Writing database.bin...")
+	if err := table.WriteDatabaseFile(DatabasePath); err != nil {
+		log.Fatalf("Failed to write database.bin: %v", err)
+	}
+	log.Println("Writing keyword-params.json...")
+	if err := dbgen.WriteKeywordParamsFile(KeywordParamsPath, table.Params); err != nil {
+		log.Fatalf("Failed to write keyword-params.json: %v", err)
+	}
+	log.Println("Writing address-mapping.bin (server-side only)...")
+	if err := table.WriteAddressMappingFile(AddressMappingPath, accounts); err != nil {
+		log.Fatalf("Failed to write address-mapping.bin: %v", err)
+	}
+	// An index from an earlier index-layout run would point at wrong entries
+	if err := os.Remove(AddressIndexPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️  Could not remove stale address-index.bin: %v\n", err)
 	}
-	log.Fatal("Anvil did not become ready in time")
 }
 
-// generateAnvilAddresses generates Anvil account addresses deterministically
-// Anvil uses derivation path m/44'/60'/0'/0/i for account i
-func generateAnvilAddresses(count int) []common.Address {
-	addresses := make([]common.Address, count)
+// generateTokenTable writes the token-balances table for the tokens in
+// TOKENS at the block recorded in database-source.json, so both tables
+// describe the same block. An existing table is kept.
+func generateTokenTable(ctx context.Context) {
+	tokens, err := dbgen.ParseTokens(os.Getenv(TokensEnv))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", TokensEnv, err)
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	if _, err := os.Stat(TokenDatabasePath); err == nil {
+		log.Println("✓ Token table already exists at", TokenTableDir)
+		return
+	}
+	source, err := dbgen.ReadSourceFile(SourcePath)
+	if err != nil {
+		log.Fatalf("Token table needs %s: %v", SourcePath, err)
+	}
+
+	rpcClient, client := connectToAnvil(ctx)
+	defer rpcClient.Close()
 
-	// Anvil generates sequential addresses starting from a base
-	// For PoC, we'll query Anvil directly for its first account,
-	// then generate sequential addresses from there
-	// In production, use proper BIP-39/BIP-44 derivation from mnemonic
+	log.Printf("Replaying Transfer events of %d tokens up to block %d...\n", len(tokens), source.Block)
+	start := time.Now()
+	balances, err := dbgen.FetchTokenBalances(ctx, client, tokens, source.Block, LogRange)
+	if err != nil {
+		log.Fatalf("Failed to read token balances: %v", err)
+	}
+	log.Printf("Found %d (holder, token) balances in %v\n", len(balances), time.Since(start))
+
+	if err := os.MkdirAll(TokenTableDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", TokenTableDir, err)
+	}
+	if err := dbgen.WriteTokenMappingFile(TokenMappingPath, balances); err != nil {
+		log.Fatalf("Failed to write token-mapping.bin: %v", err)
+	}
+	if err := dbgen.WriteTokenInfoFile(TokenInfoPath, dbgen.TokenTableInfo{Tokens: tokens, Pairs: len(balances)}); err != nil {
+		log.Fatalf("Failed to write tokens.json: %v", err)
+	}
+	if err := dbgen.WriteTableEpochFile(TokenEpochPath, dbgen.TableEpoch{LastBlock: source.Block}); err != nil {
+		log.Fatalf("Failed to write token table epoch.json: %v", err)
+	}
+	// database.bin last: its presence marks a complete table
+	if err := dbgen.WriteTokenDatabaseFile(TokenDatabasePath, balances); err != nil {
+		log.Fatalf("Failed to write token database.bin: %v", err)
+	}
+	log.Printf("✅ Token table: %d entries in %s\n", len(balances), TokenTableDir)
+}
 
-	// Simple sequential address generation for PoC
-	// This creates deterministic, sortable addresses for testing
-	baseAddr := common.HexToAddress("0x1000000000000000000000000000000000000000")
+// generateProofTable writes the state-proofs table when STATE_PROOFS=true:
+// account proofs for the accounts of address-mapping.bin, in database order,
+// at the block recorded in database-source.json. An existing table is kept.
+func generateProofTable(ctx context.Context) {
+	if os.Getenv(StateProofsEnv) != "true" {
+		return
+	}
+	if _, err := os.Stat(ProofDatabasePath); err == nil {
+		log.Println("✓ State proofs table already exists at", ProofTableDir)
+		return
+	}
+	source, err := dbgen.ReadSourceFile(SourcePath)
+	if err != nil {
+		log.Fatalf("State proofs need %s: %v", SourcePath, err)
+	}
+	f, err := os.Open(AddressMappingPath)
+	if err != nil {
+		log.Fatalf("State proofs need the index layout's address-mapping.bin: %v", err)
+	}
+	addresses, err := dbgen.ReadMappingAddresses(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", AddressMappingPath, err)
+	}
 
-	for i := 0; i < count; i++ {
-		// Generate sequential addresses by adding index to base
-		addr := common.BigToAddress(new(big.Int).Add(baseAddr.Big(), big.NewInt(int64(i))))
-		addresses[i] = addr
+	rpcClient, _ := connectToAnvil(ctx)
+	defer rpcClient.Close()
 
-		if (i+1)%1000000 == 0 {
-			log.Printf("  Generated %d/%d addresses...\n", i+1, count)
-		}
+	log.Printf("Fetching account proofs of %d accounts at block %d...\n", len(addresses), source.Block)
+	start := time.Now()
+	cfg := fetchConfig()
+	cfg.BatchSize = ProofBatchSize
+	proofs, root, err := dbgen.FetchAccountProofs(ctx, rpcClient, addresses, source.Block, cfg)
+	if err != nil {
+		log.Fatalf("Failed to read account proofs: %v", err)
 	}
+	if source.StateRoot != nil && *source.StateRoot != root {
+		log.Fatalf("Account proofs start at %s, block %d has state root %s", root.Hex(), source.Block, source.StateRoot.Hex())
+	}
+	log.Printf("Fetched %d proofs in %v (state root %s)\n", len(proofs), time.Since(start), root.Hex())
 
-	return addresses
+	info, err := dbgen.WriteProofTable(ProofTableDir, proofs, source.Block, root)
+	if err != nil {
+		log.Fatalf("Failed to write state proofs table: %v", err)
+	}
+	log.Printf("✅ State proofs table: %d accounts x %d entries in %s\n", info.Accounts, info.RecordWords, ProofTableDir)
 }
 
-// queryBalancesConcurrent queries account balances with high concurrency
-func queryBalancesConcurrent(client *ethclient.Client, addresses []common.Address) []AccountData {
-	accounts := make([]AccountData, len(addresses))
-
-	// Worker pool
-	jobs := make(chan int, len(addresses))
-	var wg sync.WaitGroup
-
-	// Progress tracking
-	var processed int64
-	var mu sync.Mutex
-
-	// Start workers
-	for w := 0; w < ConcurrentWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ctx := context.Background()
-
-			for i := range jobs {
-				balance, err := client.BalanceAt(ctx, addresses[i], nil)
-				if err != nil {
-					log.Printf("Error querying balance for %s: %v\n", addresses[i].Hex(), err)
-					balance = big.NewInt(0)
-				}
-
-				accounts[i] = AccountData{
-					Address: addresses[i],
-					Balance: balance,
-				}
-
-				// Progress reporting
-				mu.Lock()
-				processed++
-				if processed%BatchSize == 0 {
-					log.Printf("  Processed %d/%d accounts (%.1f%%)\n",
-						processed, len(addresses),
-						float64(processed)/float64(len(addresses))*100)
-				}
-				mu.Unlock()
-			}
-		}()
+// runIncremental refreshes an existing database.bin from the block recorded
+// in database-source.json to the head (or BALANCE_BLOCK), fetching only the
+// accounts touched in between, and writes the changed entries as an update set
+func runIncremental(ctx context.Context) {
+	source, err := dbgen.ReadSourceFile(SourcePath)
+	if err != nil {
+		log.Fatalf("Incremental mode needs %s from an earlier run: %v", SourcePath, err)
 	}
 
-	// Send jobs
-	for i := range addresses {
-		jobs <- i
+	rpcClient, client := connectToAnvil(ctx)
+	defer rpcClient.Close()
+
+	to, _ := targetBlock(ctx, client)
+	if to == source.Block {
+		log.Printf("✓ Database already at block %d\n", to)
+		return
+	}
+	log.Printf("Refreshing database from block %d to %d...\n", source.Block, to)
+
+	start := time.Now()
+	set, report, err := dbgen.Refresh(ctx, client, rpcClient, DatabasePath, AddressMappingPath, source.Block, to, fetchConfig())
+	if report != nil && report.Fetch != nil {
+		checkFetchReport(report.Fetch)
+	}
+	if err != nil {
+		log.Fatalf("Incremental refresh failed: %v", err)
+	}
+	log.Printf("Scanned %d blocks: %d accounts touched, %d changed in %v\n",
+		report.Blocks, report.Touched, len(set.Updates), time.Since(start))
+	if len(report.Unknown) > 0 {
+		log.Printf("⚠️  %d touched accounts are not in the database and were ignored\n", len(report.Unknown))
 	}
-	close(jobs)
 
-	// Wait for completion
-	wg.Wait()
+	if err := os.MkdirAll(UpdatesDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", UpdatesDir, err)
+	}
+	updatePath := filepath.Join(UpdatesDir, fmt.Sprintf("update-%06d-%06d.bin", set.FromBlock, set.ToBlock))
+	if err := dbgen.WriteUpdateSetFile(updatePath, set); err != nil {
+		log.Fatalf("Failed to write update set: %v", err)
+	}
+	log.Printf("Wrote %s\n", updatePath)
 
-	return accounts
+	source.Block = to
+	if err := dbgen.WriteSourceFile(SourcePath, source); err != nil {
+		log.Fatalf("Failed to write %s: %v", SourcePath, err)
+	}
+	log.Println("✅ Incremental refresh complete!")
 }
 
-// writeDatabaseBin writes database.bin with 8-byte balance entries
-func writeDatabaseBin(accounts []AccountData) error {
-	f, err := os.Create(DatabasePath)
+// connectToAnvil dials the node and waits until it answers
+func connectToAnvil(ctx context.Context) (*rpc.Client, *ethclient.Client) {
+	rpcClient, err := rpc.DialContext(ctx, AnvilURL)
 	if err != nil {
-		return err
+		log.Fatalf("Failed to connect to Anvil: %v", err)
 	}
-	defer f.Close()
+	client := ethclient.NewClient(rpcClient)
+
+	log.Println("Connected to Anvil successfully")
 
-	// Write 8 bytes per account (uint64 balance in wei, truncated)
-	for _, acc := range accounts {
-		// Convert big.Int balance to uint64 (sufficient for PoC)
-		balance := acc.Balance.Uint64()
+	// Wait for Anvil to be ready
+	if err := dbgen.WaitForNode(ctx, client, 30, 2*time.Second); err != nil {
+		log.Fatalf("Anvil did not become ready in time: %v", err)
+	}
+	return rpcClient, client
+}
 
-		// Write as little-endian uint64
-		var buf [8]byte
-		binary.LittleEndian.PutUint64(buf[:], balance)
-		if _, err := f.Write(buf[:]); err != nil {
-			return err
+// targetBlock returns BALANCE_BLOCK if set (pinned), else the current head
+func targetBlock(ctx context.Context, client *ethclient.Client) (block uint64, pinned bool) {
+	if v := os.Getenv(BalanceBlockEnv); v != "" {
+		block, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			log.Fatalf("Invalid %s %q: %v", BalanceBlockEnv, v, err)
 		}
+		return block, true
+	}
+	block, err := client.BlockNumber(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get block number: %v", err)
 	}
+	return block, false
+}
 
-	return nil
+func fetchConfig() dbgen.FetchConfig {
+	cfg := dbgen.DefaultFetchConfig()
+	cfg.BatchSize = RPCBatchSize
+	cfg.Concurrency = RPCConcurrency
+	cfg.MaxAttempts = RPCMaxAttempts
+	cfg.ProgressEvery = ProgressInterval
+	return cfg
 }
 
-// writeAddressMapping writes address-mapping.bin with address→index mapping
-func writeAddressMapping(accounts []AccountData) error {
-	f, err := os.Create(AddressMappingPath)
+// checkFetchReport writes the report and exits non-zero if any balance is
+// missing
+func checkFetchReport(report *dbgen.FetchReport) {
+	if err := report.WriteFile(FetchReportPath); err != nil {
+		log.Printf("⚠️  Could not write %s: %v\n", FetchReportPath, err)
+	}
+	if err := report.Err(); err != nil {
+		for i, f := range report.Failures {
+			if i == 10 {
+				log.Printf("  ... and %d more (see %s)\n", len(report.Failures)-i, FetchReportPath)
+				break
+			}
+			log.Printf("  %s: %s (%d attempts)\n", f.Address.Hex(), f.Error, f.Attempts)
+		}
+		log.Fatalf("❌ %v; not writing the database", err)
+	}
+}
+
+// importStateDump reads the accounts from a state dump file
+func importStateDump(path string) ([]dbgen.AccountData, dbgen.SourceInfo) {
+	log.Printf("Importing state dump %s...\n", path)
+	startImport := time.Now()
+
+	var block *uint64
+	if v := os.Getenv(StateDumpBlockEnv); v != "" {
+		n, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			log.Fatalf("Invalid %s %q: %v", StateDumpBlockEnv, v, err)
+		}
+		block = &n
+	}
+
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		log.Fatalf("Failed to open state dump: %v", err)
 	}
 	defer f.Close()
 
-	// Write 24 bytes per account (20 bytes address + 4 bytes index)
-	for i, acc := range accounts {
-		// Write address (20 bytes)
-		if _, err := f.Write(acc.Address.Bytes()); err != nil {
-			return err
-		}
+	accounts, info, err := dbgen.ImportStateDump(f, block)
+	if errors.Is(err, dbgen.ErrNoSourceBlock) {
+		log.Fatalf("%v; set %s", err, StateDumpBlockEnv)
+	}
+	if err != nil {
+		log.Fatalf("Failed to import state dump: %v", err)
+	}
+	log.Printf("Imported %d accounts (%s, block %d) in %v\n",
+		info.Accounts, info.Format, info.Block, time.Since(startImport))
+	if info.Skipped > 0 {
+		log.Printf("⚠️  Skipped %d entries without an address\n", info.Skipped)
+	}
 
-		// Write index (4 bytes, little-endian)
-		var buf [4]byte
-		binary.LittleEndian.PutUint32(buf[:], uint32(i))
-		if _, err := f.Write(buf[:]); err != nil {
-			return err
-		}
+	source := dbgen.SourceInfo{
+		Source:   "state-dump",
+		Format:   info.Format,
+		Block:    info.Block,
+		Accounts: info.Accounts,
+		Skipped:  info.Skipped,
+	}
+	if info.StateRoot != (common.Hash{}) {
+		source.StateRoot = &info.StateRoot
+	}
+	return accounts, source
+}
+
+// generateAnvilAddresses derives the first count accounts of the configured
+// mnemonic along m/44'/60'/0'/0/i, the accounts Anvil funds, on all cores
+func generateAnvilAddresses(count int) []common.Address {
+	mnemonic := envOr(MnemonicEnv, AnvilMnemonic)
+	path := envOr(DerivationPathEnv, dbgen.DefaultDerivationPath)
+	log.Printf("Deriving %s/i for i < %d on %d cores\n", path, count, runtime.NumCPU())
+
+	addresses, err := dbgen.DeriveAddresses(mnemonic, os.Getenv(PassphraseEnv), path, count, runtime.NumCPU())
+	if err != nil {
+		log.Fatalf("Failed to derive addresses: %v", err)
 	}
+	return addresses
+}
 
-	return nil
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
 }
 
 // verifyOutput checks file sizes match expected values
-func verifyOutput() {
+func verifyOutput(count, entries int, addressIndex bool) {
 	// Check database.bin
 	dbInfo, err := os.Stat(DatabasePath)
 	if err != nil {
 		log.Printf("⚠️  Could not stat database.bin: %v\n", err)
 	} else {
-		expectedDB := int64(TotalAccounts * 8)
+		expectedDB := int64(entries * dbgen.DBEntrySize)
 		if dbInfo.Size() == expectedDB {
 			log.Printf("✅ database.bin: %d bytes (expected %d)\n", dbInfo.Size(), expectedDB)
 		} else {
@@ -265,11 +574,21 @@ func verifyOutput() {
 	if err != nil {
 		log.Printf("⚠️  Could not stat address-mapping.bin: %v\n", err)
 	} else {
-		expectedMap := int64(TotalAccounts * 24)
+		expectedMap := int64(count * dbgen.AddressMappingEntrySize)
 		if mapInfo.Size() == expectedMap {
 			log.Printf("✅ address-mapping.bin: %d bytes (expected %d)\n", mapInfo.Size(), expectedMap)
 		} else {
 			log.Printf("❌ address-mapping.bin: %d bytes (expected %d)\n", mapInfo.Size(), expectedMap)
 		}
 	}
+
+	// address-index.bin size depends on collisions; report it
+	if !addressIndex {
+		return
+	}
+	if idxInfo, err := os.Stat(AddressIndexPath); err != nil {
+		log.Printf("⚠️  Could not stat address-index.bin: %v\n", err)
+	} else {
+		log.Printf("✅ address-index.bin: %d bytes (%.2f per account)\n", idxInfo.Size(), float64(idxInfo.Size())/float64(max(count, 1)))
+	}
 }
@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"math/rand"
+	"testing"
+
+	plinkoclient "plinko-client"
+)
+
+// benchAccounts is large enough for server work to dominate the
+// round trip, and small enough to build an LWE hint in a second or two
+const benchAccounts = 1 << 16
+
+// BenchmarkQuery compares the query modes on the same database, through
+// the HTTP API. Hint and query sizes are reported per mode.
+func BenchmarkQuery(b *testing.B) {
+	h := New(b, benchAccounts, testSeed)
+	lweHint := h.EnableLWE(b)
+	c := h.Client(b, testHintConfig(h))
+	pair := plinkoclient.NewDPFPair(h.PIR.URL, h.PIR.URL) // One server twice: timing only
+	ctx := contextFor(b)
+	rng := rand.New(rand.NewSource(5))
+	n := int(h.Header.DBSize)
+
+	b.Run("punctured", func(b *testing.B) {
+		b.ReportMetric(float64(h.Entries()*plinkoclient.EntrySize), "hint-bytes")
+		b.ReportMetric(float64(8*(h.Header.SetSize-1)), "query-bytes")
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Query(ctx, uint64(rng.Intn(n))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("lwe", func(b *testing.B) {
+		b.ReportMetric(float64(4*lweHint.Rows*lweHint.Dimension), "hint-bytes")
+		b.ReportMetric(float64(4*lweHint.Cols), "query-bytes")
+		for i := 0; i < b.N; i++ {
+			if _, err := c.QueryLWE(ctx, lweHint, uint64(rng.Intn(n))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("dpf", func(b *testing.B) {
+		b.ReportMetric(0, "hint-bytes")
+		b.ReportMetric(float64(2+16+18*int(plinkoclient.DPFDomainBits(h.Entries()))), "query-bytes")
+		for i := 0; i < b.N; i++ {
+			if _, _, err := pair.Query(ctx, uint64(rng.Intn(n))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,605 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"piano-pir-db-generator/dbgen"
+	"piano-pir-hint-generator/hintgen"
+	"piano-pir-server/pirserver"
+	plinkoclient "plinko-client"
+	"plinko-e2e/ethmock"
+	"plinko-update-service/plinko"
+	"plinko-update-service/updater"
+)
+
+// dialChain serves chain over JSON-RPC and dials it with ethclient, over
+// WebSocket when ws is set
+func dialChain(t testing.TB, chain *ethmock.Chain, ws bool) *ethclient.Client {
+	t.Helper()
+	rpcServer := ethmock.NewServer(chain)
+	srv := httptest.NewServer(rpcServer)
+	t.Cleanup(srv.Close)
+	t.Cleanup(rpcServer.Close)
+
+	url := srv.URL
+	if ws {
+		url = "ws" + strings.TrimPrefix(url, "http")
+	}
+	client, err := ethclient.Dial(url)
+	mustDo(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestDBGeneratorQueriesFakeChain(t *testing.T) {
+	chain := ethmock.NewChain()
+	addresses := make([]common.Address, 300)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		chain.SetBalance(addresses[i], big.NewInt(int64(i)*1e9))
+	}
+	chain.Mine()
+	// Changes after the pinned block must not leak into the snapshot
+	chain.SetBalance(addresses[5], big.NewInt(42))
+	chain.Mine()
+
+	client := dialChain(t, chain, false)
+	ctx := contextFor(t)
+	mustDo(t, dbgen.WaitForNode(ctx, client, 3, time.Millisecond))
+
+	cfg := dbgen.DefaultFetchConfig()
+	cfg.BatchSize, cfg.Concurrency = 32, 4
+	accounts, report, err := dbgen.FetchBalances(ctx, client.Client(), addresses, 1, cfg)
+	mustDo(t, err)
+	mustDo(t, report.Err())
+	if report.Batches != 10 || report.Retries != 0 {
+		t.Errorf("%d batches, %d retries; want 10 and 0", report.Batches, report.Retries)
+	}
+	for i, acc := range accounts {
+		want, err := chain.BalanceAt(ctx, addresses[i], big.NewInt(1))
+		mustDo(t, err)
+		if acc.Address != addresses[i] || acc.Balance.Cmp(want) != 0 {
+			t.Fatalf("account %d = %s %s, want %s %s", i, acc.Address.Hex(), acc.Balance, addresses[i].Hex(), want)
+		}
+	}
+	if accounts[5].Balance.Int64() != 5e9 {
+		t.Fatalf("account 5 balance %s, want block 1 value 5e9", accounts[5].Balance)
+	}
+
+	// A block the node does not have fails every account instead of
+	// silently storing zeros
+	cfg.MaxAttempts, cfg.InitialBackoff = 2, time.Millisecond
+	_, report, err = dbgen.FetchBalances(ctx, client.Client(), addresses[:10], 99, cfg)
+	mustDo(t, err)
+	if len(report.Failures) != 10 || report.Err() == nil {
+		t.Fatalf("%d failures at a missing block, want 10", len(report.Failures))
+	}
+}
+
+func TestDBGeneratorIncrementalRefresh(t *testing.T) {
+	chain := ethmock.NewChain()
+	keys := make([]*ecdsa.PrivateKey, 2)
+	addresses := make([]common.Address, 20)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+	}
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		mustDo(t, err)
+		keys[i] = key
+		addresses[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	for i, addr := range addresses {
+		chain.SetBalance(addr, big.NewInt(int64(i+1)*1e15))
+	}
+	chain.Mine()
+
+	client := dialChain(t, chain, false)
+	ctx := contextFor(t)
+	cfg := dbgen.DefaultFetchConfig()
+	cfg.ProgressEvery = 0
+
+	// Full run at block 1
+	accounts, report, err := dbgen.FetchBalances(ctx, client.Client(), addresses, 1, cfg)
+	mustDo(t, err)
+	mustDo(t, report.Err())
+	dbgen.SortAccounts(accounts)
+	dir := t.TempDir()
+	dbPath, mappingPath := filepath.Join(dir, "database.bin"), filepath.Join(dir, "address-mapping.bin")
+	mustDo(t, dbgen.WriteDatabaseFile(dbPath, accounts))
+	mustDo(t, dbgen.WriteAddressMappingFile(mappingPath, accounts))
+
+	// Two transfers, one to an account outside the database, then quiet blocks
+	newcomer := common.HexToAddress("0x00000000000000000000000000000000000000ff")
+	_, err = chain.Transfer(keys[0], addresses[5], big.NewInt(3e14))
+	mustDo(t, err)
+	chain.Mine()
+	_, err = chain.Transfer(keys[1], newcomer, big.NewInt(1e14))
+	mustDo(t, err)
+	chain.Mine()
+	head := chain.MineEmpty(2)
+
+	set, refresh, err := dbgen.Refresh(ctx, client, client.Client(), dbPath, mappingPath, 1, head, cfg)
+	mustDo(t, err)
+	if refresh.Blocks != 4 || refresh.Touched != 4 || len(refresh.Unknown) != 1 || refresh.Unknown[0] != newcomer {
+		t.Fatalf("refresh report %+v, want 4 blocks, 4 touched, unknown %s", refresh, newcomer.Hex())
+	}
+	if set.FromBlock != 1 || set.ToBlock != head || len(set.Updates) != 3 {
+		t.Fatalf("update set %d..%d with %d updates, want 1..%d with 3", set.FromBlock, set.ToBlock, len(set.Updates), head)
+	}
+
+	// database.bin now matches the head state, and the update set describes
+	// exactly the entries that moved
+	db, err := os.ReadFile(dbPath)
+	mustDo(t, err)
+	changed := make(map[uint64]dbgen.Update)
+	for _, u := range set.Updates {
+		changed[u.Index] = u
+	}
+	for i, acc := range accounts {
+		want, err := chain.BalanceAt(ctx, acc.Address, nil)
+		mustDo(t, err)
+		got := binary.LittleEndian.Uint64(db[i*dbgen.DBEntrySize:])
+		if got != want.Uint64() {
+			t.Fatalf("entry %d (%s) = %d, want %s", i, acc.Address.Hex(), got, want)
+		}
+		if u, ok := changed[uint64(i)]; ok != (acc.Balance.Uint64() != got) ||
+			ok && (u.Address != acc.Address || u.OldValue != acc.Balance.Uint64() || u.NewValue != got) {
+			t.Fatalf("entry %d: update %+v for %d -> %d", i, u, acc.Balance.Uint64(), got)
+		}
+	}
+}
+
+func TestUpdateServiceFollowsFakeChain(t *testing.T) {
+	const blocks = 3
+
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	chain := ethmock.NewChain()
+	chain.MineEmpty(blocks)
+
+	// plinko-update-service against the fake chain over WebSocket, as in
+	// docker-compose
+	database, hdr, err := plinko.LoadDatabase(h.path("hint.bin"))
+	mustDo(t, err)
+	pm := plinko.NewPlinkoUpdateManager(database, hdr.ChunkSize, hdr.SetSize)
+	pm.EnableCacheMode()
+	svc := updater.New(dialChain(t, chain, true), database, pm, updater.Config{
+		DeltaDir:        h.path("deltas"),
+		PollInterval:    time.Millisecond,
+		SimulateChanges: true,
+		ChangesPerBlock: 64,
+		DBSize:          hdr.DBSize,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.Run(ctx)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for svc.BlockHeight() < blocks && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if svc.BlockHeight() != blocks || svc.DeltasGenerated() != blocks {
+		t.Fatalf("update service at block %d with %d deltas, want %d", svc.BlockHeight(), svc.DeltasGenerated(), blocks)
+	}
+
+	if n := h.SyncServer(t, blocks, database); n != blocks*64 {
+		t.Fatalf("%d entries changed, want %d", n, blocks*64)
+	}
+	if _, err := c.SyncDeltas(contextFor(t), blocks); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+
+	// Block b changes entries b*64 .. b*64+63 to b*1000+i
+	for b := uint64(1); b <= blocks; b++ {
+		for _, i := range []uint64{0, 31, 63} {
+			idx := b*64 + i
+			got, err := c.Query(contextFor(t), idx)
+			if err != nil {
+				t.Fatalf("Query(%d): %v", idx, err)
+			}
+			if want := b*1000 + i; got != want {
+				t.Fatalf("Query(%d) = %d, want %d", idx, got, want)
+			}
+		}
+	}
+}
+
+func TestUpdateServiceInsertsAccountsAndGrows(t *testing.T) {
+	const (
+		accounts = 4000 // 128 x 32 = 4096 slots, 96 of them padding
+		perBlock = 40   // Blocks 1 and 2 fit, block 3 does not
+	)
+	h := New(t, accounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+	chain := ethmock.NewChain()
+	chain.MineEmpty(3)
+
+	st, err := updater.LoadState(h.Dir, true)
+	mustDo(t, err)
+	if st.Book == nil || st.Book.Free() != 96 {
+		t.Fatalf("address book %+v, want 96 free slots", st.Book)
+	}
+	svc := updater.New(dialChain(t, chain, false), st.Database, st.Manager, updater.Config{
+		DeltaDir:            h.path("deltas"),
+		NewAccountsPerBlock: perBlock,
+	})
+	// plinko-hint-generator in HINT_MODE=watch, called directly
+	svc.EnableInsertion(st.Book, h.path("address-additions.bin"), &updater.FileGrower{
+		DataDir: h.Dir,
+		Regenerate: func(context.Context) error {
+			_, err := hintgen.Regenerate(h.path("regenerate.json"), h.path("database.bin"), h.path("hint.bin"))
+			return err
+		},
+	})
+
+	// Blocks 1-2: new accounts take padding slots 4000-4079
+	for b := uint64(1); b <= 2; b++ {
+		mustDo(t, svc.ProcessBlock(ctx, b))
+	}
+	if n := h.SyncServer(t, 2, svc.Database()); n != 2*perBlock {
+		t.Fatalf("%d entries changed, want %d", n, 2*perBlock)
+	}
+	if _, err := c.SyncDeltas(ctx, 2); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+	for b := uint64(1); b <= 2; b++ {
+		added, err := c.FetchAdditions(ctx, b)
+		mustDo(t, err)
+		if len(added) != perBlock {
+			t.Fatalf("block %d: %d additions, want %d", b, len(added), perBlock)
+		}
+		for i, e := range added {
+			wantIdx := accounts + (b-1)*perBlock + uint64(i)
+			if e.Address != plinkoclient.Address(updater.SimulatedAddress(b, i)) || e.Index != wantIdx {
+				t.Fatalf("block %d addition %d = %v@%d, want %d", b, i, e.Address, e.Index, wantIdx)
+			}
+			if got, err := c.Query(ctx, e.Index); err != nil || got != b*1000+uint64(i) {
+				t.Fatalf("Query(%d) = %d, %v; want %d", e.Index, got, err, b*1000+uint64(i))
+			}
+		}
+	}
+
+	// Block 3 needs 40 slots with 16 left: epoch 1 with room to spare
+	mustDo(t, svc.ProcessBlock(ctx, 3))
+	if svc.Epoch() != 1 {
+		t.Fatalf("epoch %d after growth, want 1", svc.Epoch())
+	}
+	if _, err := os.Stat(h.path("regenerate.json")); !os.IsNotExist(err) {
+		t.Errorf("regenerate.json still present: %v", err)
+	}
+	h.Reload(t)
+	if want := uint64(accounts + 3*perBlock); h.Header.DBSize != want || h.Header.Epoch != 1 {
+		t.Fatalf("regenerated header %+v, want %d entries at epoch 1", h.Header, want)
+	}
+	if n := h.SyncServer(t, 3, svc.Database()); n != perBlock {
+		t.Fatalf("%d entries changed in block 3, want %d", n, perBlock)
+	}
+
+	// Epoch 0 hints end at block 2
+	if _, err := c.SyncDeltas(ctx, 3); !errors.Is(err, plinkoclient.ErrEpochMismatch) {
+		t.Fatalf("epoch 0 SyncDeltas past the growth: %v, want ErrEpochMismatch", err)
+	}
+	if c.Hints().LastBlock != 2 {
+		t.Fatalf("epoch 0 hints at block %d, want 2", c.Hints().LastBlock)
+	}
+
+	// Epoch 1 hints start after block 2 and find every account in the
+	// extended address-mapping.bin
+	c = h.Client(t, testHintConfig(h))
+	info, err := c.FetchEpochInfo(ctx)
+	mustDo(t, err)
+	if info == nil || *info != (plinkoclient.EpochInfo{Epoch: 1, LastBlock: 2}) {
+		t.Fatalf("epoch.json = %+v, want epoch 1 after block 2", info)
+	}
+	c.Hints().LastBlock = info.LastBlock
+	if _, err := c.SyncDeltas(ctx, 3); err != nil {
+		t.Fatalf("epoch 1 SyncDeltas: %v", err)
+	}
+	for b := uint64(1); b <= 3; b++ {
+		for _, i := range []int{0, perBlock - 1} {
+			f, err := os.Open(h.path("address-mapping.bin"))
+			mustDo(t, err)
+			idx, err := plinkoclient.LookupAddress(f, plinkoclient.Address(updater.SimulatedAddress(b, i)))
+			f.Close()
+			mustDo(t, err)
+			if got, err := c.Query(ctx, idx); err != nil || got != b*1000+uint64(i) {
+				t.Fatalf("block %d account %d: Query(%d) = %d, %v; want %d", b, i, idx, got, err, b*1000+uint64(i))
+			}
+		}
+	}
+	for _, idx := range []uint64{0, accounts - 1} {
+		if got, err := c.Query(ctx, idx); err != nil || got != h.Value(idx) {
+			t.Fatalf("Query(%d) = %d, %v; want %d", idx, got, err, h.Value(idx))
+		}
+	}
+}
+
+func TestUpdateServiceTracksTokenTransfers(t *testing.T) {
+	const tableName = "token-balances"
+
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+	chain := ethmock.NewChain()
+	client := dialChain(t, chain, false)
+
+	tokens := []dbgen.Token{
+		{Address: common.HexToAddress("0x00000000000000000000000000000000000000aa"), Symbol: "USDC"},
+		{Address: common.HexToAddress("0x00000000000000000000000000000000000000bb"), Symbol: "DAI"},
+	}
+	holders := make([]common.Address, 20)
+	for i := range holders {
+		holders[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+	}
+	type pair struct{ holder, token common.Address }
+	want := make(map[pair]uint64)
+	transfer := func(token, from, to common.Address, value uint64) {
+		chain.TransferToken(token, from, to, new(big.Int).SetUint64(value))
+		if from != (common.Address{}) {
+			want[pair{from, token}] -= value
+		}
+		if to != (common.Address{}) {
+			want[pair{to, token}] += value
+		}
+	}
+
+	// Block 1 mints USDC to every holder and DAI to the first half; block 2
+	// moves some of it around
+	for i, holder := range holders {
+		transfer(tokens[0].Address, common.Address{}, holder, uint64(i+1)*1e6)
+		if i < len(holders)/2 {
+			transfer(tokens[1].Address, common.Address{}, holder, uint64(i+1)*1e18)
+		}
+	}
+	chain.Mine()
+	transfer(tokens[0].Address, holders[0], holders[1], 5e5)
+	transfer(tokens[1].Address, holders[2], holders[15], 1e18) // New DAI holder
+	chain.Mine()
+
+	// db-generator with TOKENS, pinned to block 2
+	dir := h.path(filepath.Join("tables", tableName))
+	mustDo(t, os.MkdirAll(filepath.Join(dir, "deltas"), 0755))
+	balances, err := dbgen.FetchTokenBalances(ctx, client, tokens, 2, 1)
+	mustDo(t, err)
+	if len(balances) != len(holders)+len(holders)/2+1 {
+		t.Fatalf("%d token balances at block 2, want %d", len(balances), len(holders)+len(holders)/2+1)
+	}
+	mustDo(t, dbgen.WriteTokenMappingFile(filepath.Join(dir, "token-mapping.bin"), balances))
+	mustDo(t, dbgen.WriteTokenInfoFile(filepath.Join(dir, "tokens.json"), dbgen.TokenTableInfo{Tokens: tokens, Pairs: len(balances)}))
+	mustDo(t, dbgen.WriteTableEpochFile(filepath.Join(dir, "epoch.json"), dbgen.TableEpoch{LastBlock: 2}))
+	mustDo(t, dbgen.WriteTokenDatabaseFile(filepath.Join(dir, "database.bin"), balances))
+
+	// plinko-hint-generator, then the PIR server mounts the table
+	_, _, _, err = hintgen.WriteTableHint(filepath.Join(dir, "database.bin"), filepath.Join(dir, "hint.bin"))
+	mustDo(t, err)
+	table, err := pirserver.LoadTable(pirserver.TableConfig{Name: tableName, Dir: dir})
+	mustDo(t, err)
+	mustDo(t, h.Server.MountTable(tableName, table))
+	if table.LastBlock() != 2 {
+		t.Fatalf("table feed starts after block %d, want 2", table.LastBlock())
+	}
+
+	// plinko-update-service follows the chain from block 2 on
+	tt, err := updater.LoadTokenTable(dir, true)
+	mustDo(t, err)
+	st, err := updater.LoadState(h.Dir, true)
+	mustDo(t, err)
+	svc := updater.New(client, st.Database, st.Manager, updater.Config{
+		DeltaDir:   h.path("deltas"),
+		StartBlock: 2,
+	})
+	svc.EnableTokens(client, tt)
+
+	// Block 3 creates two pairs and drains one, block 4 burns, block 5 is
+	// empty
+	newcomer := common.HexToAddress("0x00000000000000000000000000000000000000ff")
+	transfer(tokens[0].Address, holders[3], newcomer, 4e6)
+	transfer(tokens[1].Address, holders[4], holders[19], 5e18)
+	chain.Mine()
+	transfer(tokens[0].Address, holders[5], common.Address{}, 1e6)
+	chain.Mine()
+	head := chain.MineEmpty(1)
+	for b := uint64(3); b <= head; b++ {
+		mustDo(t, svc.ProcessBlock(ctx, b))
+	}
+	if got := tt.Book.Additions(); len(got) != 2 {
+		t.Fatalf("%d pairs added, want 2", len(got))
+	}
+	// The PIR server follows the table's own deltas/
+	if n, err := table.SyncDeltas(); n != int(head-2) || err != nil {
+		t.Fatalf("server applied %d token deltas, %v; want %d", n, err, head-2)
+	}
+	for idx, v := range tt.Database {
+		if got := table.DBAccess(uint64(idx))[0]; got != v {
+			t.Fatalf("server entry %d = %d, update service has %d", idx, got, v)
+		}
+	}
+
+	// The client syncs the table like the account database and finds pairs
+	// in token-mapping.bin plus the published additions
+	root := plinkoclient.New(h.PIR.URL, h.CDN.URL)
+	tables, err := root.Tables(ctx)
+	mustDo(t, err)
+	if len(tables) != 1 || tables[0].Name != tableName || tables[0].LastBlock != head {
+		t.Fatalf("/v1/tables = %+v, want %s at block %d", tables, tableName, head)
+	}
+	c := root.Table(tableName)
+	hintPath := filepath.Join(t.TempDir(), "hint.bin")
+	hdr, err := c.DownloadHint(ctx, hintPath, nil)
+	mustDo(t, err)
+	cfg := plinkoclient.DefaultHintConfig(hdr)
+	cfg.PrimaryHints = int(16 * hdr.ChunkSize)
+	cfg.Rand = rand.New(rand.NewSource(testSeed))
+	hints, err := plinkoclient.BuildHintsFromFile(hintPath, cfg)
+	mustDo(t, err)
+	c.SetHints(hints)
+	info, err := c.FetchEpochInfo(ctx)
+	mustDo(t, err)
+	if info == nil || info.LastBlock != 2 {
+		t.Fatalf("table epoch.json = %+v, want last block 2", info)
+	}
+	c.Hints().LastBlock = info.LastBlock
+	if _, err := c.SyncDeltas(ctx, head); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+	tokenInfo, err := c.FetchTokenInfo(ctx)
+	mustDo(t, err)
+	if len(tokenInfo.Tokens) != 2 || tokenInfo.Tokens[0].Symbol != "USDC" {
+		t.Fatalf("tokens.json = %+v", tokenInfo)
+	}
+
+	mappingPath := filepath.Join(t.TempDir(), "token-mapping.bin")
+	mustDo(t, c.DownloadTokenMapping(ctx, mappingPath))
+	mapping, err := os.ReadFile(mappingPath)
+	mustDo(t, err)
+	for b := uint64(3); b <= head; b++ {
+		added, err := c.FetchTokenAdditions(ctx, b)
+		mustDo(t, err)
+		if (b == 3) != (len(added) == 2) || b != 3 && len(added) != 0 {
+			t.Fatalf("block %d: %d additions", b, len(added))
+		}
+		var buf bytes.Buffer
+		mustDo(t, plinkoclient.WriteTokenMappingEntries(&buf, added))
+		mapping = append(mapping, buf.Bytes()...)
+	}
+
+	for _, holder := range append(holders, newcomer) {
+		found, err := plinkoclient.LookupHolderTokens(bytes.NewReader(mapping), plinkoclient.Address(holder))
+		mustDo(t, err)
+		var indices []uint64
+		var pairs []pair
+		for _, tok := range tokens {
+			if idx, ok := found[plinkoclient.Address(tok.Address)]; ok {
+				indices = append(indices, idx)
+				pairs = append(pairs, pair{holder, tok.Address})
+			} else if want[pair{holder, tok.Address}] != 0 {
+				t.Fatalf("%s holds %s but has no table entry", holder.Hex(), tok.Symbol)
+			}
+		}
+		got, err := c.QueryBatch(ctx, indices)
+		mustDo(t, err)
+		for i, p := range pairs {
+			if got[i] != want[p] {
+				t.Fatalf("%s %s balance = %d, want %d", p.holder.Hex(), p.token.Hex(), got[i], want[p])
+			}
+		}
+	}
+}
+
+// headerAt gives the header of a fixed block whatever number is asked for,
+// like a node on another fork
+type headerAt struct {
+	client *ethclient.Client
+	block  int64
+}
+
+func (h headerAt) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return h.client.HeaderByNumber(ctx, big.NewInt(h.block))
+}
+
+func TestStateProofsVerifyAgainstHeaders(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+	chain := ethmock.NewChain()
+	client := dialChain(t, chain, false)
+
+	// Balances past 2^64, which database.bin truncates but proofs carry whole
+	accounts := make([]dbgen.AccountData, 40)
+	for i := range accounts {
+		balance := new(big.Int).Mul(big.NewInt(int64(i+1)), big.NewInt(1e18))
+		balance.Mul(balance, big.NewInt(100))
+		accounts[i] = dbgen.AccountData{Address: common.BigToAddress(big.NewInt(int64(0x2000 + i))), Balance: balance}
+		chain.SetBalance(accounts[i].Address, balance)
+	}
+	chain.Mine()
+	chain.SetBalance(accounts[7].Address, big.NewInt(1)) // After the table's block
+	chain.Mine()
+
+	// db-generator with STATE_PROOFS, pinned to block 1: proofs for the
+	// accounts of address-mapping.bin, in database order
+	dbgen.SortAccounts(accounts)
+	var mapping bytes.Buffer
+	mustDo(t, dbgen.WriteAddressMapping(&mapping, accounts))
+	addresses, err := dbgen.ReadMappingAddresses(&mapping)
+	mustDo(t, err)
+	cfg := dbgen.DefaultFetchConfig()
+	cfg.BatchSize, cfg.Concurrency = 8, 2
+	proofs, stateRoot, err := dbgen.FetchAccountProofs(ctx, client.Client(), addresses, 1, cfg)
+	mustDo(t, err)
+	header, err := client.HeaderByNumber(ctx, big.NewInt(1))
+	mustDo(t, err)
+	if stateRoot != header.Root {
+		t.Fatalf("proofs start at %s, block 1 has state root %s", stateRoot.Hex(), header.Root.Hex())
+	}
+	dir := h.path(filepath.Join("tables", dbgen.ProofTableName))
+	written, err := dbgen.WriteProofTable(dir, proofs, 1, stateRoot)
+	mustDo(t, err)
+
+	// plinko-hint-generator, then the PIR server mounts the table
+	_, _, _, err = hintgen.WriteTableHint(filepath.Join(dir, "database.bin"), filepath.Join(dir, "hint.bin"))
+	mustDo(t, err)
+	table, err := pirserver.LoadTable(pirserver.TableConfig{Name: dbgen.ProofTableName, Dir: dir})
+	mustDo(t, err)
+	mustDo(t, h.Server.MountTable(dbgen.ProofTableName, table))
+
+	c := plinkoclient.New(h.PIR.URL, h.CDN.URL).Table(plinkoclient.StateProofTableName)
+	hintPath := filepath.Join(t.TempDir(), "hint.bin")
+	hdr, err := c.DownloadHint(ctx, hintPath, nil)
+	mustDo(t, err)
+	info, err := c.FetchStateProofInfo(ctx)
+	mustDo(t, err)
+	if info.Block != 1 || info.RecordWords != written.RecordWords || info.Accounts != len(accounts) {
+		t.Fatalf("proofs.json = %+v, db-generator wrote %+v", info, written)
+	}
+	hintCfg := plinkoclient.StateProofHintConfig(hdr, info, 5)
+	hintCfg.PrimaryHints = int(64 * hdr.ChunkSize)
+	hintCfg.Rand = rand.New(rand.NewSource(testSeed))
+	hints, err := plinkoclient.BuildHintsFromFile(hintPath, hintCfg)
+	mustDo(t, err)
+	epoch, err := c.FetchEpochInfo(ctx) // The snapshot's block, as plinko hint fetch reads it
+	mustDo(t, err)
+	hints.LastBlock = epoch.LastBlock
+	c.SetHints(hints)
+
+	// Balances come out whole and as of block 1, checked against the node's
+	// header rather than the PIR server
+	for _, idx := range []uint64{0, 7, 23, uint64(len(accounts) - 1)} {
+		addr := plinkoclient.Address(accounts[idx].Address)
+		acct, err := c.QueryVerifiedAccount(ctx, client, info, addr, idx)
+		if err != nil {
+			t.Fatalf("account %d: %v", idx, err)
+		}
+		if !acct.Exists || acct.Balance.Cmp(accounts[idx].Balance) != 0 || acct.Block != 1 {
+			t.Fatalf("account %d = %+v, want balance %s at block 1", idx, acct, accounts[idx].Balance)
+		}
+	}
+
+	// Block 2 changed the state: its root does not accept block 1's proofs
+	_, err = c.QueryVerifiedAccount(ctx, headerAt{client, 2}, info, plinkoclient.Address(accounts[3].Address), 3)
+	if !errors.Is(err, plinkoclient.ErrStateProofInvalid) {
+		t.Fatalf("proof against block 2's header = %v, want ErrStateProofInvalid", err)
+	}
+}
@@ -0,0 +1,642 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"piano-pir-db-generator/dbgen"
+	"piano-pir-hint-generator/hintgen"
+	"piano-pir-server/pirserver"
+	plinkoclient "plinko-client"
+)
+
+const (
+	testAccounts = 1 << 12
+	testSeed     = 20241109
+)
+
+func testHintConfig(h *Harness) plinkoclient.HintConfig {
+	cfg := plinkoclient.DefaultHintConfig(h.Header)
+	cfg.PrimaryHints = int(16 * h.Header.ChunkSize) // Keep ErrNoHint out of the picture
+	cfg.Rand = rand.New(rand.NewSource(testSeed))
+	return cfg
+}
+
+func TestPrivateQueriesReturnDatabaseValues(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		idx := uint64(rng.Intn(int(h.Entries())))
+		got, err := c.Query(ctx, idx)
+		if err != nil {
+			t.Fatalf("Query(%d): %v", idx, err)
+		}
+		if got != h.Value(idx) {
+			t.Fatalf("Query(%d) = %d, want %d", idx, got, h.Value(idx))
+		}
+	}
+}
+
+func TestAddressLookupThenQuery(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	mapping, err := os.ReadFile(h.path("address-mapping.bin"))
+	mustDo(t, err)
+
+	for _, i := range []int{0, 1, testAccounts / 2, testAccounts - 1} {
+		acc := h.Accounts[i]
+		idx, err := plinkoclient.LookupAddress(bytes.NewReader(mapping), plinkoclient.Address(acc.Address))
+		if err != nil {
+			t.Fatalf("LookupAddress(%s): %v", acc.Address.Hex(), err)
+		}
+		got, err := c.Query(ctx, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != acc.Balance.Uint64() {
+			t.Errorf("balance of %s = %d, want %d", acc.Address.Hex(), got, acc.Balance.Uint64())
+		}
+	}
+}
+
+func TestAddressIndexLookup(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	// Downloaded from the CDN like the CLI does
+	idx, err := c.DownloadAddressIndex(ctx, filepath.Join(t.TempDir(), "address-index.bin"))
+	mustDo(t, err)
+	if idx.Len() != testAccounts {
+		t.Fatalf("index has %d accounts, want %d", idx.Len(), testAccounts)
+	}
+	for i, acc := range h.Accounts {
+		got, err := idx.Lookup(plinkoclient.Address(acc.Address))
+		if err != nil || got != uint64(i) {
+			t.Fatalf("Lookup(%s) = %d, %v; want %d", acc.Address.Hex(), got, err, i)
+		}
+	}
+	got, err := c.Query(ctx, uint64(testAccounts/3))
+	mustDo(t, err)
+	if want := h.Accounts[testAccounts/3].Balance.Uint64(); got != want {
+		t.Fatalf("balance %d, want %d", got, want)
+	}
+
+	// Big enough for bucket/fingerprint collisions, which must still resolve
+	rng := rand.New(rand.NewSource(testSeed))
+	accounts := make([]dbgen.AccountData, 1<<16)
+	for i := range accounts {
+		rng.Read(accounts[i].Address[:])
+	}
+	var buf bytes.Buffer
+	mustDo(t, dbgen.WriteAddressIndex(&buf, accounts))
+	if per := float64(buf.Len()) / float64(len(accounts)); per > 6 {
+		t.Errorf("index uses %.1f bytes per account", per)
+	}
+	if idx, err = plinkoclient.LoadAddressIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Overflow() == 0 {
+		t.Fatal("no overflow entries; pick a seed with collisions")
+	}
+	for i, acc := range accounts {
+		if got, err := idx.Lookup(plinkoclient.Address(acc.Address)); err != nil || got != uint64(i) {
+			t.Fatalf("Lookup(%s) = %d, %v; want %d", acc.Address.Hex(), got, err, i)
+		}
+	}
+
+	// Unknown addresses are mostly rejected (expected false positives ~8/2^16)
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		var addr plinkoclient.Address
+		rng.Read(addr[:])
+		if _, err := idx.Lookup(addr); err == nil {
+			falsePositives++
+		} else if !errors.Is(err, plinkoclient.ErrAddressNotFound) {
+			t.Fatal(err)
+		}
+	}
+	if falsePositives > 10 {
+		t.Errorf("%d of 10000 unknown addresses matched", falsePositives)
+	}
+}
+
+func TestKeywordQueries(t *testing.T) {
+	const buckets = testAccounts / 2
+	n := buckets * 85 / 100
+	h := NewKeyword(t, n, buckets, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	// Only keyword-params.json is needed: no address map is published
+	params, err := c.FetchKeywordParams(ctx)
+	mustDo(t, err)
+	mustDo(t, params.Validate(h.Header.DBSize))
+	if params.Buckets != buckets || params.Accounts != n || !bytes.Equal(params.Seed, h.Keyword.Seed) {
+		t.Fatalf("keyword params %+v, want %+v", params, h.Keyword)
+	}
+	if _, err := os.Stat(h.path("address-mapping.bin")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("keyword layout wrote address-mapping.bin (%v)", err)
+	}
+
+	for _, i := range []int{0, 1, n / 2, n - 1} {
+		acc := h.Accounts[i]
+		got, err := c.QueryAddress(ctx, params, plinkoclient.Address(acc.Address))
+		if err != nil {
+			t.Fatalf("QueryAddress(%s): %v", acc.Address.Hex(), err)
+		}
+		if got != acc.Balance.Uint64() {
+			t.Errorf("balance of %s = %d, want %d", acc.Address.Hex(), got, acc.Balance.Uint64())
+		}
+	}
+
+	// Balance updates reach keyword lookups through the usual deltas
+	acc := h.Accounts[n/3]
+	addr := plinkoclient.Address(acc.Address)
+	var balanceIdx uint64
+	for i := 0; i < params.Hashes; i++ {
+		if b := params.Bucket(addr, i); h.Value(2*b) == params.Tag(addr) {
+			balanceIdx = 2*b + 1
+		}
+	}
+	if balanceIdx == 0 {
+		t.Fatalf("%s is in none of its candidate buckets", acc.Address.Hex())
+	}
+	block := h.ApplyBlock(t, map[uint64]uint64{balanceIdx: 31337})
+	if _, err := c.SyncDeltas(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.QueryAddress(ctx, params, addr); err != nil || got != 31337 {
+		t.Errorf("balance after update = %d, %v; want 31337", got, err)
+	}
+
+	var unknown plinkoclient.Address
+	rand.New(rand.NewSource(1)).Read(unknown[:])
+	if _, err := c.QueryAddress(ctx, params, unknown); !errors.Is(err, plinkoclient.ErrAddressNotFound) {
+		t.Errorf("QueryAddress(unknown) = %v, want ErrAddressNotFound", err)
+	}
+}
+
+func TestQueriesAfterUpdates(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	// Query a few entries first so some hints are programmed before the
+	// deltas arrive
+	touched := []uint64{7, 8, 1000, 2047, 4095}
+	for _, idx := range touched {
+		if _, err := c.Query(ctx, idx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	for block := 0; block < 5; block++ {
+		changes := make(map[uint64]uint64)
+		for _, idx := range touched {
+			changes[idx] = rng.Uint64()
+		}
+		for i := 0; i < 50; i++ {
+			changes[uint64(rng.Intn(testAccounts))] = rng.Uint64()
+		}
+		h.ApplyBlock(t, changes)
+	}
+
+	n, err := c.SyncDeltas(ctx, 1<<62)
+	if err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+	if c.Hints().LastBlock != 5 || n == 0 {
+		t.Fatalf("synced to block %d with %d deltas, want block 5", c.Hints().LastBlock, n)
+	}
+
+	check := append([]uint64(nil), touched...)
+	for i := 0; i < 100; i++ {
+		check = append(check, uint64(rng.Intn(testAccounts)))
+	}
+	for _, idx := range check {
+		got, err := c.Query(ctx, idx)
+		if err != nil {
+			t.Fatalf("Query(%d): %v", idx, err)
+		}
+		if got != h.Value(idx) {
+			t.Fatalf("Query(%d) after updates = %d, want %d", idx, got, h.Value(idx))
+		}
+	}
+}
+
+func TestUnsyncedHintsRejectNewerAnswer(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	// The block changes the queried entry and every entry of the last chunk,
+	// so another member of the set too: a client that skipped the delta
+	// would decode a wrong value from the answer
+	const idx = 123
+	old := h.Value(idx)
+	changes := map[uint64]uint64{idx: old ^ 0xff}
+	last := (h.Header.SetSize - 1) * h.Header.ChunkSize
+	for i := last; i < last+h.Header.ChunkSize && i < testAccounts; i++ {
+		changes[i] = h.Value(i) ^ 1
+	}
+	block := h.ApplyBlock(t, changes)
+
+	if got, err := c.Query(ctx, idx); !errors.Is(err, plinkoclient.ErrBlockMismatch) {
+		t.Fatalf("unsynced Query = %#x, %v; want ErrBlockMismatch", got, err)
+	}
+	if _, err := c.SyncDeltas(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Query(ctx, idx); err != nil || got != h.Value(idx) {
+		t.Errorf("Query after sync = %#x, %v; want %#x", got, err, h.Value(idx))
+	}
+}
+
+func TestTwoServerDPFQueries(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+
+	// Two operators serve the same hint.bin and follow the same delta feed
+	var servers [2]*pirserver.PlinkoPIRServer
+	var urls [2]string
+	for i := range servers {
+		s, err := pirserver.LoadTable(pirserver.TableConfig{Name: pirserver.DefaultTableName, Dir: h.Dir})
+		mustDo(t, err)
+		limits := pirserver.DefaultLimits()
+		limits.PerIPRate, limits.GlobalRate = 0, 0
+		s.SetLimits(limits)
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		servers[i], urls[i] = s, ts.URL
+	}
+	pair := plinkoclient.NewDPFPair(urls[0], urls[1])
+
+	rng := rand.New(rand.NewSource(3))
+	check := func(block uint64) {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			idx := uint64(rng.Intn(testAccounts))
+			got, at, err := pair.Query(ctx, idx)
+			if err != nil {
+				t.Fatalf("Query(%d): %v", idx, err)
+			}
+			if got != h.Value(idx) || at != block {
+				t.Fatalf("Query(%d) = %d at block %d, want %d at block %d", idx, got, at, h.Value(idx), block)
+			}
+		}
+	}
+	check(0)
+
+	changes := make(map[uint64]uint64)
+	for i := 0; i < 200; i++ {
+		changes[uint64(rng.Intn(testAccounts))] = rng.Uint64()
+	}
+	block := h.ApplyBlock(t, changes)
+
+	// Until both servers have the block their shares do not combine
+	if _, err := servers[0].SyncDeltas(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pair.Query(ctx, 1); !errors.Is(err, plinkoclient.ErrServersDiverged) {
+		t.Fatalf("Query with one server behind = %v, want ErrServersDiverged", err)
+	}
+	if _, err := servers[1].SyncDeltas(); err != nil {
+		t.Fatal(err)
+	}
+	check(block)
+	for idx, v := range changes {
+		if got, _, err := pair.Query(ctx, idx); err != nil || got != v {
+			t.Fatalf("Query(%d) after update = %d, %v; want %d", idx, got, err, v)
+		}
+	}
+}
+
+func TestLWEQueriesMatchPunctured(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	hint := h.EnableLWE(t)
+	c := h.Client(t, testHintConfig(h))
+	ctx := contextFor(t)
+
+	rng := rand.New(rand.NewSource(4))
+	indices := []uint64{0, h.Entries() - 1}
+	for i := 0; i < 30; i++ {
+		indices = append(indices, uint64(rng.Intn(int(h.Entries()))))
+	}
+	for _, idx := range indices {
+		lwe, err := c.QueryLWE(ctx, hint, idx)
+		if err != nil {
+			t.Fatalf("QueryLWE(%d): %v", idx, err)
+		}
+		punctured, err := c.Query(ctx, idx)
+		if err != nil {
+			t.Fatalf("Query(%d): %v", idx, err)
+		}
+		if lwe != h.Value(idx) || punctured != h.Value(idx) {
+			t.Fatalf("index %d: LWE %d, punctured %d, want %d", idx, lwe, punctured, h.Value(idx))
+		}
+	}
+
+	// LWE answers stay on the epoch's snapshot; punctured queries see deltas
+	old := h.Value(5)
+	h.ApplyBlock(t, map[uint64]uint64{5: old + 1})
+	if got, err := c.QueryLWE(ctx, hint, 5); err != nil || got != old {
+		t.Errorf("QueryLWE after a block = %d, %v; want the snapshot value %d", got, err, old)
+	}
+}
+
+func TestShardedQueries(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+
+	// Four shard processes and a coordinator stand in for the one server
+	const count = 4
+	limits := pirserver.DefaultLimits()
+	limits.PerIPRate, limits.GlobalRate = 0, 0
+	var shards []*pirserver.PlinkoPIRServer
+	var urls []string
+	for i := uint64(0); i < count; i++ {
+		s, err := pirserver.LoadTable(pirserver.TableConfig{
+			Name:  pirserver.DefaultTableName,
+			Dir:   h.Dir,
+			Shard: pirserver.Shard{Index: i, Count: count},
+		})
+		mustDo(t, err)
+		s.SetLimits(limits)
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		shards, urls = append(shards, s), append(urls, ts.URL)
+	}
+	coord, err := pirserver.NewCoordinator(ctx, urls, nil)
+	mustDo(t, err)
+	coord.SetLimits(limits)
+	front := httptest.NewServer(coord.Handler())
+	t.Cleanup(front.Close)
+
+	c := plinkoclient.New(front.URL, h.CDN.URL)
+	hintPath := filepath.Join(t.TempDir(), "hint.bin")
+	_, err = c.DownloadHint(ctx, hintPath, nil)
+	mustDo(t, err)
+	table, err := plinkoclient.BuildHintsFromFile(hintPath, testHintConfig(h))
+	mustDo(t, err)
+	c.SetHints(table)
+
+	rng := rand.New(rand.NewSource(4))
+	check := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			idx := uint64(rng.Intn(testAccounts))
+			got, err := c.Query(ctx, idx)
+			if err != nil {
+				t.Fatalf("Query(%d): %v", idx, err)
+			}
+			if got != h.Value(idx) {
+				t.Fatalf("Query(%d) = %d, want %d", idx, got, h.Value(idx))
+			}
+		}
+	}
+	check()
+
+	// Each shard applies the block's deltas for its own chunks
+	changes := make(map[uint64]uint64)
+	for i := 0; i < 200; i++ {
+		changes[uint64(rng.Intn(testAccounts))] = rng.Uint64()
+	}
+	h.ApplyBlock(t, changes)
+	for _, s := range shards {
+		if _, err := s.SyncDeltas(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.SyncDeltas(ctx, 1<<62); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+	check()
+	queried := 0
+	for idx, v := range changes {
+		if got, err := c.Query(ctx, idx); err != nil || got != v {
+			t.Fatalf("Query(%d) after update = %d, %v; want %d", idx, got, err, v)
+		}
+		if queried++; queried == 20 {
+			break
+		}
+	}
+}
+
+func TestReplicatedQueriesThroughRouter(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+
+	// A leader and two followers of the table, with a router in front
+	const token = "e2e-replication"
+	limits := pirserver.DefaultLimits()
+	limits.PerIPRate, limits.GlobalRate = 0, 0
+	var replicas []*pirserver.PlinkoPIRServer
+	var urls []string
+	for i := 0; i < 3; i++ {
+		s, err := pirserver.LoadTable(pirserver.TableConfig{Name: pirserver.DefaultTableName, Dir: h.Dir})
+		mustDo(t, err)
+		s.SetLimits(limits)
+		if i == 0 {
+			mustDo(t, s.EnableReplication(token))
+		}
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		replicas, urls = append(replicas, s), append(urls, ts.URL)
+	}
+	leader := replicas[0]
+	sync := func(i int) {
+		t.Helper()
+		f := pirserver.Follower{Leader: urls[0], Replica: urls[i], Token: token}
+		if _, err := replicas[i].SyncFromLeader(ctx, f); err != nil {
+			t.Fatalf("follower %d: %v", i, err)
+		}
+	}
+	router := pirserver.NewRouter(urls[0], token, nil)
+	sync(1)
+	sync(2)
+	mustDo(t, router.Refresh(ctx))
+	front := httptest.NewServer(router.Handler())
+	t.Cleanup(front.Close)
+
+	c := plinkoclient.New(front.URL, h.CDN.URL)
+	hintPath := filepath.Join(t.TempDir(), "hint.bin")
+	_, err := c.DownloadHint(ctx, hintPath, nil)
+	mustDo(t, err)
+	table, err := plinkoclient.BuildHintsFromFile(hintPath, testHintConfig(h))
+	mustDo(t, err)
+	c.SetHints(table)
+
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 60; i++ {
+		idx := uint64(rng.Intn(testAccounts))
+		if got, err := c.Query(ctx, idx); err != nil || got != h.Value(idx) {
+			t.Fatalf("Query(%d) = %d, %v; want %d", idx, got, err, h.Value(idx))
+		}
+	}
+
+	// Block 1 reaches the leader and follower 1; follower 2 lags
+	changes := make(map[uint64]uint64)
+	old := make(map[uint64]uint64)
+	for len(changes) < 20 {
+		idx := uint64(rng.Intn(testAccounts))
+		changes[idx], old[idx] = rng.Uint64(), h.Value(idx)
+	}
+	h.ApplyBlock(t, changes)
+	if _, err := leader.SyncDeltas(); err != nil {
+		t.Fatal(err)
+	}
+	sync(1)
+	mustDo(t, router.Refresh(ctx))
+
+	// Hints still at block 0 are answered by the lagging follower, so their
+	// answers decode to the block 0 values
+	queried := 0
+	for idx, v := range old {
+		if got, err := c.Query(ctx, idx); err != nil || got != v {
+			t.Fatalf("Query(%d) at block 0 = %d, %v; want %d", idx, got, err, v)
+		}
+		if queried++; queried == 10 {
+			break
+		}
+	}
+
+	if _, err := c.SyncDeltas(ctx, 1<<62); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+	for idx, v := range changes {
+		if got, err := c.Query(ctx, idx); err != nil || got != v {
+			t.Fatalf("Query(%d) at block 1 = %d, %v; want %d", idx, got, err, v)
+		}
+	}
+	if got := replicas[2].LastBlock(); got != 0 {
+		t.Errorf("lagging follower at block %d", got)
+	}
+}
+
+func TestHintsFromDatabaseStream(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+
+	// A table server loaded from the same directory streams its hint.bin
+	s, err := pirserver.LoadTable(pirserver.TableConfig{Name: pirserver.DefaultTableName, Dir: h.Dir})
+	mustDo(t, err)
+	limits := pirserver.DefaultLimits()
+	limits.PerIPRate, limits.GlobalRate = 0, 0
+	s.SetLimits(limits)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	// A block lands before the client starts: the stream still holds the
+	// epoch's hint.bin and the client catches up through the delta feed
+	rng := rand.New(rand.NewSource(6))
+	changes := make(map[uint64]uint64)
+	for len(changes) < 20 {
+		changes[uint64(rng.Intn(testAccounts))] = rng.Uint64()
+	}
+	h.ApplyBlock(t, changes)
+	if _, err := s.SyncDeltas(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := plinkoclient.New(ts.URL, h.CDN.URL)
+	m, err := c.FetchDBManifest(ctx)
+	mustDo(t, err)
+	if m.Header() != h.Header || m.StartBlock != 0 {
+		t.Fatalf("manifest %+v, want header %+v at block 0", m, h.Header)
+	}
+	table, err := c.BuildHintsFromStream(ctx, m, testHintConfig(h))
+	mustDo(t, err)
+	c.SetHints(table)
+	if _, err := c.SyncDeltas(ctx, 1<<62); err != nil {
+		t.Fatalf("SyncDeltas: %v", err)
+	}
+
+	// Same hints as from the CDN's hint.bin
+	for i := 0; i < 60; i++ {
+		idx := uint64(rng.Intn(testAccounts))
+		if got, err := c.Query(ctx, idx); err != nil || got != h.Value(idx) {
+			t.Fatalf("Query(%d) = %d, %v; want %d", idx, got, err, h.Value(idx))
+		}
+	}
+	for idx, v := range changes {
+		if got, err := c.Query(ctx, idx); err != nil || got != v {
+			t.Fatalf("Query(%d) after the block = %d, %v; want %d", idx, got, err, v)
+		}
+	}
+}
+
+func TestVerifiedDPFQueries(t *testing.T) {
+	h := New(t, testAccounts, testSeed)
+	ctx := contextFor(t)
+	_, err := hintgen.WriteMerkleRoot(h.path(hintgen.MerkleRootFile), h.path("hint.bin"))
+	mustDo(t, err)
+
+	// Both servers rebuild the tree and check it against the published root
+	serve := func(s *pirserver.PlinkoPIRServer) string {
+		limits := pirserver.DefaultLimits()
+		limits.PerIPRate, limits.GlobalRate = 0, 0
+		s.SetLimits(limits)
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		return ts.URL
+	}
+	var urls [2]string
+	for i := range urls {
+		s, err := pirserver.LoadTable(pirserver.TableConfig{Name: pirserver.DefaultTableName, Dir: h.Dir})
+		mustDo(t, err)
+		if !s.MerkleEnabled() {
+			t.Fatal("server did not accept the hint generator's merkle-root.json")
+		}
+		urls[i] = serve(s)
+	}
+	root, err := plinkoclient.New(urls[0], h.CDN.URL).FetchMerkleRoot(ctx)
+	mustDo(t, err)
+	pair := plinkoclient.NewDPFPair(urls[0], urls[1])
+
+	rng := rand.New(rand.NewSource(7))
+	indices := []uint64{0, testAccounts - 1}
+	for i := 0; i < 10; i++ {
+		indices = append(indices, uint64(rng.Intn(testAccounts)))
+	}
+	for _, idx := range indices {
+		if got, err := pair.QueryVerified(ctx, root, idx); err != nil || got != h.Value(idx) {
+			t.Fatalf("QueryVerified(%d) = %d, %v; want %d", idx, got, err, h.Value(idx))
+		}
+	}
+
+	// A server answering from a database with one entry changed is caught
+	data, err := os.ReadFile(h.path("hint.bin"))
+	mustDo(t, err)
+	data[hintgen.HintHeaderSize+42*hintgen.DBEntrySize] ^= 1
+	forged := filepath.Join(t.TempDir(), "hint.bin")
+	mustDo(t, os.WriteFile(forged, data, 0644))
+	liar, err := pirserver.LoadHintFile(forged)
+	mustDo(t, err)
+	liar.EnableMerkle()
+	pair = plinkoclient.NewDPFPair(urls[0], serve(liar))
+	caught := 0
+	for i := 0; i < 10; i++ {
+		got, err := pair.QueryVerified(ctx, root, 42)
+		switch {
+		case errors.Is(err, plinkoclient.ErrProofInvalid):
+			caught++
+		case err != nil || got != h.Value(42):
+			t.Fatalf("QueryVerified(42) against a forged database = %d, %v", got, err)
+		}
+	}
+	if caught == 0 {
+		t.Error("no verified query noticed the forged database")
+	}
+}
@@ -0,0 +1,464 @@
+// Package ethmock is a scriptable in-memory Ethereum chain with a JSON-RPC
+// front end. It stands in for the Anvil container (eth-mock) so db-generator
+// and plinko-update-service can be tested without a network.
+package ethmock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	DefaultChainID = 31337 // Anvil's chain ID
+	GenesisTime    = 1700000000
+	BlockTime      = 12 // Seconds between blocks
+	BlockGasLimit  = 30_000_000
+	TransferGas    = 21_000
+)
+
+// ErrInsufficientBalance is returned by Transfer when the sender cannot pay
+var ErrInsufficientBalance = errors.New("insufficient balance for transfer")
+
+// Backend is every ethclient call the services make. *ethclient.Client and
+// *Chain both implement it.
+type Backend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// ProofBackend serves eth_getProof account proofs. *Chain implements it;
+// the server answers eth_getProof only for backends that do.
+type ProofBackend interface {
+	GetProof(ctx context.Context, account common.Address, blockNumber *big.Int) (*AccountResult, error)
+}
+
+// AccountResult is an eth_getProof result without storage proofs: the
+// account and the trie nodes from the block's state root down to it, or to
+// where it would be if the account does not exist
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []struct{}      `json:"storageProof"`
+}
+
+// TransferEventTopic is topic 0 of the ERC-20 Transfer(address,address,uint256) event
+var TransferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+type balanceAt struct {
+	block   uint64
+	balance *big.Int
+	nonce   uint64
+}
+
+// Chain is an in-memory chain. Balance changes and transfers accumulate in a
+// pending block until Mine seals it; queries only see mined blocks. Fees are
+// zero, so transfers move exactly their value.
+type Chain struct {
+	mu      sync.RWMutex
+	chainID *big.Int
+	signer  types.Signer
+	blocks  []*types.Block
+	logs    [][]*types.Log                 // Per block
+	history map[common.Address][]balanceAt // Ascending by block
+	nonces  map[common.Address]uint64
+	state   *trie.Trie // Head state, for the state root of the next block
+
+	proofTrie  *trie.Trie // State at proofBlock, rebuilt for GetProof
+	proofBlock uint64
+
+	pending     map[common.Address]*big.Int
+	pendingTxs  []*types.Transaction
+	pendingLogs []*types.Log
+}
+
+var (
+	_ Backend      = (*Chain)(nil)
+	_ ProofBackend = (*Chain)(nil)
+)
+
+// NewChain creates a chain holding only an empty genesis block
+func NewChain() *Chain {
+	chainID := big.NewInt(DefaultChainID)
+	c := &Chain{
+		chainID: chainID,
+		signer:  types.LatestSignerForChainID(chainID),
+		history: make(map[common.Address][]balanceAt),
+		nonces:  make(map[common.Address]uint64),
+		pending: make(map[common.Address]*big.Int),
+		state:   newStateTrie(),
+	}
+	c.seal()
+	return c
+}
+
+// SetBalance sets addr's balance in the pending block
+func (c *Chain) SetBalance(addr common.Address, balance *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[addr] = new(big.Int).Set(balance)
+}
+
+// Transfer signs a zero-fee value transfer from key to `to` and adds it to
+// the pending block
+func (c *Chain) Transfer(key *ecdsa.PrivateKey, to common.Address, value *big.Int) (*types.Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	fromBalance := c.pendingBalance(from)
+	if fromBalance.Cmp(value) < 0 {
+		return nil, fmt.Errorf("%s has %s wei, needs %s: %w", from.Hex(), fromBalance, value, ErrInsufficientBalance)
+	}
+
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    c.nonces[from],
+		GasPrice: new(big.Int),
+		Gas:      TransferGas,
+		To:       &to,
+		Value:    new(big.Int).Set(value),
+	}), c.signer, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.nonces[from]++
+	c.pending[from] = new(big.Int).Sub(fromBalance, value)
+	c.pending[to] = new(big.Int).Add(c.pendingBalance(to), value)
+	c.pendingTxs = append(c.pendingTxs, tx)
+	return tx, nil
+}
+
+// TransferToken adds an ERC-20 Transfer event of token to the pending
+// block. There is no token contract behind it: the chain only records the
+// log, as a token would emit it (from the zero address for a mint).
+func (c *Chain) TransferToken(token, from, to common.Address, value *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pendingLogs = append(c.pendingLogs, &types.Log{
+		Address: token,
+		Topics:  []common.Hash{TransferEventTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    common.BigToHash(value).Bytes(),
+	})
+}
+
+// Mine seals the pending block and returns it
+func (c *Chain) Mine() *types.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seal()
+}
+
+// MineEmpty seals n blocks without changes and returns the new head number
+func (c *Chain) MineEmpty(n int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < n; i++ {
+		c.seal()
+	}
+	return c.head().NumberU64()
+}
+
+// Head returns the latest mined block
+func (c *Chain) Head() *types.Block {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.head()
+}
+
+// ChangedAccounts returns the addresses whose balance was set or transferred
+// in block number, sorted
+func (c *Chain) ChangedAccounts(number uint64) []common.Address {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var changed []common.Address
+	for addr, h := range c.history {
+		i := sort.Search(len(h), func(i int) bool { return h[i].block >= number })
+		if i < len(h) && h[i].block == number {
+			changed = append(changed, addr)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Hex() < changed[j].Hex()
+	})
+	return changed
+}
+
+// ChainID implements Backend
+func (c *Chain) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(c.chainID), nil
+}
+
+// BlockNumber implements Backend
+func (c *Chain) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.Head().NumberU64(), nil
+}
+
+// BalanceAt implements Backend. A nil blockNumber means the latest block.
+func (c *Chain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	b, err := c.blockByNumber(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(c.balanceAt(account, b.NumberU64())), nil
+}
+
+// HeaderByNumber implements Backend. A nil number means the latest block.
+func (c *Chain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b, err := c.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header(), nil
+}
+
+// BlockByNumber implements Backend. A nil number means the latest block.
+func (c *Chain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blockByNumber(number)
+}
+
+// FilterLogs implements Backend for block ranges (a nil FromBlock or
+// ToBlock is the head), addresses and topics; BlockHash queries are not
+// supported
+func (c *Chain) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if q.BlockHash != nil {
+		return nil, errors.New("ethmock: block hash log filters are not supported")
+	}
+	from, err := c.blockByNumber(q.FromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.blockByNumber(q.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []types.Log
+	for n := from.NumberU64(); n <= to.NumberU64(); n++ {
+		for _, l := range c.logs[n] {
+			if matchLog(l, q) {
+				out = append(out, *l)
+			}
+		}
+	}
+	return out, nil
+}
+
+// matchLog applies the address and topic filters of q to l
+// GetProof implements ProofBackend
+func (c *Chain) GetProof(ctx context.Context, account common.Address, blockNumber *big.Int) (*AccountResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block, err := c.blockByNumber(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	number := block.NumberU64()
+	if c.proofTrie == nil || c.proofBlock != number {
+		c.proofTrie = c.stateAt(number)
+		c.proofBlock = number
+	}
+
+	var nodes proofNodes
+	if err := c.proofTrie.Prove(crypto.Keccak256(account[:]), &nodes); err != nil {
+		return nil, err
+	}
+	nonce := uint64(0)
+	if h := c.history[account]; len(h) > 0 {
+		i := sort.Search(len(h), func(i int) bool { return h[i].block > number })
+		if i > 0 {
+			nonce = h[i-1].nonce
+		}
+	}
+	return &AccountResult{
+		Address:      account,
+		AccountProof: nodes,
+		Balance:      (*hexutil.Big)(c.balanceAt(account, number)),
+		CodeHash:     types.EmptyCodeHash,
+		Nonce:        hexutil.Uint64(nonce),
+		StorageHash:  types.EmptyRootHash,
+		StorageProof: []struct{}{},
+	}, nil
+}
+
+// proofNodes collects the nodes trie.Prove writes, root first
+type proofNodes []hexutil.Bytes
+
+func (p *proofNodes) Put(key, value []byte) error {
+	*p = append(*p, common.CopyBytes(value))
+	return nil
+}
+
+func (p *proofNodes) Delete(key []byte) error { return nil }
+
+func matchLog(l *types.Log, q ethereum.FilterQuery) bool {
+	if len(q.Addresses) > 0 && !containsAddress(q.Addresses, l.Address) {
+		return false
+	}
+	if len(q.Topics) > len(l.Topics) {
+		return false
+	}
+	for i, alternatives := range q.Topics {
+		if len(alternatives) == 0 {
+			continue // Wildcard
+		}
+		match := false
+		for _, topic := range alternatives {
+			match = match || topic == l.Topics[i]
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddress(list []common.Address, addr common.Address) bool {
+	for _, a := range list {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chain) head() *types.Block {
+	return c.blocks[len(c.blocks)-1]
+}
+
+func (c *Chain) blockByNumber(number *big.Int) (*types.Block, error) {
+	if number == nil {
+		return c.head(), nil
+	}
+	if !number.IsUint64() || number.Uint64() >= uint64(len(c.blocks)) {
+		return nil, ethereum.NotFound
+	}
+	return c.blocks[number.Uint64()], nil
+}
+
+func (c *Chain) balanceAt(addr common.Address, number uint64) *big.Int {
+	h := c.history[addr]
+	i := sort.Search(len(h), func(i int) bool { return h[i].block > number })
+	if i == 0 {
+		return new(big.Int)
+	}
+	return h[i-1].balance
+}
+
+func (c *Chain) pendingBalance(addr common.Address) *big.Int {
+	if b, ok := c.pending[addr]; ok {
+		return b
+	}
+	return c.balanceAt(addr, uint64(len(c.blocks)))
+}
+
+func newStateTrie() *trie.Trie {
+	return trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+}
+
+// updateAccount writes an account into a state trie the way geth does:
+// keyed by the address hash, with no code or storage, and removed while it
+// is empty (EIP-161)
+func updateAccount(tr *trie.Trie, addr common.Address, balance *big.Int, nonce uint64) {
+	key := crypto.Keccak256(addr[:])
+	if balance.Sign() == 0 && nonce == 0 {
+		tr.MustDelete(key)
+		return
+	}
+	data, err := rlp.EncodeToBytes(&types.StateAccount{
+		Nonce:    nonce,
+		Balance:  balance,
+		Root:     types.EmptyRootHash,
+		CodeHash: types.EmptyCodeHash.Bytes(),
+	})
+	if err != nil {
+		panic(err) // Encoding a StateAccount cannot fail
+	}
+	tr.MustUpdate(key, data)
+}
+
+// stateAt rebuilds the state trie of block number from the history
+func (c *Chain) stateAt(number uint64) *trie.Trie {
+	tr := newStateTrie()
+	for addr, h := range c.history {
+		i := sort.Search(len(h), func(i int) bool { return h[i].block > number })
+		if i > 0 {
+			updateAccount(tr, addr, h[i-1].balance, h[i-1].nonce)
+		}
+	}
+	return tr
+}
+
+// seal turns the pending changes into the next block. The state root is the
+// root of a real state trie over the accounts' balances and nonces, so
+// eth_getProof answers verify against it.
+func (c *Chain) seal() *types.Block {
+	number := uint64(len(c.blocks))
+
+	changed := make([]common.Address, 0, len(c.pending))
+	for addr := range c.pending {
+		changed = append(changed, addr)
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Hex() < changed[j].Hex()
+	})
+
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Difficulty: new(big.Int),
+		GasLimit:   BlockGasLimit,
+		GasUsed:    TransferGas * uint64(len(c.pendingTxs)),
+		Time:       GenesisTime + BlockTime*number,
+	}
+	if number > 0 {
+		header.ParentHash = c.head().Hash()
+	}
+	for _, addr := range changed {
+		balance, nonce := c.pending[addr], c.nonces[addr]
+		c.history[addr] = append(c.history[addr], balanceAt{block: number, balance: balance, nonce: nonce})
+		updateAccount(c.state, addr, balance, nonce)
+	}
+	header.Root = c.state.Hash()
+
+	block := types.NewBlock(header, c.pendingTxs, nil, nil, trie.NewStackTrie(nil))
+	for i, l := range c.pendingLogs {
+		l.BlockNumber = number
+		l.BlockHash = block.Hash()
+		l.Index = uint(i)
+		// No transaction carries the log; give it a stable stand-in hash
+		l.TxHash = crypto.Keccak256Hash(block.Hash().Bytes(), big.NewInt(int64(i)).Bytes())
+	}
+	c.blocks = append(c.blocks, block)
+	c.logs = append(c.logs, c.pendingLogs)
+	c.pending = make(map[common.Address]*big.Int)
+	c.pendingTxs = nil
+	c.pendingLogs = nil
+	return block
+}
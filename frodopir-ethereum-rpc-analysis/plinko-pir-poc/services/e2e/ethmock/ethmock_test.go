@@ -0,0 +1,293 @@
+package ethmock
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+func serve(t *testing.T, b Backend) string {
+	t.Helper()
+	rpcServer := NewServer(b)
+	srv := httptest.NewServer(rpcServer)
+	t.Cleanup(srv.Close)
+	t.Cleanup(rpcServer.Close)
+	return srv.URL
+}
+
+func TestChainHistory(t *testing.T) {
+	ctx := context.Background()
+	c := NewChain()
+	addr := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	c.SetBalance(addr, big.NewInt(100))
+	if got, _ := c.BalanceAt(ctx, addr, nil); got.Sign() != 0 {
+		t.Fatalf("pending balance visible before Mine: %s", got)
+	}
+	c.Mine()                            // block 1: 100
+	c.MineEmpty(2)                      // blocks 2, 3
+	c.SetBalance(addr, big.NewInt(250)) // block 4: 250
+	c.Mine()
+
+	for block, want := range []int64{0, 100, 100, 100, 250} {
+		got, err := c.BalanceAt(ctx, addr, big.NewInt(int64(block)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Int64() != want {
+			t.Errorf("balance at block %d = %s, want %d", block, got, want)
+		}
+	}
+	if _, err := c.BalanceAt(ctx, addr, big.NewInt(5)); !errors.Is(err, ethereum.NotFound) {
+		t.Errorf("balance at future block: err = %v, want NotFound", err)
+	}
+	if changed := c.ChangedAccounts(4); len(changed) != 1 || changed[0] != addr {
+		t.Errorf("ChangedAccounts(4) = %v", changed)
+	}
+	if changed := c.ChangedAccounts(3); len(changed) != 0 {
+		t.Errorf("ChangedAccounts(3) = %v, want none", changed)
+	}
+}
+
+func TestTransferInsufficientBalance(t *testing.T) {
+	c := NewChain()
+	key, _ := crypto.GenerateKey()
+	_, err := c.Transfer(key, common.Address{1}, big.NewInt(1))
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("err = %v, want ErrInsufficientBalance", err)
+	}
+}
+
+func TestEthclientOverHTTPAndWebSocket(t *testing.T) {
+	ctx := context.Background()
+	chain := NewChain()
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	chain.SetBalance(from, big.NewInt(1000))
+	chain.Mine()
+	tx, err := chain.Transfer(key, to, big.NewInt(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mined := chain.Mine()
+
+	url := serve(t, chain)
+	for _, endpoint := range []string{url, "ws" + strings.TrimPrefix(url, "http")} {
+		t.Run(endpoint[:strings.Index(endpoint, ":")], func(t *testing.T) {
+			client, err := ethclient.Dial(endpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			if id, err := client.ChainID(ctx); err != nil || id.Int64() != DefaultChainID {
+				t.Fatalf("ChainID = %v, %v", id, err)
+			}
+			if n, err := client.BlockNumber(ctx); err != nil || n != 2 {
+				t.Fatalf("BlockNumber = %d, %v; want 2", n, err)
+			}
+			for addr, want := range map[common.Address]int64{from: 700, to: 300} {
+				got, err := client.BalanceAt(ctx, addr, nil)
+				if err != nil || got.Int64() != want {
+					t.Fatalf("BalanceAt(%s) = %v, %v; want %d", addr.Hex(), got, err, want)
+				}
+			}
+			if got, err := client.BalanceAt(ctx, from, big.NewInt(1)); err != nil || got.Int64() != 1000 {
+				t.Fatalf("BalanceAt(block 1) = %v, %v; want 1000", got, err)
+			}
+
+			header, err := client.HeaderByNumber(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Hash() != mined.Hash() {
+				t.Fatalf("header hash %s, want %s", header.Hash(), mined.Hash())
+			}
+
+			block, err := client.BlockByNumber(ctx, big.NewInt(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(block.Transactions()) != 1 || block.Transactions()[0].Hash() != tx.Hash() {
+				t.Fatalf("block 2 transactions %v, want [%s]", block.Transactions(), tx.Hash())
+			}
+			sender, err := client.TransactionSender(ctx, block.Transactions()[0], block.Hash(), 0)
+			if err != nil || sender != from {
+				t.Fatalf("sender = %s, %v; want %s", sender.Hex(), err, from.Hex())
+			}
+
+			if _, err := client.HeaderByNumber(ctx, big.NewInt(99)); !errors.Is(err, ethereum.NotFound) {
+				t.Fatalf("unknown block: err = %v, want NotFound", err)
+			}
+		})
+	}
+}
+
+func TestBatchRequests(t *testing.T) {
+	chain := NewChain()
+	addrs := []common.Address{{1}, {2}, {3}}
+	for i, addr := range addrs {
+		chain.SetBalance(addr, big.NewInt(int64(i+1)))
+	}
+	chain.Mine()
+
+	client, err := rpc.Dial(serve(t, chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	results := make([]hexutil.Big, len(addrs))
+	batch := make([]rpc.BatchElem, len(addrs))
+	for i, addr := range addrs {
+		batch[i] = rpc.BatchElem{Method: "eth_getBalance", Args: []interface{}{addr, "0x1"}, Result: &results[i]}
+	}
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	for i := range batch {
+		if batch[i].Error != nil || results[i].ToInt().Int64() != int64(i+1) {
+			t.Fatalf("batch[%d] = %v, %v", i, results[i].ToInt(), batch[i].Error)
+		}
+	}
+}
+
+func TestFilterLogsOverRPC(t *testing.T) {
+	ctx := context.Background()
+	chain := NewChain()
+	tokenA, tokenB := common.Address{0xa}, common.Address{0xb}
+	alice, bob := common.Address{1}, common.Address{2}
+
+	chain.TransferToken(tokenA, common.Address{}, alice, big.NewInt(500)) // Mint
+	chain.Mine()                                                          // block 1
+	chain.TransferToken(tokenA, alice, bob, big.NewInt(200))
+	chain.TransferToken(tokenB, common.Address{}, bob, big.NewInt(7))
+	chain.Mine() // block 2
+
+	client, err := ethclient.Dial(serve(t, chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: big.NewInt(0),
+		Addresses: []common.Address{tokenA},
+		Topics:    [][]common.Hash{{TransferEventTopic}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("got %d token A logs, want 2", len(logs))
+	}
+	if l := logs[1]; l.BlockNumber != 2 || l.Index != 0 || common.BytesToAddress(l.Topics[2].Bytes()) != bob ||
+		new(big.Int).SetBytes(l.Data).Int64() != 200 {
+		t.Errorf("second log = %+v", l)
+	}
+	if header, _ := client.HeaderByNumber(ctx, big.NewInt(2)); logs[1].BlockHash != header.Hash() {
+		t.Errorf("log block hash %s, want %s", logs[1].BlockHash, header.Hash())
+	}
+
+	// Receiver filter over block 2 only
+	logs, err = client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: big.NewInt(2),
+		ToBlock:   big.NewInt(2),
+		Topics:    [][]common.Hash{{TransferEventTopic}, nil, {common.BytesToHash(bob.Bytes())}},
+	})
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("got %d logs to bob, %v; want 2", len(logs), err)
+	}
+	if _, err := client.FilterLogs(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(9)}); err == nil {
+		t.Error("filter past the head succeeded")
+	}
+}
+
+func TestGetProofOverRPC(t *testing.T) {
+	ctx := context.Background()
+	chain := NewChain()
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to, absent := common.Address{0xb}, common.Address{0xc}
+	for i := 1; i <= 20; i++ {
+		chain.SetBalance(common.Address{0xd, byte(i)}, big.NewInt(int64(i)))
+	}
+	chain.SetBalance(from, big.NewInt(1000))
+	chain.Mine()
+	if _, err := chain.Transfer(key, to, big.NewInt(300)); err != nil {
+		t.Fatal(err)
+	}
+	chain.Mine()
+
+	client, err := rpc.Dial(serve(t, chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	eth := ethclient.NewClient(client)
+
+	// Proofs verify against the header's state root, at the head and before
+	for _, tc := range []struct {
+		block   int64
+		addr    common.Address
+		balance int64
+		nonce   uint64
+	}{
+		{2, from, 700, 1},
+		{2, to, 300, 0},
+		{1, from, 1000, 0},
+		{1, to, 0, 0},
+		{2, absent, 0, 0},
+	} {
+		header, err := eth.HeaderByNumber(ctx, big.NewInt(tc.block))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res AccountResult
+		if err := client.CallContext(ctx, &res, "eth_getProof", tc.addr, []string{}, hexutil.EncodeUint64(uint64(tc.block))); err != nil {
+			t.Fatal(err)
+		}
+		proofDB := memorydb.New()
+		for _, node := range res.AccountProof {
+			proofDB.Put(crypto.Keccak256(node), node)
+		}
+		val, err := trie.VerifyProof(header.Root, crypto.Keccak256(tc.addr[:]), proofDB)
+		if err != nil {
+			t.Fatalf("block %d, %s: %v", tc.block, tc.addr.Hex(), err)
+		}
+		if val == nil {
+			if tc.balance != 0 || tc.nonce != 0 {
+				t.Errorf("block %d, %s: proof of absence", tc.block, tc.addr.Hex())
+			}
+			continue
+		}
+		var acct types.StateAccount
+		if err := rlp.DecodeBytes(val, &acct); err != nil {
+			t.Fatal(err)
+		}
+		if acct.Balance.Int64() != tc.balance || acct.Nonce != tc.nonce || res.Balance.ToInt().Int64() != tc.balance {
+			t.Errorf("block %d, %s: balance %s nonce %d, want %d and %d", tc.block, tc.addr.Hex(), acct.Balance, acct.Nonce, tc.balance, tc.nonce)
+		}
+	}
+
+	var res AccountResult
+	if err := client.CallContext(ctx, &res, "eth_getProof", to, []string{}, "0x9"); err == nil || !strings.Contains(err.Error(), "header not found") {
+		t.Errorf("proof at a future block: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package ethmock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Server exposes a Backend over Ethereum JSON-RPC on HTTP (including batch
+// requests) and WebSocket, so ethclient.Dial works against it with either
+// http:// or ws:// URLs.
+//
+// Served methods: eth_chainId, net_version, eth_blockNumber, eth_getBalance,
+// eth_getBlockByNumber, eth_getLogs, and eth_getProof (account proofs only)
+// for backends that implement ProofBackend.
+type Server struct {
+	rpc *rpc.Server
+	ws  http.Handler
+}
+
+// NewServer wraps backend in a JSON-RPC server
+func NewServer(backend Backend) *Server {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", &ethAPI{backend: backend}); err != nil {
+		panic(err) // Only fails if ethAPI has no exported methods
+	}
+	if err := srv.RegisterName("net", &netAPI{backend: backend}); err != nil {
+		panic(err)
+	}
+	return &Server{rpc: srv, ws: srv.WebsocketHandler([]string{"*"})}
+}
+
+// ServeHTTP answers JSON-RPC over HTTP, upgrading WebSocket requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.ws.ServeHTTP(w, r)
+		return
+	}
+	s.rpc.ServeHTTP(w, r)
+}
+
+// Close stops the server and closes open WebSocket connections
+func (s *Server) Close() {
+	s.rpc.Stop()
+}
+
+type ethAPI struct {
+	backend Backend
+}
+
+func (api *ethAPI) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	id, err := api.backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(id), nil
+}
+
+func (api *ethAPI) BlockNumber(ctx context.Context) (hexutil.Uint64, error) {
+	n, err := api.backend.BlockNumber(ctx)
+	return hexutil.Uint64(n), err
+}
+
+func (api *ethAPI) GetBalance(ctx context.Context, address common.Address, number rpc.BlockNumber) (*hexutil.Big, error) {
+	balance, err := api.backend.BalanceAt(ctx, address, blockArg(number))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, errors.New("header not found") // Same message as geth
+	}
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(balance), nil
+}
+
+// GetBlockByNumber returns null for unknown blocks, like geth
+func (api *ethAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	block, err := api.backend.BlockByNumber(ctx, blockArg(number))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return marshalBlock(block, fullTx)
+}
+
+// GetProof answers eth_getProof. Storage keys are not supported.
+func (api *ethAPI) GetProof(ctx context.Context, address common.Address, keys []string, number rpc.BlockNumber) (*AccountResult, error) {
+	backend, ok := api.backend.(ProofBackend)
+	if !ok {
+		return nil, errors.New("eth_getProof is not supported by this backend")
+	}
+	if len(keys) > 0 {
+		return nil, errors.New("storage proofs are not supported")
+	}
+	res, err := backend.GetProof(ctx, address, blockArg(number))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, errors.New("header not found")
+	}
+	return res, err
+}
+
+// filterArgs is the eth_getLogs filter object in the shape ethclient sends:
+// block numbers, an address array and topic alternatives per position
+type filterArgs struct {
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+	BlockHash *common.Hash     `json:"blockHash"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (api *ethAPI) GetLogs(ctx context.Context, args filterArgs) ([]types.Log, error) {
+	q := ethereum.FilterQuery{BlockHash: args.BlockHash, Addresses: args.Addresses, Topics: args.Topics}
+	if args.FromBlock != nil {
+		q.FromBlock = blockArg(*args.FromBlock)
+	}
+	if args.ToBlock != nil {
+		q.ToBlock = blockArg(*args.ToBlock)
+	}
+	logs, err := api.backend.FilterLogs(ctx, q)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, errors.New("header not found")
+	}
+	if logs == nil {
+		logs = []types.Log{} // [] rather than null, like geth
+	}
+	return logs, err
+}
+
+type netAPI struct {
+	backend Backend
+}
+
+func (api *netAPI) Version(ctx context.Context) (string, error) {
+	id, err := api.backend.ChainID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// blockArg maps "latest", "pending", "safe" and "finalized" to nil (the head)
+func blockArg(number rpc.BlockNumber) *big.Int {
+	if number < 0 {
+		return nil
+	}
+	return big.NewInt(number.Int64())
+}
+
+// marshalBlock produces the eth_getBlockByNumber result ethclient expects:
+// the header fields plus hash, size, uncles and transactions (hashes, or
+// full objects with block position and sender when fullTx is set)
+func marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	fields, err := toFields(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields["hash"] = block.Hash()
+	fields["size"] = hexutil.Uint64(block.Size())
+	fields["uncles"] = []common.Hash{}
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+		if txs[i], err = marshalTransaction(tx, block, uint64(i)); err != nil {
+			return nil, err
+		}
+	}
+	fields["transactions"] = txs
+	return fields, nil
+}
+
+func marshalTransaction(tx *types.Transaction, block *types.Block, index uint64) (map[string]interface{}, error) {
+	fields, err := toFields(tx)
+	if err != nil {
+		return nil, err
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = from
+	fields["blockHash"] = block.Hash()
+	fields["blockNumber"] = (*hexutil.Big)(block.Number())
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	return fields, nil
+}
+
+// toFields round-trips v through its JSON encoding into a field map
+func toFields(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,238 @@
+// Package e2e runs the whole Plinko PIR pipeline in one process: db-generator
+// output, hint generation, the PIR server, the update manager and the Go
+// client, wired together through a temporary directory and httptest servers.
+package e2e
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"piano-pir-db-generator/dbgen"
+	"piano-pir-hint-generator/hintgen"
+	"piano-pir-server/pirserver"
+	plinkoclient "plinko-client"
+	"plinko-update-service/plinko"
+)
+
+// Harness is one in-memory deployment. Dir mirrors the shared /data volume:
+// database.bin, address-mapping.bin (or keyword-params.json), hint.bin and
+// deltas/, plus epoch.json once the database has grown.
+type Harness struct {
+	Dir      string
+	Accounts []dbgen.AccountData // Database order (generation order for NewKeyword)
+	Header   plinkoclient.HintHeader
+	Keyword  *dbgen.KeywordParams // Set by NewKeyword
+
+	Server *pirserver.PlinkoPIRServer
+	PIR    *httptest.Server // PIR server API
+	CDN    *httptest.Server // Static files from Dir
+
+	updates  *plinko.PlinkoUpdateManager
+	expected []uint64 // Current value of every (padded) entry
+	block    uint64
+}
+
+// New builds a database of n synthetic accounts and starts the services
+func New(t testing.TB, n int, seed int64) *Harness {
+	t.Helper()
+	h := &Harness{Dir: t.TempDir(), Accounts: syntheticAccounts(n, seed)}
+
+	// db-generator: accounts -> database.bin + address-mapping.bin +
+	// address-index.bin
+	dbgen.SortAccounts(h.Accounts)
+	mustDo(t, dbgen.WriteDatabaseFile(h.path("database.bin"), h.Accounts))
+	mustDo(t, dbgen.WriteAddressMappingFile(h.path("address-mapping.bin"), h.Accounts))
+	mustDo(t, dbgen.WriteAddressIndexFile(h.path("address-index.bin"), h.Accounts))
+	h.start(t, uint64(n))
+	return h
+}
+
+// NewKeyword builds a keyword-layout database (DB_LAYOUT=keyword) of n
+// synthetic accounts in a cuckoo table of the given number of buckets and
+// starts the services. Accounts stay in generation order.
+func NewKeyword(t testing.TB, n int, buckets uint64, seed int64) *Harness {
+	t.Helper()
+	h := &Harness{Dir: t.TempDir(), Accounts: syntheticAccounts(n, seed)}
+
+	// db-generator: accounts -> database.bin + keyword-params.json
+	table, err := dbgen.BuildKeywordTable(h.Accounts, buckets)
+	mustDo(t, err)
+	mustDo(t, table.WriteDatabaseFile(h.path("database.bin")))
+	mustDo(t, dbgen.WriteKeywordParamsFile(h.path("keyword-params.json"), table.Params))
+	h.Keyword = &table.Params
+	h.start(t, 2*buckets)
+	return h
+}
+
+// syntheticAccounts returns n random accounts
+func syntheticAccounts(n int, seed int64) []dbgen.AccountData {
+	rng := rand.New(rand.NewSource(seed))
+	accounts := make([]dbgen.AccountData, n)
+	for i := range accounts {
+		var addr common.Address
+		rng.Read(addr[:])
+		accounts[i] = dbgen.AccountData{Address: addr, Balance: new(big.Int).SetUint64(rng.Uint64() >> 1)}
+	}
+	return accounts
+}
+
+// start runs hint generation over database.bin (entries entries) and starts
+// the PIR server, update manager and CDN
+func (h *Harness) start(t testing.TB, entries uint64) {
+	t.Helper()
+
+	// plinko-hint-generator: database.bin -> hint.bin
+	database, err := os.ReadFile(h.path("database.bin"))
+	mustDo(t, err)
+	chunkSize, setSize := hintgen.GenParams(entries)
+	mustDo(t, hintgen.WriteHintFile(h.path("hint.bin"), database, entries, chunkSize, setSize, 0))
+	mustDo(t, os.MkdirAll(h.path("deltas"), 0755))
+	h.serve(t)
+
+	// cdn-mock
+	h.CDN = httptest.NewServer(http.FileServer(http.Dir(h.Dir)))
+	t.Cleanup(h.CDN.Close)
+}
+
+// serve loads hint.bin into a new PIR server and update manager
+func (h *Harness) serve(t testing.TB) {
+	t.Helper()
+
+	// plinko-pir-server
+	var err error
+	h.Server, err = pirserver.LoadHintFile(h.path("hint.bin"))
+	mustDo(t, err)
+	limits := pirserver.DefaultLimits()
+	limits.PerIPRate, limits.GlobalRate = 0, 0 // Tests query far faster than real clients
+	h.Server.SetLimits(limits)
+	h.PIR = httptest.NewServer(h.Server.Handler())
+	t.Cleanup(h.PIR.Close)
+
+	// plinko-update-service (its own copy of the database, as in production)
+	updateDB, hdr, err := plinko.LoadDatabase(h.path("hint.bin"))
+	mustDo(t, err)
+	h.Header = plinkoclient.HintHeader{DBSize: hdr.DBSize, ChunkSize: hdr.ChunkSize, SetSize: hdr.SetSize, Epoch: hdr.Epoch}
+	h.expected = append([]uint64(nil), updateDB...)
+	h.updates = plinko.NewPlinkoUpdateManager(updateDB, hdr.ChunkSize, hdr.SetSize)
+	h.updates.EnableCacheMode()
+}
+
+// Reload restarts the PIR server and update manager on the current
+// hint.bin, after it has been regenerated. Clients made before the reload
+// can still sync from the CDN, but their PIR server is gone.
+func (h *Harness) Reload(t testing.TB) {
+	t.Helper()
+	h.PIR.Close()
+	h.serve(t)
+}
+
+// EnableLWE publishes lwe-hint.bin for the current hint.bin, as
+// plinko-hint-generator does with LWE_HINT=true, turns on the PIR server's
+// LWE queries and returns the hint as a client downloads it. LWE answers
+// come from the database as of the call, so make it before ApplyBlock.
+func (h *Harness) EnableLWE(t testing.TB) *plinkoclient.LWEHint {
+	t.Helper()
+	mustDo(t, hintgen.WriteLWEHintFile(h.path(hintgen.LWEHintFile), h.path("hint.bin"), crand.Reader))
+	h.Server.EnableLWE()
+	hint, err := plinkoclient.New(h.PIR.URL, h.CDN.URL).DownloadLWEHint(contextFor(t), filepath.Join(t.TempDir(), "lwe-hint.bin"))
+	mustDo(t, err)
+	return hint
+}
+
+// Client returns a Go client with hints built from the CDN's hint.bin
+func (h *Harness) Client(t testing.TB, cfg plinkoclient.HintConfig) *plinkoclient.Client {
+	t.Helper()
+	c := plinkoclient.New(h.PIR.URL, h.CDN.URL)
+	hintPath := filepath.Join(t.TempDir(), "hint.bin")
+	hdr, err := c.DownloadHint(contextFor(t), hintPath, nil)
+	mustDo(t, err)
+	if hdr != h.Header {
+		t.Fatalf("downloaded header %+v, want %+v", hdr, h.Header)
+	}
+	table, err := plinkoclient.BuildHintsFromFile(hintPath, cfg)
+	mustDo(t, err)
+	c.SetHints(table)
+	return c
+}
+
+// ApplyBlock changes entries the way plinko-update-service does for one
+// block: the update manager produces deltas, which are published under
+// deltas/, and the PIR server applies the same changes. It returns the
+// block number.
+func (h *Harness) ApplyBlock(t testing.TB, changes map[uint64]uint64) uint64 {
+	t.Helper()
+	h.block++
+
+	updates := make([]plinko.DBUpdate, 0, len(changes))
+	entries := make([]pirserver.EntryUpdate, 0, len(changes))
+	for idx, v := range changes {
+		updates = append(updates, plinko.DBUpdate{
+			Index:    idx,
+			OldValue: plinko.DBEntry{h.expected[idx]},
+			NewValue: plinko.DBEntry{v},
+		})
+		entries = append(entries, pirserver.EntryUpdate{Index: idx, Value: v})
+		h.expected[idx] = v
+	}
+
+	deltas, _, err := h.updates.ApplyUpdates(updates)
+	mustDo(t, err)
+	mustDo(t, plinko.SaveDelta(h.path(fmt.Sprintf("deltas/delta-%06d.bin", h.block)), deltas))
+	mustDo(t, h.Server.ApplyUpdates(h.block, entries))
+	return h.block
+}
+
+// SyncServer applies every entry where database differs from the PIR
+// server's to the server as of block, for tests that run an update service
+// of their own instead of ApplyBlock. It returns the number of changed
+// entries.
+func (h *Harness) SyncServer(t testing.TB, block uint64, database []uint64) int {
+	t.Helper()
+	var entries []pirserver.EntryUpdate
+	for idx, v := range database {
+		if h.expected[idx] != v {
+			entries = append(entries, pirserver.EntryUpdate{Index: uint64(idx), Value: v})
+			h.expected[idx] = v
+		}
+	}
+	mustDo(t, h.Server.ApplyUpdates(block, entries))
+	return len(entries)
+}
+
+// Value returns the current value of a database entry
+func (h *Harness) Value(index uint64) uint64 {
+	return h.expected[index]
+}
+
+// Entries returns the padded number of database entries
+func (h *Harness) Entries() uint64 {
+	return uint64(len(h.expected))
+}
+
+func (h *Harness) path(name string) string {
+	return filepath.Join(h.Dir, name)
+}
+
+func mustDo(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// contextFor returns a context cancelled when the test ends
+func contextFor(t testing.TB) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
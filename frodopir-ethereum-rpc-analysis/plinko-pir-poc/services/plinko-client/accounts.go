@@ -0,0 +1,120 @@
+package plinkoclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// New accounts
+//
+// plinko-update-service gives accounts that are not in the database a
+// padding slot and publishes their address-mapping.bin records for block N
+// as /deltas/additions-N.bin, before delta-N.bin fills the slots. When the
+// slots run out, the database is regenerated under the next epoch and
+// /epoch.json names the last block folded into it:
+//
+//	{"epoch": 1, "last_block": 1234}
+//
+// Hints built from that epoch's hint.bin continue with block 1235; hints
+// from the previous epoch can only be synced up to block 1234.
+
+const (
+	EpochInfoFile = "epoch.json"
+	AdditionsFile = "address-additions.bin" // Local additions, appended by sync
+)
+
+// EpochInfo mirrors /epoch.json
+type EpochInfo struct {
+	Epoch     uint64 `json:"epoch"`
+	LastBlock uint64 `json:"last_block"` // Last block whose changes are in the epoch's hint.bin
+}
+
+// AddressMappingEntry is one address-mapping.bin record
+type AddressMappingEntry struct {
+	Address Address
+	Index   uint64
+}
+
+// FetchEpochInfo downloads /epoch.json. A deployment whose database has
+// never grown has none and gives (nil, nil).
+func (c *Client) FetchEpochInfo(ctx context.Context) (*EpochInfo, error) {
+	body, err := c.fetchOptional(ctx, "/"+EpochInfoFile)
+	if body == nil || err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var info EpochInfo
+	if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", EpochInfoFile, err)
+	}
+	return &info, nil
+}
+
+// FetchAdditions downloads the accounts added in block. Blocks that added
+// none have no file and give (nil, nil).
+func (c *Client) FetchAdditions(ctx context.Context, block uint64) ([]AddressMappingEntry, error) {
+	body, err := c.fetchOptional(ctx, fmt.Sprintf("/deltas/additions-%06d.bin", block))
+	if body == nil || err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ReadAddressMappingEntries(body)
+}
+
+// fetchOptional GETs a CDN file, returning a nil body if it does not exist
+func (c *Client) fetchOptional(ctx context.Context, file string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.CDNURL+file, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", file, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("download %s: %s", file, resp.Status)
+	}
+}
+
+// ReadAddressMappingEntries reads address-mapping.bin records
+func ReadAddressMappingEntries(r io.Reader) ([]AddressMappingEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%AddressMappingEntrySize != 0 {
+		return nil, fmt.Errorf("%d bytes of address mapping records is not a multiple of %d", len(data), AddressMappingEntrySize)
+	}
+	entries := make([]AddressMappingEntry, len(data)/AddressMappingEntrySize)
+	for i := range entries {
+		rec := data[i*AddressMappingEntrySize:]
+		entries[i] = AddressMappingEntry{
+			Address: Address(rec[:20]),
+			Index:   uint64(binary.LittleEndian.Uint32(rec[20:24])),
+		}
+	}
+	return entries, nil
+}
+
+// WriteAddressMappingEntries writes entries as address-mapping.bin records
+func WriteAddressMappingEntries(w io.Writer, entries []AddressMappingEntry) error {
+	buf := make([]byte, 0, len(entries)*AddressMappingEntrySize)
+	for _, e := range entries {
+		buf = append(buf, e.Address[:]...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(e.Index))
+	}
+	_, err := w.Write(buf)
+	return err
+}
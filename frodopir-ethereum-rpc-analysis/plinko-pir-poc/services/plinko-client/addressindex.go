@@ -0,0 +1,159 @@
+package plinkoclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// address-index.bin (written by db-generator) is the compact alternative to
+// address-mapping.bin. Addresses are keyed by k, the first 8 bytes of
+// SHA-256(address) as a big-endian uint64; the top P bits of k pick a bucket
+// and the next F bits are the fingerprint.
+//
+//	[0:8]   Magic "PLKAIDX1"
+//	[8:16]  Account count (uint64 LE)
+//	[16]    P, [17] F, [18] X (index bits), [19] reserved
+//	[20:24] Table entries M (uint32 LE)
+//	[24:28] Overflow entries O (uint32 LE)
+//	[28:32] Reserved
+//	then 2^P+1 uint32 LE bucket starts,
+//	then M packed F+X-bit entries (fingerprint | index<<F, LSB-first),
+//	then O × 24-byte (address, uint32 LE index) records sorted by address.
+//
+// Lookups are a binary search of the overflow list and of one bucket (about
+// eight entries). Every address in the database resolves to its index; an
+// address that is not may, with probability about 8/2^F, match a
+// fingerprint and get some other account's index.
+
+const (
+	AddressIndexMagic      = "PLKAIDX1"
+	AddressIndexHeaderSize = 32
+)
+
+// AddressIndex is a loaded address-index.bin
+type AddressIndex struct {
+	count      uint64
+	prefixBits uint
+	fpBits     uint
+	indexBits  uint
+	starts     []uint32
+	entries    []byte // Packed, with 8 bytes of padding for word reads
+	overflow   []byte // 24-byte records
+}
+
+// LoadAddressIndex reads an address-index.bin stream and checks its layout
+func LoadAddressIndex(r io.Reader) (*AddressIndex, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var header [AddressIndexHeaderSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("read address index header: %w", err)
+	}
+	if string(header[0:8]) != AddressIndexMagic {
+		return nil, errors.New("not an address index (bad magic)")
+	}
+	idx := &AddressIndex{
+		count:      binary.LittleEndian.Uint64(header[8:16]),
+		prefixBits: uint(header[16]),
+		fpBits:     uint(header[17]),
+		indexBits:  uint(header[18]),
+	}
+	m := uint64(binary.LittleEndian.Uint32(header[20:24]))
+	o := uint64(binary.LittleEndian.Uint32(header[24:28]))
+	if idx.prefixBits > 28 || idx.fpBits < 1 || idx.indexBits < 1 || idx.indexBits > 32 ||
+		idx.prefixBits+idx.fpBits > 64 || idx.fpBits+idx.indexBits > 56 || m+o != idx.count {
+		return nil, fmt.Errorf("invalid address index parameters P=%d F=%d X=%d M=%d O=%d count=%d",
+			idx.prefixBits, idx.fpBits, idx.indexBits, m, o, idx.count)
+	}
+
+	startBytes := make([]byte, 4*((1<<idx.prefixBits)+1))
+	if _, err := io.ReadFull(br, startBytes); err != nil {
+		return nil, fmt.Errorf("read bucket starts: %w", err)
+	}
+	idx.starts = make([]uint32, len(startBytes)/4)
+	for i := range idx.starts {
+		idx.starts[i] = binary.LittleEndian.Uint32(startBytes[4*i:])
+		if i > 0 && idx.starts[i] < idx.starts[i-1] || uint64(idx.starts[i]) > m {
+			return nil, fmt.Errorf("bucket %d starts at entry %d, out of order or past %d", i, idx.starts[i], m)
+		}
+	}
+	if uint64(idx.starts[len(idx.starts)-1]) != m {
+		return nil, fmt.Errorf("bucket starts end at %d, want %d", idx.starts[len(idx.starts)-1], m)
+	}
+
+	entryBytes := (m*uint64(idx.fpBits+idx.indexBits) + 7) / 8
+	idx.entries = make([]byte, entryBytes+8)
+	if _, err := io.ReadFull(br, idx.entries[:entryBytes]); err != nil {
+		return nil, fmt.Errorf("read index entries: %w", err)
+	}
+	idx.overflow = make([]byte, o*AddressMappingEntrySize)
+	if _, err := io.ReadFull(br, idx.overflow); err != nil {
+		return nil, fmt.Errorf("read overflow entries: %w", err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, errors.New("trailing data after address index")
+	}
+	return idx, nil
+}
+
+// LoadAddressIndexFile reads address-index.bin from path
+func LoadAddressIndexFile(path string) (*AddressIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadAddressIndex(f)
+}
+
+// Len returns the number of accounts in the index
+func (idx *AddressIndex) Len() uint64 { return idx.count }
+
+// Overflow returns the number of accounts stored with their full address
+func (idx *AddressIndex) Overflow() int { return len(idx.overflow) / AddressMappingEntrySize }
+
+// Lookup returns the database index of addr
+func (idx *AddressIndex) Lookup(addr Address) (uint64, error) {
+	n := idx.Overflow()
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(idx.overflow[i*AddressMappingEntrySize:i*AddressMappingEntrySize+20], addr[:]) >= 0
+	})
+	if i < n {
+		rec := idx.overflow[i*AddressMappingEntrySize : (i+1)*AddressMappingEntrySize]
+		if Address(rec[:20]) == addr {
+			return uint64(binary.LittleEndian.Uint32(rec[20:])), nil
+		}
+	}
+
+	sum := sha256.Sum256(addr[:])
+	key := binary.BigEndian.Uint64(sum[:8])
+	bucket := key >> (64 - idx.prefixBits)
+	fp := key >> (64 - idx.prefixBits - idx.fpBits) & (1<<idx.fpBits - 1)
+
+	lo, hi := int(idx.starts[bucket]), int(idx.starts[bucket+1])
+	j := lo + sort.Search(hi-lo, func(j int) bool {
+		got, _ := idx.entry(lo + j)
+		return got >= fp
+	})
+	if j < hi {
+		if got, index := idx.entry(j); got == fp {
+			return index, nil
+		}
+	}
+	return 0, ErrAddressNotFound
+}
+
+// entry unpacks table entry i into its fingerprint and index
+func (idx *AddressIndex) entry(i int) (fp, index uint64) {
+	width := idx.fpBits + idx.indexBits
+	bit := uint64(i) * uint64(width)
+	word := binary.LittleEndian.Uint64(idx.entries[bit/8:]) >> (bit % 8)
+	word &= 1<<width - 1
+	return word & (1<<idx.fpBits - 1), word >> idx.fpBits
+}
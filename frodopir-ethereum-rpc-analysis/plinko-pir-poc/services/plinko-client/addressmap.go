@@ -0,0 +1,75 @@
+package plinkoclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// address-mapping.bin (written by db-generator) holds one 24-byte record per
+// account: the 20-byte address followed by its database index as a uint32
+// LE. Records are in database order, which is not byte order of the
+// addresses, so lookups scan the file.
+
+const AddressMappingEntrySize = 24
+
+// ErrAddressNotFound is returned for addresses that are not in the database
+var ErrAddressNotFound = errors.New("address not in database")
+
+// Address is a 20-byte Ethereum address
+type Address [20]byte
+
+// ParseAddress parses a hex address with or without the 0x prefix
+func ParseAddress(s string) (Address, error) {
+	var a Address
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s) != 40 {
+		return a, fmt.Errorf("address must be 20 bytes of hex, got %d characters", len(s))
+	}
+	if _, err := hex.Decode(a[:], []byte(s)); err != nil {
+		return a, fmt.Errorf("invalid address: %w", err)
+	}
+	return a, nil
+}
+
+// String returns the lowercase 0x-prefixed hex address
+func (a Address) String() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+// MarshalText encodes the address as String does
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText parses a hex address, as in JSON files from db-generator
+func (a *Address) UnmarshalText(text []byte) error {
+	parsed, err := ParseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// LookupAddress scans an address-mapping.bin stream for addr and returns its
+// database index
+func LookupAddress(r io.Reader, addr Address) (uint64, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var rec [AddressMappingEntrySize]byte
+	for {
+		if _, err := io.ReadFull(br, rec[:]); err != nil {
+			if err == io.EOF {
+				return 0, ErrAddressNotFound
+			}
+			return 0, fmt.Errorf("read address mapping: %w", err)
+		}
+		if Address(rec[:20]) == addr {
+			return uint64(binary.LittleEndian.Uint32(rec[20:24])), nil
+		}
+	}
+}
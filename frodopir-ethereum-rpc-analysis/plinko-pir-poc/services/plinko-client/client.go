@@ -0,0 +1,447 @@
+// Package plinkoclient is a Go client for the Plinko PIR server.
+//
+// It downloads and verifies hint.bin from the CDN, builds Piano-style
+// primary and backup hints from it, reads database entries privately with
+// punctured queries against /v1/query/punctured, and keeps its hints current
+// by applying the delta files published by plinko-update-service. Databases
+// in the keyword layout are read by address with QueryAddress.
+package plinkoclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the server protocol this client speaks
+	ProtocolVersion = 1
+
+	ProtocolVersionHeader = "X-Plinko-Protocol-Version"
+	EpochHeader           = "X-Plinko-Epoch"
+	BlockHeader           = "X-Plinko-Block"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+var (
+	ErrEpochMismatch = errors.New("server epoch differs from the hint table's; rebuild hints")
+	ErrBlockMismatch = errors.New("server block differs from the hint table's; sync deltas and retry")
+	ErrNoHints       = errors.New("client has no hint table")
+	ErrDeltaNotFound = errors.New("delta file not published yet")
+)
+
+// APIError is an error response from the PIR server
+type APIError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pir server: %s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+// Params mirrors the server's /v1/params response
+type Params struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	APIVersion      string   `json:"api_version"`
+	Epoch           uint64   `json:"epoch"`
+	DBSize          uint64   `json:"db_size"`
+	PaddedSize      uint64   `json:"padded_size"`
+	ChunkSize       uint64   `json:"chunk_size"`
+	SetSize         uint64   `json:"set_size"`
+	EntrySize       int      `json:"entry_size"`
+	QueryTypes      []string `json:"query_types"`
+	LastBlock       uint64   `json:"last_block"` // Last delta block the server applied
+	Tables          []string `json:"tables"`     // Named tables (Table)
+}
+
+// Matches reports whether a hint.bin header describes the server's database
+func (p *Params) Matches(h HintHeader) error {
+	if p.ChunkSize != h.ChunkSize || p.SetSize != h.SetSize || p.DBSize != h.DBSize {
+		return fmt.Errorf("hint.bin (db %d, chunk %d, sets %d) does not match server (db %d, chunk %d, sets %d)",
+			h.DBSize, h.ChunkSize, h.SetSize, p.DBSize, p.ChunkSize, p.SetSize)
+	}
+	if p.Epoch != h.Epoch {
+		return fmt.Errorf("hint.bin epoch %d, server epoch %d: %w", h.Epoch, p.Epoch, ErrEpochMismatch)
+	}
+	return nil
+}
+
+// Client talks to a PIR server and the CDN that publishes hint.bin and deltas
+type Client struct {
+	ServerURL string       // e.g. http://localhost:3000
+	CDNURL    string       // e.g. http://localhost:8080
+	HTTP      *http.Client // Defaults to a client with DefaultTimeout
+
+	mu    sync.Mutex // Serializes queries and delta application
+	hints *HintTable
+}
+
+// New creates a client for the given PIR server and CDN
+func New(serverURL, cdnURL string) *Client {
+	return &Client{
+		ServerURL: serverURL,
+		CDNURL:    cdnURL,
+		HTTP:      &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// SetHints installs the hint table used by Query and ApplyDelta
+func (c *Client) SetHints(t *HintTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hints = t
+}
+
+// Hints returns the current hint table (nil if none is installed)
+func (c *Client) Hints() *HintTable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hints
+}
+
+// Params fetches the server's protocol version and database parameters
+func (c *Client) Params(ctx context.Context) (*Params, error) {
+	var p Params
+	if _, err := c.call(ctx, http.MethodGet, "/v1/params", nil, &p); err != nil {
+		return nil, err
+	}
+	if p.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("server speaks protocol %d, client speaks %d", p.ProtocolVersion, ProtocolVersion)
+	}
+	return &p, nil
+}
+
+// DownloadHint downloads hint.bin from the CDN to path. The file is checked
+// against the server's parameters (and wantSHA256, if non-nil) before it
+// replaces path.
+func (c *Client) DownloadHint(ctx context.Context, path string, wantSHA256 []byte) (HintHeader, error) {
+	params, err := c.Params(ctx)
+	if err != nil {
+		return HintHeader{}, err
+	}
+
+	tmpPath, err := c.download(ctx, "/hint.bin", path)
+	if err != nil {
+		return HintHeader{}, err
+	}
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	h, err := VerifyHintFile(tmpPath, wantSHA256)
+	if err != nil {
+		return h, err
+	}
+	if err := params.Matches(h); err != nil {
+		return h, err
+	}
+	return h, os.Rename(tmpPath, path)
+}
+
+// DownloadAddressMapping downloads address-mapping.bin from the CDN to path
+func (c *Client) DownloadAddressMapping(ctx context.Context, path string) error {
+	tmpPath, err := c.download(ctx, "/address-mapping.bin", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+	if info.Size()%AddressMappingEntrySize != 0 {
+		return fmt.Errorf("address-mapping.bin is %d bytes, not a multiple of %d", info.Size(), AddressMappingEntrySize)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// DownloadAddressIndex downloads address-index.bin from the CDN to path,
+// checking that it parses before it replaces path
+func (c *Client) DownloadAddressIndex(ctx context.Context, path string) (*AddressIndex, error) {
+	tmpPath, err := c.download(ctx, "/address-index.bin", path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	idx, err := LoadAddressIndexFile(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("address-index.bin: %w", err)
+	}
+	return idx, os.Rename(tmpPath, path)
+}
+
+// download fetches a CDN file into a temporary file next to path and returns
+// the temporary file's name; the caller verifies it and renames it into place
+func (c *Client) download(ctx context.Context, file, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.CDNURL+file, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", file, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", file, resp.Status)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("download %s: %w", file, err)
+	}
+	return f.Name(), nil
+}
+
+// puncturedRequest/puncturedResponse mirror the server's punctured query types
+type puncturedRequest struct {
+	Offsets []uint64 `json:"offsets"`
+}
+
+type puncturedResponse struct {
+	Parities []uint64 `json:"parities"`
+}
+
+// Query privately reads database entry index. The primary hint used is
+// replaced from the backups, so the table changes with every query; save it
+// afterwards if it is persisted. The hint is spent even if the query fails,
+// so a retry sends a different set. An answer from a server at another block
+// than the hints is discarded with ErrBlockMismatch.
+func (c *Client) Query(ctx context.Context, index uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hints == nil {
+		return 0, ErrNoHints
+	}
+	q, err := c.hints.prepare(index)
+	if err != nil {
+		return 0, err
+	}
+	defer c.hints.abandon(q) // Unless finished: never resend its set
+
+	var resp puncturedResponse
+	hdr, err := c.callWith(ctx, http.MethodPost, "/v1/query/punctured", c.version(), puncturedRequest{Offsets: q.offsets}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.checkVersion(hdr); err != nil {
+		return 0, err
+	}
+	return c.hints.finish(q, resp.Parities)
+}
+
+// batchRequest/batchResponse mirror the server's batched punctured query types
+type batchRequest struct {
+	Queries []puncturedRequest `json:"queries"`
+}
+
+type batchResponse struct {
+	Parities [][]uint64 `json:"parities"`
+}
+
+// QueryBatch privately reads several database entries in one request to
+// /v1/query/punctured/batch, each with its own primary hint. Values are in
+// the order of indices.
+func (c *Client) QueryBatch(ctx context.Context, indices []uint64) ([]uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hints == nil {
+		return nil, ErrNoHints
+	}
+	queries, err := c.hints.prepareBatch(indices)
+	if err != nil {
+		return nil, err
+	}
+	defer c.hints.abandon(queries...)
+
+	req := batchRequest{Queries: make([]puncturedRequest, len(queries))}
+	for i, q := range queries {
+		req.Queries[i].Offsets = q.offsets
+	}
+	var resp batchResponse
+	hdr, err := c.callWith(ctx, http.MethodPost, "/v1/query/punctured/batch", c.version(), req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkVersion(hdr); err != nil {
+		return nil, err
+	}
+	return c.hints.finishBatch(queries, resp.Parities)
+}
+
+// checkVersion rejects a punctured answer from another epoch or block than
+// the hint table's. Decoding it would return a wrong value for any entry of
+// the set that changed in between, and refresh a backup with it.
+func (c *Client) checkVersion(hdr http.Header) error {
+	if epoch, err := strconv.ParseUint(hdr.Get(EpochHeader), 10, 64); err == nil && epoch != c.hints.Header.Epoch {
+		return ErrEpochMismatch
+	}
+	if block, err := strconv.ParseUint(hdr.Get(BlockHeader), 10, 64); err == nil && block != c.hints.LastBlock {
+		return fmt.Errorf("answer at block %d, hints at block %d: %w", block, c.hints.LastBlock, ErrBlockMismatch)
+	}
+	return nil
+}
+
+// FetchDelta downloads and parses the delta file for a block
+func (c *Client) FetchDelta(ctx context.Context, block uint64) (*DeltaFile, error) {
+	url := fmt.Sprintf("%s/deltas/delta-%06d.bin", c.CDNURL, block)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download delta %d: %w", block, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrDeltaNotFound
+	default:
+		return nil, fmt.Errorf("download delta %d: %s", block, resp.Status)
+	}
+	return ParseDelta(resp.Body)
+}
+
+// SyncDeltas applies the delta files for blocks LastBlock+1 through to,
+// stopping early at the first block that has not been published. It returns
+// the number of delta entries applied. Once the database has moved on to a
+// later epoch (see EpochInfo), hints from the previous one are synced up to
+// the last block of their epoch and then get ErrEpochMismatch.
+func (c *Client) SyncDeltas(ctx context.Context, to uint64) (int, error) {
+	info, err := c.FetchEpochInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hints == nil {
+		return 0, ErrNoHints
+	}
+	var stale error
+	if info != nil && info.Epoch != c.hints.Header.Epoch {
+		if info.Epoch != c.hints.Header.Epoch+1 {
+			return 0, ErrEpochMismatch // Cannot tell where our epoch ended
+		}
+		if to > info.LastBlock {
+			to = info.LastBlock
+			stale = ErrEpochMismatch
+		}
+	}
+	applied := 0
+	for block := c.hints.LastBlock + 1; block <= to; block++ {
+		df, err := c.FetchDelta(ctx, block)
+		if errors.Is(err, ErrDeltaNotFound) {
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+		if err := c.hints.ApplyDelta(df); err != nil {
+			return applied, fmt.Errorf("block %d: %w", block, err)
+		}
+		c.hints.LastBlock = block
+		applied += len(df.Deltas)
+	}
+	if c.hints.LastBlock < to {
+		return applied, nil // Caught up with what is published
+	}
+	return applied, stale
+}
+
+// version returns the request headers naming the database version the hint
+// table is at, so a query router can pick a replica serving it. The caller
+// holds c.mu.
+func (c *Client) version() http.Header {
+	return http.Header{
+		EpochHeader: {strconv.FormatUint(c.hints.Header.Epoch, 10)},
+		BlockHeader: {strconv.FormatUint(c.hints.LastBlock, 10)},
+	}
+}
+
+// call sends a JSON request to the PIR server and decodes the response into
+// out. Error responses are returned as *APIError.
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}) (http.Header, error) {
+	return c.callWith(ctx, method, path, nil, in, out)
+}
+
+// callWith is call with extra request headers
+func (c *Client) callWith(ctx context.Context, method, path string, extra http.Header, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.ServerURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		req.Header[k] = v
+	}
+	req.Header.Set(ProtocolVersionHeader, strconv.Itoa(ProtocolVersion))
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if apiErr := decodeAPIError(resp); apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("pir server %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("decode %s response: %w", path, err)
+	}
+	return resp.Header, nil
+}
+
+// decodeAPIError reads the error envelope of a failed response, or returns
+// nil if the body is not one
+func decodeAPIError(resp *http.Response) *APIError {
+	var env struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil || env.Error.Code == "" {
+		return nil
+	}
+	env.Error.Status = resp.StatusCode
+	return &env.Error
+}
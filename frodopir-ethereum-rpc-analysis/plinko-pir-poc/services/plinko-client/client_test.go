@@ -0,0 +1,956 @@
+package plinkoclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testChunkSize = 32
+	testSetSize   = 8
+	testEpoch     = 7
+)
+
+// fakeServer serves hint.bin, deltas and punctured queries over an in-memory database
+type fakeServer struct {
+	db          []uint64
+	deltas      map[uint64][]byte
+	keyword     *KeywordParams // Served as keyword-params.json if set
+	epoch       *EpochInfo     // Served as epoch.json if set
+	batches     int
+	block       uint64          // Block answers are at: X-Plinko-Block, DPF last_block
+	pinned      string          // Epoch/block headers of the last punctured query
+	streams     int             // Database stream requests; the first two are damaged
+	tamper      bool            // Flip a bit of every verified DPF record
+	verifiedLag uint64          // Epochs the verified snapshot is behind the root
+	proofs      *StateProofInfo // Served as proofs.json if set
+	drop        int             // Punctured requests to read and then cut off
+	sent        [][]uint64      // Offsets of every punctured query received
+	onQuery     func([]uint64)  // Called once with the next punctured query's offsets
+}
+
+func newFakeServer() *fakeServer {
+	db := make([]uint64, testChunkSize*testSetSize)
+	for i := range db {
+		db[i] = uint64(i)*0x9e3779b97f4a7c15 + 1
+	}
+	return &fakeServer{db: db, deltas: make(map[uint64][]byte)}
+}
+
+func (f *fakeServer) hintBin() []byte {
+	buf := make([]byte, HintHeaderSize+len(f.db)*EntrySize)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(len(f.db)))
+	binary.LittleEndian.PutUint64(buf[8:], testChunkSize)
+	binary.LittleEndian.PutUint64(buf[16:], testSetSize)
+	binary.LittleEndian.PutUint64(buf[24:], testEpoch)
+	for i, v := range f.db {
+		binary.LittleEndian.PutUint64(buf[HintHeaderSize+i*EntrySize:], v)
+	}
+	return buf
+}
+
+// update changes entries and publishes a v1 delta file for block
+func (f *fakeServer) update(block uint64, changes map[uint64]uint64) {
+	buf := make([]byte, DeltaHeaderSize, DeltaHeaderSize+len(changes)*deltaEntrySizeV1)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(len(changes)))
+	binary.LittleEndian.PutUint64(buf[8:], DeltaFormatVersion)
+	for idx, v := range changes {
+		var e [deltaEntrySizeV1]byte
+		binary.LittleEndian.PutUint64(e[16:], f.db[idx]^v)
+		binary.LittleEndian.PutUint64(e[24:], idx)
+		buf = append(buf, e[:]...)
+		f.db[idx] = v
+	}
+	f.deltas[block] = buf
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(EpochHeader, strconv.Itoa(testEpoch))
+	w.Header().Set(BlockHeader, strconv.FormatUint(f.block, 10))
+	switch r.URL.Path {
+	case "/hint.bin":
+		w.Write(f.hintBin())
+	case "/v1/params":
+		json.NewEncoder(w).Encode(Params{
+			ProtocolVersion: ProtocolVersion, Epoch: testEpoch, DBSize: uint64(len(f.db)),
+			PaddedSize: uint64(len(f.db)), ChunkSize: testChunkSize, SetSize: testSetSize,
+		})
+	case "/v1/db/manifest":
+		raw := f.hintBin()[HintHeaderSize:]
+		m := DBManifest{Epoch: testEpoch, DBSize: uint64(len(f.db)), ChunkSize: testChunkSize, SetSize: testSetSize, EntrySize: EntrySize}
+		for c := 0; c < testSetSize; c++ {
+			sum := sha256.Sum256(raw[c*testChunkSize*EntrySize : (c+1)*testChunkSize*EntrySize])
+			m.Chunks = append(m.Chunks, sum[:])
+		}
+		json.NewEncoder(w).Encode(m)
+	case "/v1/db/stream":
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		raw := f.hintBin()[HintHeaderSize+from*testChunkSize*EntrySize:]
+		switch f.streams++; f.streams {
+		case 1: // Cut off in the middle of chunk 3
+			w.Write(raw[:(3-from)*testChunkSize*EntrySize+100])
+		case 2: // Chunk 3 corrupted in transit
+			raw = bytes.Clone(raw)
+			raw[17] ^= 1
+			w.Write(raw)
+		default:
+			w.Write(raw)
+		}
+	case "/v1/query/punctured":
+		f.pinned = r.Header.Get(EpochHeader) + "/" + r.Header.Get(BlockHeader)
+		var req puncturedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.sent = append(f.sent, req.Offsets)
+		f.queried(w, req.Offsets)
+		if f.cutOff(w) {
+			return
+		}
+		json.NewEncoder(w).Encode(puncturedResponse{Parities: f.punctured(req.Offsets)})
+	case "/v1/query/punctured/batch":
+		var req batchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.batches++
+		for _, q := range req.Queries {
+			f.sent = append(f.sent, q.Offsets)
+		}
+		if len(req.Queries) > 0 {
+			f.queried(w, req.Queries[0].Offsets)
+		}
+		if f.cutOff(w) {
+			return
+		}
+		var resp batchResponse
+		for _, q := range req.Queries {
+			resp.Parities = append(resp.Parities, f.punctured(q.Offsets))
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v1/query/dpf":
+		var req dpfRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(dpfResponse{Value: f.dpf(req.Key), LastBlock: f.block})
+	case "/v1/query/dpf/verified":
+		var req dpfRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(dpfVerifiedResponse{Record: f.dpfVerified(req.Key), Epoch: testEpoch + f.verifiedLag})
+	case "/" + MerkleRootFile:
+		levels := f.merkleLevels()
+		json.NewEncoder(w).Encode(MerkleRoot{
+			Epoch: testEpoch, Entries: uint64(len(f.db)), Depth: len(levels) - 1, Root: levels[len(levels)-1][0][:],
+		})
+	case "/v1/query/lwe":
+		var req lweRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(lweResponse{Answer: f.lwe(req.Query)})
+	case "/" + EpochInfoFile:
+		if f.epoch == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.epoch)
+	case "/" + StateProofInfoFile:
+		if f.proofs == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.proofs)
+	case "/" + KeywordParamsFile:
+		if f.keyword == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.keyword)
+	default:
+		if block, err := parseDeltaPath(r.URL.Path); err == nil && f.deltas[block] != nil {
+			w.Write(f.deltas[block])
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// queried runs onQuery once, restamping the block it may have moved
+func (f *fakeServer) queried(w http.ResponseWriter, offsets []uint64) {
+	if hook := f.onQuery; hook != nil {
+		f.onQuery = nil
+		hook(offsets)
+		w.Header().Set(BlockHeader, strconv.FormatUint(f.block, 10))
+	}
+}
+
+// cutOff closes the connection without an answer while drops are left, as
+// a network failure after the request went out
+func (f *fakeServer) cutOff(w http.ResponseWriter) bool {
+	if f.drop == 0 {
+		return false
+	}
+	f.drop--
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+	return true
+}
+
+// punctured answers a punctured query by brute force
+func (f *fakeServer) punctured(offsets []uint64) []uint64 {
+	parities := make([]uint64, testSetSize)
+	for j := range parities {
+		next := 0
+		for c := 0; c < testSetSize; c++ {
+			if c != j {
+				parities[j] ^= f.db[c*testChunkSize+int(offsets[next])]
+				next++
+			}
+		}
+	}
+	return parities
+}
+
+// decodeDPFKey parses MarshalBinary's encoding
+func decodeDPFKey(data []byte) *DPFKey {
+	var key DPFKey
+	if err := key.UnmarshalBinary(data); err != nil {
+		panic(err)
+	}
+	return &key
+}
+
+// dpf answers a DPF query by evaluating the key at every index
+func (f *fakeServer) dpf(data []byte) uint64 {
+	key := decodeDPFKey(data)
+	var acc uint64
+	for x := range f.db {
+		if key.Eval(uint64(x)) == 1 {
+			acc ^= f.db[x]
+		}
+	}
+	return acc
+}
+
+// merkleLevels hashes the database into a Merkle tree, leaves first
+func (f *fakeServer) merkleLevels() [][][sha256.Size]byte {
+	level := make([][sha256.Size]byte, len(f.db)) // A power of two
+	for i, v := range f.db {
+		leaf := binary.LittleEndian.AppendUint64([]byte{0x00}, uint64(i))
+		level[i] = sha256.Sum256(binary.LittleEndian.AppendUint64(leaf, v))
+	}
+	levels := [][][sha256.Size]byte{level}
+	for len(level) > 1 {
+		next := make([][sha256.Size]byte, len(level)/2)
+		for i := range next {
+			next[i] = sha256.Sum256(append(append([]byte{0x01}, level[2*i][:]...), level[2*i+1][:]...))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// dpfVerified answers a verified DPF query by XORing whole records
+func (f *fakeServer) dpfVerified(data []byte) []byte {
+	key := decodeDPFKey(data)
+	levels := f.merkleLevels()
+	acc := make([]byte, MerkleRecordSize(len(levels)-1))
+	for x := range f.db {
+		if key.Eval(uint64(x)) == 0 {
+			continue
+		}
+		record := binary.LittleEndian.AppendUint64(nil, f.db[x])
+		for l := range levels[:len(levels)-1] {
+			record = append(record, levels[l][x>>l^1][:]...)
+		}
+		for i := range acc {
+			acc[i] ^= record[i]
+		}
+	}
+	if f.tamper {
+		acc[40] ^= 1
+	}
+	return acc
+}
+
+// lweByte returns D[r][j] for the database laid out by LWELayout
+func (f *fakeServer) lweByte(r, j uint64) uint32 {
+	rows, _ := LWELayout(uint64(len(f.db)))
+	if i := j*(rows/8) + r/8; i < uint64(len(f.db)) {
+		return uint32(byte(f.db[i] >> (8 * (r % 8))))
+	}
+	return 0
+}
+
+// lwe answers an LWE query with D·q
+func (f *fakeServer) lwe(raw []byte) []byte {
+	rows, cols := LWELayout(uint64(len(f.db)))
+	out := make([]byte, 4*rows)
+	for r := uint64(0); r < rows; r++ {
+		var acc uint32
+		for j := uint64(0); j < cols; j++ {
+			acc += f.lweByte(r, j) * binary.LittleEndian.Uint32(raw[4*j:])
+		}
+		binary.LittleEndian.PutUint32(out[4*r:], acc)
+	}
+	return out
+}
+
+// lweHint builds the lwe-hint.bin plinko-hint-generator would publish
+func (f *fakeServer) lweHint(n uint64) *LWEHint {
+	hint := &LWEHint{LWEHeader: LWEHeader{Entries: uint64(len(f.db)), Epoch: testEpoch, Dimension: n, Seed: [LWESeedSize]byte{7}}}
+	hint.Rows, hint.Cols = LWELayout(hint.Entries)
+	hint.H = make([]uint32, hint.Rows*n)
+	gen, a := newLWEMatrixA(hint.Seed, n), make([]uint32, n)
+	for j := uint64(0); j < hint.Cols; j++ {
+		gen.next(a)
+		for r := uint64(0); r < hint.Rows; r++ {
+			for k := range a {
+				hint.H[r*n+uint64(k)] += f.lweByte(r, j) * a[k]
+			}
+		}
+	}
+	return hint
+}
+
+// parseDeltaPath extracts the block number from /deltas/delta-NNNNNN.bin
+func parseDeltaPath(path string) (uint64, error) {
+	const prefix, suffix = "/deltas/delta-", ".bin"
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) {
+		return 0, errors.New("not a delta path")
+	}
+	return strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(path, prefix), suffix), 10, 64)
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeServer) {
+	t.Helper()
+	fake := newFakeServer()
+	return newTestClientFor(t, fake), fake
+}
+
+// newTestClientFor serves fake and returns a client with hints built from it
+func newTestClientFor(t *testing.T, fake *fakeServer) *Client {
+	t.Helper()
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+
+	c := New(ts.URL, ts.URL)
+	path := filepath.Join(t.TempDir(), "hint.bin")
+	if _, err := c.DownloadHint(context.Background(), path, nil); err != nil {
+		t.Fatalf("DownloadHint: %v", err)
+	}
+	cfg := HintConfig{PrimaryHints: 16 * testChunkSize, BackupsPerChunk: 4, Rand: rand.New(rand.NewSource(1))}
+	table, err := BuildHintsFromFile(path, cfg)
+	if err != nil {
+		t.Fatalf("BuildHintsFromFile: %v", err)
+	}
+	c.SetHints(table)
+	return c
+}
+
+func TestQueryDecodesAndRefreshes(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+
+	// Query every entry of chunk 3 twice: the second round uses refreshed hints
+	for round := 0; round < 2; round++ {
+		for i := uint64(3 * testChunkSize); i < 4*testChunkSize; i += 9 {
+			got, err := c.Query(ctx, i)
+			if err != nil {
+				t.Fatalf("round %d Query(%d): %v", round, i, err)
+			}
+			if got != fake.db[i] {
+				t.Fatalf("round %d Query(%d) = %#x, want %#x", round, i, got, fake.db[i])
+			}
+		}
+	}
+	if s := c.Hints().Stats(); s.Primary != 16*testChunkSize-4 || s.EmptyChunks != 1 {
+		t.Errorf("stats after 8 queries in chunk 3 (4 backups) = %+v", s)
+	}
+}
+
+func TestRetryAfterFailedTransportSendsNewSets(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+	before := c.Hints().Stats().Primary
+
+	fake.drop = 2
+	if _, err := c.Query(ctx, 42); err == nil {
+		t.Fatal("Query over a cut-off connection succeeded")
+	}
+	if got, err := c.Query(ctx, 42); err == nil {
+		t.Fatalf("second cut-off Query = %#x", got)
+	}
+	if got, err := c.Query(ctx, 42); err != nil || got != fake.db[42] {
+		t.Fatalf("Query after failures = %#x, %v; want %#x", got, err, fake.db[42])
+	}
+
+	fake.drop = 1
+	indices := []uint64{3, 42, 200}
+	if _, err := c.QueryBatch(ctx, indices); err == nil {
+		t.Fatal("QueryBatch over a cut-off connection succeeded")
+	}
+	got, err := c.QueryBatch(ctx, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, idx := range indices {
+		if got[i] != fake.db[idx] {
+			t.Fatalf("batch value %d = %#x, want %#x", i, got[i], fake.db[idx])
+		}
+	}
+
+	// Every set reached the server at most once, failed requests included
+	seen := make(map[string]bool)
+	for _, offsets := range fake.sent {
+		key := fmt.Sprint(offsets)
+		if seen[key] {
+			t.Fatalf("offsets %v sent twice", offsets)
+		}
+		seen[key] = true
+	}
+	if len(fake.sent) != 3+2*len(indices) {
+		t.Errorf("server saw %d queries, want %d", len(fake.sent), 3+2*len(indices))
+	}
+	// The hints of failed queries are gone; answered ones were refreshed
+	if s := c.Hints().Stats(); s.Primary != before-2-len(indices) {
+		t.Errorf("%d primary hints left of %d, want %d", s.Primary, before, before-2-len(indices))
+	}
+}
+
+func TestDeltasKeepHintsCurrent(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+
+	// Program a hint on index 40 first so deltas must reach programmed hints too
+	if _, err := c.Query(ctx, 40); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.update(1, map[uint64]uint64{40: 111, 41: 222, 200: 333})
+	fake.update(2, map[uint64]uint64{40: 444})
+	fake.block = 2
+
+	n, err := c.SyncDeltas(ctx, 10)
+	if err != nil || n != 4 {
+		t.Fatalf("SyncDeltas = %d, %v; want 4 deltas", n, err)
+	}
+	if c.Hints().LastBlock != 2 {
+		t.Errorf("LastBlock = %d, want 2", c.Hints().LastBlock)
+	}
+
+	for _, idx := range []uint64{40, 41, 200, 5} {
+		got, err := c.Query(ctx, idx)
+		if err != nil {
+			t.Fatalf("Query(%d): %v", idx, err)
+		}
+		if got != fake.db[idx] {
+			t.Errorf("Query(%d) = %d, want %d", idx, got, fake.db[idx])
+		}
+	}
+	// Queries name the version the hints are at, for query routers
+	if want := strconv.Itoa(testEpoch) + "/2"; fake.pinned != want {
+		t.Errorf("query pinned to %q, want %q", fake.pinned, want)
+	}
+}
+
+func TestQueryAtAnotherBlockRejected(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+	before := c.Hints().Stats().Primary
+
+	// The server applies a block the client has not synced as the query
+	// arrives, changing another entry of the set (in the last chunk; index 42
+	// is in the first): its parity no longer decodes to the queried entry
+	advance := func(offsets []uint64) {
+		other := (testSetSize-1)*testChunkSize + offsets[len(offsets)-1]
+		fake.update(fake.block+1, map[uint64]uint64{other: ^fake.db[other]})
+		fake.block++
+	}
+	fake.onQuery = advance
+	if got, err := c.Query(ctx, 42); !errors.Is(err, ErrBlockMismatch) {
+		t.Fatalf("Query at a newer block = %#x, %v; want ErrBlockMismatch", got, err)
+	}
+	fake.onQuery = advance
+	if got, err := c.QueryBatch(ctx, []uint64{42, 100}); !errors.Is(err, ErrBlockMismatch) {
+		t.Fatalf("QueryBatch at a newer block = %#x, %v; want ErrBlockMismatch", got, err)
+	}
+	// The hints are spent, not refreshed from the wrong parities
+	if s := c.Hints().Stats(); s.Primary != before-3 {
+		t.Errorf("%d primary hints left of %d, want %d", s.Primary, before, before-3)
+	}
+
+	if n, err := c.SyncDeltas(ctx, 10); err != nil || n != 2 {
+		t.Fatalf("SyncDeltas = %d, %v; want 2 deltas", n, err)
+	}
+	if got, err := c.Query(ctx, 42); err != nil || got != fake.db[42] {
+		t.Errorf("Query after sync = %#x, %v; want %#x", got, err, fake.db[42])
+	}
+}
+
+func TestBuildHintsFromStream(t *testing.T) {
+	defer func(d time.Duration) { streamRetryDelay = d }(streamRetryDelay)
+	streamRetryDelay = time.Millisecond
+	fake := newFakeServer()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	c := New(ts.URL, ts.URL)
+	ctx := context.Background()
+
+	m, err := c.FetchDBManifest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := HintConfig{PrimaryHints: 16 * testChunkSize, BackupsPerChunk: 4, Rand: rand.New(rand.NewSource(2))}
+	table, err := c.BuildHintsFromStream(ctx, m, cfg)
+	if err != nil {
+		t.Fatalf("BuildHintsFromStream: %v", err)
+	}
+	// Resumed at chunk 3 after the cut, then again after the bad digest
+	if fake.streams != 3 {
+		t.Errorf("%d stream requests, want 3", fake.streams)
+	}
+	c.SetHints(table)
+	for i := uint64(0); i < testChunkSize*testSetSize; i += 13 {
+		if got, err := c.Query(ctx, i); err != nil || got != fake.db[i] {
+			t.Fatalf("Query(%d) = %#x, %v; want %#x", i, got, err, fake.db[i])
+		}
+	}
+
+	// A manifest that does not match the data fails after the retries
+	m.Chunks[5] = make([]byte, sha256.Size)
+	if _, err := c.BuildHintsFromStream(ctx, m, cfg); !errors.Is(err, ErrStreamCorrupt) {
+		t.Errorf("BuildHintsFromStream with a wrong digest = %v, want ErrStreamCorrupt", err)
+	}
+}
+
+func TestStreamOutlastsHTTPTimeout(t *testing.T) {
+	defer func(d, c time.Duration) { streamRetryDelay, streamChunkTimeout = d, c }(streamRetryDelay, streamChunkTimeout)
+	streamRetryDelay, streamChunkTimeout = time.Millisecond, 250*time.Millisecond
+	fake := newFakeServer()
+	size := testChunkSize * EntrySize
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/db/stream" {
+			fake.ServeHTTP(w, r)
+			return
+		}
+		requests = append(requests, r.URL.Query().Get("from"))
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		raw := fake.hintBin()[HintHeaderSize+from*size:]
+		switch len(requests) {
+		case 1: // Over the stream rate limit
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]APIError{"error": {Code: "rate_limited", Retryable: true}})
+			return
+		case 2: // Two chunks, then nothing
+			w.Write(raw[:2*size])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		// Slower in all than the client's Timeout, but never stalled
+		for c := 0; c < len(raw)/size; c++ {
+			time.Sleep(10 * time.Millisecond) // Well inside streamChunkTimeout, even on a loaded machine
+			w.Write(raw[c*size : (c+1)*size])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+	c := New(ts.URL, ts.URL)
+	c.HTTP.Timeout = 50 * time.Millisecond
+	ctx := context.Background()
+
+	m, err := c.FetchDBManifest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := HintConfig{PrimaryHints: 16 * testChunkSize, BackupsPerChunk: 4, Rand: rand.New(rand.NewSource(2))}
+	table, err := c.BuildHintsFromStream(ctx, m, cfg)
+	if err != nil {
+		t.Fatalf("BuildHintsFromStream: %v", err)
+	}
+	if want := []string{"0", "0", "2"}; fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("streams from chunks %v, want %v", requests, want)
+	}
+	c.SetHints(table)
+	if got, err := c.Query(ctx, 200); err != nil || got != fake.db[200] {
+		t.Fatalf("Query(200) = %#x, %v; want %#x", got, err, fake.db[200])
+	}
+}
+
+func TestSyncStopsAtEpochEnd(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+
+	fake.update(1, map[uint64]uint64{40: 111})
+	fake.update(2, map[uint64]uint64{41: 222})
+	fake.update(3, map[uint64]uint64{42: 333}) // First block of the next epoch
+	fake.epoch = &EpochInfo{Epoch: testEpoch + 1, LastBlock: 2}
+	fake.block = 2 // Still serving the old epoch
+
+	n, err := c.SyncDeltas(ctx, 10)
+	if !errors.Is(err, ErrEpochMismatch) || n != 2 {
+		t.Fatalf("SyncDeltas = %d, %v; want 2 deltas and ErrEpochMismatch", n, err)
+	}
+	if c.Hints().LastBlock != 2 {
+		t.Errorf("LastBlock = %d, want 2", c.Hints().LastBlock)
+	}
+	if got, err := c.Query(ctx, 41); err != nil || got != 222 {
+		t.Errorf("Query(41) = %d, %v; want 222", got, err)
+	}
+
+	// Two epochs on, there is no telling which blocks are ours
+	fake.epoch = &EpochInfo{Epoch: testEpoch + 2, LastBlock: 3}
+	if _, err := c.SyncDeltas(ctx, 10); !errors.Is(err, ErrEpochMismatch) {
+		t.Errorf("SyncDeltas two epochs on: %v, want ErrEpochMismatch", err)
+	}
+}
+
+func TestHintTableRoundTrip(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+	if _, err := c.Query(ctx, 17); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hints.dat")
+	if err := c.Hints().SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadHintTableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Stats() != c.Hints().Stats() || loaded.Header != c.Hints().Header {
+		t.Fatalf("loaded table differs: %+v vs %+v", loaded.Stats(), c.Hints().Stats())
+	}
+
+	c.SetHints(loaded)
+	if got, err := c.Query(ctx, 17); err != nil || got != fake.db[17] {
+		t.Errorf("Query(17) with loaded table = %d, %v; want %d", got, err, fake.db[17])
+	}
+}
+
+func TestLegacyDeltaRejected(t *testing.T) {
+	legacy := make([]byte, DeltaHeaderSize+deltaEntrySizeV0)
+	binary.LittleEndian.PutUint64(legacy, 1)
+	df, err := ParseDelta(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := &HintTable{Header: HintHeader{ChunkSize: 1, SetSize: 1}}
+	if err := table.ApplyDelta(df); !errors.Is(err, ErrLegacyDelta) {
+		t.Errorf("ApplyDelta(v0) = %v, want ErrLegacyDelta", err)
+	}
+}
+
+func TestVerifyHintFileRejectsTruncated(t *testing.T) {
+	data := newFakeServer().hintBin()
+	path := filepath.Join(t.TempDir(), "hint.bin")
+	os.WriteFile(path, data[:len(data)-8], 0644)
+	if _, err := VerifyHintFile(path, nil); err == nil {
+		t.Error("truncated hint.bin verified")
+	}
+}
+
+func TestLookupAddress(t *testing.T) {
+	var mapping bytes.Buffer
+	for i := uint32(0); i < 3; i++ {
+		var rec [AddressMappingEntrySize]byte
+		rec[0], rec[19] = 0x10, byte(i)
+		binary.LittleEndian.PutUint32(rec[20:], 2-i)
+		mapping.Write(rec[:])
+	}
+
+	addr, err := ParseAddress("0x1000000000000000000000000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := LookupAddress(bytes.NewReader(mapping.Bytes()), addr); err != nil || idx != 1 {
+		t.Errorf("LookupAddress = %d, %v; want 1", idx, err)
+	}
+
+	addr[19] = 9
+	if _, err := LookupAddress(bytes.NewReader(mapping.Bytes()), addr); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("LookupAddress(missing) = %v, want ErrAddressNotFound", err)
+	}
+	if _, err := ParseAddress("0x1234"); err == nil {
+		t.Error("short address parsed")
+	}
+}
+
+func TestLookupHolderTokens(t *testing.T) {
+	alice, bob := Address{0xa1}, Address{0xb0}
+	usdc, dai := Address{0x01}, Address{0x02}
+	var mapping bytes.Buffer
+	WriteTokenMappingEntries(&mapping, []TokenMappingEntry{
+		{Holder: alice, Token: usdc, Index: 0},
+		{Holder: alice, Token: dai, Index: 1},
+		{Holder: bob, Token: dai, Index: 2},
+	})
+
+	held, err := LookupHolderTokens(bytes.NewReader(mapping.Bytes()), alice)
+	if err != nil || len(held) != 2 || held[usdc] != 0 || held[dai] != 1 {
+		t.Errorf("alice holds %v, %v", held, err)
+	}
+	if held, err := LookupHolderTokens(bytes.NewReader(mapping.Bytes()), Address{0xcc}); err != nil || len(held) != 0 {
+		t.Errorf("unknown holder holds %v, %v", held, err)
+	}
+	if _, err := ReadTokenMappingEntries(bytes.NewReader(mapping.Bytes()[:50])); err == nil {
+		t.Error("truncated token mapping read")
+	}
+
+	var info TokenInfo
+	if err := json.Unmarshal([]byte(`{"tokens":[{"address":"0x0100000000000000000000000000000000000000","symbol":"USDC"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Tokens) != 1 || info.Tokens[0].Address != usdc {
+		t.Errorf("tokens.json decoded as %+v", info)
+	}
+	tc := New("http://pir", "http://cdn").Table(TokenTableName)
+	if tc.ServerURL != "http://pir/tables/token-balances" || tc.CDNURL != "http://cdn/tables/token-balances" {
+		t.Errorf("table client URLs %s, %s", tc.ServerURL, tc.CDNURL)
+	}
+}
+
+func TestAddressIndex(t *testing.T) {
+	// One bucket (P=0), 16-bit fingerprints, 2-bit indices: a and b in the
+	// table, c in the overflow list
+	a, b, c := Address{0xaa}, Address{0xbb}, Address{0xcc}
+	fp := func(addr Address) uint64 {
+		sum := sha256.Sum256(addr[:])
+		return uint64(binary.BigEndian.Uint16(sum[:2]))
+	}
+	words := []uint64{fp(a) | 2<<16, fp(b) | 0<<16}
+	if fp(a) > fp(b) {
+		words[0], words[1] = words[1], words[0]
+	}
+
+	var file bytes.Buffer
+	header := make([]byte, AddressIndexHeaderSize)
+	copy(header, AddressIndexMagic)
+	binary.LittleEndian.PutUint64(header[8:], 3)
+	header[16], header[17], header[18] = 0, 16, 2
+	binary.LittleEndian.PutUint32(header[20:], 2)
+	binary.LittleEndian.PutUint32(header[24:], 1)
+	file.Write(header)
+	file.Write([]byte{0, 0, 0, 0, 2, 0, 0, 0}) // Bucket starts 0, 2
+	packed := words[0] | words[1]<<18
+	file.Write([]byte{byte(packed), byte(packed >> 8), byte(packed >> 16), byte(packed >> 24), byte(packed >> 32)})
+	file.Write(c[:])
+	file.Write([]byte{1, 0, 0, 0})
+	data := file.Bytes()
+
+	idx, err := LoadAddressIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 3 || idx.Overflow() != 1 {
+		t.Fatalf("Len = %d, Overflow = %d; want 3 and 1", idx.Len(), idx.Overflow())
+	}
+	for addr, want := range map[Address]uint64{a: 2, b: 0, c: 1} {
+		if got, err := idx.Lookup(addr); err != nil || got != want {
+			t.Errorf("Lookup(%s) = %d, %v; want %d", addr, got, err, want)
+		}
+	}
+	if _, err := idx.Lookup(Address{0xdd}); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("Lookup(missing) = %v, want ErrAddressNotFound", err)
+	}
+
+	if _, err := LoadAddressIndex(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("truncated index loaded")
+	}
+	if _, err := LoadAddressIndex(bytes.NewReader(append(data[:len(data):len(data)], 0))); err == nil {
+		t.Error("index with trailing data loaded")
+	}
+}
+
+func TestQueryBatch(t *testing.T) {
+	c, fake := newTestClient(t)
+	ctx := context.Background()
+
+	// Entries in several chunks, one twice: every query needs its own hint
+	indices := []uint64{5, 6, 100, 5, 255, 37}
+	for round := 0; round < 3; round++ {
+		got, err := c.QueryBatch(ctx, indices)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		for i, idx := range indices {
+			if got[i] != fake.db[idx] {
+				t.Fatalf("round %d: value %d (index %d) = %#x, want %#x", round, i, idx, got[i], fake.db[idx])
+			}
+		}
+	}
+	if fake.batches != 3 {
+		t.Errorf("server saw %d batch requests, want 3", fake.batches)
+	}
+
+	// Refreshed hints still decode single queries
+	for _, idx := range indices {
+		if got, err := c.Query(ctx, idx); err != nil || got != fake.db[idx] {
+			t.Errorf("Query(%d) after batches = %#x, %v; want %#x", idx, got, err, fake.db[idx])
+		}
+	}
+	if _, err := c.QueryBatch(ctx, []uint64{1, testChunkSize * testSetSize}); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("QueryBatch(out of range) = %v, want ErrIndexOutOfRange", err)
+	}
+}
+
+func TestQueryAddress(t *testing.T) {
+	fake := newFakeServer()
+	ctx := context.Background()
+
+	// Place two accounts in a keyword table over the fake database, one in
+	// its last candidate bucket
+	fake.keyword = &KeywordParams{
+		Version: KeywordFormatVersion, Buckets: uint64(len(fake.db) / 2), Hashes: 3,
+		Seed: HexBytes{0x5e, 0xed}, Accounts: 2,
+	}
+	p := fake.keyword
+	accounts := map[Address]uint64{{0x01}: 1_000_000, {0x02}: 42}
+	place := map[Address]int{{0x01}: 0, {0x02}: 2}
+	for addr, balance := range accounts {
+		b := p.Bucket(addr, place[addr])
+		fake.db[2*b], fake.db[2*b+1] = p.Tag(addr), balance
+	}
+	c := newTestClientFor(t, fake)
+
+	got, err := c.FetchKeywordParams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Buckets != p.Buckets || !bytes.Equal(got.Seed, p.Seed) {
+		t.Fatalf("FetchKeywordParams = %+v, want %+v", got, p)
+	}
+	for addr, want := range accounts {
+		if balance, err := c.QueryAddress(ctx, got, addr); err != nil || balance != want {
+			t.Errorf("QueryAddress(%s) = %d, %v; want %d", addr, balance, err, want)
+		}
+	}
+	if _, err := c.QueryAddress(ctx, got, Address{0x03}); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("QueryAddress(missing) = %v, want ErrAddressNotFound", err)
+	}
+	if fake.batches != 3 {
+		t.Errorf("server saw %d batch requests for 3 lookups, want 3", fake.batches)
+	}
+}
+
+func TestDPFPair(t *testing.T) {
+	a, b := newFakeServer(), newFakeServer()
+	a.block, b.block = 12, 12
+	tsA, tsB := httptest.NewServer(a), httptest.NewServer(b)
+	defer tsA.Close()
+	defer tsB.Close()
+	pair := NewDPFPair(tsA.URL, tsB.URL)
+	ctx := context.Background()
+
+	for _, idx := range []uint64{0, 77, testChunkSize*testSetSize - 1} {
+		got, block, err := pair.Query(ctx, idx)
+		if err != nil || got != a.db[idx] || block != 12 {
+			t.Errorf("Query(%d) = %#x at block %d, %v; want %#x at 12", idx, got, block, err, a.db[idx])
+		}
+	}
+	if _, _, err := pair.Query(ctx, testChunkSize*testSetSize); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("Query(out of range) = %v, want ErrIndexOutOfRange", err)
+	}
+
+	// One server a block ahead: the shares do not combine
+	b.block = 13
+	if _, _, err := pair.Query(ctx, 77); !errors.Is(err, ErrServersDiverged) {
+		t.Errorf("Query across blocks = %v, want ErrServersDiverged", err)
+	}
+}
+
+func TestQueryVerified(t *testing.T) {
+	a, b := newFakeServer(), newFakeServer()
+	tsA, tsB := httptest.NewServer(a), httptest.NewServer(b)
+	defer tsA.Close()
+	defer tsB.Close()
+	pair := NewDPFPair(tsA.URL, tsB.URL)
+	ctx := context.Background()
+
+	root, err := New(tsA.URL, tsA.URL).FetchMerkleRoot(ctx)
+	if err != nil {
+		t.Fatalf("FetchMerkleRoot: %v", err)
+	}
+	for _, idx := range []uint64{0, 77, 128, testChunkSize*testSetSize - 1} {
+		if got, err := pair.QueryVerified(ctx, root, idx); err != nil || got != a.db[idx] {
+			t.Errorf("QueryVerified(%d) = %#x, %v; want %#x", idx, got, err, a.db[idx])
+		}
+	}
+
+	// One server lying about a sibling, or both about the database
+	b.tamper = true
+	if _, err := pair.QueryVerified(ctx, root, 77); !errors.Is(err, ErrProofInvalid) {
+		t.Errorf("QueryVerified with a tampered record = %v, want ErrProofInvalid", err)
+	}
+	b.tamper = false
+	a.db[77]++
+	b.db[77]++
+	if _, err := pair.QueryVerified(ctx, root, 77); !errors.Is(err, ErrProofInvalid) {
+		t.Errorf("QueryVerified with a changed entry = %v, want ErrProofInvalid", err)
+	}
+
+	stale := *root
+	stale.Epoch--
+	if _, err := pair.QueryVerified(ctx, &stale, 0); !errors.Is(err, ErrEpochMismatch) {
+		t.Errorf("QueryVerified with an old root = %v, want ErrEpochMismatch", err)
+	}
+
+	// Answers pinned to a snapshot the root does not commit to
+	b.verifiedLag = 1
+	if _, err := pair.QueryVerified(ctx, root, 0); !errors.Is(err, ErrEpochMismatch) {
+		t.Errorf("QueryVerified with an answer for another epoch = %v, want ErrEpochMismatch", err)
+	}
+}
+
+func TestQueryLWE(t *testing.T) {
+	fake := newFakeServer()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	c := New(ts.URL, ts.URL)
+	hint := fake.lweHint(1024)
+
+	// Round trip through the file format
+	var buf bytes.Buffer
+	header := make([]byte, LWEHintHeaderSize)
+	copy(header, LWEHintMagic)
+	for i, v := range []uint64{hint.Entries, hint.Epoch, hint.Rows, hint.Cols, hint.Dimension} {
+		binary.LittleEndian.PutUint64(header[8+8*i:], v)
+	}
+	copy(header[48:], hint.Seed[:])
+	buf.Write(header)
+	binary.Write(&buf, binary.LittleEndian, hint.H)
+	loaded, err := LoadLWEHint(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("LoadLWEHint: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, idx := range []uint64{0, 1, 77, 200, testChunkSize*testSetSize - 1} {
+		got, err := c.QueryLWE(ctx, loaded, idx)
+		if err != nil || got != fake.db[idx] {
+			t.Errorf("QueryLWE(%d) = %#x, %v; want %#x", idx, got, err, fake.db[idx])
+		}
+	}
+	if _, err := c.QueryLWE(ctx, loaded, testChunkSize*testSetSize); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("QueryLWE(out of range) = %v, want ErrIndexOutOfRange", err)
+	}
+
+	if _, err := LoadLWEHint(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Error("LoadLWEHint accepted a truncated file")
+	}
+}
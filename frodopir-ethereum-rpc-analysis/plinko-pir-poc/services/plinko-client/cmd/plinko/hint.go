@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	plinkoclient "plinko-client"
+)
+
+func runHint(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: plinko hint fetch|info [flags]")
+	}
+	switch args[0] {
+	case "fetch":
+		return runHintFetch(ctx, args[1:])
+	case "info":
+		return runHintInfo(args[1:])
+	default:
+		return fmt.Errorf("unknown hint command %q (want fetch or info)", args[0])
+	}
+}
+
+func runHintFetch(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("hint fetch", flag.ContinueOnError)
+	g.register(fs)
+	sha := fs.String("sha256", "", "Expected SHA-256 of hint.bin (hex)")
+	keep := fs.Bool("keep", false, "Keep hint.bin after building hints")
+	primary := fs.Int("primary", 0, "Primary hints (default 8 × chunk size)")
+	backups := fs.Int("backups", 0, "Backup hints per chunk (default 4, or enough for -proof-reads)")
+	reads := fs.Int("proof-reads", plinkoclient.DefaultStateProofReads, "State proofs the hints must cover (-table state-proofs)")
+	stream := fs.Bool("stream", false, "Build hints from the server's database stream instead of hint.bin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var want []byte
+	if *sha != "" {
+		var err error
+		if want, err = hex.DecodeString(*sha); err != nil || len(want) != 32 {
+			return fmt.Errorf("-sha256 must be 64 hex characters")
+		}
+	}
+	if err := os.MkdirAll(g.stateDir(), 0755); err != nil {
+		return err
+	}
+
+	c := g.client()
+	hintPath := g.path(HintFile)
+
+	// State proofs read a whole record per account, mostly from one chunk:
+	// that takes far more backups per chunk than single-entry queries
+	var proofs *plinkoclient.StateProofInfo
+	if g.table == plinkoclient.StateProofTableName {
+		var err error
+		if proofs, err = c.FetchStateProofInfo(ctx); err != nil {
+			return err
+		}
+	}
+	config := func(hdr plinkoclient.HintHeader) plinkoclient.HintConfig {
+		cfg := plinkoclient.DefaultHintConfig(hdr)
+		if proofs != nil {
+			cfg = plinkoclient.StateProofHintConfig(hdr, proofs, *reads)
+		}
+		if *primary > 0 {
+			cfg.PrimaryHints = *primary
+		}
+		if *backups > 0 {
+			cfg.BackupsPerChunk = *backups
+		}
+		fmt.Printf("Building %d primary and %d backup hints...\n", cfg.PrimaryHints, cfg.BackupsPerChunk*int(hdr.SetSize))
+		return cfg
+	}
+
+	var hdr plinkoclient.HintHeader
+	var table *plinkoclient.HintTable
+	var start time.Time
+	if *stream {
+		// Hints straight from the server's database stream; nothing is
+		// written but the hint table
+		m, err := c.FetchDBManifest(ctx)
+		if err != nil {
+			return err
+		}
+		hdr = m.Header()
+		fmt.Printf("Streaming %s/v1/db/stream: %d entries, chunk size %d, %d chunks, epoch %d\n",
+			g.server, hdr.DBSize, hdr.ChunkSize, hdr.SetSize, hdr.Epoch)
+		cfg := config(hdr)
+		start = time.Now()
+		if table, err = c.BuildHintsFromStream(ctx, m, cfg); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Downloading %s/hint.bin...\n", g.cdn)
+		start = time.Now()
+		var err error
+		if hdr, err = c.DownloadHint(ctx, hintPath, want); err != nil {
+			return err
+		}
+		fmt.Printf("✅ hint.bin verified in %v: %d entries, chunk size %d, %d chunks, epoch %d\n",
+			time.Since(start).Round(time.Millisecond), hdr.DBSize, hdr.ChunkSize, hdr.SetSize, hdr.Epoch)
+
+		cfg := config(hdr)
+		start = time.Now()
+		if table, err = plinkoclient.BuildHintsFromFile(hintPath, cfg); err != nil {
+			return err
+		}
+
+		// A regenerated hint.bin already holds the blocks up to the end of
+		// the previous epoch
+		info, err := c.FetchEpochInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if info != nil && info.Epoch == hdr.Epoch {
+			table.LastBlock = info.LastBlock
+		}
+	}
+	if err := resetLookupFiles(&g, hdr.Epoch); err != nil {
+		return err
+	}
+	if err := table.SaveFile(g.path(HintTableFile)); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Hints built in %v and saved to %s\n", time.Since(start).Round(time.Millisecond), g.path(HintTableFile))
+
+	// Keyword-layout databases publish their cuckoo parameters; queries by
+	// address then need no address map
+	kwPath := g.path(plinkoclient.KeywordParamsFile)
+	if kw, err := c.FetchKeywordParams(ctx); err == nil {
+		if err := kw.Validate(hdr.DBSize); err != nil {
+			return err
+		}
+		data, _ := json.MarshalIndent(kw, "", "  ")
+		if err := os.WriteFile(kwPath, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Keyword layout: %d accounts in %d buckets, addresses are queried directly\n", kw.Accounts, kw.Buckets)
+	} else if err := os.Remove(kwPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if !*keep && !*stream {
+		return os.Remove(hintPath)
+	}
+	return nil
+}
+
+// resetLookupFiles drops the accounts synced into address-additions.bin,
+// which the new hints start over from, and the address index and mapping if
+// they belong to another epoch: a regenerated database appends accounts to
+// the mapping and has no index until db-generator writes one. A token
+// table's mapping is small and always downloaded again.
+func resetLookupFiles(g *globals, epoch uint64) error {
+	files := []string{plinkoclient.AdditionsFile, plinkoclient.TokenAdditionsFile}
+	if old, err := plinkoclient.LoadHintTableFile(g.path(HintTableFile)); err == nil && old.Header.Epoch != epoch {
+		files = append(files, AddressIndexFile, AddressMappingFile)
+	}
+	if g.table != "" {
+		files = append(files, plinkoclient.TokenMappingFile)
+	}
+	for _, name := range files {
+		if err := os.Remove(g.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHintInfo(args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("hint info", flag.ContinueOnError)
+	g.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, table, err := g.loadHints()
+	if err != nil {
+		return err
+	}
+	printHintTable(g.path(HintTableFile), table)
+	return nil
+}
+
+func printHintTable(path string, table *plinkoclient.HintTable) {
+	s := table.Stats()
+	fmt.Printf("%s: hint table\n", path)
+	printHintHeader(table.Header)
+	fmt.Printf("  Last block:     %d\n", table.LastBlock)
+	fmt.Printf("  Primary hints:  %d\n", s.Primary)
+	fmt.Printf("  Backup hints:   %d (min %d per chunk, %d chunks exhausted)\n", s.Backups, s.MinBackups, s.EmptyChunks)
+}
+
+func printHintHeader(h plinkoclient.HintHeader) {
+	fmt.Printf("  DB size:        %d entries\n", h.DBSize)
+	fmt.Printf("  Chunk size:     %d\n", h.ChunkSize)
+	fmt.Printf("  Set size:       %d chunks\n", h.SetSize)
+	fmt.Printf("  Epoch:          %d\n", h.Epoch)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	plinkoclient "plinko-client"
+)
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	n := fs.Int("n", 5, "Entries to print per file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: plinko inspect [-n N] <file>...")
+	}
+
+	for i, path := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := inspectFile(path, *n); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// inspectFile recognises hint tables and address indexes by magic, deltas
+// and address mappings by name, and treats anything else as hint.bin
+func inspectFile(path string, n int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(8); bytes.HasPrefix(magic, []byte("PLKHINT")) {
+		table, err := plinkoclient.LoadHintTable(br)
+		if err != nil {
+			return err
+		}
+		printHintTable(path, table)
+		return nil
+	}
+	if magic, _ := br.Peek(8); bytes.Equal(magic, []byte(plinkoclient.AddressIndexMagic)) {
+		idx, err := plinkoclient.LoadAddressIndex(br)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: address index (%d bytes, %.1f per account)\n", path, info.Size(), float64(info.Size())/float64(max(idx.Len(), 1)))
+		fmt.Printf("  Accounts: %d (%d in the overflow list)\n", idx.Len(), idx.Overflow())
+		return nil
+	}
+
+	base := filepath.Base(path)
+	switch {
+	case strings.HasPrefix(base, "delta-"):
+		return inspectDelta(path, br, n)
+	case strings.HasPrefix(base, "address-mapping"):
+		return inspectAddressMapping(path, br, n)
+	default:
+		return inspectHintBin(path, n)
+	}
+}
+
+func inspectHintBin(path string, n int) error {
+	hdr, err := plinkoclient.VerifyHintFile(path, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: hint.bin (%d bytes, size verified)\n", path, hdr.FileSize())
+	printHintHeader(hdr)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(plinkoclient.HintHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	var buf [plinkoclient.EntrySize]byte
+	for i := 0; i < n && uint64(i) < hdr.Entries(); i++ {
+		if _, err := io.ReadFull(f, buf[:]); err != nil {
+			return err
+		}
+		v := binary.LittleEndian.Uint64(buf[:])
+		fmt.Printf("  [%d] %d wei (%s ETH)\n", i, v, formatEther(v))
+	}
+	return nil
+}
+
+func inspectDelta(path string, r io.Reader, n int) error {
+	df, err := plinkoclient.ParseDelta(r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: delta file, format v%d, %d entries\n", path, df.Version, len(df.Deltas))
+	if df.Version < plinkoclient.DeltaFormatVersion {
+		fmt.Println("  ⚠️  Legacy format without database indices; clients cannot apply it")
+	}
+
+	if df.Version >= plinkoclient.DeltaFormatVersion {
+		distinct := make(map[uint64]struct{}, len(df.Deltas))
+		for _, d := range df.Deltas {
+			distinct[d.Index] = struct{}{}
+		}
+		fmt.Printf("  Distinct indices: %d\n", len(distinct))
+	}
+
+	for i, d := range df.Deltas {
+		if i >= n {
+			break
+		}
+		line := fmt.Sprintf("  [%d] hint set %d, backup %v, delta %#x", i, d.HintSetID, d.IsBackupSet, d.Delta)
+		if df.Version >= plinkoclient.DeltaFormatVersion {
+			line += fmt.Sprintf(", index %d", d.Index)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func inspectAddressMapping(path string, r io.Reader, n int) error {
+	var rec [plinkoclient.AddressMappingEntrySize]byte
+	count := 0
+	fmt.Printf("%s: address mapping\n", path)
+	for {
+		_, err := io.ReadFull(r, rec[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", count, err)
+		}
+		if count < n {
+			fmt.Printf("  %s -> %d\n", plinkoclient.Address(rec[:20]), binary.LittleEndian.Uint32(rec[20:]))
+		}
+		count++
+	}
+	fmt.Printf("  Records: %d\n", count)
+	return nil
+}
@@ -0,0 +1,182 @@
+// Command plinko queries a Plinko PIR deployment from the terminal.
+//
+//	plinko hint fetch            Download hint.bin (or -stream the database) and build local hints
+//	plinko hint info             Show how many hints are left
+//	plinko query <address>       Private balance lookup
+//	plinko query -index N        Private lookup of a database index
+//	plinko query -dpf URL ...    Hint-free lookup from -server and a second server
+//	                             (-verify: check it against merkle-root.json)
+//	plinko sync                  Apply published deltas to the local hints
+//	plinko portfolio <address>   Private ETH and token balance lookup
+//	plinko prove <address>       Private balance lookup checked against a block's
+//	                             state root (-rpc URL for headers)
+//	plinko tables                List the tables the server answers for
+//	plinko inspect <file>...     Decode hint.bin, delta, hint table, address index or mapping files
+//
+// State (hints.dat, address-index.bin, address-additions.bin,
+// keyword-params.json) lives in -data-dir. With -table NAME, hint, query and
+// sync work on another table the deployment serves (token-balances), whose
+// state lives in -data-dir/tables/NAME; -table eth-balances is the account
+// database.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	plinkoclient "plinko-client"
+)
+
+const (
+	DefaultServerURL = "http://localhost:3000"
+	DefaultCDNURL    = "http://localhost:8080"
+	DefaultDataDir   = ".plinko" // Under the user's home directory
+
+	HintTableFile      = "hints.dat"
+	HintFile           = "hint.bin"
+	AddressMappingFile = "address-mapping.bin"
+	AddressIndexFile   = "address-index.bin"
+)
+
+// globals are the flags shared by every subcommand
+type globals struct {
+	server  string
+	cdn     string
+	dataDir string
+	table   string // Empty for the account database
+}
+
+func (g *globals) register(fs *flag.FlagSet) {
+	home, _ := os.UserHomeDir()
+	fs.StringVar(&g.server, "server", envOr("PLINKO_SERVER", DefaultServerURL), "PIR server URL ($PLINKO_SERVER)")
+	fs.StringVar(&g.cdn, "cdn", envOr("PLINKO_CDN", DefaultCDNURL), "CDN URL for hint.bin and deltas ($PLINKO_CDN)")
+	fs.StringVar(&g.dataDir, "data-dir", envOr("PLINKO_DATA_DIR", filepath.Join(home, DefaultDataDir)), "Local state directory ($PLINKO_DATA_DIR)")
+	fs.Func("table", "Table to use instead of the account database (e.g. "+plinkoclient.TokenTableName+")", func(v string) error {
+		if v == plinkoclient.DefaultTableName {
+			v = ""
+		}
+		g.table = v
+		return nil
+	})
+}
+
+func (g *globals) client() *plinkoclient.Client {
+	c := plinkoclient.New(g.server, g.cdn)
+	if g.table != "" {
+		return c.Table(g.table)
+	}
+	return c
+}
+
+// stateDir is where the selected table's local state lives
+func (g *globals) stateDir() string {
+	if g.table != "" {
+		return filepath.Join(g.dataDir, "tables", g.table)
+	}
+	return g.dataDir
+}
+
+func (g *globals) path(name string) string {
+	return filepath.Join(g.stateDir(), name)
+}
+
+// withServer returns a copy of g for another PIR server
+func (g globals) withServer(url string) *globals {
+	g.server = url
+	return &g
+}
+
+// forTable returns a copy of g for another table
+func (g globals) forTable(name string) *globals {
+	g.table = name
+	return &g
+}
+
+// loadHints loads the saved hint table into a new client
+func (g *globals) loadHints() (*plinkoclient.Client, *plinkoclient.HintTable, error) {
+	table, err := plinkoclient.LoadHintTableFile(g.path(HintTableFile))
+	if errors.Is(err, os.ErrNotExist) {
+		if g.table != "" {
+			return nil, nil, fmt.Errorf("no local hints for table %s; run `plinko hint fetch -table %s` first", g.table, g.table)
+		}
+		return nil, nil, errors.New("no local hints; run `plinko hint fetch` first")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	c := g.client()
+	c.SetHints(table)
+	return c, table, nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+const usage = `Usage: plinko <command> [flags] [args]
+
+Commands:
+  hint fetch         Download hint.bin and build local hints
+  hint info          Show local hint table status
+  query <address>    Private balance lookup (or -index N; -dpf URL for no hints)
+  sync               Apply published deltas to the local hints
+  portfolio <addr>   Private ETH and token balance lookup
+  prove <address>    Private balance proven against a block header (-rpc URL)
+  tables             List the tables the server answers for
+  inspect <file>...  Decode hint.bin, delta-*.bin, hints.dat or address index/mapping
+
+hint, query and sync take -table NAME to use another table (token-balances).
+Run 'plinko <command> -h' for command flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "hint":
+		err = runHint(ctx, args)
+	case "query":
+		err = runQuery(ctx, args)
+	case "sync":
+		err = runSync(ctx, args)
+	case "portfolio":
+		err = runPortfolio(ctx, args)
+	case "prove":
+		err = runProve(ctx, args)
+	case "tables":
+		err = runTables(ctx, args)
+	case "inspect":
+		err = runInspect(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "plinko: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plinko: %v\n", err)
+		os.Exit(1)
+	}
+}
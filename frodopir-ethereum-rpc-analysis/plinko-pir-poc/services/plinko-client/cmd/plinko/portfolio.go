@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	plinkoclient "plinko-client"
+)
+
+// runPortfolio looks up an address's ETH balance and its balance of every
+// token in the token-balances table. One entry is read per listed token,
+// whether the address holds it or not (a random entry stands in for pairs
+// that are not in the table), so the server learns neither which tokens the
+// address holds nor how many.
+func runPortfolio(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("portfolio", flag.ContinueOnError)
+	g.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || g.table != "" {
+		return errors.New("usage: plinko portfolio <address>")
+	}
+	addr, err := plinkoclient.ParseAddress(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	wei, found, err := queryETH(ctx, &g, addr)
+	if err != nil {
+		return err
+	}
+
+	tg := g.forTable(plinkoclient.TokenTableName)
+	tc, table, err := tg.loadHints()
+	if err != nil {
+		return err
+	}
+	info, err := tc.FetchTokenInfo(ctx)
+	if err != nil {
+		return err
+	}
+	held, err := lookupHolderTokens(ctx, tg, tc, addr)
+	if err != nil {
+		return err
+	}
+	indices := make([]uint64, len(info.Tokens))
+	for i, tok := range info.Tokens {
+		idx, ok := held[tok.Address]
+		if !ok {
+			idx = randomIndex(table.Header)
+		}
+		indices[i] = idx
+	}
+	values, err := tc.QueryBatch(ctx, indices)
+	if serr := table.SaveFile(tg.path(HintTableFile)); serr != nil {
+		return fmt.Errorf("save token hints: %w", serr)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", addr)
+	if found {
+		fmt.Printf("  ETH: %s\n", formatEther(wei))
+	} else {
+		fmt.Printf("  ETH: 0 (not in database)\n")
+	}
+	for i, tok := range info.Tokens {
+		name := tok.Symbol
+		if name == "" {
+			name = tok.Address.String()
+		}
+		value := uint64(0)
+		if _, ok := held[tok.Address]; ok {
+			value = values[i]
+		}
+		fmt.Printf("  %s: %d\n", name, value)
+	}
+	fmt.Printf("  Queries: %d in %v (private)\n", 1+len(indices), time.Since(start).Round(time.Microsecond))
+	return nil
+}
+
+// queryETH reads addr's ETH balance from the account database. An address
+// that is not in it still costs a query, of a random entry.
+func queryETH(ctx context.Context, g *globals, addr plinkoclient.Address) (uint64, bool, error) {
+	c, table, err := g.loadHints()
+	if err != nil {
+		return 0, false, err
+	}
+	kw, err := loadKeywordParams(g)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var value uint64
+	found := true
+	if kw != nil {
+		value, err = c.QueryAddress(ctx, kw, addr)
+	} else {
+		var idx uint64
+		idx, err = lookupAddress(ctx, g, c, addr)
+		if errors.Is(err, plinkoclient.ErrAddressNotFound) {
+			idx, found = randomIndex(table.Header), false
+		} else if err != nil {
+			return 0, false, err
+		}
+		value, err = c.Query(ctx, idx)
+	}
+	if errors.Is(err, plinkoclient.ErrAddressNotFound) {
+		found, err = false, nil
+	}
+	if serr := table.SaveFile(g.path(HintTableFile)); serr != nil {
+		return 0, false, fmt.Errorf("save hints: %w", serr)
+	}
+	return value, found, err
+}
+
+// lookupHolderTokens returns the token table index of every token holder
+// has, from the pairs synced into token-additions.bin and a local copy of
+// token-mapping.bin, downloaded on first use
+func lookupHolderTokens(ctx context.Context, g *globals, c *plinkoclient.Client, holder plinkoclient.Address) (map[plinkoclient.Address]uint64, error) {
+	mappingPath := g.path(plinkoclient.TokenMappingFile)
+	if _, err := os.Stat(mappingPath); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Downloading %s/%s%s/%s (first lookup only)...\n", g.cdn, plinkoclient.TablesPath, g.table, plinkoclient.TokenMappingFile)
+		if err := c.DownloadTokenMapping(ctx, mappingPath); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(mappingPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	held, err := plinkoclient.LookupHolderTokens(f, holder)
+	if err != nil {
+		return nil, err
+	}
+
+	additions, err := os.Open(g.path(plinkoclient.TokenAdditionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return held, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer additions.Close()
+	added, err := plinkoclient.LookupHolderTokens(additions, holder)
+	if err != nil {
+		return nil, err
+	}
+	for tok, idx := range added {
+		held[tok] = idx
+	}
+	return held, nil
+}
+
+// randomIndex picks a uniformly random entry of the padded database, for
+// queries that only hide which entries are real
+func randomIndex(h plinkoclient.HintHeader) uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:]) % (h.ChunkSize * h.SetSize)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	plinkoclient "plinko-client"
+)
+
+// DefaultRPCURL is the node whose headers proofs are checked against
+const DefaultRPCURL = "http://localhost:8545"
+
+// runProve reads an address's account proof from the state-proofs table and
+// checks it against the state root of a header from -rpc, a node the user
+// trusts. The address is located with the account database's index, as for
+// query; the proof's block is the table's, not the head.
+func runProve(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("prove", flag.ContinueOnError)
+	g.register(fs)
+	rpcURL := fs.String("rpc", envOr("PLINKO_RPC", DefaultRPCURL), "Ethereum node for block headers ($PLINKO_RPC)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || g.table != "" {
+		return errors.New("usage: plinko prove [-rpc URL] <address>")
+	}
+	addr, err := plinkoclient.ParseAddress(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if kw, err := loadKeywordParams(&g); err != nil {
+		return err
+	} else if kw != nil {
+		return errors.New("state proofs need the index layout; this database is a keyword table")
+	}
+	idx, err := lookupAddress(ctx, &g, g.client(), addr)
+	if err != nil {
+		return err
+	}
+
+	pg := g.forTable(plinkoclient.StateProofTableName)
+	pc, table, err := pg.loadHints()
+	if err != nil {
+		return err
+	}
+	info, err := pc.FetchStateProofInfo(ctx)
+	if err != nil {
+		return err
+	}
+	node, err := ethclient.DialContext(ctx, *rpcURL)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	start := time.Now()
+	acct, err := pc.QueryVerifiedAccount(ctx, node, info, addr, idx)
+	elapsed := time.Since(start)
+	if serr := table.SaveFile(pg.path(HintTableFile)); serr != nil {
+		return fmt.Errorf("save state proof hints: %w", serr)
+	}
+	if errors.Is(err, plinkoclient.ErrIndexOutOfRange) {
+		return fmt.Errorf("%s joined the database after block %d and has no proof", addr, info.Block)
+	}
+	if errors.Is(err, plinkoclient.ErrStateProofHintsLow) {
+		return fmt.Errorf("%w; run `plinko hint fetch -table %s` again", err, plinkoclient.StateProofTableName)
+	}
+	if errors.Is(err, plinkoclient.ErrStateProofStale) {
+		return fmt.Errorf("%w; the deployment must rebuild it", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (index %d)\n", addr, idx)
+	fmt.Printf("  Block:   %d (state root from %s)\n", acct.Block, *rpcURL)
+	fmt.Printf("  Balance: %s wei\n", acct.Balance)
+	fmt.Printf("  Nonce:   %d\n", acct.Nonce)
+	fmt.Printf("  Proof:   verified, %d queries in %v (private)\n", info.RecordWords, elapsed.Round(time.Microsecond))
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	plinkoclient "plinko-client"
+)
+
+func runQuery(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	g.register(fs)
+	index := fs.Int64("index", -1, "Query a database index instead of an address")
+	dpfServer := fs.String("dpf", "", "Second server URL: query -server and this one with DPF keys instead of hints")
+	verify := fs.Bool("verify", false, "With -dpf: check the answer against the CDN's merkle-root.json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dpfServer != "" {
+		return runDPFQuery(ctx, &g, *dpfServer, *verify, *index, fs.Args())
+	}
+
+	c, table, err := g.loadHints()
+	if err != nil {
+		return err
+	}
+
+	var label string
+	var query func() (uint64, error)
+	switch {
+	case *index >= 0 && fs.NArg() == 0:
+		label = fmt.Sprintf("index %d", *index)
+		query = func() (uint64, error) { return c.Query(ctx, uint64(*index)) }
+	case *index < 0 && fs.NArg() == 1:
+		addr, err := plinkoclient.ParseAddress(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		kw, err := loadKeywordParams(&g)
+		if err != nil {
+			return err
+		}
+		if kw != nil {
+			label = fmt.Sprintf("%s (keyword lookup, %d buckets read)", addr, kw.Hashes)
+			query = func() (uint64, error) { return c.QueryAddress(ctx, kw, addr) }
+			break
+		}
+		idx, err := lookupAddress(ctx, &g, c, addr)
+		if err != nil {
+			return err
+		}
+		label = fmt.Sprintf("%s (index %d)", addr, idx)
+		query = func() (uint64, error) { return c.Query(ctx, idx) }
+	default:
+		return errors.New("usage: plinko query <address> | plinko query -index N")
+	}
+
+	start := time.Now()
+	value, err := query()
+	elapsed := time.Since(start)
+
+	// The used hints were replaced or spent (keyword misses and failed
+	// queries too); persist the new table before reporting
+	if serr := table.SaveFile(g.path(HintTableFile)); serr != nil {
+		return fmt.Errorf("save hints: %w", serr)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", label)
+	fmt.Printf("  Balance: %d wei (%s ETH)\n", value, formatEther(value))
+	fmt.Printf("  Query:   %v (private)\n", elapsed.Round(time.Microsecond))
+	return nil
+}
+
+// runDPFQuery reads one entry from two servers without local hints. Each
+// server reads its whole table per query, and the two must not collude.
+// With verify the answer is checked against the epoch's Merkle root.
+func runDPFQuery(ctx context.Context, g *globals, serverB string, verify bool, index int64, args []string) error {
+	a := g.client()
+	pair := &plinkoclient.DPFPair{A: a, B: g.withServer(serverB).client()}
+
+	var label string
+	var idx uint64
+	switch {
+	case index >= 0 && len(args) == 0:
+		label, idx = fmt.Sprintf("index %d", index), uint64(index)
+	case index < 0 && len(args) == 1:
+		addr, err := plinkoclient.ParseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		if kw, err := loadKeywordParams(g); err != nil || kw != nil {
+			if err == nil {
+				err = errors.New("DPF queries do not support the keyword layout; use hints")
+			}
+			return err
+		}
+		if idx, err = lookupAddress(ctx, g, a, addr); err != nil {
+			return err
+		}
+		label = fmt.Sprintf("%s (index %d)", addr, idx)
+	default:
+		return errors.New("usage: plinko query -dpf URL <address> | plinko query -dpf URL -index N")
+	}
+
+	if verify {
+		root, err := a.FetchMerkleRoot(ctx)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		value, err := pair.QueryVerified(ctx, root, idx)
+		elapsed := time.Since(start)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", label)
+		fmt.Printf("  Balance: %d wei (%s ETH)\n", value, formatEther(value))
+		fmt.Printf("  Proof:   verified against epoch %d root %x\n", root.Epoch, root.Root)
+		fmt.Printf("  Query:   %v (private, two-server DPF)\n", elapsed.Round(time.Microsecond))
+		return nil
+	}
+
+	start := time.Now()
+	value, block, err := pair.Query(ctx, idx)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", label)
+	fmt.Printf("  Balance: %d wei (%s ETH)\n", value, formatEther(value))
+	fmt.Printf("  Block:   %d\n", block)
+	fmt.Printf("  Query:   %v (private, two-server DPF)\n", elapsed.Round(time.Microsecond))
+	return nil
+}
+
+// loadKeywordParams returns the keyword-params.json saved by `hint fetch`,
+// or nil if the database is not in the keyword layout
+func loadKeywordParams(g *globals) (*plinkoclient.KeywordParams, error) {
+	data, err := os.ReadFile(g.path(plinkoclient.KeywordParamsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kw plinkoclient.KeywordParams
+	if err := json.Unmarshal(data, &kw); err != nil {
+		return nil, fmt.Errorf("%s: %w", plinkoclient.KeywordParamsFile, err)
+	}
+	return &kw, nil
+}
+
+// lookupAddress maps an address to its database index with a local copy of
+// address-index.bin, downloading it on first use. address-mapping.bin is
+// used instead if it is already present or the CDN has no index. Accounts
+// added since the epoch began are found in address-additions.bin (see sync).
+func lookupAddress(ctx context.Context, g *globals, c *plinkoclient.Client, addr plinkoclient.Address) (uint64, error) {
+	if f, err := os.Open(g.path(plinkoclient.AdditionsFile)); err == nil {
+		idx, err := plinkoclient.LookupAddress(f, addr)
+		f.Close()
+		if !errors.Is(err, plinkoclient.ErrAddressNotFound) {
+			return idx, err
+		}
+	}
+
+	indexPath, mappingPath := g.path(AddressIndexFile), g.path(AddressMappingFile)
+	if _, err := os.Stat(indexPath); err == nil {
+		idx, err := plinkoclient.LoadAddressIndexFile(indexPath)
+		if err != nil {
+			return 0, err
+		}
+		return idx.Lookup(addr)
+	}
+
+	if _, err := os.Stat(mappingPath); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Downloading %s/%s (first lookup only)...\n", g.cdn, AddressIndexFile)
+		idx, err := c.DownloadAddressIndex(ctx, indexPath)
+		if err == nil {
+			return idx.Lookup(addr)
+		}
+		fmt.Printf("  %v; falling back to %s\n", err, AddressMappingFile)
+		fmt.Printf("Downloading %s/%s (first lookup only)...\n", g.cdn, AddressMappingFile)
+		if err := c.DownloadAddressMapping(ctx, mappingPath); err != nil {
+			return 0, err
+		}
+	}
+
+	f, err := os.Open(mappingPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return plinkoclient.LookupAddress(f, addr)
+}
+
+// formatEther renders a wei amount as a decimal ether string
+func formatEther(wei uint64) string {
+	const weiPerEther = 1_000_000_000_000_000_000
+	whole := strconv.FormatUint(wei/weiPerEther, 10)
+	frac := strings.TrimRight(fmt.Sprintf("%018d", wei%weiPerEther), "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	plinkoclient "plinko-client"
+)
+
+func runSync(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	g.register(fs)
+	to := fs.Uint64("to", math.MaxUint64, "Last block to apply (default: every published delta)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, table, err := g.loadHints()
+	if err != nil {
+		return err
+	}
+	from := table.LastBlock
+
+	start := time.Now()
+	applied, syncErr := c.SyncDeltas(ctx, *to)
+
+	// Save whatever was applied, even if a later block failed
+	if table.LastBlock != from {
+		if err := table.SaveFile(g.path(HintTableFile)); err != nil {
+			return fmt.Errorf("save hints: %w", err)
+		}
+	}
+	var added int
+	switch g.table {
+	case "":
+		added, err = syncAdditions(ctx, &g, c, from+1, table.LastBlock)
+	case plinkoclient.TokenTableName:
+		added, err = syncTokenAdditions(ctx, &g, c, from+1, table.LastBlock)
+	}
+	if err != nil {
+		return fmt.Errorf("sync new entries: %w", err)
+	}
+	if errors.Is(syncErr, plinkoclient.ErrEpochMismatch) {
+		return fmt.Errorf("synced to block %d, the last of epoch %d; run `plinko hint fetch` for the new epoch: %w",
+			table.LastBlock, table.Header.Epoch, syncErr)
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+
+	if table.LastBlock == from {
+		fmt.Printf("Already up to date (block %d)\n", from)
+		return nil
+	}
+	fmt.Printf("✅ Applied %d deltas from blocks %d-%d in %v (%d new entries)\n",
+		applied, from+1, table.LastBlock, time.Since(start).Round(time.Millisecond), added)
+	return nil
+}
+
+// syncAdditions appends the accounts added in blocks from..to to the local
+// address-additions.bin, which lookupAddress consults first
+func syncAdditions(ctx context.Context, g *globals, c *plinkoclient.Client, from, to uint64) (int, error) {
+	var added []plinkoclient.AddressMappingEntry
+	for block := from; block <= to; block++ {
+		entries, err := c.FetchAdditions(ctx, block)
+		if err != nil {
+			return 0, err
+		}
+		added = append(added, entries...)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	f, err := os.OpenFile(g.path(plinkoclient.AdditionsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if err := plinkoclient.WriteAddressMappingEntries(f, added); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(added), f.Close()
+}
+
+// syncTokenAdditions appends the pairs a token table added in blocks from..to
+// to the local token-additions.bin, which portfolio consults first
+func syncTokenAdditions(ctx context.Context, g *globals, c *plinkoclient.Client, from, to uint64) (int, error) {
+	var added []plinkoclient.TokenMappingEntry
+	for block := from; block <= to; block++ {
+		entries, err := c.FetchTokenAdditions(ctx, block)
+		if err != nil {
+			return 0, err
+		}
+		added = append(added, entries...)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	f, err := os.OpenFile(g.path(plinkoclient.TokenAdditionsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if err := plinkoclient.WriteTokenMappingEntries(f, added); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(added), f.Close()
+}
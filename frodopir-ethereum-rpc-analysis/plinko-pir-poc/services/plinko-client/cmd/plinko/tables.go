@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runTables lists the server's tables with their parameters and how far
+// each has followed its deltas
+func runTables(ctx context.Context, args []string) error {
+	var g globals
+	fs := flag.NewFlagSet("tables", flag.ContinueOnError)
+	g.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || g.table != "" {
+		return errors.New("usage: plinko tables")
+	}
+
+	tables, err := g.client().Tables(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENTRIES\tCHUNK\tSETS\tEPOCH\tBLOCK\tPATH")
+	for _, t := range tables {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", t.Name, t.DBSize, t.ChunkSize, t.SetSize, t.Epoch, t.LastBlock, t.Path)
+	}
+	return w.Flush()
+}
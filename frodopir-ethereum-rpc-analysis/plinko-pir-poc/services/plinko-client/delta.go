@@ -0,0 +1,103 @@
+package plinkoclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Delta files (written by plinko-update-service saveDelta):
+//
+//	[0:8]   Delta count (uint64 LE)
+//	[8:16]  Format version (0 = legacy 24-byte entries, 1 = 32-byte entries)
+//	then per delta: HintSetID, IsBackupSet, Delta, Index (v1 only)
+//
+// Only v1 files can be applied: Piano-style hints are located by database
+// index, which v0 entries do not carry.
+
+const (
+	DeltaHeaderSize    = 16
+	DeltaFormatVersion = 1
+	deltaEntrySizeV0   = 24
+	deltaEntrySizeV1   = 32
+
+	maxDeltasPerFile = 1 << 24
+)
+
+// ErrLegacyDelta is returned when applying a v0 delta file
+var ErrLegacyDelta = errors.New("delta file has no database indices (format v0); regenerate with a current update service")
+
+// HintDelta is one entry of a delta file
+type HintDelta struct {
+	HintSetID   uint64
+	IsBackupSet bool
+	Delta       uint64 // XOR of old and new entry value
+	Index       uint64 // Database index that changed (v1 only)
+}
+
+// DeltaFile is a parsed delta file
+type DeltaFile struct {
+	Version uint64
+	Deltas  []HintDelta
+}
+
+// ParseDelta reads a delta file in either format version
+func ParseDelta(r io.Reader) (*DeltaFile, error) {
+	var header [DeltaHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read delta header: %w", err)
+	}
+	count := binary.LittleEndian.Uint64(header[0:8])
+	version := binary.LittleEndian.Uint64(header[8:16])
+
+	var entrySize int
+	switch version {
+	case 0:
+		entrySize = deltaEntrySizeV0
+	case DeltaFormatVersion:
+		entrySize = deltaEntrySizeV1
+	default:
+		return nil, fmt.Errorf("unsupported delta format version %d", version)
+	}
+	if count > maxDeltasPerFile {
+		return nil, fmt.Errorf("delta file claims %d entries", count)
+	}
+
+	df := &DeltaFile{Version: version, Deltas: make([]HintDelta, count)}
+	entry := make([]byte, entrySize)
+	for i := range df.Deltas {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return nil, fmt.Errorf("read delta %d of %d: %w", i, count, err)
+		}
+		d := &df.Deltas[i]
+		d.HintSetID = binary.LittleEndian.Uint64(entry[0:8])
+		d.IsBackupSet = binary.LittleEndian.Uint64(entry[8:16]) != 0
+		d.Delta = binary.LittleEndian.Uint64(entry[16:24])
+		if version >= DeltaFormatVersion {
+			d.Index = binary.LittleEndian.Uint64(entry[24:32])
+		}
+	}
+	return df, nil
+}
+
+// ApplyDelta XORs every entry of df into the hints whose sets contain it
+func (t *HintTable) ApplyDelta(df *DeltaFile) error {
+	if df.Version < DeltaFormatVersion {
+		return ErrLegacyDelta
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Check every entry first so a bad file leaves the table untouched
+	for _, d := range df.Deltas {
+		if d.Index >= t.Header.Entries() {
+			return fmt.Errorf("delta for index %d: %w", d.Index, ErrIndexOutOfRange)
+		}
+	}
+	for _, d := range df.Deltas {
+		t.applyDelta(d.Index, d.Delta)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package plinkoclient
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"plinko-common/dpf"
+)
+
+// Two-server DPF queries
+//
+// A client with no hints at all can still read an entry privately from two
+// non-colluding servers holding the same database: it splits the point
+// function at the index into two DPF keys, sends one to each server's
+// /v1/query/dpf, and XORs the two answers. Neither key alone reveals the
+// index. Every query costs each server a full pass over the database, so
+// this suits one-off lookups; clients that query often should build hints.
+//
+// Key generation and the key encoding live in plinko-common/dpf, which
+// the server evaluates keys with.
+
+const DPFSeedSize = dpf.SeedSize
+
+type (
+	DPFSeed       = dpf.Seed
+	DPFCorrection = dpf.Correction
+	DPFKey        = dpf.Key
+)
+
+// ErrServersDiverged means the two servers answered from different
+// databases (another block or epoch); retry once they have caught up
+var ErrServersDiverged = errors.New("dpf servers answered from different blocks")
+
+// DPFDomainBits returns the domain size, in bits, that covers n entries
+func DPFDomainBits(n uint64) uint8 { return dpf.DomainBits(n) }
+
+// GenDPF splits the point function at alpha over [0, 2^bits) into two keys
+func GenDPF(alpha uint64, bits uint8, rand io.Reader) (k0, k1 *DPFKey, err error) {
+	return dpf.Gen(alpha, bits, rand)
+}
+
+// dpfRequest/dpfResponse mirror the server's DPF query types
+type dpfRequest struct {
+	Key []byte `json:"key"`
+}
+
+type dpfResponse struct {
+	Value     uint64 `json:"value"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// DPFPair reads entries from two servers with DPF queries. The servers must
+// be run by parties that do not share what they see.
+type DPFPair struct {
+	A, B *Client
+	Rand io.Reader // Key seeds; defaults to crypto/rand
+
+	mu     sync.Mutex
+	params *Params // Agreed parameters, fetched on first use
+}
+
+// NewDPFPair creates a pair for the given server URLs
+func NewDPFPair(serverA, serverB string) *DPFPair {
+	return &DPFPair{A: New(serverA, ""), B: New(serverB, "")}
+}
+
+// Params returns the parameters both servers agree on
+func (p *DPFPair) Params(ctx context.Context) (*Params, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.params != nil {
+		return p.params, nil
+	}
+	a, err := p.A.Params(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := p.B.Params(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if a.Epoch != b.Epoch || a.PaddedSize != b.PaddedSize || a.DBSize != b.DBSize {
+		return nil, fmt.Errorf("servers serve different databases (epoch %d/%d, %d/%d entries): %w",
+			a.Epoch, b.Epoch, a.PaddedSize, b.PaddedSize, ErrServersDiverged)
+	}
+	p.params = a
+	return a, nil
+}
+
+// Query privately reads database entry index and returns it with the block
+// both servers answered at
+func (p *DPFPair) Query(ctx context.Context, index uint64) (value, lastBlock uint64, err error) {
+	params, err := p.Params(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if index >= params.DBSize {
+		return 0, 0, ErrIndexOutOfRange
+	}
+	r := p.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	k0, k1, err := GenDPF(index, DPFDomainBits(params.PaddedSize), r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var resps [2]dpfResponse
+	if err := p.ask(ctx, params, "/v1/query/dpf", [2]*DPFKey{k0, k1}, [2]any{&resps[0], &resps[1]}); err != nil {
+		return 0, 0, err
+	}
+	if resps[0].LastBlock != resps[1].LastBlock {
+		return 0, 0, fmt.Errorf("blocks %d and %d: %w", resps[0].LastBlock, resps[1].LastBlock, ErrServersDiverged)
+	}
+	return resps[0].Value ^ resps[1].Value, resps[0].LastBlock, nil
+}
+
+// ask sends keys[0] to A and keys[1] to B at path and decodes the answers
+// into out. Answers from another epoch than params' clear the cached
+// parameters and fail with ErrEpochMismatch.
+func (p *DPFPair) ask(ctx context.Context, params *Params, path string, keys [2]*DPFKey, out [2]any) error {
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, c := range []*Client{p.A, p.B} {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			data, err := keys[i].MarshalBinary()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			hdr, err := c.call(ctx, http.MethodPost, path, dpfRequest{Key: data}, out[i])
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", c.ServerURL, err)
+				return
+			}
+			if epoch, err := strconv.ParseUint(hdr.Get(EpochHeader), 10, 64); err == nil && epoch != params.Epoch {
+				errs[i] = ErrEpochMismatch
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	err := errors.Join(errs[:]...)
+	if errors.Is(err, ErrEpochMismatch) {
+		p.mu.Lock()
+		p.params = nil // Refetch on the next query
+		p.mu.Unlock()
+	}
+	return err
+}
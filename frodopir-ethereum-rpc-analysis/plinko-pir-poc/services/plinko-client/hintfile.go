@@ -0,0 +1,112 @@
+package plinkoclient
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hint.bin layout (written by plinko-hint-generator):
+//
+//	[0:8]   DBSize    (uint64 LE)
+//	[8:16]  ChunkSize (uint64 LE)
+//	[16:24] SetSize   (uint64 LE)
+//	[24:32] Epoch     (uint64 LE)
+//	[32:]   ChunkSize*SetSize database entries (uint64 LE each)
+
+const (
+	HintHeaderSize = 32
+	EntrySize      = 8
+
+	// Sanity bounds for header fields read from the network
+	maxChunkSize = 1 << 24
+	maxSetSize   = 1 << 24
+)
+
+// HintHeader is the metadata header of hint.bin
+type HintHeader struct {
+	DBSize    uint64
+	ChunkSize uint64
+	SetSize   uint64
+	Epoch     uint64
+}
+
+// Entries returns the padded number of database entries (ChunkSize*SetSize)
+func (h HintHeader) Entries() uint64 {
+	return h.ChunkSize * h.SetSize
+}
+
+// FileSize returns the exact size of a hint.bin with this header
+func (h HintHeader) FileSize() int64 {
+	return HintHeaderSize + int64(h.Entries())*EntrySize
+}
+
+// Validate checks that the header describes a usable database
+func (h HintHeader) Validate() error {
+	switch {
+	case h.ChunkSize == 0 || h.ChunkSize > maxChunkSize:
+		return fmt.Errorf("invalid chunk size %d", h.ChunkSize)
+	case h.SetSize == 0 || h.SetSize > maxSetSize:
+		return fmt.Errorf("invalid set size %d", h.SetSize)
+	case h.DBSize > h.Entries():
+		return fmt.Errorf("db size %d exceeds %d chunk slots", h.DBSize, h.Entries())
+	}
+	return nil
+}
+
+// ParseHintHeader decodes and validates the 32-byte hint.bin header
+func ParseHintHeader(b []byte) (HintHeader, error) {
+	if len(b) < HintHeaderSize {
+		return HintHeader{}, errors.New("hint.bin too small for header")
+	}
+	h := HintHeader{
+		DBSize:    binary.LittleEndian.Uint64(b[0:8]),
+		ChunkSize: binary.LittleEndian.Uint64(b[8:16]),
+		SetSize:   binary.LittleEndian.Uint64(b[16:24]),
+		Epoch:     binary.LittleEndian.Uint64(b[24:32]),
+	}
+	return h, h.Validate()
+}
+
+// ReadHintHeader reads the header from the start of r
+func ReadHintHeader(r io.Reader) (HintHeader, error) {
+	var buf [HintHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return HintHeader{}, fmt.Errorf("read hint header: %w", err)
+	}
+	return ParseHintHeader(buf[:])
+}
+
+// VerifyHintFile checks that path is a complete hint.bin. If wantSHA256 is
+// non-nil the file's digest must match it as well.
+func VerifyHintFile(path string, wantSHA256 []byte) (HintHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return HintHeader{}, err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	h, err := ReadHintHeader(io.TeeReader(f, digest))
+	if err != nil {
+		return h, err
+	}
+
+	n, err := io.Copy(digest, f)
+	if err != nil {
+		return h, err
+	}
+	if HintHeaderSize+n != h.FileSize() {
+		return h, fmt.Errorf("hint.bin is %d bytes, header says %d", HintHeaderSize+n, h.FileSize())
+	}
+
+	if wantSHA256 != nil {
+		if got := digest.Sum(nil); string(got) != string(wantSHA256) {
+			return h, fmt.Errorf("hint.bin sha256 %x, want %x", got, wantSHA256)
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,580 @@
+package plinkoclient
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Client-side hints (Piano-style)
+//
+// A primary hint is a PRF key plus the XOR of the database entries at the
+// key's offset in every chunk. A backup hint for chunk b is the same but
+// leaves chunk b out of the parity. To read index x in chunk c the client
+// finds a primary hint whose set contains x, asks the server for the parity
+// of the set with chunk c punctured out, and XORs the two. The used primary
+// is then replaced by a backup for chunk c whose set is programmed to contain
+// x in place of its chunk-c offset, so no set is ever sent to the server
+// twice. A primary leaves the table when its offsets are built, before they
+// are sent: if the request fails, or its answer is unusable, the hint is
+// lost rather than reused, since the server may already have seen its set.
+
+var (
+	ErrNoHint          = errors.New("no primary hint covers this index")
+	ErrIndexOutOfRange = errors.New("index outside the database")
+)
+
+// HintConfig sizes the hint table
+type HintConfig struct {
+	PrimaryHints    int       // Failure probability per query is about exp(-PrimaryHints/ChunkSize)
+	BackupsPerChunk int       // Queries per chunk before the table needs rebuilding
+	Rand            io.Reader // Source of PRF keys (crypto/rand if nil)
+}
+
+// DefaultHintConfig returns 8*ChunkSize primary hints (a query finds no
+// hint with probability ~e^-8) and 4 backups per chunk
+func DefaultHintConfig(h HintHeader) HintConfig {
+	return HintConfig{
+		PrimaryHints:    int(8 * h.ChunkSize),
+		BackupsPerChunk: 4,
+	}
+}
+
+// primaryHint covers one offset per chunk; if programmed, the offset in
+// progChunk is progIndex's instead of the PRF's
+type primaryHint struct {
+	key        PrfKey128
+	parity     uint64
+	programmed bool
+	progChunk  uint64
+	progIndex  uint64
+}
+
+// backupHint covers every chunk except the one it is stored under
+type backupHint struct {
+	key    PrfKey128
+	parity uint64
+}
+
+// HintTable holds the client's primary and backup hints
+type HintTable struct {
+	mu sync.Mutex
+
+	Header    HintHeader // Parameters and epoch of the hint.bin the table was built from
+	LastBlock uint64     // Last block whose delta has been applied
+
+	primary  []primaryHint
+	backup   [][]backupHint          // backup[b] excludes chunk b
+	inflight map[*preparedQuery]bool // Checked out; deltas still apply to them
+}
+
+// HintStats summarises how much of the table is left
+type HintStats struct {
+	Primary     int
+	Backups     int
+	MinBackups  int // Fewest backups left for any chunk
+	EmptyChunks int // Chunks with no backups left (queries there consume a primary for good)
+}
+
+// BuildHints builds a table from hint.bin data. r must be positioned just
+// after the header; entries are streamed one chunk at a time.
+func BuildHints(r io.Reader, h HintHeader, cfg HintConfig) (*HintTable, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.PrimaryHints <= 0 || cfg.BackupsPerChunk < 0 {
+		return nil, fmt.Errorf("invalid hint config %+v", cfg)
+	}
+	rnd := cfg.Rand
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+
+	t := &HintTable{
+		Header:  h,
+		primary: make([]primaryHint, cfg.PrimaryHints),
+		backup:  make([][]backupHint, h.SetSize),
+	}
+	for i := range t.primary {
+		if _, err := io.ReadFull(rnd, t.primary[i].key[:]); err != nil {
+			return nil, fmt.Errorf("generate keys: %w", err)
+		}
+	}
+	for b := range t.backup {
+		t.backup[b] = make([]backupHint, cfg.BackupsPerChunk)
+		for i := range t.backup[b] {
+			if _, err := io.ReadFull(rnd, t.backup[b][i].key[:]); err != nil {
+				return nil, fmt.Errorf("generate keys: %w", err)
+			}
+		}
+	}
+
+	br := bufio.NewReaderSize(r, 1<<20)
+	raw := make([]byte, h.ChunkSize*EntrySize)
+	chunk := make([]uint64, h.ChunkSize)
+
+	for c := uint64(0); c < h.SetSize; c++ {
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, fmt.Errorf("read chunk %d: %w", c, err)
+		}
+		for i := range chunk {
+			chunk[i] = binary.LittleEndian.Uint64(raw[i*EntrySize:])
+		}
+
+		for i := range t.primary {
+			p := &t.primary[i]
+			p.parity ^= chunk[prfEvalMod(&p.key, c, h.ChunkSize)]
+		}
+		for b := range t.backup {
+			if uint64(b) == c {
+				continue
+			}
+			for i := range t.backup[b] {
+				bh := &t.backup[b][i]
+				bh.parity ^= chunk[prfEvalMod(&bh.key, c, h.ChunkSize)]
+			}
+		}
+	}
+	return t, nil
+}
+
+// BuildHintsFromFile verifies hint.bin at path and builds a table from it
+func BuildHintsFromFile(path string, cfg HintConfig) (*HintTable, error) {
+	h, err := VerifyHintFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(HintHeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return BuildHints(f, h, cfg)
+}
+
+// offset returns the hint's in-chunk offset for chunk c
+func (p *primaryHint) offset(c, chunkSize uint64) uint64 {
+	if p.programmed && p.progChunk == c {
+		return p.progIndex % chunkSize
+	}
+	return prfEvalMod(&p.key, c, chunkSize)
+}
+
+// locate splits a database index into chunk and offset
+func (t *HintTable) locate(index uint64) (chunk, offset uint64, err error) {
+	if index >= t.Header.Entries() {
+		return 0, 0, ErrIndexOutOfRange
+	}
+	return index / t.Header.ChunkSize, index % t.Header.ChunkSize, nil
+}
+
+// preparedQuery is a primary hint checked out for one query
+type preparedQuery struct {
+	hint    primaryHint // No longer in t.primary
+	index   uint64      // Database index being read
+	chunk   uint64      // Chunk punctured out of the set
+	offsets []uint64    // Offsets for every other chunk, in chunk order
+}
+
+// prepare checks out a primary hint containing index and builds the
+// punctured offsets to send to the server. The hint is gone from the table
+// until finish refreshes it; abandon drops it for good.
+func (t *HintTable) prepare(index uint64) (*preparedQuery, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, off, err := t.locate(index)
+	if err != nil {
+		return nil, err
+	}
+	cs := t.Header.ChunkSize
+
+	for i := range t.primary {
+		p := &t.primary[i]
+		if p.offset(c, cs) != off {
+			continue
+		}
+		q := &preparedQuery{hint: *p, index: index, chunk: c, offsets: t.puncture(p, c)}
+		t.checkout([]int{i}, []*preparedQuery{q})
+		return q, nil
+	}
+	return nil, ErrNoHint
+}
+
+// checkout moves the primaries at positions into queries, which go in
+// flight. Positions must be distinct.
+func (t *HintTable) checkout(positions []int, queries []*preparedQuery) {
+	if t.inflight == nil {
+		t.inflight = make(map[*preparedQuery]bool)
+	}
+	for _, q := range queries {
+		t.inflight[q] = true
+	}
+	// Highest position first, so moving the last hint into a freed slot
+	// never moves one that is still to be removed
+	sorted := append([]int(nil), positions...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	for _, i := range sorted {
+		last := len(t.primary) - 1
+		t.primary[i] = t.primary[last]
+		t.primary = t.primary[:last]
+	}
+}
+
+// abandon drops queries that will not be finished: their hints are not
+// returned to the table, as the server may have seen their sets. Finished
+// queries are ignored.
+func (t *HintTable) abandon(queries ...*preparedQuery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, q := range queries {
+		delete(t.inflight, q)
+	}
+}
+
+// puncture returns p's offsets for every chunk except c, in chunk order
+func (t *HintTable) puncture(p *primaryHint, c uint64) []uint64 {
+	offsets := make([]uint64, 0, t.Header.SetSize-1)
+	for j := uint64(0); j < t.Header.SetSize; j++ {
+		if j != c {
+			offsets = append(offsets, p.offset(j, t.Header.ChunkSize))
+		}
+	}
+	return offsets
+}
+
+// prepareBatch prepares a query for each index, each with its own primary
+// hint: a hint used twice in one batch would send the same set twice
+func (t *HintTable) prepareBatch(indices []uint64) ([]*preparedQuery, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cs := t.Header.ChunkSize
+	used := make(map[int]bool, len(indices))
+	positions := make([]int, len(indices))
+	queries := make([]*preparedQuery, len(indices))
+	for qi, index := range indices {
+		c, off, err := t.locate(index)
+		if err != nil {
+			return nil, err
+		}
+		for i := range t.primary {
+			p := &t.primary[i]
+			if used[i] || p.offset(c, cs) != off {
+				continue
+			}
+			used[i] = true
+			positions[qi] = i
+			queries[qi] = &preparedQuery{hint: *p, index: index, chunk: c, offsets: t.puncture(p, c)}
+			break
+		}
+		if queries[qi] == nil {
+			return nil, ErrNoHint
+		}
+	}
+	t.checkout(positions, queries)
+	return queries, nil
+}
+
+// finish decodes the server's answer for q and refreshes the used hint.
+// parities are the server's punctured-query parities, one per chunk. A
+// malformed answer drops the hint.
+func (t *HintTable) finish(q *preparedQuery, parities []uint64) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.inflight[q] {
+		return 0, errors.New("query was already finished or abandoned")
+	}
+	delete(t.inflight, q)
+	if uint64(len(parities)) != t.Header.SetSize {
+		return 0, fmt.Errorf("server returned %d parities, want %d", len(parities), t.Header.SetSize)
+	}
+	value := q.hint.parity ^ parities[q.chunk]
+	t.refresh(q.chunk, q.index, value)
+	return value, nil
+}
+
+// finishBatch decodes the answers for a batch from prepareBatch, in the
+// same order, and refreshes the used hints
+func (t *HintTable) finishBatch(queries []*preparedQuery, parities [][]uint64) ([]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, q := range queries {
+		if !t.inflight[q] {
+			return nil, errors.New("query was already finished or abandoned")
+		}
+		delete(t.inflight, q)
+	}
+	if len(parities) != len(queries) {
+		return nil, fmt.Errorf("server answered %d queries, want %d", len(parities), len(queries))
+	}
+	values := make([]uint64, len(queries))
+	for i, q := range queries {
+		if uint64(len(parities[i])) != t.Header.SetSize {
+			return nil, fmt.Errorf("server returned %d parities for query %d, want %d", len(parities[i]), i, t.Header.SetSize)
+		}
+		values[i] = q.hint.parity ^ parities[i][q.chunk]
+	}
+	for i, q := range queries {
+		t.refresh(q.chunk, q.index, values[i])
+	}
+	return values, nil
+}
+
+// refresh replaces a consumed primary hint with a backup for chunk c,
+// programmed to contain index. Without backups the primary is gone for good.
+func (t *HintTable) refresh(c, index, value uint64) {
+	backups := t.backup[c]
+	if len(backups) == 0 {
+		return
+	}
+
+	b := backups[len(backups)-1]
+	t.backup[c] = backups[:len(backups)-1]
+	t.primary = append(t.primary, primaryHint{
+		key:        b.key,
+		parity:     b.parity ^ value,
+		programmed: true,
+		progChunk:  c,
+		progIndex:  index,
+	})
+}
+
+// applyDelta XORs delta into every hint whose set contains index (which
+// must be in range)
+func (t *HintTable) applyDelta(index, delta uint64) {
+	cs := t.Header.ChunkSize
+	c, off := index/cs, index%cs
+
+	for i := range t.primary {
+		if p := &t.primary[i]; p.offset(c, cs) == off {
+			p.parity ^= delta
+		}
+	}
+	for q := range t.inflight {
+		if q.hint.offset(c, cs) == off {
+			q.hint.parity ^= delta
+		}
+	}
+	for b := range t.backup {
+		if uint64(b) == c {
+			continue
+		}
+		for i := range t.backup[b] {
+			if bh := &t.backup[b][i]; prfEvalMod(&bh.key, c, cs) == off {
+				bh.parity ^= delta
+			}
+		}
+	}
+}
+
+// hasBackups reports whether reading entries [first, first+n) leaves no
+// chunk without a backup, so no query among them spends a primary for good
+func (t *HintTable) hasBackups(first, n uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cs := t.Header.ChunkSize
+	for i := first; i < first+n; {
+		c := i / cs
+		end := min((c+1)*cs, first+n)
+		if c >= uint64(len(t.backup)) || uint64(len(t.backup[c])) < end-i {
+			return false
+		}
+		i = end
+	}
+	return true
+}
+
+// Stats reports how many hints are left
+func (t *HintTable) Stats() HintStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := HintStats{Primary: len(t.primary), MinBackups: -1}
+	for _, backups := range t.backup {
+		s.Backups += len(backups)
+		if s.MinBackups < 0 || len(backups) < s.MinBackups {
+			s.MinBackups = len(backups)
+		}
+		if len(backups) == 0 {
+			s.EmptyChunks++
+		}
+	}
+	if s.MinBackups < 0 {
+		s.MinBackups = 0
+	}
+	return s
+}
+
+// Hint table file layout (all integers uint64 LE):
+//
+//	"PLKHINT2" magic (PLKHINT1 tables used a weaker PRF and must be rebuilt)
+//	DBSize, ChunkSize, SetSize, Epoch, LastBlock
+//	primary count, then per hint: key[16], parity, programmed, progChunk, progIndex
+//	per chunk: backup count, then per backup: key[16], parity
+
+var hintTableMagic = [8]byte{'P', 'L', 'K', 'H', 'I', 'N', 'T', '2'}
+
+// Save writes the table in the hint table file format
+func (t *HintTable) Save(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	var buf [8]byte
+	put := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		bw.Write(buf[:])
+	}
+
+	bw.Write(hintTableMagic[:])
+	put(t.Header.DBSize)
+	put(t.Header.ChunkSize)
+	put(t.Header.SetSize)
+	put(t.Header.Epoch)
+	put(t.LastBlock)
+
+	put(uint64(len(t.primary)))
+	for _, p := range t.primary {
+		bw.Write(p.key[:])
+		put(p.parity)
+		if p.programmed {
+			put(1)
+		} else {
+			put(0)
+		}
+		put(p.progChunk)
+		put(p.progIndex)
+	}
+	for _, backups := range t.backup {
+		put(uint64(len(backups)))
+		for _, b := range backups {
+			bw.Write(b.key[:])
+			put(b.parity)
+		}
+	}
+	return bw.Flush()
+}
+
+// LoadHintTable reads a table written by Save
+func LoadHintTable(r io.Reader) (*HintTable, error) {
+	br := bufio.NewReader(r)
+	var magic [8]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, fmt.Errorf("read hint table: %w", err)
+	}
+	if string(magic[:7]) == "PLKHINT" && magic != hintTableMagic {
+		return nil, errors.New("hint table from an older client; rebuild it with hint fetch")
+	}
+	if magic != hintTableMagic {
+		return nil, errors.New("not a hint table file")
+	}
+
+	var readErr error
+	var buf [8]byte
+	get := func() uint64 {
+		if readErr == nil {
+			_, readErr = io.ReadFull(br, buf[:])
+		}
+		return binary.LittleEndian.Uint64(buf[:])
+	}
+	getKey := func(k *PrfKey128) {
+		if readErr == nil {
+			_, readErr = io.ReadFull(br, k[:])
+		}
+	}
+
+	t := &HintTable{}
+	t.Header = HintHeader{DBSize: get(), ChunkSize: get(), SetSize: get(), Epoch: get()}
+	t.LastBlock = get()
+	if readErr != nil {
+		return nil, fmt.Errorf("read hint table: %w", readErr)
+	}
+	if err := t.Header.Validate(); err != nil {
+		return nil, err
+	}
+
+	n := get()
+	if readErr == nil && n > 64*t.Header.ChunkSize {
+		return nil, fmt.Errorf("hint table claims %d primary hints", n)
+	}
+	t.primary = make([]primaryHint, n)
+	for i := range t.primary {
+		p := &t.primary[i]
+		getKey(&p.key)
+		p.parity = get()
+		p.programmed = get() != 0
+		p.progChunk = get()
+		p.progIndex = get()
+	}
+
+	t.backup = make([][]backupHint, t.Header.SetSize)
+	for c := range t.backup {
+		n := get()
+		if readErr != nil {
+			break
+		}
+		if n > 1<<16 {
+			return nil, fmt.Errorf("hint table claims %d backups for chunk %d", n, c)
+		}
+		t.backup[c] = make([]backupHint, n)
+		for i := range t.backup[c] {
+			getKey(&t.backup[c][i].key)
+			t.backup[c][i].parity = get()
+		}
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("read hint table: %w", readErr)
+	}
+	return t, nil
+}
+
+// SaveFile writes the table to path atomically (temp file, sync, rename)
+func (t *HintTable) SaveFile(path string) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := t.Save(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadHintTableFile reads a table saved with SaveFile
+func LoadHintTableFile(path string) (*HintTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadHintTable(f)
+}
@@ -0,0 +1,146 @@
+package plinkoclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Keyword layout (written by db-generator with DB_LAYOUT=keyword): the
+// database is a cuckoo hash table of Buckets buckets holding two entries,
+// [2b] the account's tag and [2b+1] its balance. An address may be in any of
+// Hashes buckets,
+//
+//	bucket_i = BE64(SHA-256(seed || i || address)[:8]) mod Buckets   (i one byte)
+//	tag      = BE64(SHA-256(seed || 0xff || address)[:8]), 0 mapped to 1
+//
+// so a client needs only keyword-params.json, not an address map. Lookups
+// read both entries of every candidate bucket in one batch, whether or not
+// an earlier bucket matched, so the server always sees 2×Hashes queries.
+
+const (
+	KeywordParamsFile    = "keyword-params.json"
+	KeywordFormatVersion = 1
+
+	keywordTagDomain = 0xff
+)
+
+// KeywordParams mirrors keyword-params.json
+type KeywordParams struct {
+	Version  int      `json:"version"`
+	Buckets  uint64   `json:"buckets"`
+	Hashes   int      `json:"hashes"`
+	Seed     HexBytes `json:"seed"`
+	Accounts int      `json:"accounts"`
+}
+
+// HexBytes is a byte string encoded in JSON as 0x-prefixed hex
+type HexBytes []byte
+
+func (h *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid seed: %w", err)
+	}
+	*h = b
+	return nil
+}
+
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal("0x" + hex.EncodeToString(h))
+}
+
+// Validate checks the parameters against the server's database size
+func (p *KeywordParams) Validate(dbSize uint64) error {
+	if p.Version != KeywordFormatVersion {
+		return fmt.Errorf("keyword params version %d, client reads %d", p.Version, KeywordFormatVersion)
+	}
+	if p.Buckets == 0 || p.Hashes < 1 || p.Hashes > keywordTagDomain || len(p.Seed) == 0 {
+		return fmt.Errorf("invalid keyword params: %d buckets, %d hashes, %d-byte seed", p.Buckets, p.Hashes, len(p.Seed))
+	}
+	if 2*p.Buckets > dbSize {
+		return fmt.Errorf("keyword table of %d buckets does not fit a %d-entry database", p.Buckets, dbSize)
+	}
+	return nil
+}
+
+// Bucket returns candidate bucket i of addr
+func (p *KeywordParams) Bucket(addr Address, i int) uint64 {
+	return keywordHash(p.Seed, byte(i), addr) % p.Buckets
+}
+
+// Tag returns the tag stored next to addr's balance
+func (p *KeywordParams) Tag(addr Address) uint64 {
+	if tag := keywordHash(p.Seed, keywordTagDomain, addr); tag != 0 {
+		return tag
+	}
+	return 1
+}
+
+func keywordHash(seed []byte, domain byte, addr Address) uint64 {
+	h := sha256.New()
+	h.Write(seed)
+	h.Write([]byte{domain})
+	h.Write(addr[:])
+	var sum [sha256.Size]byte
+	return binary.BigEndian.Uint64(h.Sum(sum[:0])[:8])
+}
+
+// FetchKeywordParams downloads keyword-params.json from the CDN
+func (c *Client) FetchKeywordParams(ctx context.Context) (*KeywordParams, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.CDNURL+"/"+KeywordParamsFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", KeywordParamsFile, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", KeywordParamsFile, resp.Status)
+	}
+
+	var p KeywordParams
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", KeywordParamsFile, err)
+	}
+	return &p, nil
+}
+
+// QueryAddress privately reads addr's balance from a keyword-layout
+// database. Addresses that are not in the table give ErrAddressNotFound,
+// after the same queries as any other lookup.
+func (c *Client) QueryAddress(ctx context.Context, p *KeywordParams, addr Address) (uint64, error) {
+	if hints := c.Hints(); hints != nil {
+		if err := p.Validate(hints.Header.DBSize); err != nil {
+			return 0, err
+		}
+	}
+	indices := make([]uint64, 0, 2*p.Hashes)
+	for i := 0; i < p.Hashes; i++ {
+		b := p.Bucket(addr, i)
+		indices = append(indices, 2*b, 2*b+1)
+	}
+
+	values, err := c.QueryBatch(ctx, indices)
+	if err != nil {
+		return 0, err
+	}
+	tag := p.Tag(addr)
+	for i := 0; i < len(values); i += 2 {
+		if values[i] == tag {
+			return values[i+1], nil
+		}
+	}
+	return 0, ErrAddressNotFound
+}
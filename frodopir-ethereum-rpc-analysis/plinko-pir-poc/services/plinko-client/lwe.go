@@ -0,0 +1,253 @@
+package plinkoclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+
+	"plinko-common/lwe"
+)
+
+// LWE (SimplePIR/FrodoPIR-style) queries
+//
+// Instead of per-client hints, every client downloads the same public
+// lwe-hint.bin: H = D·A mod 2^32 for the database as a Rows x Cols byte
+// matrix D and a public Cols x n matrix A expanded from a seed. To read an
+// entry the client encrypts the selector of its column as
+// q = A·s + e + Δ·u_col under a fresh secret s, the server returns D·q, and
+// the 8 rows holding the entry decode as round((D·q - H·s)[r] / Δ). Queries
+// use no client state and need no refresh, but each costs the server a pass
+// over the whole database, and the answer reflects the database as of the
+// hint's epoch (the server does not apply deltas to it).
+//
+// The layout comes from plinko-common/lwe; A and the file format must
+// match plinko-hint-generator/hintgen/lwe.go.
+
+const (
+	LWEHintFile       = "lwe-hint.bin"
+	LWEHintMagic      = "PLNKLWE1"
+	LWEHintHeaderSize = 64
+	LWESeedSize       = 16
+
+	lweDelta = 1 << 24 // q/p with q = 2^32, p = 256
+
+	// Sanity bounds for header fields read from the network
+	maxLWEDimension = 1 << 12
+	maxLWERows      = 1 << 16
+)
+
+// LWEHeader is the lwe-hint.bin header
+type LWEHeader struct {
+	Entries   uint64 // Padded entries, as in hint.bin
+	Epoch     uint64
+	Rows      uint64
+	Cols      uint64
+	Dimension uint64 // n
+	Seed      [LWESeedSize]byte
+}
+
+// LWEHint is a loaded lwe-hint.bin
+type LWEHint struct {
+	LWEHeader
+	H []uint32 // Rows x Dimension, row-major
+}
+
+// LWELayout returns the matrix shape for a database of entries entries
+// (plinko-common/lwe, shared with the other services)
+func LWELayout(entries uint64) (rows, cols uint64) {
+	return lwe.Layout(entries)
+}
+
+// LoadLWEHint reads an lwe-hint.bin stream
+func LoadLWEHint(r io.Reader) (*LWEHint, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var header [LWEHintHeaderSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("read lwe hint header: %w", err)
+	}
+	if string(header[0:8]) != LWEHintMagic {
+		return nil, errors.New("not an lwe hint (bad magic)")
+	}
+	h := &LWEHint{LWEHeader: LWEHeader{
+		Entries:   binary.LittleEndian.Uint64(header[8:]),
+		Epoch:     binary.LittleEndian.Uint64(header[16:]),
+		Rows:      binary.LittleEndian.Uint64(header[24:]),
+		Cols:      binary.LittleEndian.Uint64(header[32:]),
+		Dimension: binary.LittleEndian.Uint64(header[40:]),
+	}}
+	copy(h.Seed[:], header[48:])
+	if rows, cols := LWELayout(h.Entries); rows != h.Rows || cols != h.Cols || rows > maxLWERows ||
+		h.Dimension == 0 || h.Dimension > maxLWEDimension {
+		return nil, fmt.Errorf("invalid lwe hint parameters: %d entries, %d x %d, n = %d",
+			h.Entries, h.Rows, h.Cols, h.Dimension)
+	}
+
+	raw := make([]byte, 4*h.Rows*h.Dimension)
+	if _, err := io.ReadFull(br, raw); err != nil {
+		return nil, fmt.Errorf("read lwe hint matrix: %w", err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, errors.New("lwe hint has trailing data")
+	}
+	h.H = make([]uint32, h.Rows*h.Dimension)
+	for i := range h.H {
+		h.H[i] = binary.LittleEndian.Uint32(raw[4*i:])
+	}
+	return h, nil
+}
+
+// LoadLWEHintFile reads lwe-hint.bin from path
+func LoadLWEHintFile(path string) (*LWEHint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadLWEHint(f)
+}
+
+// DownloadLWEHint downloads lwe-hint.bin from the CDN to path and loads it.
+// The hint must describe the server's database and epoch.
+func (c *Client) DownloadLWEHint(ctx context.Context, path string) (*LWEHint, error) {
+	params, err := c.Params(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tmpPath, err := c.download(ctx, "/"+LWEHintFile, path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	hint, err := LoadLWEHintFile(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	if hint.Entries != params.PaddedSize {
+		return nil, fmt.Errorf("%s covers %d entries, server has %d", LWEHintFile, hint.Entries, params.PaddedSize)
+	}
+	if hint.Epoch != params.Epoch {
+		return nil, fmt.Errorf("%s epoch %d, server epoch %d: %w", LWEHintFile, hint.Epoch, params.Epoch, ErrEpochMismatch)
+	}
+	return hint, os.Rename(tmpPath, path)
+}
+
+// lweRequest/lweResponse mirror the server's LWE query types
+type lweRequest struct {
+	Query []byte `json:"query"`
+}
+
+type lweResponse struct {
+	Answer []byte `json:"answer"`
+}
+
+// QueryLWE privately reads database entry index with an LWE query against
+// hint. The value is the entry as of the hint's epoch.
+func (c *Client) QueryLWE(ctx context.Context, hint *LWEHint, index uint64) (uint64, error) {
+	if index >= hint.Entries {
+		return 0, ErrIndexOutOfRange
+	}
+	perCol := hint.Rows / 8
+	col, row := index/perCol, (index%perCol)*8
+
+	// q = A·s + e + Δ·u_col
+	s := make([]uint32, hint.Dimension)
+	if err := binary.Read(rand.Reader, binary.LittleEndian, s); err != nil {
+		return 0, err
+	}
+	noise := make([]byte, 24*hint.Cols)
+	if _, err := io.ReadFull(rand.Reader, noise); err != nil {
+		return 0, err
+	}
+	query := make([]byte, 4*hint.Cols)
+	a := make([]uint32, hint.Dimension)
+	gen := newLWEMatrixA(hint.Seed, hint.Dimension)
+	for j := uint64(0); j < hint.Cols; j++ {
+		gen.next(a)
+		var v uint32
+		for k, x := range a {
+			v += x * s[k]
+		}
+		v += lweNoise(noise[24*j:])
+		if j == col {
+			v += lweDelta
+		}
+		binary.LittleEndian.PutUint32(query[4*j:], v)
+	}
+
+	var resp lweResponse
+	// Any block of the epoch answers from the same snapshot
+	epochHdr := http.Header{EpochHeader: {strconv.FormatUint(hint.Epoch, 10)}}
+	hdr, err := c.callWith(ctx, http.MethodPost, "/v1/query/lwe", epochHdr, lweRequest{Query: query}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	if epoch, err := strconv.ParseUint(hdr.Get(EpochHeader), 10, 64); err == nil && epoch != hint.Epoch {
+		return 0, ErrEpochMismatch
+	}
+	if uint64(len(resp.Answer)) != 4*hint.Rows {
+		return 0, fmt.Errorf("lwe answer has %d bytes, want %d", len(resp.Answer), 4*hint.Rows)
+	}
+
+	// Only the entry's 8 rows are decoded
+	var value uint64
+	for b := uint64(0); b < 8; b++ {
+		r := row + b
+		v := binary.LittleEndian.Uint32(resp.Answer[4*r:])
+		for k, h := range hint.H[r*hint.Dimension : (r+1)*hint.Dimension] {
+			v -= h * s[k]
+		}
+		value |= uint64(byte((v+lweDelta/2)/lweDelta)) << (8 * b)
+	}
+	return value, nil
+}
+
+// lweNoise turns 24 random bytes into a centered binomial sample over 82
+// coin pairs (standard deviation 6.4, as in SimplePIR), mod 2^32
+func lweNoise(b []byte) uint32 {
+	w0 := binary.LittleEndian.Uint64(b)
+	w1 := binary.LittleEndian.Uint64(b[8:])
+	w2 := binary.LittleEndian.Uint64(b[16:])
+	pos := bits.OnesCount64(w0) + bits.OnesCount64(w2&0x3ffff)
+	neg := bits.OnesCount64(w1) + bits.OnesCount64((w2>>18)&0x3ffff)
+	return uint32(int32(pos - neg))
+}
+
+// lweMatrixA yields the rows of A: the AES-128-CTR keystream under the
+// seed, as uint32 LE
+type lweMatrixA struct {
+	stream cipher.Stream
+	buf    []byte
+}
+
+func newLWEMatrixA(seed [LWESeedSize]byte, n uint64) *lweMatrixA {
+	block, err := aes.NewCipher(seed[:])
+	if err != nil {
+		panic(err)
+	}
+	return &lweMatrixA{
+		stream: cipher.NewCTR(block, make([]byte, aes.BlockSize)),
+		buf:    make([]byte, 4*n),
+	}
+}
+
+// next fills row with the next row of A
+func (g *lweMatrixA) next(row []uint32) {
+	for i := range g.buf {
+		g.buf[i] = 0
+	}
+	g.stream.XORKeyStream(g.buf, g.buf)
+	for k := range row {
+		row[k] = binary.LittleEndian.Uint32(g.buf[4*k:])
+	}
+}
@@ -0,0 +1,160 @@
+package plinkoclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Verified DPF queries
+//
+// plinko-hint-generator can publish merkle-root.json on the CDN: a SHA-256
+// Merkle root over the entries of the epoch's hint.bin. Servers that have
+// it answer DPF keys over records holding the entry and its authentication
+// path (/v1/query/dpf/verified), so the XOR of the two answers can be
+// checked against the root. A server that returns garbage is caught
+// instead of silently corrupting the result, and the keys still reveal
+// nothing about the index. The root must come from the CDN, not from the
+// servers being checked.
+//
+// Leaves are SHA-256(0x00 || index || value), inner nodes
+// SHA-256(0x01 || left || right), with index and value as 8 little-endian
+// bytes. Must match plinko-hint-generator/hintgen/merkle.go and
+// plinko-pir-server/pirserver/merkle.go.
+
+// MerkleRootFile is the CDN file with the epoch's Merkle root
+const MerkleRootFile = "merkle-root.json"
+
+// ErrProofInvalid means a verified answer's authentication path does not
+// lead to the Merkle root: at least one server answered wrongly
+var ErrProofInvalid = errors.New("answer does not match the merkle root")
+
+// MerkleRoot is merkle-root.json
+type MerkleRoot struct {
+	Epoch   uint64 `json:"epoch"`
+	Entries uint64 `json:"entries"` // Padded, as in hint.bin
+	Depth   int    `json:"depth"`
+	Root    []byte `json:"root"`
+}
+
+// MerkleRecordSize returns the size of a verified record for a tree of the
+// given depth: the value and one sibling hash per level
+func MerkleRecordSize(depth int) int {
+	return 8 + depth*sha256.Size
+}
+
+// FetchMerkleRoot downloads and checks merkle-root.json from the CDN
+func (c *Client) FetchMerkleRoot(ctx context.Context) (*MerkleRoot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.CDNURL+"/"+MerkleRootFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", MerkleRootFile, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", MerkleRootFile, resp.Status)
+	}
+	var root MerkleRoot
+	if err := json.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("%s: %w", MerkleRootFile, err)
+	}
+	if len(root.Root) != sha256.Size || root.Depth != int(DPFDomainBits(root.Entries)) {
+		return nil, fmt.Errorf("%s: %d-byte root at depth %d for %d entries", MerkleRootFile, len(root.Root), root.Depth, root.Entries)
+	}
+	return &root, nil
+}
+
+// VerifyMerkleRecord checks that record (value || authentication path)
+// proves the entry at index under root, and returns the value
+func VerifyMerkleRecord(root *MerkleRoot, index uint64, record []byte) (uint64, error) {
+	if len(record) != MerkleRecordSize(root.Depth) {
+		return 0, fmt.Errorf("%d-byte record at depth %d: %w", len(record), root.Depth, ErrProofInvalid)
+	}
+	value := binary.LittleEndian.Uint64(record)
+
+	var leaf [17]byte
+	binary.LittleEndian.PutUint64(leaf[1:], index)
+	binary.LittleEndian.PutUint64(leaf[9:], value)
+	h := sha256.Sum256(leaf[:])
+
+	var node [1 + 2*sha256.Size]byte
+	node[0] = 0x01
+	for l := 0; l < root.Depth; l++ {
+		sibling := record[8+l*sha256.Size : 8+(l+1)*sha256.Size]
+		if index>>l&1 == 0 {
+			copy(node[1:], h[:])
+			copy(node[1+sha256.Size:], sibling)
+		} else {
+			copy(node[1:], sibling)
+			copy(node[1+sha256.Size:], h[:])
+		}
+		h = sha256.Sum256(node[:])
+	}
+	if !bytes.Equal(h[:], root.Root) {
+		return 0, fmt.Errorf("entry %d: %w", index, ErrProofInvalid)
+	}
+	return value, nil
+}
+
+// dpfVerifiedResponse mirrors the server's DPFVerifiedQueryResponse
+type dpfVerifiedResponse struct {
+	Record []byte `json:"record"`
+	Epoch  uint64 `json:"epoch"` // Snapshot the answer is pinned to
+}
+
+// QueryVerified privately reads database entry index and checks it against
+// root, which must be the servers' epoch (ErrEpochMismatch otherwise: fetch
+// the new root). The value is the one at the start of the epoch, whatever
+// deltas the servers have applied since: verified answers are pinned to the
+// epoch's snapshot, and both must say so.
+func (p *DPFPair) QueryVerified(ctx context.Context, root *MerkleRoot, index uint64) (uint64, error) {
+	params, err := p.Params(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if root.Epoch != params.Epoch {
+		return 0, fmt.Errorf("merkle root for epoch %d, servers at %d: %w", root.Epoch, params.Epoch, ErrEpochMismatch)
+	}
+	if root.Entries != params.PaddedSize {
+		return 0, fmt.Errorf("merkle root over %d entries, servers hold %d", root.Entries, params.PaddedSize)
+	}
+	if index >= params.DBSize {
+		return 0, ErrIndexOutOfRange
+	}
+	r := p.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	k0, k1, err := GenDPF(index, uint8(root.Depth), r)
+	if err != nil {
+		return 0, err
+	}
+
+	var resps [2]dpfVerifiedResponse
+	if err := p.ask(ctx, params, "/v1/query/dpf/verified", [2]*DPFKey{k0, k1}, [2]any{&resps[0], &resps[1]}); err != nil {
+		return 0, err
+	}
+	for _, resp := range resps {
+		if resp.Epoch != root.Epoch {
+			return 0, fmt.Errorf("verified answer for epoch %d, root for %d: %w", resp.Epoch, root.Epoch, ErrEpochMismatch)
+		}
+	}
+	a, b := resps[0].Record, resps[1].Record
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("records of %d and %d bytes: %w", len(a), len(b), ErrProofInvalid)
+	}
+	record := make([]byte, len(a))
+	for i := range record {
+		record[i] = a[i] ^ b[i]
+	}
+	return VerifyMerkleRecord(root, index, record)
+}
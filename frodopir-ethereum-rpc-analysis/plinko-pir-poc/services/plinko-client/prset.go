@@ -0,0 +1,71 @@
+package plinkoclient
+
+import "encoding/binary"
+
+// PrfKey128 is a 16-byte PRF key
+type PrfKey128 [16]byte
+
+// PRSet represents a pseudorandom set for Plinko PIR.
+// Expansion must match plinko-pir-server/prset.go bit for bit.
+type PRSet struct {
+	Key PrfKey128
+}
+
+// NewPRSet creates a new PRSet with the given key
+func NewPRSet(key PrfKey128) *PRSet {
+	return &PRSet{Key: key}
+}
+
+// Expand generates one database index per chunk
+func (prs *PRSet) Expand(setSize uint64, chunkSize uint64) []uint64 {
+	indices := make([]uint64, setSize)
+	for i := uint64(0); i < setSize; i++ {
+		indices[i] = i*chunkSize + prs.Offset(i, chunkSize)
+	}
+	return indices
+}
+
+// Offset returns the set's offset within chunk
+func (prs *PRSet) Offset(chunk uint64, chunkSize uint64) uint64 {
+	if chunkSize == 0 {
+		return 0
+	}
+	return fnvState(&prs.Key, chunk) % chunkSize
+}
+
+// fnvState runs FNV-1a over key and x, as the server's PRSet does
+func fnvState(key *PrfKey128, x uint64) uint64 {
+	hash := uint64(2166136261)
+	for i := 0; i < 16; i++ {
+		hash ^= uint64(key[i])
+		hash *= 16777619
+	}
+
+	var xBytes [8]byte
+	binary.LittleEndian.PutUint64(xBytes[:], x)
+	for _, b := range xBytes {
+		hash ^= uint64(b)
+		hash *= 16777619
+	}
+	return hash
+}
+
+// prfEvalMod evaluates the hint PRF: PRF(key, x) mod m. FNV-1a alone is
+// not enough here. Its low bits depend only on the low bits of each input
+// byte, so mod a power-of-two chunk size a key has only m possible sets,
+// and distinct hints would send the server identical sets. The FNV state
+// goes through the murmur3 finalizer first, so every bit of the key and x
+// reaches the offset. Hint keys never leave the client, so this need not
+// match the server's PRSet.
+func prfEvalMod(key *PrfKey128, x uint64, m uint64) uint64 {
+	if m == 0 {
+		return 0
+	}
+	h := fnvState(key, x)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h % m
+}
@@ -0,0 +1,236 @@
+package plinkoclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// State proofs
+//
+// A deployment built with db-generator's STATE_PROOFS also serves a
+// state-proofs table: record i is the eth_getProof account proof of account
+// i of the account database, read at one block (proofs.json). A record
+// spans RecordWords consecutive entries, read privately with batched
+// punctured queries like any other table, so the server learns which
+// account as little as it does for a balance query. The proof is then
+// checked against the stateRoot of that block's header, fetched from a node
+// the client trusts (HeaderSource): the balance is the chain's, not the PIR
+// server's word for it.
+//
+// Record layout, from db-generator/dbgen/proofs.go: node count (uint16 LE),
+// then per node its length (uint16 LE) and RLP, zero-padded.
+//
+// The table is built once and not updated by deltas; its balances are those
+// of proofs.json's block, while the account database moves on with the
+// chain. Accounts added after that block have no proof. A table is valid
+// for StateProofMaxAge blocks after its block: past that, with the chain
+// head taken from the same trusted node, QueryVerifiedAccount refuses it
+// with ErrStateProofStale and the deployment has to build a new one.
+//
+// A record's RecordWords entries sit in one or two chunks, and every query
+// in a chunk uses up one of its backups. The default 4 backups per chunk
+// would run out within the first record, after which every query spends a
+// primary for good, so the table's hints are sized with
+// StateProofHintConfig, and QueryStateProof refuses (ErrStateProofHintsLow)
+// a read the backups cannot cover rather than drain the primaries.
+
+const (
+	StateProofTableName = "state-proofs"
+	StateProofInfoFile  = "proofs.json"
+
+	// StateProofBatch is the most punctured queries sent in one batch
+	// request: the server's default MAX_BATCH_QUERIES
+	StateProofBatch = 16
+
+	// StateProofMaxAge is how many blocks past its block a state-proofs
+	// table is accepted (about a day at 12 s blocks)
+	StateProofMaxAge = 7200
+
+	// DefaultStateProofReads is how many proofs StateProofHintConfig
+	// sizes the backups for when all of them fall in the same chunk
+	DefaultStateProofReads = 16
+)
+
+var (
+	// ErrStateProofInvalid means a retrieved proof does not lead from the
+	// header's state root to the account
+	ErrStateProofInvalid = errors.New("state proof does not match the block's state root")
+	// ErrStateProofStale means the chain head is more than StateProofMaxAge
+	// blocks past the table's block
+	ErrStateProofStale = errors.New("state-proofs table is too old")
+	// ErrStateProofHintsLow means the backups of a record's chunks cannot
+	// cover reading it; rebuild the table's hints
+	ErrStateProofHintsLow = errors.New("too few backup hints left to read a state proof")
+)
+
+// StateProofInfo mirrors proofs.json
+type StateProofInfo struct {
+	Block       uint64      `json:"block"`
+	StateRoot   common.Hash `json:"state_root"`   // As claimed by the publisher; the header's is checked
+	RecordWords int         `json:"record_words"` // Entries per account
+	Accounts    int         `json:"accounts"`
+}
+
+// HeaderSource gives the block headers proofs are checked against.
+// *ethclient.Client implements it; it should be a node the client trusts
+// (or a light client), not the PIR deployment.
+type HeaderSource interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// VerifiedAccount is an account as proven against a block's state root
+type VerifiedAccount struct {
+	Address Address
+	Block   uint64
+	Exists  bool // False for a proof of absence: zero balance and nonce
+	Nonce   uint64
+	Balance *big.Int // Full balance, not truncated to 64 bits
+}
+
+// FetchStateProofInfo downloads the state-proofs table's proofs.json (c is
+// the table's client)
+func (c *Client) FetchStateProofInfo(ctx context.Context) (*StateProofInfo, error) {
+	body, err := c.fetchOptional(ctx, "/"+StateProofInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, fmt.Errorf("download %s: %w", StateProofInfoFile, os.ErrNotExist)
+	}
+	defer body.Close()
+
+	var info StateProofInfo
+	if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", StateProofInfoFile, err)
+	}
+	if info.RecordWords < 1 {
+		return nil, fmt.Errorf("%s: %d-word records", StateProofInfoFile, info.RecordWords)
+	}
+	return &info, nil
+}
+
+// StateProofHintConfig sizes hints for the state-proofs table so that at
+// least reads proofs can be read before they need rebuilding, even if all
+// of them share a chunk: every chunk gets a backup per entry a record can
+// put in it
+func StateProofHintConfig(h HintHeader, info *StateProofInfo, reads int) HintConfig {
+	cfg := DefaultHintConfig(h)
+	cfg.BackupsPerChunk = reads * int(min(uint64(info.RecordWords), h.ChunkSize))
+	return cfg
+}
+
+// QueryStateProof privately reads the proof record of account index (its
+// index in the account database) and returns the proof nodes, unverified.
+// c is the state-proofs table's client, with hints for it. Nothing is sent
+// unless every query of the record can be refreshed from a backup.
+func (c *Client) QueryStateProof(ctx context.Context, info *StateProofInfo, index uint64) ([][]byte, error) {
+	if index >= uint64(info.Accounts) {
+		return nil, ErrIndexOutOfRange
+	}
+	w := uint64(info.RecordWords)
+	if t := c.Hints(); t == nil {
+		return nil, ErrNoHints
+	} else if !t.hasBackups(index*w, w) {
+		return nil, ErrStateProofHintsLow
+	}
+	record := make([]byte, 0, w*EntrySize)
+	for start := index * w; start < (index+1)*w; start += StateProofBatch {
+		indices := make([]uint64, 0, StateProofBatch)
+		for i := start; i < min(start+StateProofBatch, (index+1)*w); i++ {
+			indices = append(indices, i)
+		}
+		values, err := c.QueryBatch(ctx, indices)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			record = binary.LittleEndian.AppendUint64(record, v)
+		}
+	}
+	return DecodeStateProofRecord(record)
+}
+
+// DecodeStateProofRecord splits a state-proofs record into its nodes
+func DecodeStateProofRecord(record []byte) ([][]byte, error) {
+	if len(record) < 2 {
+		return nil, fmt.Errorf("%d-byte record: %w", len(record), ErrStateProofInvalid)
+	}
+	nodes := make([][]byte, binary.LittleEndian.Uint16(record))
+	off := 2
+	for i := range nodes {
+		if off+2 > len(record) {
+			return nil, fmt.Errorf("record ends before node %d: %w", i, ErrStateProofInvalid)
+		}
+		n := int(binary.LittleEndian.Uint16(record[off:]))
+		if off+2+n > len(record) {
+			return nil, fmt.Errorf("node %d runs past the record: %w", i, ErrStateProofInvalid)
+		}
+		nodes[i] = record[off+2 : off+2+n]
+		off += 2 + n
+	}
+	return nodes, nil
+}
+
+// VerifyAccountProof checks that nodes prove addr's account (or its
+// absence) under root and returns it
+func VerifyAccountProof(root common.Hash, addr Address, nodes [][]byte) (*VerifiedAccount, error) {
+	db := memorydb.New()
+	for _, n := range nodes {
+		db.Put(crypto.Keccak256(n), n)
+	}
+	val, err := trie.VerifyProof(root, crypto.Keccak256(addr[:]), db)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", addr, err, ErrStateProofInvalid)
+	}
+	acct := &VerifiedAccount{Address: addr, Balance: new(big.Int)}
+	if val == nil {
+		return acct, nil
+	}
+	var state types.StateAccount
+	if err := rlp.DecodeBytes(val, &state); err != nil {
+		return nil, fmt.Errorf("%s: account: %v: %w", addr, err, ErrStateProofInvalid)
+	}
+	acct.Exists, acct.Nonce, acct.Balance = true, state.Nonce, state.Balance
+	return acct, nil
+}
+
+// QueryVerifiedAccount privately reads the proof of addr, at index in the
+// account database, and verifies it against the stateRoot of the header
+// headers gives for info.Block. A server that answers wrongly, or a table
+// built for another chain, gives ErrStateProofInvalid; a table more than
+// StateProofMaxAge blocks behind headers' latest block, ErrStateProofStale.
+func (c *Client) QueryVerifiedAccount(ctx context.Context, headers HeaderSource, info *StateProofInfo, addr Address, index uint64) (*VerifiedAccount, error) {
+	head, err := headers.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("latest header: %w", err)
+	}
+	if head.Number.Uint64() > info.Block+StateProofMaxAge {
+		return nil, fmt.Errorf("block %d, head %d: %w", info.Block, head.Number, ErrStateProofStale)
+	}
+	header, err := headers.HeaderByNumber(ctx, new(big.Int).SetUint64(info.Block))
+	if err != nil {
+		return nil, fmt.Errorf("header %d: %w", info.Block, err)
+	}
+	nodes, err := c.QueryStateProof(ctx, info, index)
+	if err != nil {
+		return nil, err
+	}
+	acct, err := VerifyAccountProof(header.Root, addr, nodes)
+	if err != nil {
+		return nil, err
+	}
+	acct.Block = info.Block
+	return acct, nil
+}
@@ -0,0 +1,202 @@
+package plinkoclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// proofList collects trie.Prove's nodes in order, root first
+type proofList [][]byte
+
+func (l *proofList) Put(key, value []byte) error {
+	*l = append(*l, common.CopyBytes(value))
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error { return nil }
+
+// fakeHeaders returns one header per block with the given state roots; the
+// highest block is the head
+type fakeHeaders map[uint64]common.Hash
+
+func (h fakeHeaders) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		var head uint64
+		for n := range h {
+			head = max(head, n)
+		}
+		number = new(big.Int).SetUint64(head)
+	}
+	root, ok := h[number.Uint64()]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &types.Header{Number: number, Root: root}, nil
+}
+
+// writeProofTable builds a state trie of accounts and lays their proofs out
+// in fake.db the way db-generator does, returning the trie's root
+func writeProofTable(t *testing.T, fake *fakeServer, accounts []Address, balances []*big.Int) common.Hash {
+	t.Helper()
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i, addr := range accounts {
+		data, _ := rlp.EncodeToBytes(&types.StateAccount{
+			Nonce: uint64(i), Balance: balances[i], Root: types.EmptyRootHash, CodeHash: types.EmptyCodeHash.Bytes(),
+		})
+		tr.MustUpdate(crypto.Keccak256(addr[:]), data)
+	}
+
+	var records [][]byte
+	words := 0
+	for _, addr := range accounts {
+		var nodes proofList
+		if err := tr.Prove(crypto.Keccak256(addr[:]), &nodes); err != nil {
+			t.Fatal(err)
+		}
+		rec := binary.LittleEndian.AppendUint16(nil, uint16(len(nodes)))
+		for _, n := range nodes {
+			rec = binary.LittleEndian.AppendUint16(rec, uint16(len(n)))
+			rec = append(rec, n...)
+		}
+		records = append(records, rec)
+		words = max(words, (len(rec)+EntrySize-1)/EntrySize)
+	}
+	if len(accounts)*words > len(fake.db) {
+		t.Fatalf("%d accounts of %d words do not fit the fake database", len(accounts), words)
+	}
+	for i, rec := range records {
+		rec = append(rec, make([]byte, words*EntrySize-len(rec))...)
+		for w := 0; w < words; w++ {
+			fake.db[i*words+w] = binary.LittleEndian.Uint64(rec[w*EntrySize:])
+		}
+	}
+	fake.proofs = &StateProofInfo{Block: 90, StateRoot: tr.Hash(), RecordWords: words, Accounts: len(accounts)}
+	return tr.Hash()
+}
+
+// setProofHints replaces c's hints with a table sized by
+// StateProofHintConfig for reads proofs
+func setProofHints(t *testing.T, c *Client, info *StateProofInfo, reads int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hint.bin")
+	hdr, err := c.DownloadHint(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := StateProofHintConfig(hdr, info, reads)
+	cfg.PrimaryHints = 16 * testChunkSize
+	cfg.Rand = rand.New(rand.NewSource(1))
+	table, err := BuildHintsFromFile(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetHints(table)
+}
+
+func TestQueryVerifiedAccount(t *testing.T) {
+	fake := newFakeServer()
+	accounts := []Address{{0x01}, {0x02}, {0x03}}
+	balances := []*big.Int{big.NewInt(5), new(big.Int).Lsh(big.NewInt(3), 80), big.NewInt(1)}
+	root := writeProofTable(t, fake, accounts, balances)
+	// A damaged record for account 2, as if the table was built wrongly
+	fake.db[fake.proofs.RecordWords*2+3] ^= 1 << 20
+	c := newTestClientFor(t, fake)
+	ctx := context.Background()
+
+	info, err := c.FetchStateProofInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setProofHints(t, c, info, 6)
+	headers := fakeHeaders{90: root}
+	for i, addr := range accounts[:2] {
+		acct, err := c.QueryVerifiedAccount(ctx, headers, info, addr, uint64(i))
+		if err != nil {
+			t.Fatalf("account %d: %v", i, err)
+		}
+		if !acct.Exists || acct.Balance.Cmp(balances[i]) != 0 || acct.Nonce != uint64(i) || acct.Block != 90 {
+			t.Errorf("account %d = %+v, want balance %s", i, acct, balances[i])
+		}
+	}
+	if fake.batches != 2*((info.RecordWords+StateProofBatch-1)/StateProofBatch) {
+		t.Errorf("%d batch requests for %d-word records", fake.batches, info.RecordWords)
+	}
+
+	// Another account's proof, or a header of another chain, does not verify
+	if _, err := c.QueryVerifiedAccount(ctx, headers, info, accounts[0], 1); !errors.Is(err, ErrStateProofInvalid) {
+		t.Errorf("proof of account 1 for account 0 = %v, want ErrStateProofInvalid", err)
+	}
+	if _, err := c.QueryVerifiedAccount(ctx, fakeHeaders{90: {0x01}}, info, accounts[0], 0); !errors.Is(err, ErrStateProofInvalid) {
+		t.Errorf("proof against another root = %v, want ErrStateProofInvalid", err)
+	}
+
+	if _, err := c.QueryVerifiedAccount(ctx, headers, info, accounts[2], 2); !errors.Is(err, ErrStateProofInvalid) {
+		t.Errorf("damaged record = %v, want ErrStateProofInvalid", err)
+	}
+	if _, err := c.QueryVerifiedAccount(ctx, headers, info, accounts[0], 3); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("index past the table = %v, want ErrIndexOutOfRange", err)
+	}
+}
+
+func TestStateProofHintsAndAge(t *testing.T) {
+	fake := newFakeServer()
+	accounts := []Address{{0x01}, {0x02}}
+	root := writeProofTable(t, fake, accounts, []*big.Int{big.NewInt(5), big.NewInt(6)})
+	c := newTestClientFor(t, fake)
+	ctx := context.Background()
+	info, err := c.FetchStateProofInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default 4 backups per chunk cannot cover a record: refused before
+	// anything is sent
+	before := c.Hints().Stats()
+	if _, err := c.QueryStateProof(ctx, info, 0); !errors.Is(err, ErrStateProofHintsLow) {
+		t.Fatalf("read with default hints = %v, want ErrStateProofHintsLow", err)
+	}
+	if fake.batches != 0 || c.Hints().Stats() != before {
+		t.Fatalf("refused read sent %d batches, hints %+v -> %+v", fake.batches, before, c.Hints().Stats())
+	}
+
+	// Hints for one read: the second read of the same chunk is refused, and
+	// no primary has been spent for good
+	setProofHints(t, c, info, 1)
+	primaries := c.Hints().Stats().Primary
+	if _, err := c.QueryVerifiedAccount(ctx, fakeHeaders{90: root}, info, accounts[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.QueryStateProof(ctx, info, 0); !errors.Is(err, ErrStateProofHintsLow) {
+		t.Fatalf("second read = %v, want ErrStateProofHintsLow", err)
+	}
+	if got := c.Hints().Stats().Primary; got != primaries {
+		t.Errorf("%d primaries after one read, want %d", got, primaries)
+	}
+
+	// Past StateProofMaxAge the table is refused before any query
+	setProofHints(t, c, info, 1)
+	batches := fake.batches
+	stale := fakeHeaders{90: root, 90 + StateProofMaxAge + 1: {}}
+	if _, err := c.QueryVerifiedAccount(ctx, stale, info, accounts[1], 1); !errors.Is(err, ErrStateProofStale) {
+		t.Fatalf("stale table = %v, want ErrStateProofStale", err)
+	}
+	if fake.batches != batches {
+		t.Errorf("stale table still sent %d batches", fake.batches-batches)
+	}
+	aged := fakeHeaders{90: root, 90 + StateProofMaxAge: {}}
+	if _, err := c.QueryVerifiedAccount(ctx, aged, info, accounts[1], 1); err != nil {
+		t.Errorf("table at StateProofMaxAge = %v", err)
+	}
+}
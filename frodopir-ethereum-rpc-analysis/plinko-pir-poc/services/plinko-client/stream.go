@@ -0,0 +1,222 @@
+package plinkoclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Building hints from the server's database stream
+//
+// Instead of downloading hint.bin from the CDN, a client can stream the
+// database from the PIR server (GET /v1/db/stream) and fold it into fresh
+// hints one chunk at a time, so neither the file nor the whole database is
+// ever held. The manifest (GET /v1/db/manifest) pins the epoch and lists a
+// SHA-256 digest per chunk: every chunk is checked before it is used, and a
+// stream that breaks off or delivers a bad chunk is resumed from that
+// chunk. Must match plinko-pir-server/pirserver/stream.go.
+//
+// A whole database takes far longer than DefaultTimeout to download, so the
+// stream is read without c.HTTP's Timeout; a connection that stalls is cut
+// off after DefaultTimeout without a whole chunk and resumed. A 429 from
+// the server's stream rate limit is retried after its Retry-After.
+
+// MaxStreamRetries is how many times in a row a chunk is requested again
+// before BuildHintsFromStream gives up
+const MaxStreamRetries = 5
+
+var (
+	// streamRetryDelay grows by itself with every retry of a chunk
+	streamRetryDelay = 200 * time.Millisecond
+	// streamChunkTimeout is the longest one chunk (a few hundred KB at most)
+	// may take before the stream is reopened
+	streamChunkTimeout = DefaultTimeout
+)
+
+// ErrStreamCorrupt means a chunk kept failing its manifest digest
+var ErrStreamCorrupt = errors.New("database stream chunk does not match the manifest")
+
+// DBManifest describes the database stream of a table
+type DBManifest struct {
+	Epoch      uint64   `json:"epoch"`
+	DBSize     uint64   `json:"db_size"`
+	ChunkSize  uint64   `json:"chunk_size"`
+	SetSize    uint64   `json:"set_size"`
+	EntrySize  int      `json:"entry_size"`
+	StartBlock uint64   `json:"start_block"` // Apply deltas after this block
+	Chunks     [][]byte `json:"chunks"`      // SHA-256 of each chunk's entries
+}
+
+// Header returns the hint.bin header the stream corresponds to
+func (m *DBManifest) Header() HintHeader {
+	return HintHeader{DBSize: m.DBSize, ChunkSize: m.ChunkSize, SetSize: m.SetSize, Epoch: m.Epoch}
+}
+
+// FetchDBManifest fetches and checks the server's database manifest
+func (c *Client) FetchDBManifest(ctx context.Context) (*DBManifest, error) {
+	var m DBManifest
+	if _, err := c.call(ctx, http.MethodGet, "/v1/db/manifest", nil, &m); err != nil {
+		return nil, err
+	}
+	if err := m.Header().Validate(); err != nil {
+		return nil, fmt.Errorf("db manifest: %w", err)
+	}
+	if m.EntrySize != EntrySize || uint64(len(m.Chunks)) != m.SetSize {
+		return nil, fmt.Errorf("db manifest: %d-byte entries and %d digests for %d chunks", m.EntrySize, len(m.Chunks), m.SetSize)
+	}
+	for i, d := range m.Chunks {
+		if len(d) != sha256.Size {
+			return nil, fmt.Errorf("db manifest: chunk %d digest is %d bytes", i, len(d))
+		}
+	}
+	return &m, nil
+}
+
+// BuildHintsFromStream builds a fresh hint table from the server's database
+// stream at m's epoch. The table starts at m.StartBlock; sync deltas from
+// there.
+func (c *Client) BuildHintsFromStream(ctx context.Context, m *DBManifest, cfg HintConfig) (*HintTable, error) {
+	t, err := BuildHints(c.StreamDatabase(ctx, m), m.Header(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.LastBlock = m.StartBlock
+	return t, nil
+}
+
+// StreamDatabase returns the database entries at m's epoch in chunk order,
+// as in hint.bin after the header. Chunks are verified against m and
+// re-requested after failures.
+func (c *Client) StreamDatabase(ctx context.Context, m *DBManifest) io.Reader {
+	return &dbStreamReader{ctx: ctx, c: c, m: m}
+}
+
+// dbStreamReader reads verified chunks, reopening the stream where it broke
+type dbStreamReader struct {
+	ctx context.Context
+	c   *Client
+	m   *DBManifest
+
+	body    io.ReadCloser      // Open stream positioned at chunk next
+	cancel  context.CancelFunc // Ends body's request
+	stall   *time.Timer        // Cancels it when a chunk takes too long
+	next    uint64             // Next chunk to read
+	chunk   []byte             // Verified chunk being handed out
+	pos     int
+	retries int
+	wait    time.Duration // Retry-After of the last rate-limited request
+}
+
+func (r *dbStreamReader) Read(p []byte) (int, error) {
+	for r.pos == len(r.chunk) {
+		if r.next == r.m.SetSize {
+			r.close()
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			r.close()
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// fill reads and verifies chunk next, retrying from it on failure
+func (r *dbStreamReader) fill() error {
+	if r.chunk == nil {
+		r.chunk = make([]byte, r.m.ChunkSize*EntrySize)
+	}
+	for {
+		err := r.open()
+		if err == nil {
+			r.stall.Reset(streamChunkTimeout)
+			_, err = io.ReadFull(r.body, r.chunk)
+		}
+		if err == nil {
+			if sum := sha256.Sum256(r.chunk); bytes.Equal(sum[:], r.m.Chunks[r.next]) {
+				r.next++
+				r.pos = 0
+				r.retries = 0
+				return nil
+			}
+			err = fmt.Errorf("chunk %d: %w", r.next, ErrStreamCorrupt)
+		}
+
+		// The rest of this response cannot be trusted to line up
+		r.close()
+		var apiErr *APIError
+		if r.ctx.Err() != nil || errors.Is(err, ErrEpochMismatch) || (errors.As(err, &apiErr) && !apiErr.Retryable) {
+			return err
+		}
+		if r.retries++; r.retries > MaxStreamRetries {
+			return fmt.Errorf("stream chunk %d: %w", r.next, err)
+		}
+		delay := max(time.Duration(r.retries)*streamRetryDelay, r.wait)
+		r.wait = 0
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// open requests the stream from chunk next when none is open
+func (r *dbStreamReader) open() error {
+	if r.body != nil {
+		return nil
+	}
+	path := fmt.Sprintf("/v1/db/stream?epoch=%d&from=%d", r.m.Epoch, r.next)
+	ctx, cancel := context.WithCancel(r.ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.c.ServerURL+path, nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	req.Header.Set(ProtocolVersionHeader, strconv.Itoa(ProtocolVersion))
+	hc := *r.c.HTTP
+	hc.Timeout = 0 // The stall timer bounds each chunk instead
+	stall := time.AfterFunc(streamChunkTimeout, cancel)
+	resp, err := hc.Do(req)
+	if err != nil {
+		stall.Stop()
+		cancel()
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer cancel()
+		defer stall.Stop()
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				r.wait = time.Duration(secs) * time.Second
+			}
+		}
+		if apiErr := decodeAPIError(resp); apiErr != nil {
+			if apiErr.Code == "epoch_mismatch" {
+				return ErrEpochMismatch
+			}
+			return apiErr
+		}
+		return fmt.Errorf("pir server %s: %s", path, resp.Status)
+	}
+	r.body, r.cancel, r.stall = resp.Body, cancel, stall
+	return nil
+}
+
+func (r *dbStreamReader) close() {
+	if r.body != nil {
+		r.stall.Stop()
+		r.body.Close()
+		r.cancel()
+		r.body = nil
+	}
+}
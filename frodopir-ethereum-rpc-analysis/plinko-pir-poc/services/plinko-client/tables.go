@@ -0,0 +1,54 @@
+package plinkoclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// Named tables
+//
+// A server can answer queries against several tables: the account database
+// (eth-balances, also served at the root) and others such as token-balances.
+// Each has its own parameters, epoch and deltas, and its server API and CDN
+// files live under /tables/<name>/. Table gives a Client for one; it needs
+// hints built from that table's hint.bin.
+
+const (
+	TablesPath       = "/tables/"
+	DefaultTableName = "eth-balances"
+)
+
+// TableInfo is one /v1/tables entry
+type TableInfo struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Epoch      uint64 `json:"epoch"`
+	DBSize     uint64 `json:"db_size"`
+	PaddedSize uint64 `json:"padded_size"`
+	ChunkSize  uint64 `json:"chunk_size"`
+	SetSize    uint64 `json:"set_size"`
+	LastBlock  uint64 `json:"last_block"` // Last delta block the server applied
+}
+
+// Table returns a client for the named table, sharing c's HTTP client. The
+// account database (DefaultTableName) is published at the CDN root, so its
+// client uses c's URLs.
+func (c *Client) Table(name string) *Client {
+	t := New(c.ServerURL, c.CDNURL)
+	if name != DefaultTableName {
+		t = New(c.ServerURL+TablesPath+name, c.CDNURL+TablesPath+name)
+	}
+	t.HTTP = c.HTTP
+	return t
+}
+
+// Tables lists the tables the server answers for
+func (c *Client) Tables(ctx context.Context) ([]TableInfo, error) {
+	var resp struct {
+		Tables []TableInfo `json:"tables"`
+	}
+	if _, err := c.call(ctx, http.MethodGet, "/v1/tables", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tables, nil
+}
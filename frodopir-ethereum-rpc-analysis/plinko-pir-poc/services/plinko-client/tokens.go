@@ -0,0 +1,149 @@
+package plinkoclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Token balances
+//
+// A deployment built with db-generator's TOKENS also serves a
+// token-balances table: one uint64 entry per (holder, token) pair, truncated
+// like ETH balances. The server mounts it under /tables/token-balances/ and
+// the CDN publishes its hint.bin, epoch.json and deltas under the same path,
+// so Table gives a Client for it that works like the account one. Pairs are
+// located through token-mapping.bin (44-byte records: holder, token, uint32
+// LE index) and, for pairs added since, the table's additions-N.bin files.
+
+const (
+	TokenTableName        = "token-balances"
+	TokenInfoFile         = "tokens.json"
+	TokenMappingFile      = "token-mapping.bin"
+	TokenAdditionsFile    = "token-additions.bin" // Local additions, appended by sync
+	TokenMappingEntrySize = 44
+)
+
+// Token is one tokens.json entry
+type Token struct {
+	Address Address `json:"address"`
+	Symbol  string  `json:"symbol,omitempty"`
+}
+
+// TokenInfo mirrors tokens.json
+type TokenInfo struct {
+	Tokens []Token `json:"tokens"`
+	Pairs  int     `json:"pairs"` // Pairs in the initial table
+}
+
+// TokenMappingEntry is one token-mapping.bin record
+type TokenMappingEntry struct {
+	Holder Address
+	Token  Address
+	Index  uint64
+}
+
+// FetchTokenInfo downloads a token table's tokens.json (c is the table's
+// client)
+func (c *Client) FetchTokenInfo(ctx context.Context) (*TokenInfo, error) {
+	body, err := c.fetchOptional(ctx, "/"+TokenInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, fmt.Errorf("download %s: %w", TokenInfoFile, os.ErrNotExist)
+	}
+	defer body.Close()
+
+	var info TokenInfo
+	if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", TokenInfoFile, err)
+	}
+	return &info, nil
+}
+
+// DownloadTokenMapping downloads token-mapping.bin from the table's CDN path
+func (c *Client) DownloadTokenMapping(ctx context.Context, path string) error {
+	tmpPath, err := c.download(ctx, "/"+TokenMappingFile, path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+	if info.Size()%TokenMappingEntrySize != 0 {
+		return fmt.Errorf("%s is %d bytes, not a multiple of %d", TokenMappingFile, info.Size(), TokenMappingEntrySize)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// FetchTokenAdditions downloads the pairs a token table added in block.
+// Blocks that added none have no file and give (nil, nil).
+func (c *Client) FetchTokenAdditions(ctx context.Context, block uint64) ([]TokenMappingEntry, error) {
+	body, err := c.fetchOptional(ctx, fmt.Sprintf("/deltas/additions-%06d.bin", block))
+	if body == nil || err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ReadTokenMappingEntries(body)
+}
+
+// LookupHolderTokens scans token-mapping.bin records for holder and returns
+// the database index of each token it holds
+func LookupHolderTokens(r io.Reader, holder Address) (map[Address]uint64, error) {
+	found := make(map[Address]uint64)
+	br := bufio.NewReaderSize(r, 1<<20)
+	var rec [TokenMappingEntrySize]byte
+	for {
+		if _, err := io.ReadFull(br, rec[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return found, nil
+			}
+			return nil, fmt.Errorf("read token mapping: %w", err)
+		}
+		if Address(rec[:20]) == holder {
+			found[Address(rec[20:40])] = uint64(binary.LittleEndian.Uint32(rec[40:44]))
+		}
+	}
+}
+
+// ReadTokenMappingEntries reads token-mapping.bin records
+func ReadTokenMappingEntries(r io.Reader) ([]TokenMappingEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%TokenMappingEntrySize != 0 {
+		return nil, fmt.Errorf("%d bytes of token mapping records is not a multiple of %d", len(data), TokenMappingEntrySize)
+	}
+	entries := make([]TokenMappingEntry, len(data)/TokenMappingEntrySize)
+	for i := range entries {
+		rec := data[i*TokenMappingEntrySize:]
+		entries[i] = TokenMappingEntry{
+			Holder: Address(rec[:20]),
+			Token:  Address(rec[20:40]),
+			Index:  uint64(binary.LittleEndian.Uint32(rec[40:44])),
+		}
+	}
+	return entries, nil
+}
+
+// WriteTokenMappingEntries writes entries as token-mapping.bin records
+func WriteTokenMappingEntries(w io.Writer, entries []TokenMappingEntry) error {
+	buf := make([]byte, 0, len(entries)*TokenMappingEntrySize)
+	for _, e := range entries {
+		buf = append(buf, e.Holder[:]...)
+		buf = append(buf, e.Token[:]...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(e.Index))
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,239 @@
+// Package dpf is the two-server distributed point function (DPF) that
+// plinko-client generates keys with and plinko-pir-server evaluates.
+//
+// A light client without hints can read index x from two non-colluding
+// servers that hold the same database. It splits the point function
+// f(i) = [i == x] into two keys and sends one to each server. Each server
+// evaluates its key over the whole domain and returns the XOR of the
+// entries where its share is 1; the shares differ only at x, so the XOR of
+// the two answers is entry x. Either key on its own is pseudorandom and
+// says nothing about x.
+//
+// The construction is the tree DPF of Boyle, Gilboa and Ishai ("Function
+// Secret Sharing: Improvements and Extensions", CCS 2016) with a 1-bit
+// output: the control bit at each leaf is the share. The PRG is fixed-key
+// AES in Matyas-Meyer-Oseas mode.
+package dpf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	SeedSize = 16
+	cwSize   = SeedSize + 2 // Seed correction, left and right control bit corrections
+)
+
+// PRG keys; changing them breaks every deployed client
+var prg = newGenerator([]byte("plinko-dpf-prg-L"), []byte("plinko-dpf-prg-R"))
+
+// Seed is a node seed of the DPF tree
+type Seed [SeedSize]byte
+
+// Correction is the correction word of one tree level
+type Correction struct {
+	Seed   Seed
+	TLeft  uint8
+	TRight uint8
+}
+
+// Key is one server's share of a point function over [0, 2^Bits)
+type Key struct {
+	Bits uint8
+	Seed Seed
+	T    uint8
+	CW   []Correction // One per level
+}
+
+// KeySize returns the encoded size of a key over [0, 2^bits)
+func KeySize(bits uint8) int {
+	return 2 + SeedSize + int(bits)*cwSize
+}
+
+// DomainBits returns the domain size, in bits, that covers n entries
+func DomainBits(n uint64) uint8 {
+	if n <= 1 {
+		return 0
+	}
+	return uint8(bits.Len64(n - 1))
+}
+
+// MarshalBinary encodes k as [bits][seed][t] then per level [seed][tL][tR]
+func (k *Key) MarshalBinary() ([]byte, error) {
+	if len(k.CW) != int(k.Bits) {
+		return nil, fmt.Errorf("dpf key has %d correction words for %d bits", len(k.CW), k.Bits)
+	}
+	buf := make([]byte, 0, KeySize(k.Bits))
+	buf = append(buf, k.Bits)
+	buf = append(buf, k.Seed[:]...)
+	buf = append(buf, k.T)
+	for _, cw := range k.CW {
+		buf = append(buf, cw.Seed[:]...)
+		buf = append(buf, cw.TLeft, cw.TRight)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a key written by MarshalBinary
+func (k *Key) UnmarshalBinary(data []byte) error {
+	if len(data) < 2+SeedSize {
+		return errors.New("dpf key too short")
+	}
+	n := data[0]
+	if n > 63 || len(data) != KeySize(n) {
+		return fmt.Errorf("dpf key of %d bytes does not match a %d-bit domain", len(data), n)
+	}
+	k.Bits = n
+	copy(k.Seed[:], data[1:])
+	k.T = data[1+SeedSize]
+	if k.T > 1 {
+		return errors.New("dpf key control bit is not 0 or 1")
+	}
+	k.CW = make([]Correction, n)
+	for i := range k.CW {
+		rec := data[2+SeedSize+i*cwSize:]
+		copy(k.CW[i].Seed[:], rec)
+		k.CW[i].TLeft, k.CW[i].TRight = rec[SeedSize], rec[SeedSize+1]
+		if k.CW[i].TLeft > 1 || k.CW[i].TRight > 1 {
+			return errors.New("dpf key control bit correction is not 0 or 1")
+		}
+	}
+	return nil
+}
+
+// Gen splits the point function at alpha over [0, 2^bits) into two keys
+func Gen(alpha uint64, bits uint8, rand io.Reader) (k0, k1 *Key, err error) {
+	if bits > 63 || alpha>>bits != 0 {
+		return nil, nil, fmt.Errorf("index %d outside a %d-bit domain", alpha, bits)
+	}
+	var s [2]Seed
+	if _, err := io.ReadFull(rand, s[0][:]); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(rand, s[1][:]); err != nil {
+		return nil, nil, err
+	}
+	k0 = &Key{Bits: bits, Seed: s[0], T: 0, CW: make([]Correction, bits)}
+	k1 = &Key{Bits: bits, Seed: s[1], T: 1, CW: make([]Correction, bits)}
+
+	t := [2]uint8{0, 1}
+	for i := uint8(0); i < bits; i++ {
+		bit := uint8(alpha>>(bits-1-i)) & 1
+		var sL, sR [2]Seed
+		var tL, tR [2]uint8
+		for b := 0; b < 2; b++ {
+			sL[b], tL[b], sR[b], tR[b] = prg.expand(&s[b])
+		}
+
+		// The branch off the path must look the same to both servers
+		var cw Correction
+		if bit == 0 {
+			cw.Seed = xorSeed(sR[0], sR[1])
+		} else {
+			cw.Seed = xorSeed(sL[0], sL[1])
+		}
+		cw.TLeft = tL[0] ^ tL[1] ^ bit ^ 1
+		cw.TRight = tR[0] ^ tR[1] ^ bit
+		k0.CW[i], k1.CW[i] = cw, cw
+
+		for b := 0; b < 2; b++ {
+			keep, tKeep, tCW := sL[b], tL[b], cw.TLeft
+			if bit == 1 {
+				keep, tKeep, tCW = sR[b], tR[b], cw.TRight
+			}
+			if t[b] == 1 {
+				keep = xorSeed(keep, cw.Seed)
+				tKeep ^= tCW
+			}
+			s[b], t[b] = keep, tKeep
+		}
+	}
+	return k0, k1, nil
+}
+
+// Eval returns the key's share of the point function at x
+func (k *Key) Eval(x uint64) uint8 {
+	s, t := k.Seed, k.T
+	for i := uint8(0); i < k.Bits; i++ {
+		sL, tL, sR, tR := k.children(i, &s, t)
+		if (x>>(k.Bits-1-i))&1 == 0 {
+			s, t = sL, tL
+		} else {
+			s, t = sR, tR
+		}
+	}
+	return t
+}
+
+// Walk calls leaf with the key's share at every x in [lo, hi), in order.
+// It expands the tree depth first and skips subtrees outside the range,
+// so a full walk costs one PRG call per node rather than Bits per leaf.
+func (k *Key) Walk(lo, hi uint64, leaf func(x uint64, t uint8)) {
+	var walk func(s *Seed, t uint8, level uint8, prefix uint64)
+	walk = func(s *Seed, t uint8, level uint8, prefix uint64) {
+		if prefix<<(k.Bits-level) >= hi || (prefix+1)<<(k.Bits-level) <= lo {
+			return
+		}
+		if level == k.Bits {
+			leaf(prefix, t)
+			return
+		}
+		sL, tL, sR, tR := k.children(level, s, t)
+		walk(&sL, tL, level+1, prefix<<1)
+		walk(&sR, tR, level+1, prefix<<1|1)
+	}
+	walk(&k.Seed, k.T, 0, 0)
+}
+
+// children expands the node (s, t) at level into its two corrected children
+func (k *Key) children(level uint8, s *Seed, t uint8) (sL Seed, tL uint8, sR Seed, tR uint8) {
+	sL, tL, sR, tR = prg.expand(s)
+	if t == 1 {
+		cw := &k.CW[level]
+		sL, sR = xorSeed(sL, cw.Seed), xorSeed(sR, cw.Seed)
+		tL, tR = tL^cw.TLeft, tR^cw.TRight
+	}
+	return
+}
+
+// generator is the PRG: it doubles a seed into two seeds and two control
+// bits
+type generator struct {
+	left, right cipher.Block
+}
+
+func newGenerator(left, right []byte) *generator {
+	l, err := aes.NewCipher(left)
+	if err != nil {
+		panic(err)
+	}
+	r, err := aes.NewCipher(right)
+	if err != nil {
+		panic(err)
+	}
+	return &generator{left: l, right: r}
+}
+
+// expand returns AES_L(s)^s and AES_R(s)^s, each with its lowest bit taken
+// out as the control bit
+func (g *generator) expand(s *Seed) (sL Seed, tL uint8, sR Seed, tR uint8) {
+	g.left.Encrypt(sL[:], s[:])
+	g.right.Encrypt(sR[:], s[:])
+	sL, sR = xorSeed(sL, *s), xorSeed(sR, *s)
+	tL, tR = sL[0]&1, sR[0]&1
+	sL[0] &^= 1
+	sR[0] &^= 1
+	return
+}
+
+func xorSeed(a, b Seed) Seed {
+	for i := range a {
+		a[i] ^= b[i]
+	}
+	return a
+}
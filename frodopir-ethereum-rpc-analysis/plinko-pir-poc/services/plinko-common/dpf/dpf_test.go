@@ -0,0 +1,84 @@
+package dpf
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestPointFunction(t *testing.T) {
+	for _, bits := range []uint8{0, 1, 5, 10} {
+		for _, alpha := range []uint64{0, (1 << bits) / 3, (1 << bits) - 1} {
+			k0, k1, err := Gen(alpha, bits, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x := uint64(0); x < 1<<bits; x++ {
+				want := uint8(0)
+				if x == alpha {
+					want = 1
+				}
+				if got := k0.Eval(x) ^ k1.Eval(x); got != want {
+					t.Fatalf("bits %d, alpha %d: shares at %d XOR to %d", bits, alpha, x, got)
+				}
+			}
+		}
+	}
+
+	if _, _, err := Gen(16, 4, rand.Reader); err == nil {
+		t.Error("Gen accepted an index outside the domain")
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	k0, _, err := Gen(301, 9, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := k0.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != KeySize(9) {
+		t.Fatalf("encoded key is %d bytes, want %d", len(data), KeySize(9))
+	}
+
+	var got Key
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for x := uint64(0); x < 512; x++ {
+		if got.Eval(x) != k0.Eval(x) {
+			t.Fatalf("decoded key differs at %d", x)
+		}
+	}
+
+	if err := got.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("UnmarshalBinary accepted a truncated key")
+	}
+	data[1+SeedSize] = 2
+	if err := got.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary accepted a control bit of 2")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	k0, _, err := Gen(77, 7, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]uint64{{0, 128}, {0, 100}, {13, 77}, {50, 50}} {
+		next := r[0]
+		k0.Walk(r[0], r[1], func(x uint64, share uint8) {
+			if x != next {
+				t.Fatalf("walk [%d, %d) visited %d, want %d", r[0], r[1], x, next)
+			}
+			if want := k0.Eval(x); share != want {
+				t.Fatalf("walk share at %d = %d, Eval says %d", x, share, want)
+			}
+			next++
+		})
+		if next != r[1] {
+			t.Errorf("walk [%d, %d) stopped at %d", r[0], r[1], next)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module plinko-common
+
+go 1.21
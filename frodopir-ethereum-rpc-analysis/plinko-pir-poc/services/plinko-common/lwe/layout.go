@@ -0,0 +1,19 @@
+// Package lwe holds what plinko-hint-generator, plinko-pir-server and
+// plinko-client must agree on for SimplePIR/FrodoPIR-style LWE queries.
+//
+// The database is viewed as a Rows x Cols byte matrix D: column j holds
+// entries j*E to j*E+E-1 (E = Rows/8), each as 8 little-endian bytes down
+// the column.
+package lwe
+
+import "math"
+
+// Layout returns the matrix shape for a database of entries entries:
+// about square in bytes, 8 rows per entry
+func Layout(entries uint64) (rows, cols uint64) {
+	perCol := uint64(math.Ceil(math.Sqrt(float64(entries) / 8)))
+	if perCol == 0 {
+		perCol = 1
+	}
+	return 8 * perCol, (entries + perCol - 1) / perCol
+}
@@ -0,0 +1,21 @@
+package lwe
+
+import "testing"
+
+func TestLayout(t *testing.T) {
+	for _, tc := range []struct{ entries, rows, cols uint64 }{
+		{0, 8, 0},
+		{1, 8, 1},
+		{48, 24, 16},
+		{1 << 20, 2904, 2889},
+		{1 << 23, 8192, 8192},
+	} {
+		rows, cols := Layout(tc.entries)
+		if rows != tc.rows || cols != tc.cols {
+			t.Errorf("Layout(%d) = %d x %d, want %d x %d", tc.entries, rows, cols, tc.rows, tc.cols)
+		}
+		if rows%8 != 0 || rows/8*cols < tc.entries {
+			t.Errorf("Layout(%d) = %d x %d does not hold every entry", tc.entries, rows, cols)
+		}
+	}
+}
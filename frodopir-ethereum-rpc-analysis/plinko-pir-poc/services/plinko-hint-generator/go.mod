@@ -1,3 +1,7 @@
 module piano-pir-hint-generator
 
 go 1.21
+
+require plinko-common v0.0.0
+
+replace plinko-common => ../plinko-common
@@ -0,0 +1,107 @@
+// Package hintgen lays a database out as hint.bin: a 32-byte header followed
+// by the entries padded to ChunkSize*SetSize.
+package hintgen
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DBEntrySize    = 8  // 8 bytes per entry
+	HintHeaderSize = 32 // [DBSize][ChunkSize][SetSize][Epoch]
+)
+
+// GenParams generates Plinko PIR parameters (ChunkSize, SetSize)
+// Same logic as Plinko PIR util.GenParams
+func GenParams(dbSize uint64) (uint64, uint64) {
+	targetChunkSize := uint64(2 * math.Sqrt(float64(dbSize)))
+	chunkSize := uint64(1)
+	for chunkSize < targetChunkSize {
+		chunkSize *= 2
+	}
+	setSize := uint64(math.Ceil(float64(dbSize) / float64(chunkSize)))
+	// Round up to the next multiple of 4
+	setSize = (setSize + 3) / 4 * 4
+	return chunkSize, setSize
+}
+
+// WriteHint writes hint.bin for database (raw little-endian entries) to w,
+// zero-padding it to chunkSize*setSize entries
+func WriteHint(w io.Writer, database []byte, dbSize, chunkSize, setSize, epoch uint64) error {
+	total := chunkSize * setSize * DBEntrySize
+	if uint64(len(database)) > total {
+		return fmt.Errorf("database of %d bytes exceeds %d chunk slots", len(database), chunkSize*setSize)
+	}
+
+	// Write Plinko PIR metadata header (32 bytes)
+	// Format: [DBSize:8][ChunkSize:8][SetSize:8][Epoch:8]
+	// Epoch identifies the database version; the initial snapshot is epoch 0
+	header := make([]byte, HintHeaderSize)
+	binary.LittleEndian.PutUint64(header[0:8], dbSize)
+	binary.LittleEndian.PutUint64(header[8:16], chunkSize)
+	binary.LittleEndian.PutUint64(header[16:24], setSize)
+	binary.LittleEndian.PutUint64(header[24:32], epoch)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Write database in Piano chunked format
+	// The database is already in the correct format (sequential entries)
+	// Plinko PIR chunks it logically: chunk i = entries [i*chunkSize : (i+1)*chunkSize]
+	if _, err := w.Write(database); err != nil {
+		return err
+	}
+
+	// Pad the last chunks with zero entries
+	_, err := io.CopyN(w, zeroReader{}, int64(total-uint64(len(database))))
+	return err
+}
+
+// WriteHintFile writes hint.bin to path. It goes through a temporary file
+// in the same directory, so a service watching path never loads half of it.
+func WriteHintFile(path string, database []byte, dbSize, chunkSize, setSize, epoch uint64) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err := WriteHint(w, database, dbSize, chunkSize, setSize, epoch); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
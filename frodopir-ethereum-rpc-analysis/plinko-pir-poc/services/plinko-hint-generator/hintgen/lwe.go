@@ -0,0 +1,199 @@
+package hintgen
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"plinko-common/lwe"
+)
+
+// LWE hint
+//
+// For the SimplePIR/FrodoPIR-style query mode the database is a Rows x Cols
+// byte matrix D (LWELayout; column j holds entries j*E to j*E+E-1, E =
+// Rows/8, each as 8 little-endian bytes down the column), and clients need
+// H = D·A mod 2^32, where A is a public Cols x n matrix of uint32s: the
+// AES-128-CTR keystream (key = seed, zero IV) read row by row. lwe-hint.bin
+// is:
+//
+//	[0:8]   Magic "PLNKLWE1"
+//	[8:16]  Entries (padded, as in hint.bin)
+//	[16:24] Epoch
+//	[24:32] Rows
+//	[32:40] Cols
+//	[40:48] n (LWE dimension)
+//	[48:64] Seed of A
+//	then Rows x n uint32 LE, row-major
+//
+// It is derived from hint.bin, so both describe the same database. The
+// layout comes from plinko-common/lwe; A must match
+// plinko-pir-server/pirserver/lwe.go and plinko-client/lwe.go.
+
+const (
+	LWEHintFile       = "lwe-hint.bin"
+	LWEHintMagic      = "PLNKLWE1"
+	LWEHintHeaderSize = 64
+	LWEDimension      = 1024 // n, with q = 2^32 as in SimplePIR
+	LWESeedSize       = 16
+
+	lweRowBlock = 64 // Rows of H accumulated together, to reuse each row of A
+)
+
+// LWELayout returns the matrix shape for a database of entries entries
+// (plinko-common/lwe, shared with the other services)
+func LWELayout(entries uint64) (rows, cols uint64) {
+	return lwe.Layout(entries)
+}
+
+// WriteLWEHintFile writes lwePath for the database in hintPath, with A
+// expanded from a seed read from rand. It computes Rows·Cols·n
+// multiply-adds, spread over all cores: 6.9e10 for 2^23 entries. Measured
+// at 2^20 entries, one core does about 1.9e9 a second, so 2^23 entries
+// take about 40 CPU-seconds.
+func WriteLWEHintFile(lwePath, hintPath string, rand io.Reader) (err error) {
+	data, err := os.ReadFile(hintPath)
+	if err != nil {
+		return err
+	}
+	if len(data) < HintHeaderSize || (len(data)-HintHeaderSize)%DBEntrySize != 0 {
+		return fmt.Errorf("%s: bad size %d", hintPath, len(data))
+	}
+	epoch := binary.LittleEndian.Uint64(data[24:32])
+	entries := uint64(len(data)-HintHeaderSize) / DBEntrySize
+	var seed [LWESeedSize]byte
+	if _, err := io.ReadFull(rand, seed[:]); err != nil {
+		return err
+	}
+
+	rows, cols := LWELayout(entries)
+	d := lweMatrix(data[HintHeaderSize:], entries, rows, cols)
+	h := lweHint(d, rows, cols, seed)
+
+	f, err := os.CreateTemp(filepath.Dir(lwePath), ".tmp-"+filepath.Base(lwePath)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	header := make([]byte, LWEHintHeaderSize)
+	copy(header, LWEHintMagic)
+	binary.LittleEndian.PutUint64(header[8:], entries)
+	binary.LittleEndian.PutUint64(header[16:], epoch)
+	binary.LittleEndian.PutUint64(header[24:], rows)
+	binary.LittleEndian.PutUint64(header[32:], cols)
+	binary.LittleEndian.PutUint64(header[40:], LWEDimension)
+	copy(header[48:], seed[:])
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	var buf [4]byte
+	for _, v := range h {
+		binary.LittleEndian.PutUint32(buf[:], v)
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), lwePath)
+}
+
+// lweMatrix lays raw little-endian entries out as D, row-major
+func lweMatrix(database []byte, entries, rows, cols uint64) []byte {
+	perCol := rows / 8
+	d := make([]byte, rows*cols)
+	for i := uint64(0); i < entries; i++ {
+		col, row := i/perCol, (i%perCol)*8
+		for b := uint64(0); b < 8; b++ {
+			d[(row+b)*cols+col] = database[i*DBEntrySize+b]
+		}
+	}
+	return d
+}
+
+// lweHint returns H = D·A, Rows x n row-major. Workers take blocks of
+// lweRowBlock rows and stream A through them, regenerating it per block.
+func lweHint(d []byte, rows, cols uint64, seed [LWESeedSize]byte) []uint32 {
+	h := make([]uint32, rows*LWEDimension)
+	blocks := make(chan uint64)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := make([]uint32, LWEDimension)
+			for start := range blocks {
+				end := min(start+lweRowBlock, rows)
+				gen := newLWEMatrixA(seed)
+				for j := uint64(0); j < cols; j++ {
+					gen.next(a)
+					for r := start; r < end; r++ {
+						x := uint32(d[r*cols+j])
+						if x == 0 {
+							continue
+						}
+						acc := h[r*LWEDimension : (r+1)*LWEDimension]
+						for k, v := range a {
+							acc[k] += x * v
+						}
+					}
+				}
+			}
+		}()
+	}
+	for start := uint64(0); start < rows; start += lweRowBlock {
+		blocks <- start
+	}
+	close(blocks)
+	wg.Wait()
+	return h
+}
+
+// lweMatrixA yields the rows of A in order
+type lweMatrixA struct {
+	stream cipher.Stream
+	buf    []byte
+}
+
+func newLWEMatrixA(seed [LWESeedSize]byte) *lweMatrixA {
+	block, err := aes.NewCipher(seed[:])
+	if err != nil {
+		panic(err)
+	}
+	return &lweMatrixA{
+		stream: cipher.NewCTR(block, make([]byte, aes.BlockSize)),
+		buf:    make([]byte, 4*LWEDimension),
+	}
+}
+
+// next fills row with the next row of A
+func (g *lweMatrixA) next(row []uint32) {
+	for i := range g.buf {
+		g.buf[i] = 0
+	}
+	g.stream.XORKeyStream(g.buf, g.buf)
+	for k := range row {
+		row[k] = binary.LittleEndian.Uint32(g.buf[4*k:])
+	}
+}
@@ -0,0 +1,117 @@
+package hintgen
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// Merkle commitment
+//
+// merkle-root.json commits to the entries of an epoch's hint.bin, so that
+// clients can check what a PIR server hands back without trusting it:
+//
+//	{"epoch": 3, "entries": 8388608, "depth": 23, "root": "<base64 SHA-256>"}
+//
+// The tree has 2^depth leaves, depth being the bits of the padded entry
+// count; leaves past the last entry hold the value 0. With H = SHA-256:
+//
+//	leaf(i, v)  = H(0x00 || i || v)    i, v as 8 little-endian bytes
+//	node(l, r)  = H(0x01 || l || r)
+//
+// Binding the index into the leaf stops a server from answering with a
+// valid entry from another slot. The hashing must match
+// plinko-pir-server/pirserver/merkle.go and plinko-client/merkle.go.
+
+const MerkleRootFile = "merkle-root.json"
+
+// MerkleRoot is merkle-root.json
+type MerkleRoot struct {
+	Epoch   uint64 `json:"epoch"`
+	Entries uint64 `json:"entries"` // Padded, as in hint.bin
+	Depth   int    `json:"depth"`
+	Root    []byte `json:"root"` // base64 in JSON
+}
+
+// MerkleDepth returns the depth of the tree over entries leaves
+func MerkleDepth(entries uint64) int {
+	if entries <= 1 {
+		return 0
+	}
+	return bits.Len64(entries - 1)
+}
+
+// WriteMerkleRoot writes rootPath for the database in hintPath. Like
+// hint.bin it is renamed into place.
+func WriteMerkleRoot(rootPath, hintPath string) (root MerkleRoot, err error) {
+	data, err := os.ReadFile(hintPath)
+	if err != nil {
+		return root, err
+	}
+	if len(data) < HintHeaderSize || (len(data)-HintHeaderSize)%DBEntrySize != 0 {
+		return root, fmt.Errorf("%s: bad size %d", hintPath, len(data))
+	}
+	entries := uint64(len(data)-HintHeaderSize) / DBEntrySize
+	root = MerkleRoot{
+		Epoch:   binary.LittleEndian.Uint64(data[24:32]),
+		Entries: entries,
+		Depth:   MerkleDepth(entries),
+		Root:    merkleRoot(data[HintHeaderSize:], MerkleDepth(entries)),
+	}
+
+	out, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return root, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(rootPath), ".tmp-"+filepath.Base(rootPath)+"-*")
+	if err != nil {
+		return root, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(append(out, '\n')); err != nil {
+		return root, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return root, err
+	}
+	if err := f.Close(); err != nil {
+		return root, err
+	}
+	return root, os.Rename(f.Name(), rootPath)
+}
+
+// merkleRoot hashes raw little-endian entries up to the root, one level at
+// a time
+func merkleRoot(database []byte, depth int) []byte {
+	entries := uint64(len(database) / DBEntrySize)
+	level := make([][sha256.Size]byte, 1<<depth)
+	var leaf [17]byte
+	for i := range level {
+		binary.LittleEndian.PutUint64(leaf[1:], uint64(i))
+		binary.LittleEndian.PutUint64(leaf[9:], 0)
+		if uint64(i) < entries {
+			copy(leaf[9:], database[i*DBEntrySize:(i+1)*DBEntrySize])
+		}
+		level[i] = sha256.Sum256(leaf[:])
+	}
+	var node [1 + 2*sha256.Size]byte
+	node[0] = 0x01
+	for len(level) > 1 {
+		for i := range level[:len(level)/2] {
+			copy(node[1:], level[2*i][:])
+			copy(node[1+sha256.Size:], level[2*i+1][:])
+			level[i] = sha256.Sum256(node[:])
+		}
+		level = level[:len(level)/2]
+	}
+	return level[0][:]
+}
@@ -0,0 +1,74 @@
+package hintgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Regeneration
+//
+// plinko-update-service fills the padding slots with new accounts. When they
+// run out it rewrites database.bin with every account, including the ones
+// that did not fit, and leaves regenerate.json:
+//
+//	{"epoch": 1, "db_size": 8389000, "last_block": 1234}
+//
+// Regenerate answers it with a hint.bin for db_size entries under GrowParams
+// and the requested epoch, then removes the request.
+
+// RegenerateRequest is regenerate.json
+type RegenerateRequest struct {
+	Epoch     uint64 `json:"epoch"`
+	DBSize    uint64 `json:"db_size"`    // Entries in database.bin
+	LastBlock uint64 `json:"last_block"` // Last block whose changes are in database.bin
+}
+
+// ReadRegenerateRequest reads regenerate.json from path
+func ReadRegenerateRequest(path string) (RegenerateRequest, error) {
+	var req RegenerateRequest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("%s: %w", path, err)
+	}
+	if req.DBSize == 0 {
+		return req, fmt.Errorf("%s: db_size is 0", path)
+	}
+	return req, nil
+}
+
+// GrowParams returns parameters for a grown database of dbSize entries,
+// with at least dbSize/16 padding slots left for the accounts that come
+// next
+func GrowParams(dbSize uint64) (uint64, uint64) {
+	headroom := dbSize / 16
+	if headroom == 0 {
+		headroom = 1
+	}
+	return GenParams(dbSize + headroom)
+}
+
+// Regenerate answers the request at requestPath: it writes hintPath from
+// databasePath with GrowParams and the requested epoch, and removes the
+// request
+func Regenerate(requestPath, databasePath, hintPath string) (RegenerateRequest, error) {
+	req, err := ReadRegenerateRequest(requestPath)
+	if err != nil {
+		return req, err
+	}
+	database, err := os.ReadFile(databasePath)
+	if err != nil {
+		return req, err
+	}
+	if uint64(len(database)) != req.DBSize*DBEntrySize {
+		return req, fmt.Errorf("%s has %d bytes, request is for %d entries", databasePath, len(database), req.DBSize)
+	}
+	chunkSize, setSize := GrowParams(req.DBSize)
+	if err := WriteHintFile(hintPath, database, req.DBSize, chunkSize, setSize, req.Epoch); err != nil {
+		return req, err
+	}
+	return req, os.Remove(requestPath)
+}
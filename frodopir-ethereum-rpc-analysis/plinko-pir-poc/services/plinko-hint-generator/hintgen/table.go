@@ -0,0 +1,30 @@
+package hintgen
+
+import (
+	"fmt"
+	"os"
+)
+
+// Additional tables
+//
+// db-generator can write more PIR tables next to the account database, each
+// in its own directory under tables/ with a database.bin of 8-byte entries
+// (token-balances: one entry per (holder, token) pair). Each gets its own
+// hint.bin. Their entry counts are not fixed, and new pairs appear with
+// every block, so they are laid out with GrowParams headroom from the start.
+
+// WriteTableHint writes hintPath for the table in databasePath and returns
+// its entry count and parameters
+func WriteTableHint(databasePath, hintPath string) (dbSize, chunkSize, setSize uint64, err error) {
+	database, err := os.ReadFile(databasePath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(database)%DBEntrySize != 0 {
+		return 0, 0, 0, fmt.Errorf("%s has %d bytes, not a multiple of %d", databasePath, len(database), DBEntrySize)
+	}
+	dbSize = uint64(len(database) / DBEntrySize)
+	chunkSize, setSize = GrowParams(max(dbSize, 1))
+	err = WriteHintFile(hintPath, database, dbSize, chunkSize, setSize, 0)
+	return dbSize, chunkSize, setSize, err
+}
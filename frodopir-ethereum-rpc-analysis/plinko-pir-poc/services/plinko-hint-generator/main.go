@@ -1,34 +1,38 @@
 package main
 
 import (
-	"encoding/binary"
+	"crypto/rand"
 	"log"
-	"math"
 	"os"
+	"path/filepath"
 	"time"
+
+	"piano-pir-hint-generator/hintgen"
 )
 
 const (
 	DatabasePath = "/data/database.bin"
 	HintPath     = "/data/hint.bin"
+	RequestPath  = "/data/regenerate.json" // Written by plinko-update-service when it runs out of slots
+
+	// Optional tables written by db-generator, each in TablesDir/<name>
+	TablesDir = "/data/tables"
 
 	DBSize      = 8388608  // 2^23 accounts
 	DBEntrySize = 8        // 8 bytes per entry
-)
 
-// GenParams generates Plinko PIR parameters (ChunkSize, SetSize)
-// Same logic as Plinko PIR util.GenParams
-func GenParams(dbSize uint64) (uint64, uint64) {
-	targetChunkSize := uint64(2 * math.Sqrt(float64(dbSize)))
-	chunkSize := uint64(1)
-	for chunkSize < targetChunkSize {
-		chunkSize *= 2
-	}
-	setSize := uint64(math.Ceil(float64(dbSize) / float64(chunkSize)))
-	// Round up to the next multiple of 4
-	setSize = (setSize + 3) / 4 * 4
-	return chunkSize, setSize
-}
+	InitialEpoch = 0 // Epoch of the first snapshot; later snapshots count up
+
+	WatchInterval = 2 * time.Second // HINT_MODE=watch polling interval
+
+	// LWE_HINT=true also writes lwe-hint.bin next to every hint.bin, for
+	// the server's SimplePIR/FrodoPIR-style query mode
+	LWEHintEnv = "LWE_HINT"
+
+	// MERKLE_ROOT=true also writes merkle-root.json next to every hint.bin,
+	// for the server's verified DPF query mode
+	MerkleRootEnv = "MERKLE_ROOT"
+)
 
 func main() {
 	log.Println("========================================")
@@ -40,8 +44,28 @@ func main() {
 	// Wait for database.bin to exist
 	waitForDatabase()
 
+	// HINT_MODE=watch: stay up and answer the update service's regeneration
+	// requests
+	if os.Getenv("HINT_MODE") == "watch" {
+		log.Printf("Watching for %s...\n", RequestPath)
+		for {
+			if _, err := os.Stat(RequestPath); err == nil {
+				regenerate()
+			}
+			time.Sleep(WatchInterval)
+		}
+	}
+
+	// A pending request replaces the initial snapshot: database.bin has
+	// already grown past DBSize
+	if _, err := os.Stat(RequestPath); err == nil {
+		regenerate()
+		generateTableHints()
+		return
+	}
+
 	// Calculate Piano parameters
-	chunkSize, setSize := GenParams(DBSize)
+	chunkSize, setSize := hintgen.GenParams(DBSize)
 	totalEntries := chunkSize * setSize
 
 	log.Printf("Plinko PIR Parameters:\n")
@@ -70,19 +94,97 @@ func main() {
 	// Generate hint.bin with Piano format
 	log.Println("Generating hint.bin...")
 	startGen := time.Now()
-	if err := generateHint(database, chunkSize, setSize); err != nil {
+	if err := hintgen.WriteHintFile(HintPath, database, DBSize, chunkSize, setSize, InitialEpoch); err != nil {
 		log.Fatalf("Failed to generate hint: %v", err)
 	}
 	log.Printf("Generated hint.bin in %v\n", time.Since(startGen))
 
 	// Verify output
 	verifyOutput()
+	writeLWEHint(HintPath)
+	writeMerkleRoot(HintPath)
+
+	generateTableHints()
 
 	log.Println()
 	log.Println("✅ Hint generation complete!")
 	log.Printf("Total time: %v\n", time.Since(startRead))
 }
 
+// regenerate answers regenerate.json with a re-parameterized hint.bin
+func regenerate() {
+	start := time.Now()
+	req, err := hintgen.Regenerate(RequestPath, DatabasePath, HintPath)
+	if err != nil {
+		log.Fatalf("Failed to regenerate hint.bin: %v", err)
+	}
+	chunkSize, setSize := hintgen.GrowParams(req.DBSize)
+	log.Printf("✅ Regenerated hint.bin for %d entries in %v (epoch %d, after block %d)\n",
+		req.DBSize, time.Since(start), req.Epoch, req.LastBlock)
+	log.Printf("  Chunk Size: %d, Set Size: %d (%d free slots)\n",
+		chunkSize, setSize, chunkSize*setSize-req.DBSize)
+	writeLWEHint(HintPath)
+	writeMerkleRoot(HintPath)
+}
+
+// Tables is every optional table db-generator can build: token-balances
+// (TOKENS) and state-proofs (STATE_PROOFS)
+var Tables = []string{"token-balances", "state-proofs"}
+
+// generateTableHints writes hint.bin for each table db-generator built that
+// has no hint yet
+func generateTableHints() {
+	for _, name := range Tables {
+		databasePath := filepath.Join(TablesDir, name, "database.bin")
+		hintPath := filepath.Join(TablesDir, name, "hint.bin")
+		if _, err := os.Stat(databasePath); err != nil {
+			continue
+		}
+		if _, err := os.Stat(hintPath); err == nil {
+			log.Printf("✓ %s hint.bin already exists\n", name)
+			continue
+		}
+		start := time.Now()
+		dbSize, chunkSize, setSize, err := hintgen.WriteTableHint(databasePath, hintPath)
+		if err != nil {
+			log.Fatalf("Failed to generate %s hint.bin: %v", name, err)
+		}
+		log.Printf("✅ %s hint.bin: %d entries in %v\n", name, dbSize, time.Since(start))
+		log.Printf("  Chunk Size: %d, Set Size: %d (%d free slots)\n", chunkSize, setSize, chunkSize*setSize-dbSize)
+		writeLWEHint(hintPath)
+		writeMerkleRoot(hintPath)
+	}
+}
+
+// writeLWEHint writes lwe-hint.bin next to hintPath if LWE_HINT is set
+func writeLWEHint(hintPath string) {
+	if os.Getenv(LWEHintEnv) != "true" {
+		return
+	}
+	lwePath := filepath.Join(filepath.Dir(hintPath), hintgen.LWEHintFile)
+	start := time.Now()
+	if err := hintgen.WriteLWEHintFile(lwePath, hintPath, rand.Reader); err != nil {
+		log.Fatalf("Failed to generate %s: %v", lwePath, err)
+	}
+	info, _ := os.Stat(lwePath)
+	log.Printf("✅ %s: %.1f MB in %v\n", lwePath, float64(info.Size())/1024/1024, time.Since(start))
+}
+
+// writeMerkleRoot writes merkle-root.json next to hintPath if MERKLE_ROOT
+// is set
+func writeMerkleRoot(hintPath string) {
+	if os.Getenv(MerkleRootEnv) != "true" {
+		return
+	}
+	rootPath := filepath.Join(filepath.Dir(hintPath), hintgen.MerkleRootFile)
+	start := time.Now()
+	root, err := hintgen.WriteMerkleRoot(rootPath, hintPath)
+	if err != nil {
+		log.Fatalf("Failed to generate %s: %v", rootPath, err)
+	}
+	log.Printf("✅ %s: epoch %d, depth %d, root %x in %v\n", rootPath, root.Epoch, root.Depth, root.Root, time.Since(start))
+}
+
 func waitForDatabase() {
 	log.Println("Waiting for database.bin...")
 	for i := 0; i < 60; i++ {
@@ -102,35 +204,6 @@ func readDatabase() ([]byte, error) {
 	return os.ReadFile(DatabasePath)
 }
 
-func generateHint(database []byte, chunkSize, setSize uint64) error {
-	f, err := os.Create(HintPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Write Plinko PIR metadata header (32 bytes)
-	// Format: [DBSize:8][ChunkSize:8][SetSize:8][Reserved:8]
-	header := make([]byte, 32)
-	binary.LittleEndian.PutUint64(header[0:8], DBSize)
-	binary.LittleEndian.PutUint64(header[8:16], chunkSize)
-	binary.LittleEndian.PutUint64(header[16:24], setSize)
-	binary.LittleEndian.PutUint64(header[24:32], 0) // Reserved
-
-	if _, err := f.Write(header); err != nil {
-		return err
-	}
-
-	// Write database in Piano chunked format
-	// The database is already in the correct format (sequential entries)
-	// Plinko PIR chunks it logically: chunk i = entries [i*chunkSize : (i+1)*chunkSize]
-	if _, err := f.Write(database); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func verifyOutput() {
 	info, err := os.Stat(HintPath)
 	if err != nil {
@@ -139,7 +212,7 @@ func verifyOutput() {
 	}
 
 	// Expected size: 32 bytes header + (chunkSize * setSize * 8 bytes)
-	chunkSize, setSize := GenParams(DBSize)
+	chunkSize, setSize := hintgen.GenParams(DBSize)
 	expectedSize := 32 + int64(chunkSize*setSize*DBEntrySize)
 
 	sizeMB := float64(info.Size()) / 1024 / 1024
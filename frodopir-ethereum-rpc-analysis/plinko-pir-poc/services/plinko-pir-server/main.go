@@ -1,104 +1,185 @@
 package main
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"piano-pir-server/pirserver"
 )
 
 const (
 	// Server configuration
 	ServerPort = "3000"
-	HintPath   = "/data/hint.bin"
-
-	// Database configuration
-	DBEntrySize   = 8
-	DBEntryLength = 1 // DBEntrySize / 8
+	DataDir    = "/data"
+
+	// Tables (TABLES: comma-separated name=dir, first served at the root).
+	// Unset serves /data as eth-balances plus every /data/tables/<name>/
+	// with a hint.bin.
+	TablesEnv         = "TABLES"
+	DeltaPollInterval = 2 * time.Second
+
+	// Sharding (SHARD=i/N serves chunk range i of N of every table; SHARDS,
+	// comma-separated shard URLs, runs a coordinator in front of them instead)
+	ShardEnv       = "SHARD"
+	ShardsEnv      = "SHARDS"
+	ShardWaitLimit = 120 * time.Second
+
+	// Replication (REPLICATION_ROLE: leader, follower or router). Followers
+	// tail LEADER_URL and report REPLICA_URL, their own address; a router
+	// forwards queries for LEADER_URL's table to replicas at the requested
+	// version. REPLICATION_TOKEN is shared by all three.
+	ReplicationRoleEnv  = "REPLICATION_ROLE"
+	LeaderURLEnv        = "LEADER_URL"
+	ReplicaURLEnv       = "REPLICA_URL"
+	ReplicationTokenEnv = "REPLICATION_TOKEN"
+
+	// Verified DPF queries (MERKLE_NODES: Merkle tree nodes kept in memory
+	// per table; the levels below are rebuilt for every verified query)
+	MerkleNodesEnv = "MERKLE_NODES"
+
+	// Logging configuration (LOG_LEVEL: quiet, info or debug)
+	LogLevelEnv = "LOG_LEVEL"
+
+	// Shutdown configuration
+	ShutdownDrainTimeout = 15 * time.Second // Max time to finish in-flight queries
 )
 
-type DBEntry [DBEntryLength]uint64
-
-type PlinkoPIRServer struct {
-	database  []uint64 // In-memory database
-	dbSize    uint64   // Number of database entries
-	chunkSize uint64   // Plinko PIR chunk size
-	setSize   uint64   // Plinko PIR set size
-}
-
-// Query request/response types
-type PlaintextQueryRequest struct {
-	Index uint64 `json:"index"`
-}
+func main() {
+	log.Println("========================================")
+	log.Println("Plinko PIR Server")
+	log.Println("========================================")
+	log.Println()
 
-type PlaintextQueryResponse struct {
-	Value           uint64 `json:"value"`
-	ServerTimeNanos uint64 `json:"server_time_nanos"`
-}
+	// Cancelled on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-type FullSetQueryRequest struct {
-	PRFKey []byte `json:"prf_key"` // 16-byte PRF key
-}
+	// Query log verbosity
+	logLevel, err := pirserver.ParseLogLevel(os.Getenv(LogLevelEnv))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", LogLevelEnv, err)
+	}
 
-type FullSetQueryResponse struct {
-	Value           uint64 `json:"value"`
-	ServerTimeNanos uint64 `json:"server_time_nanos"`
-}
+	// Request limits
+	limits, err := pirserver.LoadLimits()
+	if err != nil {
+		log.Fatalf("Invalid limit configuration: %v", err)
+	}
 
-type SetParityQueryRequest struct {
-	Indices []uint64 `json:"indices"` // Set of database indices
-}
+	// Coordinator mode: no database of its own
+	if urls := os.Getenv(ShardsEnv); urls != "" {
+		runCoordinator(ctx, strings.Split(urls, ","), logLevel, limits)
+		return
+	}
 
-type SetParityQueryResponse struct {
-	Parity          uint64 `json:"parity"`
-	ServerTimeNanos uint64 `json:"server_time_nanos"`
-}
+	role := os.Getenv(ReplicationRoleEnv)
+	switch role {
+	case "", "leader", "follower":
+	case "router":
+		runRouter(ctx, limits)
+		return
+	default:
+		log.Fatalf("Invalid %s %q: use leader, follower or router", ReplicationRoleEnv, role)
+	}
+	if role == "follower" && (os.Getenv(LeaderURLEnv) == "" || os.Getenv(ReplicaURLEnv) == "") {
+		log.Fatalf("Followers need %s and %s", LeaderURLEnv, ReplicaURLEnv)
+	}
 
-// CORS middleware to enable cross-origin requests from the browser
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-
-		// Handle preflight OPTIONS request
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+	// Tables to serve
+	configs, err := tableConfigs()
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", TablesEnv, err)
+	}
+	if v := os.Getenv(ShardEnv); v != "" {
+		shard, err := pirserver.ParseShard(v)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", ShardEnv, err)
+		}
+		for i := range configs {
+			configs[i].Shard = shard
+		}
+	}
+	if v := os.Getenv(MerkleNodesEnv); v != "" {
+		nodes, err := strconv.Atoi(v)
+		if err != nil || nodes < 1 {
+			log.Fatalf("Invalid %s %q: want a positive node count", MerkleNodesEnv, v)
+		}
+		for i := range configs {
+			configs[i].MerkleNodes = nodes
 		}
-
-		// Call the next handler
-		next(w, r)
 	}
-}
-
-func main() {
-	log.Println("========================================")
-	log.Println("Plinko PIR Server")
-	log.Println("========================================")
-	log.Println()
 
-	// Wait for hint.bin
-	waitForHint()
+	// Wait for the first table's hint.bin
+	waitForHint(filepath.Join(configs[0].Dir, pirserver.TableHintFile))
 
-	// Load database
+	// Load databases
 	log.Println("Loading database from hint.bin...")
-	server := loadServer()
+	server, err := pirserver.LoadTable(configs[0])
+	if err != nil {
+		log.Fatalf("Failed to load hint.bin: %v", err)
+	}
+	server.SetLogger(pirserver.NewPrivacyLogger(os.Stderr, logLevel))
+	server.SetLimits(limits)
 	log.Printf("✅ Database loaded: %d entries (%d MB)\n",
-		server.dbSize, server.dbSize*DBEntrySize/1024/1024)
-	log.Printf("   ChunkSize: %d, SetSize: %d\n", server.chunkSize, server.setSize)
+		server.DBSize(), server.DBSize()*pirserver.DBEntrySize/1024/1024)
+	log.Printf("   ChunkSize: %d, SetSize: %d, Epoch: %d\n", server.ChunkSize(), server.SetSize(), server.Epoch())
+	log.Printf("   Protocol version: %d (API %s)\n", pirserver.ProtocolVersion, pirserver.APIPrefix)
+	log.Printf("   Served at / and %s%s/\n", pirserver.TablesPrefix, configs[0].Name)
+	if server.LWEEnabled() {
+		log.Printf("   LWE queries: on (%s published)\n", pirserver.LWEHintFile)
+	}
+	if server.MerkleEnabled() {
+		log.Printf("   Verified DPF queries: on (%s matches)\n", pirserver.MerkleRootFile)
+	}
+	if shard := server.Shard(); shard.Count > 0 {
+		first, end := shard.Chunks(server.SetSize())
+		log.Printf("   Shard %s: chunks [%d, %d)\n", shard, first, end)
+	}
 	log.Println()
 
-	// Setup HTTP handlers with CORS middleware
-	http.HandleFunc("/health", corsMiddleware(server.healthHandler))
-	http.HandleFunc("/query/plaintext", corsMiddleware(server.plaintextQueryHandler))
-	http.HandleFunc("/query/fullset", corsMiddleware(server.fullSetQueryHandler))
-	http.HandleFunc("/query/setparity", corsMiddleware(server.setParityQueryHandler))
+	tables := []*pirserver.PlinkoPIRServer{server}
+	for _, cfg := range configs[1:] {
+		table, err := pirserver.LoadTable(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load table %s: %v", cfg.Name, err)
+		}
+		if err := server.MountTable(cfg.Name, table); err != nil {
+			log.Fatalf("Failed to mount table %s: %v", cfg.Name, err)
+		}
+		tables = append(tables, table)
+		log.Printf("✅ Table %s loaded: %d entries (epoch %d), served under %s%s/\n",
+			cfg.Name, table.DBSize(), table.Epoch(), pirserver.TablesPrefix, cfg.Name)
+	}
+	log.Println()
+
+	// Each table applies its own deltas/ as plinko-update-service publishes
+	// them, or as its leader has applied them
+	for _, table := range tables {
+		switch role {
+		case "leader":
+			if err := table.EnableReplication(os.Getenv(ReplicationTokenEnv)); err != nil {
+				log.Fatalf("Failed to enable replication of table %s: %v", table.Name(), err)
+			}
+			go followDeltas(ctx, table)
+		case "follower":
+			go followLeader(ctx, table)
+		default:
+			go followDeltas(ctx, table)
+		}
+	}
+	if role != "" {
+		log.Printf("Replication role: %s\n", role)
+	}
 
 	// Start server
 	addr := ":" + ServerPort
@@ -107,243 +188,173 @@ func main() {
 	log.Println()
 	log.Println("Privacy Mode: ENABLED")
 	log.Println("⚠️  Server will NEVER log queried addresses")
+	log.Printf("Query log level: %s\n", logLevel)
+	log.Printf("Limits: body %d bytes, %d indices/query, %d queries/batch, %.0f q/s per IP (burst %.0f), %.0f q/s global (burst %.0f), DPF/LWE cost %.0f\n",
+		limits.MaxBodyBytes, server.MaxIndices(), limits.MaxBatch,
+		limits.PerIPRate, limits.PerIPBurst, limits.GlobalRate, limits.GlobalBurst, limits.ScanCost)
 	log.Println()
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	// CORS and protocol version middleware are applied by Handler
+	httpServer := &http.Server{Addr: addr, Handler: server.Handler()}
+	limits.ApplyTimeouts(httpServer)
+	if err := serveUntilDone(ctx, httpServer); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
+	log.Println("✅ Server stopped")
 }
 
-func waitForHint() {
-	log.Println("Waiting for hint.bin...")
-	for i := 0; i < 120; i++ {
-		if _, err := os.Stat(HintPath); err == nil {
-			log.Println("✅ hint.bin found")
-			return
-		}
-		if i%10 == 0 && i > 0 {
-			log.Printf("  Still waiting... (%d/120s)\n", i)
-		}
-		time.Sleep(1 * time.Second)
-	}
-	log.Fatal("Timeout waiting for hint.bin")
-}
-
-func loadServer() *PlinkoPIRServer {
-	data, err := os.ReadFile(HintPath)
-	if err != nil {
-		log.Fatalf("Failed to read hint.bin: %v", err)
+// serveUntilDone runs srv until ctx is cancelled, then drains in-flight
+// requests for up to ShutdownDrainTimeout
+func serveUntilDone(ctx context.Context, srv *http.Server) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
 	}
 
-	// Read metadata header
-	if len(data) < 32 {
-		log.Fatal("Invalid hint.bin: too small for header")
-	}
-
-	dbSize := binary.LittleEndian.Uint64(data[0:8])
-	chunkSize := binary.LittleEndian.Uint64(data[8:16])
-	setSize := binary.LittleEndian.Uint64(data[16:24])
-
-	// Extract database (skip 32-byte header)
-	dbBytes := data[32:]
-	dbEntries := len(dbBytes) / DBEntrySize
+	log.Printf("Shutdown requested, draining in-flight queries (up to %v)...\n", ShutdownDrainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownDrainTimeout)
+	defer cancel()
 
-	database := make([]uint64, dbEntries)
-	for i := 0; i < dbEntries; i++ {
-		database[i] = binary.LittleEndian.Uint64(dbBytes[i*DBEntrySize : (i+1)*DBEntrySize])
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
 	}
-
-	return &PlinkoPIRServer{
-		database:  database,
-		dbSize:    dbSize,
-		chunkSize: chunkSize,
-		setSize:   setSize,
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
 }
 
-// DBAccess safely accesses database entry by index
-func (s *PlinkoPIRServer) DBAccess(id uint64) DBEntry {
-	if id < uint64(len(s.database)/DBEntryLength) {
-		startIdx := id * DBEntryLength
-		return DBEntry{s.database[startIdx]}
+// runCoordinator serves the table held by the shards at urls, merging
+// their answers, until ctx is cancelled
+func runCoordinator(ctx context.Context, urls []string, logLevel pirserver.LogLevel, limits pirserver.Limits) {
+	log.Printf("Coordinating %d shards...\n", len(urls))
+	client := &http.Client{Timeout: limits.WriteTimeout}
+	var coord *pirserver.Coordinator
+	var err error
+	for start := time.Now(); ; time.Sleep(time.Second) {
+		if coord, err = pirserver.NewCoordinator(ctx, urls, client); err == nil {
+			break
+		}
+		if time.Since(start) > ShardWaitLimit || ctx.Err() != nil {
+			log.Fatalf("Shards not ready: %v", err)
+		}
 	}
-	// Return zero for out-of-bounds
-	return DBEntry{0}
-}
-
-// healthHandler returns server health status
-func (s *PlinkoPIRServer) healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":     "healthy",
-		"service":    "plinko-pir-server",
-		"db_size":    s.dbSize,
-		"chunk_size": s.chunkSize,
-		"set_size":   s.setSize,
-	})
-}
-
-// plaintextQueryHandler handles direct database lookups (for testing)
-// ⚠️  Privacy: Does NOT log the queried index
-func (s *PlinkoPIRServer) plaintextQueryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	coord.SetLogger(pirserver.NewPrivacyLogger(os.Stderr, logLevel))
+	coord.SetLimits(limits)
+	log.Printf("✅ %d shards at epoch %d: %d entries, ChunkSize: %d, SetSize: %d\n",
+		len(urls), coord.Epoch(), coord.DBSize(), coord.ChunkSize(), coord.SetSize())
+	for i, u := range coord.Shards() {
+		log.Printf("   Shard %d: %s\n", i, u)
 	}
 
-	var req PlaintextQueryRequest
-
-	if r.Method == http.MethodPost {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
-			return
-		}
-	} else {
-		// GET request: parse index from query parameter
-		indexStr := r.URL.Query().Get("index")
-		if indexStr == "" {
-			http.Error(w, "Missing index parameter", http.StatusBadRequest)
-			return
-		}
-		index, err := strconv.ParseUint(indexStr, 10, 64)
-		if err != nil {
-			http.Error(w, "Invalid index", http.StatusBadRequest)
-			return
+	addr := ":" + ServerPort
+	log.Printf("🚀 Plinko PIR Coordinator listening on %s\n", addr)
+	log.Printf("Query log level: %s\n", logLevel)
+	log.Println()
+	httpServer := &http.Server{Addr: addr, Handler: coord.Handler()}
+	limits.ApplyTimeouts(httpServer)
+
+	// Shards restarted on a new epoch: stop, so a restart reads the new
+	// parameters, rather than answer epoch_mismatch indefinitely
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-coord.EpochChanged():
+			log.Printf("⚠️  Shards moved past epoch %d; stopping\n", coord.Epoch())
+			cancel()
+		case <-serveCtx.Done():
 		}
-		req.Index = index
+	}()
+	if err := serveUntilDone(serveCtx, httpServer); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
-
-	// Execute query
-	startTime := time.Now()
-	entry := s.DBAccess(req.Index)
-	elapsed := time.Since(startTime)
-
-	// ⚠️  PRIVACY: Never log the queried index!
-	// log.Printf("Query completed in %v\n", elapsed) // OK - no index
-	// log.Printf("Query for index %d\n", req.Index) // NEVER DO THIS!
-
-	// Return response
-	resp := PlaintextQueryResponse{
-		Value:           entry[0],
-		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	select {
+	case <-coord.EpochChanged():
+		log.Fatalf("Coordinator stopped: %v", pirserver.ErrEpochChanged)
+	default:
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	log.Println("✅ Coordinator stopped")
 }
 
-// fullSetQueryHandler handles Plinko PIR FullSet queries
-// ⚠️  Privacy: Query is information-theoretically private
-func (s *PlinkoPIRServer) fullSetQueryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req FullSetQueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+// runRouter forwards queries for LEADER_URL's table to its replicas until
+// ctx is cancelled
+func runRouter(ctx context.Context, limits pirserver.Limits) {
+	leader := strings.TrimSuffix(os.Getenv(LeaderURLEnv), "/")
+	if leader == "" {
+		log.Fatalf("Routers need %s", LeaderURLEnv)
 	}
+	client := &http.Client{Timeout: limits.WriteTimeout}
+	router := pirserver.NewRouter(leader, os.Getenv(ReplicationTokenEnv), client)
+	router.MaxBody = limits.MaxBodyBytes
+	go router.Run(ctx, DeltaPollInterval, func(err error) {
+		log.Printf("⚠️  Replication status unavailable: %v\n", err)
+	})
 
-	// Validate PRF key
-	if len(req.PRFKey) != 16 {
-		http.Error(w, "PRF key must be 16 bytes", http.StatusBadRequest)
-		return
-	}
-
-	// Log what server sees (privacy-preserving)
-	log.Println("========================================")
-	log.Println("🔒 PRIVATE QUERY RECEIVED")
-	log.Println("========================================")
-	log.Printf("Server sees: PRF Key (16 bytes): %x\n", req.PRFKey[:8]) // Show first 8 bytes
-	log.Println("Server CANNOT determine:")
-	log.Println("  ❌ Which address is being queried")
-	log.Println("  ❌ Which balance is being requested")
-	log.Println("  ❌ Any user information")
-	log.Println("Server will compute parity over ~1024 database entries...")
-	log.Println("========================================")
-
-	// Execute Plinko PIR FullSet query
-	startTime := time.Now()
-	parity := s.HandleFullSetQuery(req.PRFKey)
-	elapsed := time.Since(startTime)
-
-	// Log query completion without revealing content
-	log.Printf("✅ FullSet query completed in %v\n", elapsed)
-	log.Printf("Server response: Parity value (uint64): %d\n", parity[0])
-	log.Println("Server remains oblivious to queried address!")
-	log.Println("========================================")
+	addr := ":" + ServerPort
+	log.Printf("🚀 Plinko PIR Router listening on %s, leader %s\n", addr, leader)
 	log.Println()
-
-	resp := FullSetQueryResponse{
-		Value:           parity[0],
-		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	httpServer := &http.Server{Addr: addr, Handler: router.Handler()}
+	limits.ApplyTimeouts(httpServer)
+	if err := serveUntilDone(ctx, httpServer); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	log.Println("✅ Router stopped")
 }
 
-// HandleFullSetQuery implements Plinko PIR FullSet query
-func (s *PlinkoPIRServer) HandleFullSetQuery(prfKeyBytes []byte) DBEntry {
-	// Convert PRF key
-	var prfKey PrfKey128
-	copy(prfKey[:], prfKeyBytes)
-
-	// Expand PRF key to set of indices
-	prSet := NewPRSet(prfKey)
-	expandedSet := prSet.Expand(s.setSize, s.chunkSize)
-
-	// Compute XOR parity over the set
-	var parity DBEntry
-	for _, id := range expandedSet {
-		entry := s.DBAccess(id)
-		parity[0] ^= entry[0]
+// tableConfigs reads TABLES, or discovers the tables under DataDir
+func tableConfigs() ([]pirserver.TableConfig, error) {
+	if v := os.Getenv(TablesEnv); v != "" {
+		return pirserver.ParseTableConfig(v)
 	}
-
-	return parity
+	return pirserver.DiscoverTables(DataDir)
 }
 
-// setParityQueryHandler handles SetParity queries (simplified Plinko PIR)
-// ⚠️  Privacy: Does not log which indices were queried
-func (s *PlinkoPIRServer) setParityQueryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// followDeltas keeps table current until ctx is cancelled. A table whose
+// hint.bin moved to a new epoch stops at the old epoch's last block and
+// keeps answering from it until the server is restarted.
+func followDeltas(ctx context.Context, table *pirserver.PlinkoPIRServer) {
+	err := table.FollowDeltas(ctx, DeltaPollInterval)
+	if err != nil {
+		log.Printf("⚠️  Table %s stopped following deltas at block %d: %v\n", table.Name(), table.LastBlock(), err)
 	}
+}
 
-	var req SetParityQueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+// followLeader keeps table at its leader's height until ctx is cancelled or
+// the leader moves to a new epoch
+func followLeader(ctx context.Context, table *pirserver.PlinkoPIRServer) {
+	path := pirserver.TablesPrefix + table.Name()
+	f := pirserver.Follower{
+		Leader:  strings.TrimSuffix(os.Getenv(LeaderURLEnv), "/") + path,
+		Replica: strings.TrimSuffix(os.Getenv(ReplicaURLEnv), "/") + path,
+		Token:   os.Getenv(ReplicationTokenEnv),
+		Client:  &http.Client{Timeout: 30 * time.Second},
 	}
-
-	// Execute query
-	startTime := time.Now()
-	parity := s.HandleSetParityQuery(req.Indices)
-	elapsed := time.Since(startTime)
-
-	// Log query completion (count only, never the indices!)
-	log.Printf("SetParity query (%d indices) completed in %v\n",
-		len(req.Indices), elapsed)
-
-	resp := SetParityQueryResponse{
-		Parity:          parity[0],
-		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	err := table.FollowLeader(ctx, f, DeltaPollInterval, func(err error) {
+		log.Printf("⚠️  Table %s: %v\n", table.Name(), err)
+	})
+	if err != nil {
+		log.Printf("⚠️  Table %s stopped following %s at block %d: %v\n", table.Name(), f.Leader, table.LastBlock(), err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
 }
 
-// HandleSetParityQuery computes XOR parity over a set of indices
-func (s *PlinkoPIRServer) HandleSetParityQuery(indices []uint64) DBEntry {
-	var parity DBEntry
-	for _, index := range indices {
-		entry := s.DBAccess(index)
-		parity[0] ^= entry[0]
+func waitForHint(path string) {
+	log.Println("Waiting for hint.bin...")
+	for i := 0; i < 120; i++ {
+		if _, err := os.Stat(path); err == nil {
+			log.Println("✅ hint.bin found")
+			return
+		}
+		if i%10 == 0 && i > 0 {
+			log.Printf("  Still waiting... (%d/120s)\n", i)
+		}
+		time.Sleep(1 * time.Second)
 	}
-	return parity
+	log.Fatal("Timeout waiting for hint.bin")
 }
@@ -0,0 +1,125 @@
+package pirserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// API versioning
+//
+// ProtocolVersion changes whenever a client built for the previous version
+// would compute wrong answers (set expansion, entry layout, hint format).
+// Every response carries it in X-Plinko-Protocol-Version together with the
+// database epoch, and clients may send the header to have the server refuse
+// queries it cannot answer compatibly. Answers to the XOR queries also
+// carry X-Plinko-Block, the last delta block they reflect.
+
+const (
+	APIPrefix       = "/v1"
+	ProtocolVersion = 1
+
+	ProtocolVersionHeader = "X-Plinko-Protocol-Version"
+	EpochHeader           = "X-Plinko-Epoch"
+	BlockHeader           = "X-Plinko-Block"
+)
+
+// ParamsResponse describes everything a client needs to build compatible queries
+type ParamsResponse struct {
+	ProtocolVersion int         `json:"protocol_version"`
+	APIVersion      string      `json:"api_version"`
+	Epoch           uint64      `json:"epoch"`
+	DBSize          uint64      `json:"db_size"`
+	PaddedSize      uint64      `json:"padded_size"`
+	ChunkSize       uint64      `json:"chunk_size"`
+	SetSize         uint64      `json:"set_size"`
+	EntrySize       int         `json:"entry_size"`
+	QueryTypes      []QueryKind `json:"query_types"`
+	LastBlock       uint64      `json:"last_block"`       // Last delta block applied
+	Tables          []string    `json:"tables,omitempty"` // Served under /tables/<name>/
+	Shard           *ShardInfo  `json:"shard,omitempty"`  // Set when serving one shard of the table
+}
+
+// paramsHandler returns the protocol version and database parameters
+func (s *PlinkoPIRServer) paramsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: "Method not allowed; use GET",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ParamsResponse{
+		ProtocolVersion: ProtocolVersion,
+		APIVersion:      APIPrefix[1:],
+		Epoch:           s.epoch,
+		DBSize:          s.dbSize,
+		PaddedSize:      s.paddedSize(),
+		ChunkSize:       s.chunkSize,
+		SetSize:         s.setSize,
+		EntrySize:       DBEntrySize,
+		QueryTypes:      s.queryTypes(),
+		LastBlock:       s.LastBlock(),
+		Tables:          s.Tables(),
+		Shard:           s.shardInfo(),
+	})
+}
+
+// queryTypes lists the query kinds this table answers
+func (s *PlinkoPIRServer) queryTypes() []QueryKind {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kinds := make([]QueryKind, 0, len(queryKinds))
+	for _, kind := range queryKinds {
+		switch {
+		case kind == QueryKindLWE && s.lwe == nil:
+		case kind == QueryKindDPFVerified && s.merkle == nil:
+		default:
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// versioned stamps responses with the protocol version and epoch, and
+// rejects requests from clients that speak a different protocol version
+func (s *PlinkoPIRServer) versioned(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ProtocolVersionHeader, strconv.Itoa(ProtocolVersion))
+		w.Header().Set(EpochHeader, strconv.FormatUint(s.epoch, 10))
+
+		if v := r.Header.Get(ProtocolVersionHeader); v != "" && v != strconv.Itoa(ProtocolVersion) {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Code:    ErrCodeUnsupportedProtocol,
+				Message: "Server speaks protocol version " + strconv.Itoa(ProtocolVersion) + "; refresh parameters from " + APIPrefix + "/params",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// stampBlock records the last delta block an answer reflects
+func stampBlock(w http.ResponseWriter, block uint64) {
+	w.Header().Set(BlockHeader, strconv.FormatUint(block, 10))
+}
+
+// deprecatedAlias serves an unversioned route and points clients at its successor
+func deprecatedAlias(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+// notFoundHandler answers unknown paths with the JSON error envelope
+func (s *PlinkoPIRServer) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, APIError{
+		Code:    ErrCodeNotFound,
+		Message: "Unknown endpoint; see " + APIPrefix + "/params",
+	})
+}
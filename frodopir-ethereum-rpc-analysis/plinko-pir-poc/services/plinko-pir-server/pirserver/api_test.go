@@ -0,0 +1,104 @@
+package pirserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestMux returns the handler main serves
+func newTestMux(s *PlinkoPIRServer) http.Handler {
+	return s.Handler()
+}
+
+func TestParamsEndpoint(t *testing.T) {
+	s := newTestServer(&bytes.Buffer{})
+	s.epoch = 42
+	rec := httptest.NewRecorder()
+	newTestMux(s).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/params", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var params ParamsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &params); err != nil {
+		t.Fatal(err)
+	}
+	if params.ProtocolVersion != ProtocolVersion || params.Epoch != 42 ||
+		params.ChunkSize != testChunkSize || params.SetSize != testSetSize || params.EntrySize != DBEntrySize {
+		t.Errorf("params = %+v", params)
+	}
+	if got := rec.Header().Get(ProtocolVersionHeader); got != strconv.Itoa(ProtocolVersion) {
+		t.Errorf("%s = %q", ProtocolVersionHeader, got)
+	}
+	if got := rec.Header().Get(EpochHeader); got != "42" {
+		t.Errorf("%s = %q, want 42", EpochHeader, got)
+	}
+}
+
+func TestDeprecatedAliasesMatchV1(t *testing.T) {
+	mux := newTestMux(newTestServer(&bytes.Buffer{}))
+	body := fmt.Sprintf(`{"prf_key": %s}`, byteArrayJSON(testPRFKey))
+
+	query := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+	legacy, v1 := query("/query/fullset"), query("/v1/query/fullset")
+
+	var a, b FullSetQueryResponse
+	json.Unmarshal(legacy.Body.Bytes(), &a)
+	json.Unmarshal(v1.Body.Bytes(), &b)
+	if legacy.Code != http.StatusOK || v1.Code != http.StatusOK || a.Value != b.Value {
+		t.Fatalf("legacy (%d, %d) and v1 (%d, %d) answers differ", legacy.Code, a.Value, v1.Code, b.Value)
+	}
+
+	if legacy.Header().Get("Deprecation") != "true" ||
+		!strings.Contains(legacy.Header().Get("Link"), "/v1/query/fullset") {
+		t.Errorf("legacy route missing deprecation headers: %v", legacy.Header())
+	}
+	if v1.Header().Get("Deprecation") != "" {
+		t.Error("v1 route marked deprecated")
+	}
+}
+
+func TestErrorsUseJSONEnvelope(t *testing.T) {
+	mux := newTestMux(newTestServer(&bytes.Buffer{}))
+
+	tests := []struct {
+		method, target, body string
+		header               string
+		wantStatus           int
+		wantCode             string
+	}{
+		{http.MethodGet, "/v1/query/fullset", "", "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{http.MethodPost, "/v1/query/fullset", `{"prf_key": [1,2]}`, "", http.StatusBadRequest, ErrCodeInvalidPRFKey},
+		{http.MethodPost, "/v1/query/setparity", `not json`, "", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{http.MethodGet, "/v1/query/plaintext", "", "", http.StatusBadRequest, ErrCodeMissingParameter},
+		{http.MethodGet, "/v1/query/plaintext?index=-1", "", "", http.StatusBadRequest, ErrCodeInvalidIndex},
+		{http.MethodGet, "/v2/query/fullset", "", "", http.StatusNotFound, ErrCodeNotFound},
+		{http.MethodGet, "/v1/params", "", "99", http.StatusBadRequest, ErrCodeUnsupportedProtocol},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.header != "" {
+			req.Header.Set(ProtocolVersionHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, rec.Code, tt.wantStatus)
+		}
+		if got := decodeAPIError(t, rec); got.Code != tt.wantCode || got.Message == "" {
+			t.Errorf("%s %s: error = %+v, want code %s", tt.method, tt.target, got, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,620 @@
+package pirserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coordinator
+//
+// A Coordinator serves one table from the shard servers that hold it
+// (shard.go). It validates each query as a server would, sends it to every
+// shard and XORs their partial answers: set parities and DPF shares
+// directly, punctured parity lists element by element. All shards must
+// answer from the coordinator's epoch and from the same delta block (the
+// X-Plinko-Block header), or the merged answer would mix two databases.
+// When a block lands on some shards before others the query is sent again,
+// and after shardAttempts the client gets a retryable shards_diverged
+// error. Once every shard answers from a new epoch (they were restarted on
+// a regenerated hint.bin) the coordinator's parameters are stale: queries
+// get epoch_mismatch and EpochChanged is closed, so the coordinator can be
+// restarted to read the new ones. LWE queries need the whole matrix and are
+// not served.
+//
+// Shards see every query the coordinator forwards, so they learn no more
+// than an unsharded server would. All their traffic comes from the
+// coordinator, so shards should run without per-IP rate limits.
+
+const (
+	shardAttempts   = 3
+	shardRetryDelay = 50 * time.Millisecond
+)
+
+// coordinatorKinds lists the query kinds a Coordinator answers
+var coordinatorKinds = []QueryKind{QueryKindPlaintext, QueryKindFullSet, QueryKindSetParity, QueryKindPunctured, QueryKindBatch, QueryKindDPF}
+
+// Coordinator merges the answers of a table's shards
+type Coordinator struct {
+	front  *PlinkoPIRServer // Parameters, limits, logger and metrics; holds no entries
+	shards []string         // Shard base URLs, by shard index
+	client *http.Client
+	padded uint64
+
+	movedOnce sync.Once
+	moved     chan struct{} // Closed once the shards serve another epoch
+}
+
+// NewCoordinator reads the parameters of every shard in urls and checks
+// that together they hold one table: one epoch, one set of parameters, and
+// each shard of the split exactly once. A nil client uses
+// http.DefaultClient.
+func NewCoordinator(ctx context.Context, urls []string, client *http.Client) (*Coordinator, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no shards configured")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := &Coordinator{shards: make([]string, len(urls)), client: client, moved: make(chan struct{})}
+
+	var first *ParamsResponse
+	for _, u := range urls {
+		u = strings.TrimRight(u, "/")
+		p, err := c.shardParams(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.ProtocolVersion != ProtocolVersion:
+			return nil, fmt.Errorf("shard %s speaks protocol version %d, want %d", u, p.ProtocolVersion, ProtocolVersion)
+		case p.Shard == nil || p.Shard.Count != uint64(len(urls)) || p.Shard.Index >= p.Shard.Count:
+			return nil, fmt.Errorf("shard %s does not serve one of %d shards", u, len(urls))
+		case c.shards[p.Shard.Index] != "":
+			return nil, fmt.Errorf("shards %s and %s both serve shard %d", c.shards[p.Shard.Index], u, p.Shard.Index)
+		}
+		if first == nil {
+			first = p
+		} else if p.Epoch != first.Epoch || p.DBSize != first.DBSize || p.PaddedSize != first.PaddedSize ||
+			p.ChunkSize != first.ChunkSize || p.SetSize != first.SetSize {
+			return nil, fmt.Errorf("shard %s serves epoch %d with %d entries (%d x %d), others epoch %d with %d entries (%d x %d)",
+				u, p.Epoch, p.DBSize, p.ChunkSize, p.SetSize, first.Epoch, first.DBSize, first.ChunkSize, first.SetSize)
+		}
+		c.shards[p.Shard.Index] = u
+	}
+
+	c.front = NewPlinkoPIRServer(nil, first.DBSize, first.ChunkSize, first.SetSize, first.Epoch)
+	c.front.metrics = NewServerMetrics(first.PaddedSize, first.ChunkSize, first.SetSize)
+	c.padded = first.PaddedSize
+	return c, nil
+}
+
+// shardParams fetches one shard's /v1/params
+func (c *Coordinator) shardParams(ctx context.Context, base string) (*ParamsResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+APIPrefix+"/params", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("shard %s: %w", base, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("shard %s: params returned %s", base, resp.Status)
+	}
+	var p ParamsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("shard %s: params: %w", base, err)
+	}
+	return &p, nil
+}
+
+// SetLogger replaces the query logger
+func (c *Coordinator) SetLogger(l *PrivacyLogger) { c.front.SetLogger(l) }
+
+// SetLimits replaces the request limits and resets the rate limiter
+func (c *Coordinator) SetLimits(limits Limits) { c.front.SetLimits(limits) }
+
+// Table parameters, as served by the shards
+func (c *Coordinator) DBSize() uint64    { return c.front.dbSize }
+func (c *Coordinator) ChunkSize() uint64 { return c.front.chunkSize }
+func (c *Coordinator) SetSize() uint64   { return c.front.setSize }
+func (c *Coordinator) Epoch() uint64     { return c.front.epoch }
+
+// EpochChanged is closed once every shard answers from another epoch than
+// the one the coordinator was started on. From then on every query gets
+// epoch_mismatch; restart the coordinator to serve the new epoch.
+func (c *Coordinator) EpochChanged() <-chan struct{} {
+	return c.moved
+}
+
+// Shards returns the shard base URLs by shard index
+func (c *Coordinator) Shards() []string {
+	return append([]string(nil), c.shards...)
+}
+
+// Handler returns every coordinator route with CORS and protocol version
+// middleware
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range c.routes() {
+		mux.HandleFunc(rt.path, corsMiddleware(c.front.versioned(rt.handler)))
+	}
+	return mux
+}
+
+// routes lists the coordinator's endpoints: the /v1/ API of a single
+// table, without LWE queries or the deprecated aliases
+func (c *Coordinator) routes() []route {
+	f := c.front
+	return []route{
+		{APIPrefix + "/health", c.healthHandler},
+		{APIPrefix + "/params", c.paramsHandler},
+		{APIPrefix + "/query/plaintext", f.queryRoute(QueryKindPlaintext, c.plaintextHandler)},
+		{APIPrefix + "/query/fullset", f.queryRoute(QueryKindFullSet, c.fullSetHandler)},
+		{APIPrefix + "/query/setparity", f.queryRoute(QueryKindSetParity, c.setParityHandler)},
+		{APIPrefix + "/query/punctured", f.queryRoute(QueryKindPunctured, c.puncturedHandler)},
+		{APIPrefix + "/query/punctured/batch", f.queryRoute(QueryKindBatch, c.batchHandler)},
+		{APIPrefix + "/query/dpf", f.queryRoute(QueryKindDPF, c.dpfHandler)},
+
+		{"/health", c.healthHandler},
+		{"/metrics", f.metricsHandler},
+		{"/", f.notFoundHandler},
+	}
+}
+
+// healthHandler reports the coordinator's own health
+func (c *Coordinator) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "healthy",
+		"service":          "plinko-pir-coordinator",
+		"protocol_version": ProtocolVersion,
+		"epoch":            c.front.epoch,
+		"db_size":          c.front.dbSize,
+		"chunk_size":       c.front.chunkSize,
+		"set_size":         c.front.setSize,
+		"shards":           len(c.shards),
+	})
+}
+
+// paramsHandler returns the table's parameters. LastBlock is the last
+// block every shard has applied.
+func (c *Coordinator) paramsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: "Method not allowed; use GET",
+		})
+		return
+	}
+
+	var lastBlock uint64
+	for i, u := range c.shards {
+		p, err := c.shardParams(r.Context(), u)
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, APIError{
+				Code:      ErrCodeShardUnavailable,
+				Message:   "A shard did not answer",
+				Retryable: true,
+			})
+			return
+		}
+		if i == 0 || p.LastBlock < lastBlock {
+			lastBlock = p.LastBlock
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ParamsResponse{
+		ProtocolVersion: ProtocolVersion,
+		APIVersion:      APIPrefix[1:],
+		Epoch:           c.front.epoch,
+		DBSize:          c.front.dbSize,
+		PaddedSize:      c.padded,
+		ChunkSize:       c.front.chunkSize,
+		SetSize:         c.front.setSize,
+		EntrySize:       DBEntrySize,
+		QueryTypes:      coordinatorKinds,
+		LastBlock:       lastBlock,
+	})
+}
+
+// shardReply is one shard's response to a forwarded query
+type shardReply struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// fanOut sends req to path on every shard. Once all shards have answered
+// from the coordinator's epoch and one block, it passes each answer to
+// merge and returns the block. Otherwise it sends the error response and
+// returns false.
+func (c *Coordinator) fanOut(w http.ResponseWriter, r *http.Request, kind QueryKind, path string, req interface{}, merge func(body []byte) error) (uint64, bool) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		panic(err) // Request types always marshal
+	}
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.moved:
+			c.rejectMoved(w, kind)
+			return 0, false
+		default:
+		}
+
+		replies := c.callShards(r.Context(), path, body)
+		for _, rep := range replies {
+			if rep.err != nil {
+				c.front.rejectAPI(w, kind, http.StatusBadGateway, APIError{
+					Code:      ErrCodeShardUnavailable,
+					Message:   "A shard did not answer",
+					Retryable: true,
+				})
+				return 0, false
+			}
+		}
+		for _, rep := range replies {
+			if rep.status != http.StatusOK {
+				// The query passed the coordinator's checks, so this is a
+				// shard-side failure; pass its error envelope on
+				c.front.log.Query(QueryEvent{Kind: kind, Status: rep.status})
+				if after := rep.header.Get("Retry-After"); after != "" {
+					w.Header().Set("Retry-After", after)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(rep.status)
+				w.Write(rep.body)
+				return 0, false
+			}
+		}
+
+		epoch, block, ok := pinned(replies)
+		if ok && epoch != c.front.epoch {
+			c.movedOnce.Do(func() { close(c.moved) })
+			c.rejectMoved(w, kind)
+			return 0, false
+		}
+		if ok {
+			for _, rep := range replies {
+				if err := merge(rep.body); err != nil {
+					c.front.rejectAPI(w, kind, http.StatusBadGateway, APIError{
+						Code:      ErrCodeShardUnavailable,
+						Message:   "A shard returned a malformed answer",
+						Retryable: true,
+					})
+					return 0, false
+				}
+			}
+			return block, true
+		}
+
+		if attempt == shardAttempts {
+			c.front.rejectAPI(w, kind, http.StatusServiceUnavailable, APIError{
+				Code:      ErrCodeShardsDiverged,
+				Message:   "Shards are not at the same database version; retry shortly",
+				Retryable: true,
+			})
+			return 0, false
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(shardRetryDelay):
+		}
+	}
+}
+
+// callShards POSTs body to path on every shard concurrently
+func (c *Coordinator) callShards(ctx context.Context, path string, body []byte) []shardReply {
+	replies := make([]shardReply, len(c.shards))
+	var wg sync.WaitGroup
+	for i, u := range c.shards {
+		wg.Add(1)
+		go func(rep *shardReply, url string) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+path, bytes.NewReader(body))
+			if err != nil {
+				rep.err = err
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := c.client.Do(req)
+			if err != nil {
+				rep.err = err
+				return
+			}
+			defer resp.Body.Close()
+			rep.status, rep.header = resp.StatusCode, resp.Header
+			rep.body, rep.err = io.ReadAll(resp.Body)
+		}(&replies[i], u)
+	}
+	wg.Wait()
+	return replies
+}
+
+// pinned returns the epoch and block every reply was answered at, if all
+// replies carry the same ones
+func pinned(replies []shardReply) (epoch, block uint64, ok bool) {
+	for i, rep := range replies {
+		e, err1 := strconv.ParseUint(rep.header.Get(EpochHeader), 10, 64)
+		b, err2 := strconv.ParseUint(rep.header.Get(BlockHeader), 10, 64)
+		if err1 != nil || err2 != nil || (i > 0 && (e != epoch || b != block)) {
+			return 0, 0, false
+		}
+		epoch, block = e, b
+	}
+	return epoch, block, true
+}
+
+// rejectMoved answers a query after the shards moved to a new epoch
+func (c *Coordinator) rejectMoved(w http.ResponseWriter, kind QueryKind) {
+	c.front.rejectAPI(w, kind, http.StatusConflict, APIError{
+		Code:    ErrCodeEpochMismatch,
+		Message: "Shards moved to a new epoch; rebuild hints from the new hint.bin",
+	})
+}
+
+// reply logs a merged answer and sends it with its block
+func (c *Coordinator) reply(w http.ResponseWriter, kind QueryKind, entries int, elapsed time.Duration, block uint64, resp interface{}) {
+	c.front.log.Query(QueryEvent{
+		Kind:    kind,
+		Entries: entries,
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// xorInto XORs src into dst element by element
+func xorInto(dst, src []uint64) error {
+	if len(src) != len(dst) {
+		return fmt.Errorf("%d parities, want %d", len(src), len(dst))
+	}
+	for i, v := range src {
+		dst[i] ^= v
+	}
+	return nil
+}
+
+// plaintextHandler merges plaintext lookups: only the owning shard's
+// answer is nonzero
+// ⚠️  Privacy: Does NOT log the queried index
+func (c *Coordinator) plaintextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		c.front.methodNotAllowed(w, QueryKindPlaintext, "GET, POST")
+		return
+	}
+	req, ok := c.front.plaintextRequest(w, r)
+	if !ok {
+		return
+	}
+
+	startTime := time.Now()
+	var resp PlaintextQueryResponse
+	block, ok := c.fanOut(w, r, QueryKindPlaintext, APIPrefix+"/query/plaintext", req, func(body []byte) error {
+		var part PlaintextQueryResponse
+		err := json.Unmarshal(body, &part)
+		resp.Value ^= part.Value
+		return err
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindPlaintext, 1, elapsed, block, resp)
+}
+
+// fullSetHandler merges FullSet parities
+// ⚠️  Privacy: Never logs the PRF key or the returned parity
+func (c *Coordinator) fullSetHandler(w http.ResponseWriter, r *http.Request) {
+	f := c.front
+	if r.Method != http.MethodPost {
+		f.methodNotAllowed(w, QueryKindFullSet, "POST")
+		return
+	}
+	var req FullSetQueryRequest
+	if !f.decodeJSON(w, r, QueryKindFullSet, &req) {
+		return
+	}
+	if len(req.PRFKey) != 16 {
+		f.rejectAPI(w, QueryKindFullSet, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidPRFKey,
+			Message: "PRF key must be 16 bytes",
+		})
+		return
+	}
+
+	startTime := time.Now()
+	var resp FullSetQueryResponse
+	block, ok := c.fanOut(w, r, QueryKindFullSet, APIPrefix+"/query/fullset", req, func(body []byte) error {
+		var part FullSetQueryResponse
+		err := json.Unmarshal(body, &part)
+		resp.Value ^= part.Value
+		return err
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindFullSet, int(f.setSize), elapsed, block, resp)
+}
+
+// setParityHandler merges SetParity parities
+// ⚠️  Privacy: Does not log which indices were queried
+func (c *Coordinator) setParityHandler(w http.ResponseWriter, r *http.Request) {
+	f := c.front
+	if r.Method != http.MethodPost {
+		f.methodNotAllowed(w, QueryKindSetParity, "POST")
+		return
+	}
+	var req SetParityQueryRequest
+	if !f.decodeJSON(w, r, QueryKindSetParity, &req) {
+		return
+	}
+	if max := f.limits.maxIndices(f.setSize); len(req.Indices) > max {
+		f.rejectAPI(w, QueryKindSetParity, http.StatusBadRequest, APIError{
+			Code:    ErrCodeTooManyIndices,
+			Message: fmt.Sprintf("At most %d indices per query", max),
+		})
+		return
+	}
+
+	startTime := time.Now()
+	var resp SetParityQueryResponse
+	block, ok := c.fanOut(w, r, QueryKindSetParity, APIPrefix+"/query/setparity", req, func(body []byte) error {
+		var part SetParityQueryResponse
+		err := json.Unmarshal(body, &part)
+		resp.Parity ^= part.Parity
+		return err
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindSetParity, len(req.Indices), elapsed, block, resp)
+}
+
+// puncturedHandler merges punctured answers element by element
+// ⚠️  Privacy: Never logs the offsets or the returned parities
+func (c *Coordinator) puncturedHandler(w http.ResponseWriter, r *http.Request) {
+	f := c.front
+	if r.Method != http.MethodPost {
+		f.methodNotAllowed(w, QueryKindPunctured, "POST")
+		return
+	}
+	var req PuncturedQueryRequest
+	if !f.decodeJSON(w, r, QueryKindPunctured, &req) {
+		return
+	}
+	if apiErr := f.checkOffsets(req.Offsets); apiErr != nil {
+		f.rejectAPI(w, QueryKindPunctured, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	startTime := time.Now()
+	resp := PuncturedQueryResponse{Parities: make([]uint64, f.setSize)}
+	block, ok := c.fanOut(w, r, QueryKindPunctured, APIPrefix+"/query/punctured", req, func(body []byte) error {
+		var part PuncturedQueryResponse
+		if err := json.Unmarshal(body, &part); err != nil {
+			return err
+		}
+		return xorInto(resp.Parities, part.Parities)
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindPunctured, len(req.Offsets), elapsed, block, resp)
+}
+
+// batchHandler merges batches of punctured answers
+// ⚠️  Privacy: Never logs the offsets or the returned parities
+func (c *Coordinator) batchHandler(w http.ResponseWriter, r *http.Request) {
+	f := c.front
+	if r.Method != http.MethodPost {
+		f.methodNotAllowed(w, QueryKindBatch, "POST")
+		return
+	}
+	var req BatchQueryRequest
+	if !f.decodeJSON(w, r, QueryKindBatch, &req) {
+		return
+	}
+	if len(req.Queries) == 0 || len(req.Queries) > f.limits.maxBatch() {
+		f.rejectAPI(w, QueryKindBatch, http.StatusBadRequest, APIError{
+			Code:    ErrCodeTooManyQueries,
+			Message: fmt.Sprintf("A batch holds 1 to %d queries", f.limits.maxBatch()),
+		})
+		return
+	}
+	for _, q := range req.Queries {
+		if apiErr := f.checkOffsets(q.Offsets); apiErr != nil {
+			f.rejectAPI(w, QueryKindBatch, http.StatusBadRequest, *apiErr)
+			return
+		}
+	}
+
+	startTime := time.Now()
+	resp := BatchQueryResponse{Parities: make([][]uint64, len(req.Queries))}
+	for i := range resp.Parities {
+		resp.Parities[i] = make([]uint64, f.setSize)
+	}
+	block, ok := c.fanOut(w, r, QueryKindBatch, APIPrefix+"/query/punctured/batch", req, func(body []byte) error {
+		var part BatchQueryResponse
+		if err := json.Unmarshal(body, &part); err != nil {
+			return err
+		}
+		if len(part.Parities) != len(resp.Parities) {
+			return fmt.Errorf("%d answers, want %d", len(part.Parities), len(resp.Parities))
+		}
+		for i, p := range part.Parities {
+			if err := xorInto(resp.Parities[i], p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindBatch, len(req.Queries)*int(f.setSize-1), elapsed, block, resp)
+}
+
+// dpfHandler merges the shards' shares of one DPF key into the share a
+// single server would return
+// ⚠️  Privacy: Never logs the key or the returned share
+func (c *Coordinator) dpfHandler(w http.ResponseWriter, r *http.Request) {
+	f := c.front
+	if r.Method != http.MethodPost {
+		f.methodNotAllowed(w, QueryKindDPF, "POST")
+		return
+	}
+	var req DPFQueryRequest
+	if !f.decodeJSON(w, r, QueryKindDPF, &req) {
+		return
+	}
+	var key DPFKey
+	if err := key.UnmarshalBinary(req.Key); err != nil || key.Bits != DPFDomainBits(c.padded) {
+		f.rejectAPI(w, QueryKindDPF, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("DPF key must cover a %d-bit domain (%d bytes)", DPFDomainBits(c.padded), DPFKeySize(DPFDomainBits(c.padded))),
+		})
+		return
+	}
+
+	startTime := time.Now()
+	var resp DPFQueryResponse
+	block, ok := c.fanOut(w, r, QueryKindDPF, APIPrefix+"/query/dpf", req, func(body []byte) error {
+		var part DPFQueryResponse
+		err := json.Unmarshal(body, &part)
+		resp.Value ^= part.Value
+		return err
+	})
+	if !ok {
+		return
+	}
+	elapsed := time.Since(startTime)
+	resp.LastBlock = block
+	resp.ServerTimeNanos = uint64(elapsed.Nanoseconds())
+	c.reply(w, QueryKindDPF, int(c.padded), elapsed, block, resp)
+}
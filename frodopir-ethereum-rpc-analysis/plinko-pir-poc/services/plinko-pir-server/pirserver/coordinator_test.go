@@ -0,0 +1,244 @@
+package pirserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	if sh, err := ParseShard("2/3"); err != nil || sh != (Shard{Index: 2, Count: 3}) {
+		t.Errorf("ParseShard(2/3) = %v, %v", sh, err)
+	}
+	for _, bad := range []string{"", "3", "3/3", "1/0", "-1/2", "a/b"} {
+		if _, err := ParseShard(bad); err == nil {
+			t.Errorf("ParseShard(%q) succeeded", bad)
+		}
+	}
+
+	// Shards tile the chunks even when the split is uneven
+	var next uint64
+	for i := uint64(0); i < 3; i++ {
+		first, end := Shard{Index: i, Count: 3}.Chunks(8)
+		if first != next || end <= first {
+			t.Fatalf("shard %d/3 of 8 chunks = [%d, %d)", i, first, end)
+		}
+		next = end
+	}
+	if next != 8 {
+		t.Errorf("shards end at chunk %d, want 8", next)
+	}
+}
+
+// newShardedTable writes a table of chunkSize*setSize entries to a temp
+// dir and serves it whole and as count shards behind a coordinator
+func newShardedTable(t *testing.T, chunkSize, setSize uint64, count int) (dir string, whole *PlinkoPIRServer, shards []*PlinkoPIRServer, coord *Coordinator) {
+	t.Helper()
+	dir = t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	n := chunkSize * setSize
+	buf := binary.LittleEndian.AppendUint64(nil, n-3)
+	buf = binary.LittleEndian.AppendUint64(buf, chunkSize)
+	buf = binary.LittleEndian.AppendUint64(buf, setSize)
+	buf = binary.LittleEndian.AppendUint64(buf, 5) // Epoch
+	for i := uint64(0); i < n; i++ {
+		buf = binary.LittleEndian.AppendUint64(buf, 0x5eed_0000_0000_0000+i*0x1_0000_0001)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TableHintFile), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	whole, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole.SetLimits(noRateLimits())
+	var urls []string
+	for i := count - 1; i >= 0; i-- { // Any order
+		s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir, Shard: Shard{Index: uint64(i), Count: uint64(count)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetLimits(noRateLimits()) // Shards trust the coordinator's limits
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		shards = append([]*PlinkoPIRServer{s}, shards...)
+		urls = append(urls, ts.URL)
+	}
+	coord, err = NewCoordinator(context.Background(), urls, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord.SetLimits(noRateLimits())
+	return dir, whole, shards, coord
+}
+
+func TestCoordinatorMatchesWholeTable(t *testing.T) {
+	const chunkSize, setSize = 16, 8
+	dir, whole, shards, coord := newShardedTable(t, chunkSize, setSize, 3)
+	if got := len(shards[0].database); got != 2*chunkSize {
+		t.Fatalf("shard 0 holds %d entries, want %d", got, 2*chunkSize)
+	}
+	if coord.Epoch() != 5 || coord.SetSize() != setSize {
+		t.Fatalf("coordinator epoch %d, set size %d", coord.Epoch(), coord.SetSize())
+	}
+
+	offsets := make([]uint64, setSize-1)
+	for i := range offsets {
+		offsets[i] = uint64(i*5) % chunkSize
+	}
+	key0, _, err := GenDPF(77, DPFDomainBits(chunkSize*setSize), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyData, _ := key0.MarshalBinary()
+	dpfBody, _ := json.Marshal(DPFQueryRequest{Key: keyData})
+	puncturedBody, _ := json.Marshal(PuncturedQueryRequest{Offsets: offsets})
+	batchBody, _ := json.Marshal(BatchQueryRequest{Queries: []PuncturedQueryRequest{{Offsets: offsets}, {Offsets: make([]uint64, setSize-1)}}})
+	queries := []struct{ method, target, body string }{
+		{http.MethodGet, "/v1/query/plaintext?index=40", ""},
+		{http.MethodPost, "/v1/query/plaintext", `{"index":127}`},
+		{http.MethodPost, "/v1/query/fullset", `{"prf_key":"AAECAwQFBgcICQoLDA0ODw=="}`},
+		{http.MethodPost, "/v1/query/setparity", `{"indices":[0,17,40,41,90,127]}`},
+		{http.MethodPost, "/v1/query/punctured", string(puncturedBody)},
+		{http.MethodPost, "/v1/query/punctured/batch", string(batchBody)},
+		{http.MethodPost, "/v1/query/dpf", string(dpfBody)},
+	}
+
+	// Shards apply the deltas for their own entries, so the merged answers
+	// follow the table block by block
+	check := func(block uint64) {
+		t.Helper()
+		for _, q := range queries {
+			want := httptest.NewRecorder()
+			whole.Handler().ServeHTTP(want, httptest.NewRequest(q.method, q.target, strings.NewReader(q.body)))
+			got := httptest.NewRecorder()
+			coord.Handler().ServeHTTP(got, httptest.NewRequest(q.method, q.target, strings.NewReader(q.body)))
+			if got.Code != http.StatusOK || want.Code != http.StatusOK {
+				t.Fatalf("%s: coordinator status %d (%s), server status %d", q.target, got.Code, got.Body, want.Code)
+			}
+			if got.Header().Get(BlockHeader) != want.Header().Get(BlockHeader) || got.Header().Get(BlockHeader) == "" {
+				t.Errorf("%s: block %q, want %q", q.target, got.Header().Get(BlockHeader), want.Header().Get(BlockHeader))
+			}
+			var gotAnswer, wantAnswer map[string]interface{}
+			json.Unmarshal(got.Body.Bytes(), &gotAnswer)
+			json.Unmarshal(want.Body.Bytes(), &wantAnswer)
+			delete(gotAnswer, "server_time_nanos")
+			delete(wantAnswer, "server_time_nanos")
+			g, _ := json.Marshal(gotAnswer)
+			w, _ := json.Marshal(wantAnswer)
+			if !bytes.Equal(g, w) {
+				t.Errorf("%s at block %d: coordinator %s, server %s", q.target, block, g, w)
+			}
+		}
+	}
+	check(0)
+
+	writeDelta(t, dir, 1, [][3]uint64{{40, whole.database[40], 1}, {127, whole.database[127], 2}, {17, whole.database[17], 3}})
+	for _, s := range append(shards, whole) {
+		if n, err := s.SyncDeltas(); n != 1 || err != nil {
+			t.Fatalf("SyncDeltas = %d, %v", n, err)
+		}
+	}
+	check(1)
+
+	var params ParamsResponse
+	rec := httptest.NewRecorder()
+	coord.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/params", nil))
+	json.Unmarshal(rec.Body.Bytes(), &params)
+	if params.LastBlock != 1 || params.PaddedSize != chunkSize*setSize || params.Shard != nil {
+		t.Errorf("coordinator params %+v", params)
+	}
+}
+
+func TestCoordinatorPinsBlock(t *testing.T) {
+	dir, _, shards, coord := newShardedTable(t, 4, 4, 2)
+	writeDelta(t, dir, 1, [][3]uint64{{0, shards[0].database[0], 9}})
+	shards[1].SyncDeltas() // Shard 0 is still at block 0
+
+	rec := httptest.NewRecorder()
+	coord.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/setparity", strings.NewReader(`{"indices":[0,15]}`)))
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusServiceUnavailable || body.Error.Code != ErrCodeShardsDiverged || !body.Error.Retryable {
+		t.Fatalf("diverged shards: status %d, %+v", rec.Code, body.Error)
+	}
+
+	shards[0].SyncDeltas()
+	rec = httptest.NewRecorder()
+	coord.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/setparity", strings.NewReader(`{"indices":[0]}`)))
+	var resp SetParityQueryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Parity != 9 || rec.Header().Get(BlockHeader) != "1" {
+		t.Errorf("after catching up: status %d, parity %d, block %q", rec.Code, resp.Parity, rec.Header().Get(BlockHeader))
+	}
+}
+
+func TestCoordinatorStopsOnNewEpoch(t *testing.T) {
+	_, _, shards, coord := newShardedTable(t, 4, 4, 2)
+	query := func() (*httptest.ResponseRecorder, APIError) {
+		rec := httptest.NewRecorder()
+		coord.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/setparity", strings.NewReader(`{"indices":[0,15]}`)))
+		var body ErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body.Error
+	}
+	moved := func() bool {
+		select {
+		case <-coord.EpochChanged():
+			return true
+		default:
+			return false
+		}
+	}
+
+	// One shard restarted on the new hint.bin: the others may follow
+	shards[0].epoch = 6
+	if rec, apiErr := query(); rec.Code != http.StatusServiceUnavailable || apiErr.Code != ErrCodeShardsDiverged || moved() {
+		t.Fatalf("one shard at a new epoch: status %d, %+v, epoch changed %v", rec.Code, apiErr, moved())
+	}
+
+	shards[1].epoch = 6
+	if rec, apiErr := query(); rec.Code != http.StatusConflict || apiErr.Code != ErrCodeEpochMismatch || apiErr.Retryable {
+		t.Fatalf("all shards at a new epoch: status %d, %+v", rec.Code, apiErr)
+	}
+	if !moved() {
+		t.Fatal("EpochChanged not closed")
+	}
+	// Without asking the shards again
+	shards[0].epoch, shards[1].epoch = 5, 5
+	if rec, apiErr := query(); apiErr.Code != ErrCodeEpochMismatch {
+		t.Errorf("query after the move: status %d, %+v", rec.Code, apiErr)
+	}
+}
+
+func TestNewCoordinatorChecksShards(t *testing.T) {
+	_, _, shards, _ := newShardedTable(t, 4, 4, 2)
+	a := httptest.NewServer(shards[0].Handler())
+	defer a.Close()
+	b := httptest.NewServer(shards[1].Handler())
+	defer b.Close()
+	whole := httptest.NewServer(NewPlinkoPIRServer(make([]uint64, 16), 16, 4, 4, 5).Handler())
+	defer whole.Close()
+
+	for name, urls := range map[string][]string{
+		"missing shard":   {a.URL},
+		"duplicate shard": {a.URL, a.URL},
+		"unsharded":       {a.URL, whole.URL},
+	} {
+		if _, err := NewCoordinator(context.Background(), urls, nil); err == nil {
+			t.Errorf("%s: NewCoordinator succeeded", name)
+		}
+	}
+	if _, err := NewCoordinator(context.Background(), []string{b.URL, a.URL + "/"}, nil); err != nil {
+		t.Errorf("NewCoordinator: %v", err)
+	}
+}
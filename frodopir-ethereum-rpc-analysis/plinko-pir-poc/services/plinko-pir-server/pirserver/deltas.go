@@ -0,0 +1,119 @@
+package pirserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Delta feed
+//
+// plinko-update-service publishes one delta file per block in each table's
+// deltas/ directory (format in plinko-update-service/plinko/delta.go). Every
+// v1 entry carries the database index that changed and the XOR of its old
+// and new value, so the server keeps its copy current by XORing the deltas
+// into its own database, in block order, starting from the same hint.bin the
+// update service started from. Each table follows its own directory.
+
+const (
+	deltaHeaderSize    = 16 // [count][version]
+	deltaEntrySize     = 32 // [hintSetID][isBackup][delta][index]
+	deltaFormatVersion = 1
+)
+
+// ErrEpochChanged means the table's hint.bin was regenerated under a new
+// epoch: deltas past the old epoch's last block belong to the new
+// parameters, and the server has to be restarted to load them
+var ErrEpochChanged = errors.New("table moved to a new epoch; restart to load the new hint.bin")
+
+// LastBlock returns the last block whose deltas have been applied
+func (s *PlinkoPIRServer) LastBlock() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastBlock
+}
+
+// SyncDeltas applies every published delta file after LastBlock, stopping
+// at the first block that has none. Servers not loaded with LoadTable have
+// no feed and apply nothing.
+func (s *PlinkoPIRServer) SyncDeltas() (applied int, err error) {
+	if s.dir == "" {
+		return 0, nil
+	}
+	info, err := readTableEpoch(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		block := s.LastBlock() + 1
+		if info != nil && info.Epoch != s.epoch && block > info.LastBlock {
+			return applied, ErrEpochChanged
+		}
+		path := filepath.Join(s.dir, "deltas", fmt.Sprintf("delta-%06d.bin", block))
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return applied, nil
+		}
+		if err != nil {
+			return applied, err
+		}
+		if err := s.applyDeltaFile(block, data); err != nil {
+			return applied, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		applied++
+	}
+}
+
+// applyDeltaFile XORs one block's deltas into the database. Nothing is
+// applied unless the whole file is valid. Shards apply the deltas for their
+// own entries and still advance to the block.
+func (s *PlinkoPIRServer) applyDeltaFile(block uint64, data []byte) error {
+	if len(data) < deltaHeaderSize {
+		return errors.New("too small for header")
+	}
+	count := binary.LittleEndian.Uint64(data[0:8])
+	if version := binary.LittleEndian.Uint64(data[8:16]); version != deltaFormatVersion {
+		return fmt.Errorf("delta format version %d, want %d", version, deltaFormatVersion)
+	}
+	entries := data[deltaHeaderSize:]
+	if uint64(len(entries)) != count*deltaEntrySize {
+		return fmt.Errorf("%d bytes of entries for %d deltas", len(entries), count)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.paddedSize()
+	for off := 0; off < len(entries); off += deltaEntrySize {
+		if idx := binary.LittleEndian.Uint64(entries[off+24:]); idx >= n {
+			return fmt.Errorf("delta index %d outside database of %d entries", idx, n)
+		}
+	}
+	for off := 0; off < len(entries); off += deltaEntrySize {
+		if i, ok := s.local(binary.LittleEndian.Uint64(entries[off+24:])); ok {
+			s.database[i] ^= binary.LittleEndian.Uint64(entries[off+16:])
+		}
+	}
+	s.lastBlock = block
+	return nil
+}
+
+// FollowDeltas calls SyncDeltas every interval until ctx is cancelled (and
+// returns nil) or a sync fails
+func (s *PlinkoPIRServer) FollowDeltas(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.SyncDeltas(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pirserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDelta writes a v1 delta file setting entries to new values, given
+// their old ones
+func writeDelta(t *testing.T, dir string, block uint64, changes [][3]uint64) {
+	t.Helper()
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(len(changes)))
+	buf = binary.LittleEndian.AppendUint64(buf, deltaFormatVersion)
+	for _, c := range changes {
+		idx, old, new := c[0], c[1], c[2]
+		buf = binary.LittleEndian.AppendUint64(buf, 0) // Hint set
+		buf = binary.LittleEndian.AppendUint64(buf, 0) // Primary
+		buf = binary.LittleEndian.AppendUint64(buf, old^new)
+		buf = binary.LittleEndian.AppendUint64(buf, idx)
+	}
+	path := filepath.Join(dir, "deltas", fmt.Sprintf("delta-%06d.bin", block))
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncDeltas(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	s := NewPlinkoPIRServer([]uint64{10, 20, 30, 40}, 4, 2, 2, 0)
+	s.dir = dir
+
+	writeDelta(t, dir, 1, [][3]uint64{{1, 20, 21}})
+	writeDelta(t, dir, 2, [][3]uint64{{1, 21, 22}, {3, 40, 0}})
+	writeDelta(t, dir, 4, [][3]uint64{{0, 10, 11}}) // Block 3 is not published yet
+	if n, err := s.SyncDeltas(); n != 2 || err != nil {
+		t.Fatalf("SyncDeltas = %d, %v; want 2 blocks", n, err)
+	}
+	if s.LastBlock() != 2 || s.database[0] != 10 || s.database[1] != 22 || s.database[3] != 0 {
+		t.Fatalf("after block %d: %v", s.LastBlock(), s.database)
+	}
+
+	// A bad file is rejected whole
+	writeDelta(t, dir, 3, [][3]uint64{{2, 30, 31}, {9, 0, 1}})
+	if _, err := s.SyncDeltas(); err == nil || s.database[2] != 30 || s.LastBlock() != 2 {
+		t.Fatalf("out-of-range delta: %v, database %v at block %d", err, s.database, s.LastBlock())
+	}
+
+	// Epoch 1 starts after block 3: the epoch 0 table stops there
+	writeDelta(t, dir, 3, [][3]uint64{{2, 30, 31}})
+	os.WriteFile(filepath.Join(dir, "epoch.json"), []byte(`{"epoch": 1, "last_block": 3}`), 0644)
+	if n, err := s.SyncDeltas(); n != 1 || !errors.Is(err, ErrEpochChanged) || s.database[0] != 10 {
+		t.Fatalf("SyncDeltas across an epoch = %d, %v; database %v", n, err, s.database)
+	}
+
+	// A table loaded at epoch 1 starts after block 3
+	hint := binary.LittleEndian.AppendUint64(nil, 4)
+	for _, v := range []uint64{2, 2, 1, 10, 22, 31, 0} {
+		hint = binary.LittleEndian.AppendUint64(hint, v)
+	}
+	os.WriteFile(filepath.Join(dir, TableHintFile), hint, 0644)
+	s, err := LoadTable(TableConfig{Name: "eth-balances", Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.SyncDeltas(); n != 1 || err != nil || s.LastBlock() != 4 || s.database[0] != 11 {
+		t.Fatalf("epoch 1 SyncDeltas = %d, %v; block %d, database %v", n, err, s.LastBlock(), s.database)
+	}
+}
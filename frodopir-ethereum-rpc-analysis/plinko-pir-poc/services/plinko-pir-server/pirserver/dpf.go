@@ -0,0 +1,122 @@
+package pirserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"plinko-common/dpf"
+)
+
+// Two-server DPF queries
+//
+// A light client without hints can read an entry from two non-colluding
+// servers holding the same database by sending each one share of a
+// distributed point function (see plinko-common/dpf). Each query reads
+// every entry, so it costs O(n) server time against the O(√n) of a
+// punctured query, in exchange for no client download at all; the rate
+// limiter charges it accordingly (see Limits.ScanCost).
+
+const DPFSeedSize = dpf.SeedSize
+
+type (
+	DPFSeed       = dpf.Seed
+	DPFCorrection = dpf.Correction
+	DPFKey        = dpf.Key
+)
+
+// DPFKeySize returns the encoded size of a key over [0, 2^bits)
+func DPFKeySize(bits uint8) int { return dpf.KeySize(bits) }
+
+// DPFDomainBits returns the domain size, in bits, that covers n entries
+func DPFDomainBits(n uint64) uint8 { return dpf.DomainBits(n) }
+
+// GenDPF splits the point function at alpha over [0, 2^bits) into two
+// keys. The client does this; the server has it for tests and tools.
+func GenDPF(alpha uint64, bits uint8, rand io.Reader) (k0, k1 *DPFKey, err error) {
+	return dpf.Gen(alpha, bits, rand)
+}
+
+// dpfAnswer is the XOR of database[x-base] over every base <= x < base+n
+// where the key's share is 1
+func dpfAnswer(key *DPFKey, database []uint64, base, n uint64) uint64 {
+	var acc uint64
+	key.Walk(base, base+n, func(x uint64, t uint8) {
+		if t == 1 {
+			acc ^= database[(x-base)*DBEntryLength]
+		}
+	})
+	return acc
+}
+
+// evalAll returns the key's share at every x in [0, 2^Bits), one byte each
+func evalAll(key *DPFKey) []uint8 {
+	out := make([]uint8, 1<<key.Bits)
+	key.Walk(0, 1<<key.Bits, func(x uint64, t uint8) { out[x] = t })
+	return out
+}
+
+// DPFQueryRequest carries one server's key share
+type DPFQueryRequest struct {
+	Key []byte `json:"key"` // MarshalBinary encoding, base64 in JSON
+}
+
+// DPFQueryResponse is one server's answer share. Both servers must answer
+// from the same block, so the client checks LastBlock before XORing.
+type DPFQueryResponse struct {
+	Value           uint64 `json:"value"`
+	LastBlock       uint64 `json:"last_block"`
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+// dpfQueryHandler answers a DPF key share
+// ⚠️  Privacy: Never logs the key or the returned share
+func (s *PlinkoPIRServer) dpfQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindDPF, "POST")
+		return
+	}
+
+	var req DPFQueryRequest
+	if !s.decodeJSON(w, r, QueryKindDPF, &req) {
+		return
+	}
+
+	var key DPFKey
+	padded := s.paddedSize()
+	if err := key.UnmarshalBinary(req.Key); err != nil || key.Bits != DPFDomainBits(padded) {
+		s.rejectAPI(w, QueryKindDPF, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("DPF key must cover a %d-bit domain (%d bytes)", DPFDomainBits(padded), DPFKeySize(DPFDomainBits(padded))),
+		})
+		return
+	}
+
+	startTime := time.Now()
+	value, lastBlock := s.HandleDPFQuery(&key)
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindDPF,
+		Entries: int(padded),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	stampBlock(w, lastBlock)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DPFQueryResponse{
+		Value:           value,
+		LastBlock:       lastBlock,
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	})
+}
+
+// HandleDPFQuery returns the key's answer share and the block it reflects
+func (s *PlinkoPIRServer) HandleDPFQuery(key *DPFKey) (value, lastBlock uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return dpfAnswer(key, s.database, s.base, uint64(len(s.database)/DBEntryLength)), s.lastBlock
+}
@@ -0,0 +1,73 @@
+package pirserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDPFAnswer(t *testing.T) {
+	// 100 entries do not fill the 7-bit domain; the tail is skipped
+	database := make([]uint64, 100)
+	for i := range database {
+		database[i] = uint64(i)*0x9e3779b97f4a7c15 + 1
+	}
+	for _, alpha := range []uint64{0, 63, 99} {
+		k0, k1, err := GenDPF(alpha, DPFDomainBits(100), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := dpfAnswer(k0, database, 0, 100) ^ dpfAnswer(k1, database, 0, 100)
+		if got != database[alpha] {
+			t.Errorf("answer for %d = %d, want %d", alpha, got, database[alpha])
+		}
+	}
+}
+
+func TestDPFQueryHandler(t *testing.T) {
+	database := make([]uint64, 32)
+	for i := range database {
+		database[i] = uint64(1000 + i)
+	}
+	a := NewPlinkoPIRServer(database, 30, 8, 4, 0)
+	b := NewPlinkoPIRServer(append([]uint64(nil), database...), 30, 8, 4, 0)
+	a.lastBlock, b.lastBlock = 7, 7
+
+	query := func(s *PlinkoPIRServer, key *DPFKey) (*httptest.ResponseRecorder, DPFQueryResponse) {
+		data, _ := key.MarshalBinary()
+		body, _ := json.Marshal(DPFQueryRequest{Key: data})
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, APIPrefix+"/query/dpf", bytes.NewReader(body)))
+		var resp DPFQueryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	k0, k1, err := GenDPF(21, DPFDomainBits(32), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recA, respA := query(a, k0)
+	recB, respB := query(b, k1)
+	if recA.Code != http.StatusOK || recB.Code != http.StatusOK {
+		t.Fatalf("status %d, %d: %s %s", recA.Code, recB.Code, recA.Body, recB.Body)
+	}
+	if got := respA.Value ^ respB.Value; got != 1021 {
+		t.Errorf("reconstructed %d, want 1021", got)
+	}
+	if respA.LastBlock != 7 || respB.LastBlock != 7 {
+		t.Errorf("last_block = %d, %d; want 7", respA.LastBlock, respB.LastBlock)
+	}
+
+	// A key for another database size is refused
+	small, _, _ := GenDPF(3, 4, rand.Reader)
+	rec, _ := query(a, small)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), fmt.Sprintf("%d-bit", 5)) {
+		t.Errorf("wrong-size key: status %d, %s", rec.Code, rec.Body)
+	}
+}
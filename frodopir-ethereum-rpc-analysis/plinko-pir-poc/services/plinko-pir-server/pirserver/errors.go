@@ -0,0 +1,70 @@
+package pirserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Structured error responses
+//
+// Every error the server returns uses the same envelope:
+//
+//	{"error": {"code": "invalid_prf_key", "message": "...", "retryable": false}}
+//
+// Error bodies contain a fixed code, a fixed message and whether retrying
+// later can succeed. Messages never echo request contents.
+
+// Error codes
+const (
+	ErrCodeMethodNotAllowed    = "method_not_allowed"
+	ErrCodeInvalidRequest      = "invalid_request"
+	ErrCodeMissingParameter    = "missing_parameter"
+	ErrCodeInvalidIndex        = "invalid_index"
+	ErrCodeInvalidPRFKey       = "invalid_prf_key"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeUnsupportedProtocol = "unsupported_protocol_version"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeBodyTooLarge        = "body_too_large"
+	ErrCodeTooManyIndices      = "too_many_indices"
+	ErrCodeTooManyQueries      = "too_many_queries"
+	ErrCodeShardUnavailable    = "shard_unavailable"
+	ErrCodeShardsDiverged      = "shards_diverged"
+	ErrCodeUnauthorized        = "unauthorized"
+	ErrCodeVersionUnavailable  = "version_unavailable"
+	ErrCodeInternal            = "internal_error"
+	ErrCodeEpochMismatch       = "epoch_mismatch"
+)
+
+// APIError is the machine-readable description of a rejected request
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+// ErrorResponse is the JSON body of an error response
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeAPIError sends apiErr with the given HTTP status
+func writeAPIError(w http.ResponseWriter, status int, apiErr APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr})
+}
+
+// methodNotAllowed rejects a request made with the wrong HTTP method
+func (s *PlinkoPIRServer) methodNotAllowed(w http.ResponseWriter, kind QueryKind, allowed string) {
+	w.Header().Set("Allow", allowed)
+	s.rejectAPI(w, kind, http.StatusMethodNotAllowed, APIError{
+		Code:    ErrCodeMethodNotAllowed,
+		Message: "Method not allowed; use " + allowed,
+	})
+}
+
+// rejectAPI sends a structured error and logs the rejection (status only)
+func (s *PlinkoPIRServer) rejectAPI(w http.ResponseWriter, kind QueryKind, status int, apiErr APIError) {
+	s.log.Query(QueryEvent{Kind: kind, Status: status})
+	writeAPIError(w, status, apiErr)
+}
@@ -0,0 +1,358 @@
+package pirserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request limits for the query endpoints
+//
+// Every query is bounded before any database work happens: the body size is
+// capped, SetParity queries may name at most one index per chunk (setSize),
+// and token buckets limit the rate per client IP and across all clients.
+// Most queries read O(√n) entries and take one token; DPF and LWE queries
+// read the whole database and take ScanCost tokens (see queryCost).
+// Rejections use the structured error body from errors.go.
+//
+// The database stream (stream.go) sends a whole table per request, so it has
+// its own token buckets, counted in stream requests rather than queries,
+// and its own write deadline in place of the server's WriteTimeout.
+
+// Limits configures request size, rate and timeout limits
+type Limits struct {
+	MaxBodyBytes int64 // Max request body size
+	MaxIndices   int   // Max indices per SetParity query (0 = setSize)
+	MaxBatch     int   // Max punctured queries per batch request (0 = default)
+
+	PerIPRate   float64 // Sustained queries/second per client IP (0 = unlimited)
+	PerIPBurst  float64 // Bucket size per client IP
+	GlobalRate  float64 // Sustained queries/second across all clients (0 = unlimited)
+	GlobalBurst float64 // Global bucket size
+	ScanCost    float64 // Tokens taken by a query that reads every entry (DPF, LWE; 0 = 1)
+
+	StreamPerIPRate   float64 // Sustained /db/stream requests/second per client IP (0 = unlimited)
+	StreamPerIPBurst  float64
+	StreamGlobalRate  float64 // Sustained /db/stream requests/second across all clients (0 = unlimited)
+	StreamGlobalBurst float64
+
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	StreamWriteTimeout time.Duration // Whole /db/stream response, replacing WriteTimeout (0 = none)
+}
+
+// DefaultLimits returns the limits used when no environment overrides are set
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodyBytes: 256 << 10, // A full 1,024-index SetParity query is ~22 KB
+		MaxIndices:   0,
+		MaxBatch:     16, // A keyword lookup needs 2 queries per cuckoo hash
+
+		PerIPRate:   20,
+		PerIPBurst:  40,
+		GlobalRate:  1000,
+		GlobalBurst: 2000,
+		ScanCost:    20, // One whole-database query a second per IP
+
+		// A client streams once per hint table, resuming a few times at most
+		StreamPerIPRate:   1.0 / 60,
+		StreamPerIPBurst:  8,
+		StreamGlobalRate:  1,
+		StreamGlobalBurst: 32,
+
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        10 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        60 * time.Second,
+		StreamWriteTimeout: 30 * time.Minute, // 64 MB at ~40 KB/s
+	}
+}
+
+// LoadLimits reads limit overrides from the environment
+func LoadLimits() (Limits, error) {
+	l := DefaultLimits()
+	var err error
+	var n int64
+
+	if l.MaxBodyBytes, err = envInt("MAX_BODY_BYTES", l.MaxBodyBytes); err != nil {
+		return l, err
+	}
+	if n, err = envInt("MAX_QUERY_INDICES", int64(l.MaxIndices)); err != nil {
+		return l, err
+	}
+	l.MaxIndices = int(n)
+	if n, err = envInt("MAX_BATCH_QUERIES", int64(l.MaxBatch)); err != nil {
+		return l, err
+	}
+	l.MaxBatch = int(n)
+
+	if l.PerIPRate, err = envFloat("RATE_LIMIT_PER_IP", l.PerIPRate); err != nil {
+		return l, err
+	}
+	if l.PerIPBurst, err = envFloat("RATE_LIMIT_PER_IP_BURST", l.PerIPBurst); err != nil {
+		return l, err
+	}
+	if l.GlobalRate, err = envFloat("RATE_LIMIT_GLOBAL", l.GlobalRate); err != nil {
+		return l, err
+	}
+	if l.GlobalBurst, err = envFloat("RATE_LIMIT_GLOBAL_BURST", l.GlobalBurst); err != nil {
+		return l, err
+	}
+	if l.ScanCost, err = envFloat("RATE_LIMIT_SCAN_COST", l.ScanCost); err != nil {
+		return l, err
+	}
+	if l.StreamPerIPRate, err = envFloat("STREAM_RATE_LIMIT_PER_IP", l.StreamPerIPRate); err != nil {
+		return l, err
+	}
+	if l.StreamPerIPBurst, err = envFloat("STREAM_RATE_LIMIT_PER_IP_BURST", l.StreamPerIPBurst); err != nil {
+		return l, err
+	}
+	if l.StreamGlobalRate, err = envFloat("STREAM_RATE_LIMIT_GLOBAL", l.StreamGlobalRate); err != nil {
+		return l, err
+	}
+	if l.StreamGlobalBurst, err = envFloat("STREAM_RATE_LIMIT_GLOBAL_BURST", l.StreamGlobalBurst); err != nil {
+		return l, err
+	}
+
+	if l.ReadHeaderTimeout, err = envDuration("READ_HEADER_TIMEOUT", l.ReadHeaderTimeout); err != nil {
+		return l, err
+	}
+	if l.ReadTimeout, err = envDuration("READ_TIMEOUT", l.ReadTimeout); err != nil {
+		return l, err
+	}
+	if l.WriteTimeout, err = envDuration("WRITE_TIMEOUT", l.WriteTimeout); err != nil {
+		return l, err
+	}
+	if l.IdleTimeout, err = envDuration("IDLE_TIMEOUT", l.IdleTimeout); err != nil {
+		return l, err
+	}
+	if l.StreamWriteTimeout, err = envDuration("STREAM_WRITE_TIMEOUT", l.StreamWriteTimeout); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// maxIndices returns the SetParity index limit for a server with setSize chunks
+func (l Limits) maxIndices(setSize uint64) int {
+	if l.MaxIndices > 0 {
+		return l.MaxIndices
+	}
+	return int(setSize)
+}
+
+// maxBatch returns the batch query limit, DefaultLimits' when unset
+func (l Limits) maxBatch() int {
+	if l.MaxBatch > 0 {
+		return l.MaxBatch
+	}
+	return DefaultLimits().MaxBatch
+}
+
+// queryCost returns the tokens a query of kind takes from the rate limiter
+func (l Limits) queryCost(kind QueryKind) float64 {
+	switch kind {
+	case QueryKindDPF, QueryKindLWE, QueryKindDPFVerified:
+		if l.ScanCost > 0 {
+			return l.ScanCost
+		}
+	}
+	return 1
+}
+
+// streamLimits returns l with the stream's rates in place of the query
+// rates, for the stream's RateLimiter
+func (l Limits) streamLimits() Limits {
+	l.PerIPRate, l.PerIPBurst = l.StreamPerIPRate, l.StreamPerIPBurst
+	l.GlobalRate, l.GlobalBurst = l.StreamGlobalRate, l.StreamGlobalBurst
+	return l
+}
+
+// ApplyTimeouts sets the server's read/write timeouts
+func (l Limits) ApplyTimeouts(srv *http.Server) {
+	srv.ReadHeaderTimeout = l.ReadHeaderTimeout
+	srv.ReadTimeout = l.ReadTimeout
+	srv.WriteTimeout = l.WriteTimeout
+	srv.IdleTimeout = l.IdleTimeout
+}
+
+// tokenBucket is a classic token bucket refilled continuously at rate/second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last call, up to burst
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// check reports whether the bucket holds cost tokens, or a full bucket if
+// cost exceeds burst, and if not how long until it does
+func (b *tokenBucket) check(rate, burst, cost float64) (bool, time.Duration) {
+	cost = math.Min(cost, burst)
+	if b.tokens >= cost {
+		return true, 0
+	}
+	return false, time.Duration((cost - b.tokens) / rate * float64(time.Second))
+}
+
+// charge takes cost tokens, or a full bucket if cost exceeds burst, after
+// check allowed it
+func (b *tokenBucket) charge(burst, cost float64) {
+	b.tokens -= math.Min(cost, burst)
+}
+
+// RateLimiter combines a global token bucket with one bucket per client IP
+type RateLimiter struct {
+	mu     sync.Mutex
+	limits Limits
+	global tokenBucket
+	perIP  map[string]*tokenBucket
+	swept  time.Time        // Last evictIdle
+	now    func() time.Time // Overridable for tests
+}
+
+// ipBucketIdleTTL is how long an idle client's bucket is kept. After this
+// long the bucket would be full again anyway, so dropping it is lossless.
+// Idle buckets are swept at most once per TTL, so one is kept for up to
+// twice as long.
+const ipBucketIdleTTL = 5 * time.Minute
+
+// NewRateLimiter creates a limiter; rates of 0 disable the matching limit
+func NewRateLimiter(limits Limits) *RateLimiter {
+	return &RateLimiter{
+		limits: limits,
+		perIP:  make(map[string]*tokenBucket),
+		now:    time.Now,
+	}
+}
+
+// Allow reports whether a query from ip costing cost tokens may proceed,
+// and if not, how long the client should wait and whether the global limit
+// was the cause. Tokens are only taken from either bucket when both allow
+// the query.
+func (rl *RateLimiter) Allow(ip string, cost float64) (ok bool, retryAfter time.Duration, global bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+
+	var b *tokenBucket
+	if rl.limits.PerIPRate > 0 {
+		var exists bool
+		if b, exists = rl.perIP[ip]; !exists {
+			if now.Sub(rl.swept) >= ipBucketIdleTTL {
+				rl.evictIdle(now)
+			}
+			b = &tokenBucket{}
+			rl.perIP[ip] = b
+		}
+		b.refill(now, rl.limits.PerIPRate, rl.limits.PerIPBurst)
+		if ok, wait := b.check(rl.limits.PerIPRate, rl.limits.PerIPBurst, cost); !ok {
+			return false, wait, false
+		}
+	}
+	if rl.limits.GlobalRate > 0 {
+		rl.global.refill(now, rl.limits.GlobalRate, rl.limits.GlobalBurst)
+		if ok, wait := rl.global.check(rl.limits.GlobalRate, rl.limits.GlobalBurst, cost); !ok {
+			return false, wait, true
+		}
+		rl.global.charge(rl.limits.GlobalBurst, cost)
+	}
+	if b != nil {
+		b.charge(rl.limits.PerIPBurst, cost)
+	}
+	return true, 0, false
+}
+
+// evictIdle drops buckets that have been idle long enough to be full
+func (rl *RateLimiter) evictIdle(now time.Time) {
+	for ip, b := range rl.perIP {
+		if now.Sub(b.last) > ipBucketIdleTTL {
+			delete(rl.perIP, ip)
+		}
+	}
+	rl.swept = now
+}
+
+// clientIP returns the remote IP of a request (without port)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// guard applies rate limiting, weighted by kind, and the body size limit to
+// a query handler
+func (s *PlinkoPIRServer) guard(kind QueryKind, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter != nil {
+			if ok, wait, global := s.limiter.Allow(clientIP(r), s.limits.queryCost(kind)); !ok {
+				setRetryAfter(w, wait)
+				msg := "Too many queries from this client"
+				if global {
+					msg = "Server is at its query rate limit"
+				}
+				s.rejectAPI(w, kind, http.StatusTooManyRequests, APIError{
+					Code:      ErrCodeRateLimited,
+					Message:   msg,
+					Retryable: true,
+				})
+				return
+			}
+		}
+
+		if s.limits.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.limits.MaxBodyBytes)
+		}
+		next(w, r)
+	}
+}
+
+// setRetryAfter tells a rate-limited client how long to wait, in whole
+// seconds (at least one)
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// decodeJSON decodes a query body into v. On failure it sends the rejection
+// and returns false.
+func (s *PlinkoPIRServer) decodeJSON(w http.ResponseWriter, r *http.Request, kind QueryKind, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.rejectAPI(w, kind, http.StatusRequestEntityTooLarge, APIError{
+			Code:    ErrCodeBodyTooLarge,
+			Message: fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit),
+		})
+		return false
+	}
+
+	s.rejectAPI(w, kind, http.StatusBadRequest, APIError{
+		Code:    ErrCodeInvalidRequest,
+		Message: "Request body is not valid JSON for this query",
+	})
+	return false
+}
@@ -0,0 +1,271 @@
+package pirserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLimitedServer(limits Limits) (*PlinkoPIRServer, *http.ServeMux) {
+	s := newTestServer(&bytes.Buffer{})
+	s.limits = limits
+	s.limiter = NewRateLimiter(limits)
+
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.path, rt.handler)
+	}
+	return s, mux
+}
+
+// noRateLimits returns DefaultLimits without the rate limits, for tests
+// that send one server far more queries than a client would
+func noRateLimits() Limits {
+	limits := DefaultLimits()
+	limits.PerIPRate, limits.GlobalRate = 0, 0
+	return limits
+}
+
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error body is not structured JSON: %q", rec.Body.String())
+	}
+	return resp.Error
+}
+
+func TestSetParityRejectsTooManyIndices(t *testing.T) {
+	_, mux := newLimitedServer(DefaultLimits())
+
+	indices := make([]uint64, testSetSize+1)
+	body, _ := json.Marshal(SetParityQueryRequest{Indices: indices})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/setparity", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if got := decodeAPIError(t, rec); got.Code != ErrCodeTooManyIndices || got.Retryable {
+		t.Errorf("error = %+v, want non-retryable %s", got, ErrCodeTooManyIndices)
+	}
+
+	// Exactly setSize indices is allowed
+	body, _ = json.Marshal(SetParityQueryRequest{Indices: indices[:testSetSize]})
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/setparity", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status for %d indices = %d, want 200", testSetSize, rec.Code)
+	}
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	limits := DefaultLimits()
+	limits.MaxBodyBytes = 64
+	_, mux := newLimitedServer(limits)
+
+	body := fmt.Sprintf(`{"indices": [%s1]}`, strings.Repeat("1,", 100))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/setparity", strings.NewReader(body)))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+	if got := decodeAPIError(t, rec); got.Code != ErrCodeBodyTooLarge {
+		t.Errorf("error code = %q, want %q", got.Code, ErrCodeBodyTooLarge)
+	}
+}
+
+func TestPerIPRateLimit(t *testing.T) {
+	limits := DefaultLimits()
+	limits.PerIPRate, limits.PerIPBurst = 1, 2
+	s, mux := newLimitedServer(limits)
+
+	now := time.Unix(1_700_000_000, 0)
+	s.limiter.now = func() time.Time { return now }
+
+	query := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/query/plaintext?index=1", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := query("10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("query %d within burst: status %d", i, rec.Code)
+		}
+	}
+
+	rec := query("10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status after burst = %d, want 429", rec.Code)
+	}
+	if got := decodeAPIError(t, rec); got.Code != ErrCodeRateLimited || !got.Retryable {
+		t.Errorf("error = %+v, want retryable %s", got, ErrCodeRateLimited)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own bucket
+	if rec := query("10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other client status = %d, want 200", rec.Code)
+	}
+
+	// The bucket refills over time
+	now = now.Add(time.Second)
+	if rec := query("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("status after refill = %d, want 200", rec.Code)
+	}
+}
+
+func TestScanQueriesCostMore(t *testing.T) {
+	limits := DefaultLimits()
+	limits.PerIPRate, limits.PerIPBurst, limits.ScanCost = 1, 20, 10
+	s, mux := newLimitedServer(limits)
+
+	now := time.Unix(1_700_000_000, 0)
+	s.limiter.now = func() time.Time { return now }
+
+	key, _, err := GenDPF(1, DPFDomainBits(testChunkSize*testSetSize), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := key.MarshalBinary()
+	body, _ := json.Marshal(DPFQueryRequest{Key: data})
+	dpf := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, APIPrefix+"/query/dpf", bytes.NewReader(body))
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	plaintext := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/query/plaintext?index=1", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Two DPF queries empty a 20-token bucket
+	for i := 0; i < 2; i++ {
+		if rec := dpf("10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("DPF query %d within burst: status %d: %s", i, rec.Code, rec.Body)
+		}
+	}
+	rec := dpf("10.0.0.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third DPF query: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want 10 (the whole scan cost)", got)
+	}
+
+	// A plaintext query from the same client only needs one token back
+	now = now.Add(time.Second)
+	if rec := plaintext("10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("plaintext after 1s: status %d, want 200", rec.Code)
+	}
+
+	// The same 20 tokens cover 20 plaintext queries
+	for i := 0; i < 20; i++ {
+		if rec := plaintext("10.0.0.2:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("plaintext query %d within burst: status %d", i, rec.Code)
+		}
+	}
+}
+
+func TestCostAboveBurstTakesWholeBucket(t *testing.T) {
+	limits := DefaultLimits()
+	limits.PerIPRate, limits.PerIPBurst = 1, 4
+	limits.GlobalRate = 0
+	rl := NewRateLimiter(limits)
+	now := time.Unix(1_700_000_000, 0)
+	rl.now = func() time.Time { return now }
+
+	if ok, _, _ := rl.Allow("10.0.0.1", 20); !ok {
+		t.Fatal("query costing more than the burst rejected on a full bucket")
+	}
+	if ok, wait, _ := rl.Allow("10.0.0.1", 20); ok || wait != 4*time.Second {
+		t.Errorf("second query = %v, wait %v; want rejected until the bucket refills (4s)", ok, wait)
+	}
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	limits := DefaultLimits()
+	limits.PerIPRate = 0
+	limits.GlobalRate, limits.GlobalBurst = 1, 3
+	rl := NewRateLimiter(limits)
+	now := time.Unix(1_700_000_000, 0)
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := rl.Allow(fmt.Sprintf("10.0.0.%d", i), 1); !ok {
+			t.Fatalf("query %d within global burst rejected", i)
+		}
+	}
+	if ok, _, global := rl.Allow("10.0.0.9", 1); ok || !global {
+		t.Errorf("Allow after global burst = %v (global %v), want rejected by global limit", ok, global)
+	}
+}
+
+func TestGlobalRejectionKeepsPerIPTokens(t *testing.T) {
+	limits := DefaultLimits()
+	limits.PerIPRate, limits.PerIPBurst = 0.1, 2
+	limits.GlobalRate, limits.GlobalBurst = 1, 2
+	rl := NewRateLimiter(limits)
+	now := time.Unix(1_700_000_000, 0)
+	rl.now = func() time.Time { return now }
+
+	if ok, _, _ := rl.Allow("10.0.0.1", 2); !ok {
+		t.Fatal("first query rejected")
+	}
+	if ok, _, global := rl.Allow("10.0.0.2", 2); ok || !global {
+		t.Fatalf("Allow on an empty global bucket = %v (global %v), want rejected by global limit", ok, global)
+	}
+	// The rejected client's own bucket was not charged for it
+	now = now.Add(2 * time.Second)
+	if ok, wait, _ := rl.Allow("10.0.0.2", 2); !ok {
+		t.Errorf("Allow once the global bucket refilled = rejected for %v, want allowed", wait)
+	}
+}
+
+func TestIdleBucketsSweptOncePerTTL(t *testing.T) {
+	limits := DefaultLimits()
+	limits.GlobalRate = 0
+	rl := NewRateLimiter(limits)
+	now := time.Unix(1_700_000_000, 0)
+	rl.now = func() time.Time { return now }
+
+	allow := func(ip string, at time.Duration) {
+		t.Helper()
+		now = time.Unix(1_700_000_000, 0).Add(at)
+		if ok, _, _ := rl.Allow(ip, 1); !ok {
+			t.Fatalf("query from %s rejected", ip)
+		}
+	}
+	allow("10.0.0.1", 0)
+	allow("10.0.0.2", ipBucketIdleTTL/2)
+	allow("10.0.0.3", ipBucketIdleTTL+time.Second) // Sweeps .1
+	if len(rl.perIP) != 2 {
+		t.Fatalf("%d buckets after the first sweep, want 2", len(rl.perIP))
+	}
+	// .2 is idle by now, but the last sweep was too recent for another
+	allow("10.0.0.4", ipBucketIdleTTL*3/2+2*time.Second)
+	if len(rl.perIP) != 3 {
+		t.Fatalf("%d buckets between sweeps, want 3", len(rl.perIP))
+	}
+	allow("10.0.0.5", 2*ipBucketIdleTTL+2*time.Second) // Sweeps .2 and .3
+	if _, ok := rl.perIP["10.0.0.4"]; !ok || len(rl.perIP) != 2 {
+		t.Errorf("%d buckets after the second sweep (.4 kept: %v), want .4 and .5", len(rl.perIP), ok)
+	}
+}
@@ -0,0 +1,105 @@
+package pirserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Privacy-safe logging for query handlers
+//
+// Handlers never call the log package directly. They report a QueryEvent,
+// which only has room for the query kind, the number of database entries
+// touched, the elapsed time and the HTTP status. Keys, indices, parities and
+// values have no field to go into, so they cannot end up in the logs.
+
+// LogLevel selects how much the server logs about queries
+type LogLevel int
+
+const (
+	LogLevelQuiet LogLevel = iota // No per-query lines
+	LogLevelInfo                  // One line per completed query
+	LogLevelDebug                 // Completed and rejected queries
+)
+
+// QueryKind names a query endpoint in log lines
+type QueryKind string
+
+const (
+	QueryKindPlaintext   QueryKind = "plaintext"
+	QueryKindFullSet     QueryKind = "fullset"
+	QueryKindSetParity   QueryKind = "setparity"
+	QueryKindPunctured   QueryKind = "punctured"
+	QueryKindBatch       QueryKind = "punctured_batch"
+	QueryKindDPF         QueryKind = "dpf"
+	QueryKindLWE         QueryKind = "lwe"
+	QueryKindDPFVerified QueryKind = "dpf_verified"
+)
+
+// QueryEvent is everything a handler is allowed to log about a query
+type QueryEvent struct {
+	Kind    QueryKind     // Which endpoint served the query
+	Entries int           // Number of database entries touched
+	Elapsed time.Duration // Server-side computation time
+	Status  int           // HTTP status returned to the client
+}
+
+// PrivacyLogger writes QueryEvents at the configured verbosity
+type PrivacyLogger struct {
+	level LogLevel
+	out   *log.Logger
+}
+
+// NewPrivacyLogger creates a logger writing to w
+func NewPrivacyLogger(w io.Writer, level LogLevel) *PrivacyLogger {
+	return &PrivacyLogger{
+		level: level,
+		out:   log.New(w, "", log.LstdFlags),
+	}
+}
+
+// ParseLogLevel parses "quiet", "info" or "debug" (case-insensitive)
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "quiet", "off", "none":
+		return LogLevelQuiet, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "debug":
+		return LogLevelDebug, nil
+	}
+	return LogLevelInfo, fmt.Errorf("unknown log level %q (want quiet, info or debug)", s)
+}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelQuiet:
+		return "quiet"
+	case LogLevelDebug:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// Query logs a completed or rejected query
+func (l *PrivacyLogger) Query(ev QueryEvent) {
+	if l == nil {
+		return
+	}
+
+	if ev.Status >= http.StatusBadRequest {
+		if l.level >= LogLevelDebug {
+			l.out.Printf("%s query rejected: status %d\n", ev.Kind, ev.Status)
+		}
+		return
+	}
+
+	if l.level >= LogLevelInfo {
+		l.out.Printf("%s query (%d entries) completed in %v\n",
+			ev.Kind, ev.Entries, ev.Elapsed)
+	}
+}
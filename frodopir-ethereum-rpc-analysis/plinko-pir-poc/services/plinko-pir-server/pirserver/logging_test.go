@@ -0,0 +1,448 @@
+package pirserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Distinctive values make any leak easy to spot in the log output.
+const (
+	testChunkSize = 64
+	testSetSize   = 16
+	testSecretIdx = 987_654 % (testChunkSize * testSetSize)
+)
+
+// testDPFSeeds stands in for crypto/rand when generating DPF keys
+var testDPFSeeds = bytes.Repeat([]byte{0x5b, 0xe1, 0x2f, 0x96}, 8)
+
+var testPRFKey = []byte{
+	0xa7, 0x3c, 0x91, 0x5e, 0xd2, 0x08, 0x6f, 0xb4,
+	0x19, 0xe5, 0x77, 0x2a, 0xcd, 0x40, 0x8b, 0xf3,
+}
+
+// newTestServer builds a server whose entries are large, unique numbers
+func newTestServer(logOut *bytes.Buffer) *PlinkoPIRServer {
+	n := uint64(testChunkSize * testSetSize)
+	database := make([]uint64, n)
+	for i := range database {
+		database[i] = 0x5eed_0000_0000_0000 + uint64(i)*0x1_0000_0001
+	}
+	s := &PlinkoPIRServer{
+		database:  database,
+		dbSize:    n,
+		chunkSize: testChunkSize,
+		setSize:   testSetSize,
+		log:       NewPrivacyLogger(logOut, LogLevelDebug),
+		metrics:   NewServerMetrics(n, testChunkSize, testSetSize),
+	}
+	s.EnableLWE()
+	s.EnableMerkle()
+	return s
+}
+
+// routeRequest is a request fixture for one route
+type routeRequest struct {
+	method string
+	target string
+	body   string
+	secret []string // Request-derived strings that must not be logged
+}
+
+func indexSecrets(indices ...uint64) []string {
+	var out []string
+	for _, idx := range indices {
+		out = append(out, strconv.FormatUint(idx, 10), fmt.Sprintf("%x", idx))
+	}
+	return out
+}
+
+// routeRequests returns valid and invalid requests for every route. A route
+// without fixtures fails the test, so new handlers must be added here.
+func routeRequests() map[string][]routeRequest {
+	keyJSON := byteArrayJSON(testPRFKey)
+	keyHex := hex.EncodeToString(testPRFKey)
+	keySecrets := []string{keyHex, keyHex[:16], keyHex[16:], keyJSON}
+
+	indices := []uint64{testSecretIdx, 123_457, 654_321}
+	indicesJSON, _ := json.Marshal(indices)
+
+	offsets := make([]uint64, testSetSize-1)
+	for i := range offsets {
+		offsets[i] = uint64(i*37+11) % testChunkSize
+	}
+	offsetsJSON, _ := json.Marshal(offsets)
+
+	fixtures := map[string][]routeRequest{
+		"/health": {
+			{method: http.MethodGet, target: "/health"},
+		},
+		"/metrics": {
+			{method: http.MethodGet, target: "/metrics"},
+		},
+		"/query/plaintext": {
+			{
+				method: http.MethodPost, target: "/query/plaintext",
+				body:   fmt.Sprintf(`{"index": %d}`, testSecretIdx),
+				secret: indexSecrets(testSecretIdx),
+			},
+			{
+				method: http.MethodGet, target: fmt.Sprintf("/query/plaintext?index=%d", testSecretIdx),
+				secret: indexSecrets(testSecretIdx),
+			},
+			{
+				method: http.MethodGet, target: "/query/plaintext?index=not-a-number-7731",
+				secret: []string{"not-a-number-7731"},
+			},
+			{
+				method: http.MethodPost, target: "/query/plaintext",
+				body:   `{"index": "secret-string-4242"}`,
+				secret: []string{"secret-string-4242"},
+			},
+		},
+		"/query/fullset": {
+			{
+				method: http.MethodPost, target: "/query/fullset",
+				body:   fmt.Sprintf(`{"prf_key": %s}`, keyJSON),
+				secret: keySecrets,
+			},
+			{
+				method: http.MethodPost, target: "/query/fullset",
+				body:   fmt.Sprintf(`{"prf_key": %s}`, byteArrayJSON(testPRFKey[:12])),
+				secret: []string{keyHex[:16]},
+			},
+		},
+		"/query/setparity": {
+			{
+				method: http.MethodPost, target: "/query/setparity",
+				body:   fmt.Sprintf(`{"indices": %s}`, indicesJSON),
+				secret: indexSecrets(indices...),
+			},
+			{
+				method: http.MethodPost, target: "/query/setparity",
+				body:   `{"indices": [123457, "654321"]}`,
+				secret: indexSecrets(123_457, 654_321),
+			},
+		},
+		"/": {
+			{method: http.MethodGet, target: "/query/unknown?index=7654321", secret: []string{"7654321"}},
+		},
+	}
+
+	// The /v1/ tree shares handlers with the deprecated aliases
+	for _, path := range []string{"/health", "/query/plaintext", "/query/fullset", "/query/setparity"} {
+		var v1 []routeRequest
+		for _, fx := range fixtures[path] {
+			fx.target = APIPrefix + fx.target
+			v1 = append(v1, fx)
+		}
+		fixtures[APIPrefix+path] = v1
+	}
+	fixtures[APIPrefix+"/query/punctured"] = []routeRequest{
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/punctured",
+			body:   fmt.Sprintf(`{"offsets": %s}`, offsetsJSON),
+			secret: []string{string(offsetsJSON[1 : len(offsetsJSON)-1])},
+		},
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/punctured",
+			body:   `{"offsets": [33, 7, 61]}`,
+			secret: []string{"33, 7, 61", "33,7,61"},
+		},
+	}
+	fixtures[APIPrefix+"/query/punctured/batch"] = []routeRequest{
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/punctured/batch",
+			body:   fmt.Sprintf(`{"queries": [{"offsets": %s}, {"offsets": %s}]}`, offsetsJSON, offsetsJSON),
+			secret: []string{string(offsetsJSON[1 : len(offsetsJSON)-1])},
+		},
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/punctured/batch",
+			body:   `{"queries": [{"offsets": [33, 7, 61]}]}`,
+			secret: []string{"33, 7, 61", "33,7,61"},
+		},
+	}
+	fixtures[APIPrefix+"/params"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/params"},
+	}
+	fixtures[APIPrefix+"/tables"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/tables"},
+	}
+
+	dpfKey, _, _ := GenDPF(testSecretIdx, DPFDomainBits(testChunkSize*testSetSize), bytes.NewReader(testDPFSeeds))
+	dpfBytes, _ := dpfKey.MarshalBinary()
+	dpfB64 := base64.StdEncoding.EncodeToString(dpfBytes)
+	fixtures[APIPrefix+"/query/dpf"] = []routeRequest{
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/dpf",
+			body:   fmt.Sprintf(`{"key": %q}`, dpfB64),
+			secret: []string{dpfB64, dpfB64[:24], hex.EncodeToString(dpfBytes[1:17])},
+		},
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/dpf",
+			body:   fmt.Sprintf(`{"key": %q}`, dpfB64[:40]),
+			secret: []string{dpfB64[:24]},
+		},
+	}
+
+	fixtures[APIPrefix+"/query/dpf/verified"] = []routeRequest{
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/dpf/verified",
+			body:   fmt.Sprintf(`{"key": %q}`, dpfB64),
+			secret: []string{dpfB64, dpfB64[:24], hex.EncodeToString(dpfBytes[1:17])},
+		},
+	}
+
+	_, lweCols := LWELayout(testChunkSize * testSetSize)
+	lweQuery := make([]byte, 4*lweCols)
+	for i := range lweQuery {
+		lweQuery[i] = testDPFSeeds[i%len(testDPFSeeds)] ^ byte(i)
+	}
+	lweB64 := base64.StdEncoding.EncodeToString(lweQuery)
+	fixtures[APIPrefix+"/query/lwe"] = []routeRequest{
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/lwe",
+			body:   fmt.Sprintf(`{"query": %q}`, lweB64),
+			secret: []string{lweB64[:32], hex.EncodeToString(lweQuery[:16])},
+		},
+		{
+			method: http.MethodPost, target: APIPrefix + "/query/lwe",
+			body:   fmt.Sprintf(`{"query": %q}`, lweB64[:64]),
+			secret: []string{lweB64[:32]},
+		},
+	}
+	fixtures[APIPrefix+"/db/manifest"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/db/manifest"},
+	}
+	fixtures[APIPrefix+"/db/stream"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/db/stream?epoch=0&from=3&chunks=2"},
+	}
+	fixtures[APIPrefix+"/replication/log"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/replication/log?after=0"},
+	}
+	fixtures[APIPrefix+"/replication/ack"] = []routeRequest{
+		{method: http.MethodPost, target: APIPrefix + "/replication/ack", body: `{"replica": "http://replica-1:3000", "epoch": 0, "last_block": 3}`},
+	}
+	fixtures[APIPrefix+"/replication/status"] = []routeRequest{
+		{method: http.MethodGet, target: APIPrefix + "/replication/status"},
+	}
+	return fixtures
+}
+
+// logTimings matches the durations in query log lines, which are logged on
+// purpose and can collide with short secrets such as an index in hex
+var logTimings = regexp.MustCompile(`completed in [0-9.]+[a-zµ]+`)
+
+// loggedText returns captured log output without durations
+func loggedText(logs *bytes.Buffer) string {
+	return logTimings.ReplaceAllString(logs.String(), "completed in <duration>")
+}
+
+// responseSecrets collects every number in a JSON response body
+func responseSecrets(body []byte) []string {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil // Plain-text error bodies carry no query results
+	}
+
+	var out []string
+	for key, v := range decoded {
+		if key == "server_time_nanos" {
+			continue // Timing is logged on purpose
+		}
+		num, ok := v.(float64)
+		if !ok || num < 1000 {
+			continue // Small numbers (sizes, counts) are too common to match on
+		}
+		u := uint64(num)
+		out = append(out, strconv.FormatUint(u, 10), fmt.Sprintf("%x", u))
+	}
+
+	// json.Number loses precision on uint64, so also scan raw digit runs
+	for _, field := range strings.FieldsFunc(string(body), func(r rune) bool {
+		return r < '0' || r > '9'
+	}) {
+		if len(field) >= 7 {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+func TestHandlersNeverLogQueryMaterial(t *testing.T) {
+	fixtures := routeRequests()
+	defer log.SetOutput(os.Stderr)
+
+	for _, rt := range newTestServer(&bytes.Buffer{}).routes() {
+		reqs, ok := fixtures[rt.path]
+		if !ok {
+			t.Errorf("route %s has no privacy logging fixture; add one to routeRequests", rt.path)
+			continue
+		}
+
+		for _, fx := range reqs {
+			// Capture the standard logger too, in case a handler bypasses s.log
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			s := newTestServer(&logs)
+			handler := corsMiddleware(s.routes()[indexOfRoute(s, rt.path)].handler)
+
+			req := httptest.NewRequest(fx.method, fx.target, strings.NewReader(fx.body))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			secrets := append([]string{}, fx.secret...)
+			secrets = append(secrets, responseSecrets(rec.Body.Bytes())...)
+
+			logged := loggedText(&logs)
+			for _, secret := range secrets {
+				if secret != "" && strings.Contains(logged, secret) {
+					t.Errorf("%s %s (status %d) logged query material %q:\n%s",
+						fx.method, fx.target, rec.Code, secret, logged)
+				}
+			}
+		}
+	}
+}
+
+// The coordinator and its shards are held to the same standard
+func TestCoordinatorNeverLogsQueryMaterial(t *testing.T) {
+	fixtures := routeRequests()
+	defer log.SetOutput(os.Stderr)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	var urls []string
+	for i := uint64(0); i < 2; i++ {
+		shard := newTestServer(&logs)
+		first, end := Shard{Index: i, Count: 2}.Chunks(testSetSize)
+		shard.database = shard.database[first*testChunkSize : end*testChunkSize]
+		shard.shard, shard.base, shard.lwe = Shard{Index: i, Count: 2}, first*testChunkSize, nil
+		ts := httptest.NewServer(shard.Handler())
+		defer ts.Close()
+		urls = append(urls, ts.URL)
+	}
+	c, err := NewCoordinator(context.Background(), urls, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetLogger(NewPrivacyLogger(&logs, LogLevelDebug))
+	handler := c.Handler()
+
+	for _, rt := range c.routes() {
+		reqs, ok := fixtures[rt.path]
+		if !ok {
+			t.Errorf("route %s has no privacy logging fixture; add one to routeRequests", rt.path)
+			continue
+		}
+		for _, fx := range reqs {
+			logs.Reset()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(fx.method, fx.target, strings.NewReader(fx.body)))
+
+			secrets := append([]string{}, fx.secret...)
+			secrets = append(secrets, responseSecrets(rec.Body.Bytes())...)
+			logged := loggedText(&logs)
+			for _, secret := range secrets {
+				if secret != "" && strings.Contains(logged, secret) {
+					t.Errorf("coordinator %s %s (status %d) logged query material %q:\n%s",
+						fx.method, fx.target, rec.Code, secret, logged)
+				}
+			}
+		}
+	}
+}
+
+func TestHandlersLogQueryEvents(t *testing.T) {
+	var logs bytes.Buffer
+	s := newTestServer(&logs)
+
+	body := fmt.Sprintf(`{"prf_key": %s}`, byteArrayJSON(testPRFKey))
+	req := httptest.NewRequest(http.MethodPost, "/query/fullset", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.fullSetQueryHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fullset status = %d, want 200", rec.Code)
+	}
+	want := fmt.Sprintf("fullset query (%d entries) completed", testSetSize)
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log output %q does not contain %q", logs.String(), want)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	ok := QueryEvent{Kind: QueryKindSetParity, Entries: 3, Status: http.StatusOK}
+	bad := QueryEvent{Kind: QueryKindSetParity, Status: http.StatusBadRequest}
+
+	tests := []struct {
+		level        LogLevel
+		wantComplete bool
+		wantReject   bool
+	}{
+		{LogLevelQuiet, false, false},
+		{LogLevelInfo, true, false},
+		{LogLevelDebug, true, true},
+	}
+
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		l := NewPrivacyLogger(&logs, tt.level)
+		l.Query(ok)
+		l.Query(bad)
+
+		out := logs.String()
+		if got := strings.Contains(out, "completed"); got != tt.wantComplete {
+			t.Errorf("level %s: logged completion = %v, want %v", tt.level, got, tt.wantComplete)
+		}
+		if got := strings.Contains(out, "rejected"); got != tt.wantReject {
+			t.Errorf("level %s: logged rejection = %v, want %v", tt.level, got, tt.wantReject)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]LogLevel{
+		"":      LogLevelInfo,
+		"info":  LogLevelInfo,
+		"QUIET": LogLevelQuiet,
+		"debug": LogLevelDebug,
+	} {
+		got, err := ParseLogLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("ParseLogLevel(\"verbose\") succeeded, want error")
+	}
+}
+
+func indexOfRoute(s *PlinkoPIRServer, path string) int {
+	for i, rt := range s.routes() {
+		if rt.path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// byteArrayJSON encodes b the way browser clients do: as a number array
+func byteArrayJSON(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
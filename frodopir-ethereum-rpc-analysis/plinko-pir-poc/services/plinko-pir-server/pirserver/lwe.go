@@ -0,0 +1,164 @@
+package pirserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"plinko-common/lwe"
+)
+
+// LWE (SimplePIR/FrodoPIR-style) queries
+//
+// The database is viewed as a Rows x Cols matrix D of bytes: column j holds
+// entries j*E to j*E+E-1 (E = Rows/8), each as 8 little-endian bytes down the
+// column. plinko-hint-generator publishes lwe-hint.bin with H = D·A mod 2^32,
+// where A is a public Cols x n matrix expanded from a seed. To read column j
+// the client sends q = A·s + e + Δ·u_j for a fresh secret s, small noise e and
+// Δ = 2^24; the server answers D·q, and the client recovers column j as
+// round((D·q - H·s) / Δ). The server only sees an LWE ciphertext.
+//
+// H describes the database as of hint.bin, so LWE answers come from a
+// snapshot taken when the table is loaded, not the delta-updated database;
+// new values arrive with the next epoch, as in FrodoPIR. The layout comes
+// from plinko-common/lwe; A must match plinko-hint-generator/hintgen/lwe.go
+// and plinko-client/lwe.go.
+
+// LWEHintFile is published next to a table's hint.bin when LWE queries are
+// enabled (LWE_HINT=true in the hint generator)
+const LWEHintFile = "lwe-hint.bin"
+
+// LWELayout returns the matrix shape for a database of entries entries
+// (plinko-common/lwe, shared with the other services)
+func LWELayout(entries uint64) (rows, cols uint64) {
+	return lwe.Layout(entries)
+}
+
+// lweSnapshot is the byte matrix D, row-major
+type lweSnapshot struct {
+	rows, cols uint64
+	matrix     []byte
+}
+
+// EnableLWE answers LWE queries from a snapshot of the current database.
+// Call it before any deltas are applied, so the snapshot matches hint.bin.
+// Shards hold only part of D and never answer LWE queries.
+func (s *PlinkoPIRServer) EnableLWE() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shard.Count > 0 {
+		return
+	}
+	n := uint64(len(s.database) / DBEntryLength)
+	rows, cols := LWELayout(n)
+	perCol := rows / 8
+	snap := &lweSnapshot{rows: rows, cols: cols, matrix: make([]byte, rows*cols)}
+	for i := uint64(0); i < n; i++ {
+		v := s.database[i*DBEntryLength]
+		col, row := i/perCol, (i%perCol)*8
+		for b := uint64(0); b < 8; b++ {
+			snap.matrix[(row+b)*cols+col] = byte(v >> (8 * b))
+		}
+	}
+	s.lwe = snap
+}
+
+// LWEEnabled reports whether the server answers LWE queries
+func (s *PlinkoPIRServer) LWEEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lwe != nil
+}
+
+// lweHintPublished reports whether dir has an lwe-hint.bin
+func lweHintPublished(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, LWEHintFile))
+	return err == nil
+}
+
+// answer returns D·q mod 2^32
+func (m *lweSnapshot) answer(query []uint32) []uint32 {
+	out := make([]uint32, m.rows)
+	for r := range out {
+		row := m.matrix[uint64(r)*m.cols : uint64(r+1)*m.cols]
+		var acc uint32
+		for j, d := range row {
+			acc += uint32(d) * query[j]
+		}
+		out[r] = acc
+	}
+	return out
+}
+
+// LWEQueryRequest carries the encrypted column selector: Cols uint32 LE
+// values
+type LWEQueryRequest struct {
+	Query []byte `json:"query"` // Base64 in JSON
+}
+
+// LWEQueryResponse carries D·q: Rows uint32 LE values
+type LWEQueryResponse struct {
+	Answer          []byte `json:"answer"` // Base64 in JSON
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+// lweQueryHandler answers an LWE query
+// ⚠️  Privacy: Never logs the query vector or the answer
+func (s *PlinkoPIRServer) lweQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindLWE, "POST")
+		return
+	}
+
+	s.mu.RLock()
+	snap := s.lwe
+	s.mu.RUnlock()
+	if snap == nil {
+		s.rejectAPI(w, QueryKindLWE, http.StatusNotFound, APIError{
+			Code:    ErrCodeNotFound,
+			Message: "LWE queries are not enabled for this table",
+		})
+		return
+	}
+
+	var req LWEQueryRequest
+	if !s.decodeJSON(w, r, QueryKindLWE, &req) {
+		return
+	}
+	if uint64(len(req.Query)) != 4*snap.cols {
+		s.rejectAPI(w, QueryKindLWE, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("LWE query must have %d uint32 values", snap.cols),
+		})
+		return
+	}
+
+	startTime := time.Now()
+	query := make([]uint32, snap.cols)
+	for j := range query {
+		query[j] = binary.LittleEndian.Uint32(req.Query[4*j:])
+	}
+	ans := snap.answer(query)
+	out := make([]byte, 4*len(ans))
+	for i, v := range ans {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindLWE,
+		Entries: len(snap.matrix) / 8,
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LWEQueryResponse{
+		Answer:          out,
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	})
+}
@@ -0,0 +1,86 @@
+package pirserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestLWELayout(t *testing.T) {
+	if rows, cols := LWELayout(1 << 23); rows != 8192 || cols != 8192 {
+		t.Errorf("LWELayout(2^23) = %d x %d, want 8192 x 8192", rows, cols)
+	}
+	for _, n := range []uint64{1, 7, 100, 4096, 1000003} {
+		rows, cols := LWELayout(n)
+		if rows%8 != 0 || rows/8*cols < n || rows/8*(cols-1) >= n {
+			t.Errorf("LWELayout(%d) = %d x %d does not fit the entries", n, rows, cols)
+		}
+	}
+}
+
+func TestLWEQuery(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	database := make([]uint64, 100)
+	for i := range database {
+		database[i] = rng.Uint64()
+	}
+	s := NewPlinkoPIRServer(database, 100, 25, 4, 0)
+	s.SetLimits(noRateLimits())
+	h := s.Handler()
+
+	query := func(q []uint32) *httptest.ResponseRecorder {
+		raw := make([]byte, 4*len(q))
+		for j, v := range q {
+			binary.LittleEndian.PutUint32(raw[4*j:], v)
+		}
+		body, _ := json.Marshal(LWEQueryRequest{Query: raw})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, APIPrefix+"/query/lwe", bytes.NewReader(body)))
+		return rec
+	}
+
+	rows, cols := LWELayout(100)
+	if rec := query(make([]uint32, cols)); rec.Code != http.StatusNotFound {
+		t.Fatalf("query before EnableLWE: status %d", rec.Code)
+	}
+	s.EnableLWE()
+	snapshot := slices.Clone(database)
+	s.ApplyUpdates(1, []EntryUpdate{{Index: 3, Value: 1}}) // Not in the snapshot
+
+	q := make([]uint32, cols)
+	for j := range q {
+		q[j] = rng.Uint32()
+	}
+	rec := query(q)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp LWEQueryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if uint64(len(resp.Answer)) != 4*rows {
+		t.Fatalf("answer has %d bytes, want %d", len(resp.Answer), 4*rows)
+	}
+
+	// Row r is byte r%8 of the entries at row r/8 of every column
+	perCol := rows / 8
+	for r := uint64(0); r < rows; r++ {
+		var want uint32
+		for j := uint64(0); j < cols; j++ {
+			if i := j*perCol + r/8; i < 100 {
+				want += uint32(byte(snapshot[i]>>(8*(r%8)))) * q[j]
+			}
+		}
+		if got := binary.LittleEndian.Uint32(resp.Answer[4*r:]); got != want {
+			t.Fatalf("answer row %d = %d, want %d", r, got, want)
+		}
+	}
+
+	if rec := query(q[:cols-1]); rec.Code != http.StatusBadRequest {
+		t.Errorf("short query: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,307 @@
+package pirserver
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Verified DPF queries
+//
+// plinko-hint-generator (MERKLE_ROOT=true) publishes merkle-root.json, a
+// SHA-256 Merkle root over the entries of the epoch's hint.bin. A table
+// that has one for its epoch rebuilds the tree at load, refuses to start
+// if the roots differ, and answers POST /v1/query/dpf/verified: the same
+// DPF key shares as /v1/query/dpf, but over records
+//
+//	value (8 bytes LE) || sibling at level 0 || ... || sibling at level depth-1
+//
+// so the XOR of both servers' answers is the entry together with its
+// authentication path. The client recomputes the root from it and compares
+// it with the one it fetched from the CDN; a server that returns anything
+// else is caught, and neither server learns the index. As with LWE, the
+// tree describes hint.bin, so verified answers come from the epoch's
+// snapshot, not the delta-updated database: the tree is never re-committed
+// on deltas, and every answer names the epoch and block it is pinned to.
+//
+// Only the levels at and above a cut-off are kept, at most
+// TableConfig.MerkleNodes hashes (DefaultMerkleNodes: 64 MB); every level
+// stored would take 512 MB at 2^23 entries. The levels below are rebuilt
+// for each query, one subtree at a time, from the snapshot's values: a
+// query then costs about two SHA-256 per entry on top of the DPF
+// evaluation. Small tables keep their whole tree.
+//
+// Leaves are SHA-256(0x00 || index || value), inner nodes
+// SHA-256(0x01 || left || right), over 2^depth leaves (past the padded size
+// the value is 0). Must match plinko-hint-generator/hintgen/merkle.go and
+// plinko-client/merkle.go.
+
+const (
+	// MerkleRootFile is published next to a table's hint.bin when verified
+	// queries are enabled (MERKLE_ROOT=true in the hint generator)
+	MerkleRootFile = "merkle-root.json"
+
+	// DefaultMerkleNodes is how many tree nodes are kept in memory unless
+	// TableConfig.MerkleNodes says otherwise
+	DefaultMerkleNodes = 1 << 21
+)
+
+type merkleHash = [sha256.Size]byte
+
+// MerkleRoot is merkle-root.json
+type MerkleRoot struct {
+	Epoch   uint64 `json:"epoch"`
+	Entries uint64 `json:"entries"`
+	Depth   int    `json:"depth"`
+	Root    []byte `json:"root"` // base64 in JSON
+}
+
+// merkleTree holds the levels of the tree from low up, the root last
+type merkleTree struct {
+	entries []uint64 // Snapshot of the leaf values, padded size
+	depth   int
+	low     int            // Levels below are rebuilt for each query
+	levels  [][]merkleHash // levels[i] is level low+i
+	epoch   uint64         // Snapshot the tree commits to
+	block   uint64
+}
+
+// MerkleRecordSize returns the size of a verified answer for a tree of the
+// given depth
+func MerkleRecordSize(depth int) int {
+	return 8 + depth*sha256.Size
+}
+
+func merkleLeaf(index, value uint64) merkleHash {
+	var buf [17]byte
+	binary.LittleEndian.PutUint64(buf[1:], index)
+	binary.LittleEndian.PutUint64(buf[9:], value)
+	return sha256.Sum256(buf[:])
+}
+
+func merkleNode(left, right *merkleHash) merkleHash {
+	var buf [1 + 2*sha256.Size]byte
+	buf[0] = 0x01
+	copy(buf[1:], left[:])
+	copy(buf[1+sha256.Size:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// EnableMerkle answers verified DPF queries from a snapshot of the current
+// database, keeping DefaultMerkleNodes of the tree, and returns its root.
+// Call it before any deltas are applied, so the snapshot matches hint.bin.
+// Shards hold only part of the tree's leaves and never answer verified
+// queries; they get a nil root.
+func (s *PlinkoPIRServer) EnableMerkle() []byte {
+	return s.enableMerkle(DefaultMerkleNodes)
+}
+
+// enableMerkle is EnableMerkle keeping at most maxNodes tree nodes
+func (s *PlinkoPIRServer) enableMerkle(maxNodes int) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shard.Count > 0 {
+		return nil
+	}
+	n := uint64(len(s.database) / DBEntryLength)
+	tree := &merkleTree{
+		entries: make([]uint64, n),
+		depth:   int(DPFDomainBits(n)),
+		epoch:   s.epoch,
+		block:   s.lastBlock,
+	}
+	for i := range tree.entries {
+		tree.entries[i] = s.database[uint64(i)*DBEntryLength]
+	}
+	// Levels low..depth hold 2^(depth-low+1)-1 nodes
+	for tree.low < tree.depth && 1<<(tree.depth-tree.low+1)-1 > maxNodes {
+		tree.low++
+	}
+
+	level := make([]merkleHash, 1<<(tree.depth-tree.low))
+	scratch := make([]merkleHash, 1<<tree.low)
+	for b := range level {
+		level[b] = tree.subtree(b, scratch, nil, nil)
+	}
+	tree.levels = append(tree.levels, level)
+	for len(level) > 1 {
+		next := make([]merkleHash, len(level)/2)
+		for i := range next {
+			next[i] = merkleNode(&level[2*i], &level[2*i+1])
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	s.merkle = tree
+	root := level[0]
+	return root[:]
+}
+
+// subtree rebuilds the levels below low of subtree b, in nodes (2^low
+// hashes), and returns its root. With sel, the selection bits of its
+// leaves, it also folds the siblings of selected nodes into out's path
+// like answer, leaving the parity of sel in sel[0].
+func (t *merkleTree) subtree(b int, nodes []merkleHash, sel []uint8, out []byte) merkleHash {
+	first := uint64(b) << t.low
+	for i := range nodes {
+		var v uint64
+		if x := first + uint64(i); x < uint64(len(t.entries)) {
+			v = t.entries[x]
+		}
+		nodes[i] = merkleLeaf(first+uint64(i), v)
+	}
+	for l := 0; l < t.low; l++ {
+		if sel != nil {
+			foldSiblings(out[8+l*sha256.Size:8+(l+1)*sha256.Size], nodes, sel)
+			sel = sel[:len(sel)/2]
+		}
+		for i := 0; i < len(nodes)/2; i++ {
+			nodes[i] = merkleNode(&nodes[2*i], &nodes[2*i+1])
+		}
+		nodes = nodes[:len(nodes)/2]
+	}
+	return nodes[0]
+}
+
+// foldSiblings XORs into path the sibling of every node whose selection
+// bit is set, then replaces the first half of sel with the parity of each
+// pair: the selection bits of the next level up
+func foldSiblings(path []byte, level []merkleHash, sel []uint8) {
+	for m, bit := range sel {
+		if bit == 1 {
+			sibling := &level[m^1]
+			for i := range path {
+				path[i] ^= sibling[i]
+			}
+		}
+	}
+	for m := range sel[:len(sel)/2] {
+		sel[m] = sel[2*m] ^ sel[2*m+1]
+	}
+}
+
+// MerkleEnabled reports whether the server answers verified DPF queries
+func (s *PlinkoPIRServer) MerkleEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.merkle != nil
+}
+
+// readMerkleRoot reads dir/merkle-root.json, or returns nil if there is none
+func readMerkleRoot(dir string) (*MerkleRoot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MerkleRootFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var root MerkleRoot
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", MerkleRootFile, err)
+	}
+	return &root, nil
+}
+
+// answer returns the XOR of every record whose key share is 1. Rather than
+// XORing whole records, each level's siblings are folded in with the parity
+// of selected leaves below them, so a query touches every node once; only
+// the levels below low are hashed.
+func (t *merkleTree) answer(key *DPFKey) []byte {
+	out := make([]byte, MerkleRecordSize(t.depth))
+	sel := evalAll(key)
+
+	var value uint64
+	for x, bit := range sel[:len(t.entries)] {
+		if bit == 1 {
+			value ^= t.entries[x]
+		}
+	}
+	binary.LittleEndian.PutUint64(out, value)
+
+	if t.low > 0 {
+		// Subtree by subtree; each leaves its parity at its first bit
+		size := 1 << t.low
+		scratch := make([]merkleHash, size)
+		for b := 0; b < len(sel)/size; b++ {
+			t.subtree(b, scratch, sel[b*size:(b+1)*size], out)
+			sel[b] = sel[b*size]
+		}
+		sel = sel[:len(sel)/size]
+	}
+	for l := t.low; l < t.depth; l++ {
+		foldSiblings(out[8+l*sha256.Size:8+(l+1)*sha256.Size], t.levels[l-t.low], sel)
+		sel = sel[:len(sel)/2]
+	}
+	return out
+}
+
+// DPFVerifiedQueryResponse is one server's share of a verified record.
+// Verified answers are pinned to the snapshot the Merkle root was built
+// from: Epoch and Block name it, and stay the same as deltas are applied.
+type DPFVerifiedQueryResponse struct {
+	Record          []byte `json:"record"` // Value || authentication path, base64 in JSON
+	Epoch           uint64 `json:"epoch"`  // merkle-root.json's epoch
+	Block           uint64 `json:"block"`  // Last block in the snapshot
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+// dpfVerifiedQueryHandler answers a DPF key share over verified records
+// ⚠️  Privacy: Never logs the key or the returned share
+func (s *PlinkoPIRServer) dpfVerifiedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindDPFVerified, "POST")
+		return
+	}
+
+	s.mu.RLock()
+	tree := s.merkle
+	s.mu.RUnlock()
+	if tree == nil {
+		s.rejectAPI(w, QueryKindDPFVerified, http.StatusNotFound, APIError{
+			Code:    ErrCodeNotFound,
+			Message: "Verified queries are not enabled for this table",
+		})
+		return
+	}
+
+	var req DPFQueryRequest
+	if !s.decodeJSON(w, r, QueryKindDPFVerified, &req) {
+		return
+	}
+
+	var key DPFKey
+	depth := uint8(tree.depth)
+	if err := key.UnmarshalBinary(req.Key); err != nil || key.Bits != depth {
+		s.rejectAPI(w, QueryKindDPFVerified, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("DPF key must cover a %d-bit domain (%d bytes)", depth, DPFKeySize(depth)),
+		})
+		return
+	}
+
+	startTime := time.Now()
+	record := tree.answer(&key)
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindDPFVerified,
+		Entries: len(tree.entries),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DPFVerifiedQueryResponse{
+		Record:          record,
+		Epoch:           tree.epoch,
+		Block:           tree.block,
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	})
+}
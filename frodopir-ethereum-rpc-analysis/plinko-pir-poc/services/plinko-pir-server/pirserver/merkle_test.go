@@ -0,0 +1,162 @@
+package pirserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testMerkleRoot hashes entries (raw, as in hint.bin) up to the root the
+// way the hint generator does
+func testMerkleRoot(entries []byte, depth int) []byte {
+	var node func(level int, index uint64) []byte
+	node = func(level int, index uint64) []byte {
+		if level == 0 {
+			buf := binary.LittleEndian.AppendUint64([]byte{0x00}, index)
+			if off := index * DBEntrySize; off < uint64(len(entries)) {
+				buf = append(buf, entries[off:off+DBEntrySize]...)
+			} else {
+				buf = binary.LittleEndian.AppendUint64(buf, 0)
+			}
+			sum := sha256.Sum256(buf)
+			return sum[:]
+		}
+		buf := append([]byte{0x01}, node(level-1, 2*index)...)
+		sum := sha256.Sum256(append(buf, node(level-1, 2*index+1)...))
+		return sum[:]
+	}
+	return node(depth, 0)
+}
+
+func writeMerkleRoot(t *testing.T, dir string, root MerkleRoot) {
+	t.Helper()
+	data, _ := json.Marshal(root)
+	if err := os.WriteFile(filepath.Join(dir, MerkleRootFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifiedDPFQuery(t *testing.T) {
+	const chunkSize, setSize, depth = 8, 6, 6 // 48 entries under 64 leaves
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	entries := writeHintFile(t, dir, chunkSize, setSize, 4, 1000)
+	root := testMerkleRoot(entries, depth)
+	writeMerkleRoot(t, dir, MerkleRoot{Epoch: 4, Entries: chunkSize * setSize, Depth: depth, Root: root})
+
+	// The whole tree, only levels 3 and up, only the root
+	for _, c := range []struct{ nodes, low int }{{0, 0}, {15, 3}, {1, depth}} {
+		nodes, low := c.nodes, c.low
+		s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir, MerkleNodes: nodes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetLimits(noRateLimits()) // Both key shares go to this one server
+		if !s.MerkleEnabled() || !slices.Contains(s.queryTypes(), QueryKindDPFVerified) {
+			t.Fatal("verified queries not enabled with a matching merkle-root.json")
+		}
+		stored := 0
+		for _, level := range s.merkle.levels {
+			stored += len(level)
+		}
+		if stored != 1<<(depth-low+1)-1 {
+			t.Fatalf("%d nodes allowed: levels %d and up kept, %d nodes", nodes, s.merkle.low, stored)
+		}
+		// Verified answers come from the epoch's snapshot, and say so
+		writeDelta(t, dir, 1, [][3]uint64{{5, s.database[5*DBEntryLength], 7}})
+		if _, err := s.SyncDeltas(); err != nil {
+			t.Fatal(err)
+		}
+
+		query := func(key *DPFKey) (*httptest.ResponseRecorder, []byte) {
+			data, _ := key.MarshalBinary()
+			body := fmt.Sprintf(`{"key": %q}`, base64.StdEncoding.EncodeToString(data))
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, APIPrefix+"/query/dpf/verified", strings.NewReader(body)))
+			var resp DPFVerifiedQueryResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if rec.Code == http.StatusOK && (resp.Epoch != 4 || resp.Block != 0) {
+				t.Errorf("answer pinned to epoch %d block %d, want epoch 4 block 0", resp.Epoch, resp.Block)
+			}
+			return rec, resp.Record
+		}
+
+		for _, index := range []uint64{0, 5, 31, 47} {
+			k0, k1, err := GenDPF(index, depth, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec0, a := query(k0)
+			rec1, b := query(k1)
+			if rec0.Code != http.StatusOK || rec1.Code != http.StatusOK || len(a) != MerkleRecordSize(depth) || len(b) != len(a) {
+				t.Fatalf("index %d: status %d/%d, %d/%d-byte records", index, rec0.Code, rec1.Code, len(a), len(b))
+			}
+			for i := range a {
+				a[i] ^= b[i]
+			}
+			if !bytes.Equal(a[:8], entries[index*DBEntrySize:(index+1)*DBEntrySize]) {
+				t.Errorf("index %d: value %x", index, a[:8])
+			}
+
+			// Walking the path back up must reach the published root
+			h := merkleLeaf(index, binary.LittleEndian.Uint64(a))
+			for l := 0; l < depth; l++ {
+				var sibling merkleHash
+				copy(sibling[:], a[8+l*sha256.Size:])
+				if index>>l&1 == 0 {
+					h = merkleNode(&h, &sibling)
+				} else {
+					h = merkleNode(&sibling, &h)
+				}
+			}
+			if !bytes.Equal(h[:], root) {
+				t.Errorf("index %d: path leads to %x, root is %x", index, h, root)
+			}
+		}
+	}
+	s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(key *DPFKey) *httptest.ResponseRecorder {
+		data, _ := key.MarshalBinary()
+		body := fmt.Sprintf(`{"key": %q}`, base64.StdEncoding.EncodeToString(data))
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, APIPrefix+"/query/dpf/verified", strings.NewReader(body)))
+		return rec
+	}
+
+	k0, _, _ := GenDPF(3, depth-1, rand.Reader)
+	if rec := query(k0); rec.Code != http.StatusBadRequest {
+		t.Errorf("key for a %d-bit domain: status %d", depth-1, rec.Code)
+	}
+
+	// A root for an older epoch is skipped, a wrong one stops the load
+	writeMerkleRoot(t, dir, MerkleRoot{Epoch: 3, Root: root})
+	if s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir}); err != nil || s.MerkleEnabled() {
+		t.Errorf("LoadTable with an old merkle-root.json: %v, verified %v", err, err == nil && s.MerkleEnabled())
+	}
+	writeMerkleRoot(t, dir, MerkleRoot{Epoch: 4, Root: make([]byte, sha256.Size)})
+	if _, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir}); err == nil {
+		t.Error("LoadTable accepted a merkle-root.json that does not match hint.bin")
+	}
+
+	// Without a root the route is there but refuses
+	rec := httptest.NewRecorder()
+	NewPlinkoPIRServer(make([]uint64, 16), 16, 4, 4, 0).Handler().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, APIPrefix+"/query/dpf/verified", strings.NewReader(`{"key": ""}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("verified query without a root: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,226 @@
+package pirserver
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus metrics in the text exposition format
+//
+// Only fixed label values are used (query kinds from QueryKind). Nothing a
+// client sends is ever turned into a label, so metrics cannot leak queries.
+
+// DefaultLatencyBuckets covers sub-millisecond plaintext lookups up to slow
+// FullSet queries on large databases (seconds)
+var DefaultLatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Inc()          { g.Add(1) }
+func (g *Gauge) Dec()          { g.Add(-1) }
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // Upper bounds, ascending
+	counts  []uint64  // counts[i] = observations <= buckets[i]
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{buckets: b, counts: make([]uint64, len(b))}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, ub := range h.buckets {
+		if v <= ub {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveDuration records a duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, ub := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(ub), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(labels), h.count)
+}
+
+// ServerMetrics holds every metric exported by the PIR server
+type ServerMetrics struct {
+	queryLatency  map[QueryKind]*Histogram // Fixed set of kinds, filled at construction
+	queryRejected map[QueryKind]*Counter
+	inFlight      Gauge
+	dbEntries     Gauge
+	dbBytes       Gauge
+	chunkSize     Gauge
+	setSize       Gauge
+}
+
+// queryKinds lists every QueryKind that gets its own metric series
+var queryKinds = []QueryKind{QueryKindPlaintext, QueryKindFullSet, QueryKindSetParity, QueryKindPunctured, QueryKindBatch, QueryKindDPF, QueryKindLWE, QueryKindDPFVerified}
+
+// NewServerMetrics creates metrics for a server with the given parameters
+func NewServerMetrics(dbEntries, chunkSize, setSize uint64) *ServerMetrics {
+	m := &ServerMetrics{
+		queryLatency:  make(map[QueryKind]*Histogram),
+		queryRejected: make(map[QueryKind]*Counter),
+	}
+	for _, kind := range queryKinds {
+		m.queryLatency[kind] = NewHistogram(DefaultLatencyBuckets)
+		m.queryRejected[kind] = &Counter{}
+	}
+	m.dbEntries.Set(float64(dbEntries))
+	m.dbBytes.Set(float64(dbEntries * DBEntrySize))
+	m.chunkSize.Set(float64(chunkSize))
+	m.setSize.Set(float64(setSize))
+	return m
+}
+
+// instrument wraps a query handler with latency, in-flight and rejection metrics
+func (m *ServerMetrics) instrument(kind QueryKind, next http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return next
+	}
+	latency, known := m.queryLatency[kind]
+	if !known {
+		return next
+	}
+	rejected := m.queryRejected[kind]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next(rec, r)
+
+		if rec.status >= http.StatusBadRequest {
+			rejected.Inc()
+			return
+		}
+		latency.ObserveDuration(time.Since(start))
+	}
+}
+
+// Write writes all metrics in Prometheus text format
+func (m *ServerMetrics) Write(w io.Writer) {
+	fmt.Fprintln(w, "# HELP plinko_pir_query_duration_seconds Query handling time by query type.")
+	fmt.Fprintln(w, "# TYPE plinko_pir_query_duration_seconds histogram")
+	for _, kind := range queryKinds {
+		m.queryLatency[kind].write(w, "plinko_pir_query_duration_seconds",
+			fmt.Sprintf("query_type=%q", kind))
+	}
+
+	fmt.Fprintln(w, "# HELP plinko_pir_query_rejected_total Queries rejected with a 4xx/5xx status by query type.")
+	fmt.Fprintln(w, "# TYPE plinko_pir_query_rejected_total counter")
+	for _, kind := range queryKinds {
+		fmt.Fprintf(w, "plinko_pir_query_rejected_total{query_type=%q} %d\n", kind, m.queryRejected[kind].Value())
+	}
+
+	writeGauge(w, "plinko_pir_queries_in_flight", "Queries currently being processed.", m.inFlight.Value())
+	writeGauge(w, "plinko_pir_db_entries", "Number of database entries loaded.", m.dbEntries.Value())
+	writeGauge(w, "plinko_pir_db_size_bytes", "Size of the in-memory database in bytes.", m.dbBytes.Value())
+	writeGauge(w, "plinko_pir_chunk_size", "Plinko PIR chunk size.", m.chunkSize.Value())
+	writeGauge(w, "plinko_pir_set_size", "Plinko PIR set size.", m.setSize.Value())
+}
+
+// metricsHandler serves the Prometheus scrape endpoint
+func (s *PlinkoPIRServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		writeAPIError(w, http.StatusNotFound, APIError{
+			Code:    ErrCodeNotFound,
+			Message: "Metrics are disabled",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.Write(w)
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	s := fmt.Sprintf("%g", v)
+	if strings.Contains(s, "e+") {
+		s = fmt.Sprintf("%.0f", v)
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package pirserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(&bytes.Buffer{})
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.path, rt.handler)
+	}
+
+	send := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(http.MethodPost, "/query/fullset", fmt.Sprintf(`{"prf_key": %s}`, byteArrayJSON(testPRFKey)))
+	send(http.MethodPost, "/query/fullset", `{"prf_key": [1, 2, 3]}`)
+	send(http.MethodGet, fmt.Sprintf("/query/plaintext?index=%d", testSecretIdx), "")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		`plinko_pir_query_duration_seconds_count{query_type="fullset"} 1`,
+		`plinko_pir_query_duration_seconds_count{query_type="plaintext"} 1`,
+		`plinko_pir_query_duration_seconds_count{query_type="setparity"} 0`,
+		`plinko_pir_query_rejected_total{query_type="fullset"} 1`,
+		`plinko_pir_queries_in_flight 0`,
+		fmt.Sprintf("plinko_pir_db_entries %d", testChunkSize*testSetSize),
+		fmt.Sprintf("plinko_pir_db_size_bytes %d", testChunkSize*testSetSize*DBEntrySize),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+
+	// Every label value must come from the fixed set of query kinds
+	for _, line := range strings.Split(out, "\n") {
+		i := strings.Index(line, `query_type="`)
+		if i < 0 {
+			continue
+		}
+		value := line[i+len(`query_type="`):]
+		value = value[:strings.IndexByte(value, '"')]
+		if !isQueryKind(QueryKind(value)) {
+			t.Errorf("unexpected query_type label %q in %q", value, line)
+		}
+	}
+}
+
+func isQueryKind(kind QueryKind) bool {
+	for _, k := range queryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
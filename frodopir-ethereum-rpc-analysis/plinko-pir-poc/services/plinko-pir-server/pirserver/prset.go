@@ -1,4 +1,4 @@
-package main
+package pirserver
 
 import (
 	"encoding/binary"
@@ -0,0 +1,198 @@
+package pirserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Punctured queries (Piano-style online phase)
+//
+// The client holds a hint: a PRF key whose set has one offset per chunk and
+// the XOR of the database entries at those offsets. To read index x in chunk
+// c it removes chunk c from the set and sends the remaining setSize-1
+// offsets in chunk order, without saying which chunk was removed. The server
+// answers every possible placement: parities[j] is the XOR over all chunks
+// except j, where chunks before j take offsets[0..j-1] and chunks after j
+// take offsets[j..]. The client keeps parities[c] and discards the rest.
+
+type PuncturedQueryRequest struct {
+	Offsets []uint64 `json:"offsets"` // setSize-1 in-chunk offsets
+}
+
+type PuncturedQueryResponse struct {
+	Parities        []uint64 `json:"parities"` // setSize candidate parities
+	ServerTimeNanos uint64   `json:"server_time_nanos"`
+}
+
+// Batches carry several punctured queries answered against one database
+// snapshot, e.g. every candidate bucket of a keyword (address) lookup. They
+// count as one request against the rate limit, so MaxBatch stays small.
+
+type BatchQueryRequest struct {
+	Queries []PuncturedQueryRequest `json:"queries"`
+}
+
+type BatchQueryResponse struct {
+	Parities        [][]uint64 `json:"parities"` // One setSize list per query, in request order
+	ServerTimeNanos uint64     `json:"server_time_nanos"`
+}
+
+// puncturedQueryHandler handles punctured set queries
+// ⚠️  Privacy: Never logs the offsets or the returned parities
+func (s *PlinkoPIRServer) puncturedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindPunctured, "POST")
+		return
+	}
+
+	var req PuncturedQueryRequest
+	if !s.decodeJSON(w, r, QueryKindPunctured, &req) {
+		return
+	}
+
+	if apiErr := s.checkOffsets(req.Offsets); apiErr != nil {
+		s.rejectAPI(w, QueryKindPunctured, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	startTime := time.Now()
+	parities, block := s.HandlePuncturedQuery(req.Offsets)
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindPunctured,
+		Entries: len(req.Offsets),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	resp := PuncturedQueryResponse{
+		Parities:        parities,
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	}
+
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchQueryHandler answers several punctured queries at once
+// ⚠️  Privacy: Never logs the offsets or the returned parities
+func (s *PlinkoPIRServer) batchQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindBatch, "POST")
+		return
+	}
+
+	var req BatchQueryRequest
+	if !s.decodeJSON(w, r, QueryKindBatch, &req) {
+		return
+	}
+
+	if len(req.Queries) == 0 || len(req.Queries) > s.limits.maxBatch() {
+		s.rejectAPI(w, QueryKindBatch, http.StatusBadRequest, APIError{
+			Code:    ErrCodeTooManyQueries,
+			Message: fmt.Sprintf("A batch holds 1 to %d queries", s.limits.maxBatch()),
+		})
+		return
+	}
+	offsets := make([][]uint64, len(req.Queries))
+	for i, q := range req.Queries {
+		if apiErr := s.checkOffsets(q.Offsets); apiErr != nil {
+			s.rejectAPI(w, QueryKindBatch, http.StatusBadRequest, *apiErr)
+			return
+		}
+		offsets[i] = q.Offsets
+	}
+
+	startTime := time.Now()
+	parities, block := s.HandleBatchQuery(offsets)
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindBatch,
+		Entries: len(offsets) * int(s.setSize-1),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	resp := BatchQueryResponse{
+		Parities:        parities,
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	}
+
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkOffsets validates the offsets of one punctured query
+func (s *PlinkoPIRServer) checkOffsets(offsets []uint64) *APIError {
+	if s.setSize == 0 || uint64(len(offsets)) != s.setSize-1 {
+		return &APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("Punctured query needs exactly %d offsets", s.setSize-1),
+		}
+	}
+	for _, off := range offsets {
+		if off >= s.chunkSize {
+			return &APIError{
+				Code:    ErrCodeInvalidIndex,
+				Message: fmt.Sprintf("Offsets must be below the chunk size (%d)", s.chunkSize),
+			}
+		}
+	}
+	return nil
+}
+
+// HandleBatchQuery answers every query in offsets under one read lock, so
+// all answers come from the same database version, and returns the last
+// delta block they reflect
+func (s *PlinkoPIRServer) HandleBatchQuery(offsets [][]uint64) ([][]uint64, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	parities := make([][]uint64, len(offsets))
+	for i, o := range offsets {
+		parities[i] = s.puncturedParities(o)
+	}
+	return parities, s.lastBlock
+}
+
+// HandlePuncturedQuery returns the parity for every possible punctured chunk.
+// With prefix[j] the XOR of chunks 0..j-1 (using offsets[0..j-1]) and
+// suffix[j] the XOR of chunks j+1.. (using offsets[j..]), parities[j] is
+// prefix[j] ^ suffix[j], so the whole answer costs 2*setSize lookups. The
+// last delta block the answer reflects is returned with it.
+func (s *PlinkoPIRServer) HandlePuncturedQuery(offsets []uint64) ([]uint64, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.puncturedParities(offsets), s.lastBlock
+}
+
+// puncturedParities computes a punctured answer (callers hold s.mu)
+func (s *PlinkoPIRServer) puncturedParities(offsets []uint64) []uint64 {
+	k := len(offsets) + 1
+	parities := make([]uint64, k)
+
+	// Forward pass: parities[j] = prefix[j]
+	var acc uint64
+	for j := 0; j < k; j++ {
+		parities[j] = acc
+		if j < k-1 {
+			acc ^= s.DBAccess(uint64(j)*s.chunkSize + offsets[j])[0]
+		}
+	}
+
+	// Backward pass: fold in suffix[j]
+	acc = 0
+	for j := k - 1; j >= 0; j-- {
+		parities[j] ^= acc
+		if j > 0 {
+			acc ^= s.DBAccess(uint64(j)*s.chunkSize + offsets[j-1])[0]
+		}
+	}
+	return parities
+}
@@ -0,0 +1,134 @@
+package pirserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPuncturedQueryParities(t *testing.T) {
+	s := newTestServer(&bytes.Buffer{})
+	mux := newTestMux(s)
+
+	offsets := make([]uint64, testSetSize-1)
+	for i := range offsets {
+		offsets[i] = uint64(i*29+5) % testChunkSize
+	}
+	body, _ := json.Marshal(PuncturedQueryRequest{Offsets: offsets})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/punctured", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	var resp PuncturedQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Parities) != testSetSize {
+		t.Fatalf("got %d parities, want %d", len(resp.Parities), testSetSize)
+	}
+
+	// Brute force: skip chunk j, place the offsets on the remaining chunks in order
+	for j := 0; j < testSetSize; j++ {
+		var want uint64
+		next := 0
+		for c := 0; c < testSetSize; c++ {
+			if c == j {
+				continue
+			}
+			want ^= s.database[uint64(c)*testChunkSize+offsets[next]]
+			next++
+		}
+		if resp.Parities[j] != want {
+			t.Errorf("parities[%d] = %#x, want %#x", j, resp.Parities[j], want)
+		}
+	}
+}
+
+func TestPuncturedQueryValidation(t *testing.T) {
+	mux := newTestMux(newTestServer(&bytes.Buffer{}))
+
+	tooFew, _ := json.Marshal(PuncturedQueryRequest{Offsets: make([]uint64, testSetSize-2)})
+	outOfRange := make([]uint64, testSetSize-1)
+	outOfRange[3] = testChunkSize
+	badOffset, _ := json.Marshal(PuncturedQueryRequest{Offsets: outOfRange})
+
+	for _, tt := range []struct {
+		body     []byte
+		wantCode string
+	}{
+		{tooFew, ErrCodeInvalidRequest},
+		{badOffset, ErrCodeInvalidIndex},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/punctured", bytes.NewReader(tt.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+		if got := decodeAPIError(t, rec); got.Code != tt.wantCode {
+			t.Errorf("error code = %q, want %q", got.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+	s := newTestServer(&bytes.Buffer{})
+	mux := newTestMux(s)
+
+	queries := make([]PuncturedQueryRequest, 3)
+	for q := range queries {
+		queries[q].Offsets = make([]uint64, testSetSize-1)
+		for i := range queries[q].Offsets {
+			queries[q].Offsets[i] = uint64(i*13+q*7) % testChunkSize
+		}
+	}
+	body, _ := json.Marshal(BatchQueryRequest{Queries: queries})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/punctured/batch", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var resp BatchQueryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Parities) != len(queries) {
+		t.Fatalf("got %d answers, want %d", len(resp.Parities), len(queries))
+	}
+	for q := range queries {
+		want, _ := s.HandlePuncturedQuery(queries[q].Offsets)
+		for j := range want {
+			if resp.Parities[q][j] != want[j] {
+				t.Fatalf("query %d parities[%d] = %#x, want %#x", q, j, resp.Parities[q][j], want[j])
+			}
+		}
+	}
+
+	// Empty, oversized and malformed batches
+	tooMany := make([]PuncturedQueryRequest, DefaultLimits().MaxBatch+1)
+	for i := range tooMany {
+		tooMany[i] = queries[0]
+	}
+	bad := append([]PuncturedQueryRequest{queries[0]}, PuncturedQueryRequest{Offsets: []uint64{1}})
+	for _, tt := range []struct {
+		queries  []PuncturedQueryRequest
+		wantCode string
+	}{
+		{nil, ErrCodeTooManyQueries},
+		{tooMany, ErrCodeTooManyQueries},
+		{bad, ErrCodeInvalidRequest},
+	} {
+		body, _ := json.Marshal(BatchQueryRequest{Queries: tt.queries})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/query/punctured/batch", bytes.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%d queries: status = %d, want 400", len(tt.queries), rec.Code)
+		}
+		if got := decodeAPIError(t, rec); got.Code != tt.wantCode {
+			t.Errorf("%d queries: error code = %q, want %q", len(tt.queries), got.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package pirserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Leader/follower replication
+//
+// Replicas of a table serve the same database, version for version. The
+// leader follows plinko-update-service's deltas/ as a standalone server
+// does, and serves the delta files it has applied as its update log.
+// Followers load the same hint.bin, tail the log with FollowLeader and
+// acknowledge each height they reach. The leader's status lists every
+// replica's epoch and acknowledged block; a Router (router.go) reads it to
+// send each query only to replicas at the version the client asked for.
+//
+// The endpoints answer 404 unless EnableReplication was called, and with a
+// token they require "Authorization: Bearer <token>":
+//
+//	GET  /v1/replication/log?after=N  applied delta files after block N
+//	POST /v1/replication/ack          a follower's epoch and height
+//	GET  /v1/replication/status       the leader's and replicas' heights
+
+const maxLogBlocks = 64 // Delta files per log response
+
+// ReplicatedBlock is one delta file from the leader's update log
+type ReplicatedBlock struct {
+	Block uint64 `json:"block"`
+	Delta []byte `json:"delta"` // Delta file as published, base64 in JSON
+}
+
+// ReplicationLogResponse carries the blocks after the requested height, up
+// to the leader's own
+type ReplicationLogResponse struct {
+	Epoch     uint64            `json:"epoch"`
+	LastBlock uint64            `json:"last_block"` // Leader's height
+	Blocks    []ReplicatedBlock `json:"blocks"`
+}
+
+// ReplicaAck is a follower's report of the version it serves
+type ReplicaAck struct {
+	Replica   string `json:"replica"` // URL the replica serves the table at
+	Epoch     uint64 `json:"epoch"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// ReplicaStatus is the last acknowledgement from one replica
+type ReplicaStatus struct {
+	ReplicaAck
+	AckedAt time.Time `json:"acked_at"`
+}
+
+// ReplicationStatus is the leader's view of its replicas
+type ReplicationStatus struct {
+	Epoch     uint64          `json:"epoch"`
+	LastBlock uint64          `json:"last_block"` // Leader's height
+	Replicas  []ReplicaStatus `json:"replicas"`   // Sorted by URL
+}
+
+// replication is the leader's state
+type replication struct {
+	token string
+
+	mu   sync.Mutex
+	acks map[string]ReplicaStatus
+}
+
+// EnableReplication makes s a leader: it serves its applied deltas to
+// followers and records their acknowledgements. A non-empty token is
+// required from followers and routers. The table must have been loaded
+// with LoadTable, whose deltas/ directory is the log. Call it before
+// serving.
+func (s *PlinkoPIRServer) EnableReplication(token string) error {
+	if s.dir == "" {
+		return errors.New("replication needs a table loaded with LoadTable")
+	}
+	s.repl = &replication{token: token, acks: make(map[string]ReplicaStatus)}
+	return nil
+}
+
+// replicationAllowed checks that replication is on and the request has the
+// token. On failure it sends the error response and returns false.
+func (s *PlinkoPIRServer) replicationAllowed(w http.ResponseWriter, r *http.Request, method string) bool {
+	if s.repl == nil {
+		writeAPIError(w, http.StatusNotFound, APIError{
+			Code:    ErrCodeNotFound,
+			Message: "Replication is not enabled on this server",
+		})
+		return false
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: "Method not allowed; use " + method,
+		})
+		return false
+	}
+	if s.repl.token != "" {
+		want := []byte("Bearer " + s.repl.token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, APIError{
+				Code:    ErrCodeUnauthorized,
+				Message: "Missing or wrong replication token",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// replicationLogHandler serves the delta files after ?after= that the
+// leader has applied
+func (s *PlinkoPIRServer) replicationLogHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.replicationAllowed(w, r, http.MethodGet) {
+		return
+	}
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeMissingParameter,
+			Message: "after must be a block number",
+		})
+		return
+	}
+
+	resp := ReplicationLogResponse{Epoch: s.epoch, LastBlock: s.LastBlock(), Blocks: []ReplicatedBlock{}}
+	for block := after + 1; block <= resp.LastBlock && len(resp.Blocks) < maxLogBlocks; block++ {
+		data, err := os.ReadFile(filepath.Join(s.dir, "deltas", fmt.Sprintf("delta-%06d.bin", block)))
+		if err != nil {
+			// Applied deltas stay published for the epoch, so this is a
+			// broken volume, not a race
+			writeAPIError(w, http.StatusInternalServerError, APIError{
+				Code:      ErrCodeInternal,
+				Message:   "Applied delta file is missing from the log",
+				Retryable: true,
+			})
+			return
+		}
+		resp.Blocks = append(resp.Blocks, ReplicatedBlock{Block: block, Delta: data})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// replicationAckHandler records a follower's height
+func (s *PlinkoPIRServer) replicationAckHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.replicationAllowed(w, r, http.MethodPost) {
+		return
+	}
+	var ack ReplicaAck
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&ack); err != nil || ack.Replica == "" {
+		writeAPIError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Acknowledgement needs replica, epoch and last_block",
+		})
+		return
+	}
+	s.repl.mu.Lock()
+	s.repl.acks[ack.Replica] = ReplicaStatus{ReplicaAck: ack, AckedAt: time.Now().UTC()}
+	s.repl.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReplicationStatus returns the leader's height and every replica's last
+// acknowledgement
+func (s *PlinkoPIRServer) ReplicationStatus() ReplicationStatus {
+	status := ReplicationStatus{Epoch: s.epoch, LastBlock: s.LastBlock(), Replicas: []ReplicaStatus{}}
+	if s.repl == nil {
+		return status
+	}
+	s.repl.mu.Lock()
+	for _, st := range s.repl.acks {
+		status.Replicas = append(status.Replicas, st)
+	}
+	s.repl.mu.Unlock()
+	sort.Slice(status.Replicas, func(i, j int) bool {
+		return status.Replicas[i].Replica < status.Replicas[j].Replica
+	})
+	return status
+}
+
+// replicationStatusHandler serves ReplicationStatus
+func (s *PlinkoPIRServer) replicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.replicationAllowed(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.ReplicationStatus())
+}
+
+// Follower configures FollowLeader
+type Follower struct {
+	Leader  string       // Leader's URL for the table, e.g. http://leader:3000/tables/eth-balances
+	Replica string       // URL this server serves the table at, reported in acks
+	Token   string       // Replication token, if the leader has one
+	Client  *http.Client // Defaults to http.DefaultClient
+}
+
+// SyncFromLeader applies every block in the leader's log after LastBlock
+// and acknowledges the height reached. It returns ErrEpochChanged when the
+// leader serves a different epoch.
+func (s *PlinkoPIRServer) SyncFromLeader(ctx context.Context, f Follower) (applied int, err error) {
+	for {
+		var log ReplicationLogResponse
+		path := fmt.Sprintf("%s/replication/log?after=%d", APIPrefix, s.LastBlock())
+		if err := f.do(ctx, http.MethodGet, path, nil, &log); err != nil {
+			return applied, err
+		}
+		if log.Epoch != s.epoch {
+			return applied, ErrEpochChanged
+		}
+		for _, b := range log.Blocks {
+			if want := s.LastBlock() + 1; b.Block != want {
+				return applied, fmt.Errorf("leader sent block %d, want %d", b.Block, want)
+			}
+			if err := s.applyDeltaFile(b.Block, b.Delta); err != nil {
+				return applied, fmt.Errorf("block %d from leader: %w", b.Block, err)
+			}
+			applied++
+		}
+		if len(log.Blocks) == 0 || s.LastBlock() >= log.LastBlock {
+			break
+		}
+	}
+
+	ack := ReplicaAck{Replica: f.Replica, Epoch: s.epoch, LastBlock: s.LastBlock()}
+	return applied, f.do(ctx, http.MethodPost, APIPrefix+"/replication/ack", ack, nil)
+}
+
+// FollowLeader calls SyncFromLeader every interval until ctx is cancelled
+// (and returns nil) or the leader moves to a new epoch. Other failures,
+// such as the leader being unreachable, are retried on the next tick.
+func (s *PlinkoPIRServer) FollowLeader(ctx context.Context, f Follower, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.SyncFromLeader(ctx, f); errors.Is(err, ErrEpochChanged) {
+			return err
+		} else if err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// do sends one replication request to the leader
+func (f Follower) do(ctx context.Context, method, path string, in, out interface{}) error {
+	return replicationCall(ctx, f.Client, f.Token, method, f.Leader+path, in, out)
+}
+
+// replicationCall sends a replication request with the token and decodes
+// a JSON reply into out (when not nil)
+func replicationCall(ctx context.Context, client *http.Client, token, method, url string, in, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var body io.Reader = http.NoBody
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var env ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&env) == nil && env.Error.Code != "" {
+			return fmt.Errorf("%s: %s (%d %s)", url, env.Error.Message, resp.StatusCode, env.Error.Code)
+		}
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
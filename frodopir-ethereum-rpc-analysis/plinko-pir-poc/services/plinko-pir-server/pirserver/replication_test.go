@@ -0,0 +1,199 @@
+package pirserver
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// replicaSet is a leader and followers of one table, each behind its own
+// HTTP server
+type replicaSet struct {
+	dir       string
+	leader    *PlinkoPIRServer
+	followers []*PlinkoPIRServer
+	urls      []string // Leader first
+}
+
+const testReplicationToken = "s3cret-token"
+
+func newReplicaSet(t *testing.T, followers int) *replicaSet {
+	t.Helper()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	buf := binary.LittleEndian.AppendUint64(nil, 16)
+	buf = binary.LittleEndian.AppendUint64(buf, 4) // Chunk size
+	buf = binary.LittleEndian.AppendUint64(buf, 4) // Set size
+	buf = binary.LittleEndian.AppendUint64(buf, 2) // Epoch
+	for i := uint64(0); i < 16; i++ {
+		buf = binary.LittleEndian.AppendUint64(buf, 100+i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TableHintFile), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &replicaSet{dir: dir}
+	for i := 0; i <= followers; i++ {
+		// Followers load their own copy of hint.bin; here it is the same file
+		s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		if i == 0 {
+			if err := s.EnableReplication(testReplicationToken); err != nil {
+				t.Fatal(err)
+			}
+			rs.leader = s
+		} else {
+			rs.followers = append(rs.followers, s)
+		}
+		rs.urls = append(rs.urls, ts.URL)
+	}
+	return rs
+}
+
+// follower returns follower i's replication settings
+func (rs *replicaSet) follower(i int) Follower {
+	return Follower{Leader: rs.urls[0], Replica: rs.urls[i+1], Token: testReplicationToken}
+}
+
+func TestFollowersTailLeader(t *testing.T) {
+	rs := newReplicaSet(t, 2)
+	ctx := context.Background()
+
+	writeDelta(t, rs.dir, 1, [][3]uint64{{3, 103, 7}})
+	writeDelta(t, rs.dir, 2, [][3]uint64{{3, 7, 8}, {12, 112, 9}})
+	if n, err := rs.leader.SyncDeltas(); n != 2 || err != nil {
+		t.Fatalf("leader SyncDeltas = %d, %v", n, err)
+	}
+	writeDelta(t, rs.dir, 3, [][3]uint64{{0, 100, 1}}) // Published, not yet applied by the leader
+
+	// Followers only get what the leader has applied
+	for i, f := range rs.followers {
+		if n, err := f.SyncFromLeader(ctx, rs.follower(i)); n != 2 || err != nil {
+			t.Fatalf("follower %d SyncFromLeader = %d, %v", i, n, err)
+		}
+		if f.LastBlock() != 2 || !slices.Equal(f.database, rs.leader.database) {
+			t.Fatalf("follower %d at block %d: %v, leader %v", i, f.LastBlock(), f.database, rs.leader.database)
+		}
+	}
+	if n, err := rs.followers[0].SyncFromLeader(ctx, rs.follower(0)); n != 0 || err != nil {
+		t.Errorf("SyncFromLeader with nothing new = %d, %v", n, err)
+	}
+
+	status := rs.leader.ReplicationStatus()
+	if status.Epoch != 2 || status.LastBlock != 2 || len(status.Replicas) != 2 {
+		t.Fatalf("status %+v", status)
+	}
+	for _, r := range status.Replicas {
+		if r.Epoch != 2 || r.LastBlock != 2 || r.AckedAt.IsZero() {
+			t.Errorf("replica %+v", r)
+		}
+	}
+
+	// The log and acks need the token
+	bad := rs.follower(0)
+	bad.Token = "wrong"
+	if _, err := rs.followers[0].SyncFromLeader(ctx, bad); err == nil || !strings.Contains(err.Error(), ErrCodeUnauthorized) {
+		t.Errorf("SyncFromLeader with a wrong token = %v", err)
+	}
+	// Servers that are not leaders have no log
+	notLeader := Follower{Leader: rs.urls[1], Replica: rs.urls[2]}
+	if _, err := rs.followers[1].SyncFromLeader(ctx, notLeader); err == nil || !strings.Contains(err.Error(), ErrCodeNotFound) {
+		t.Errorf("SyncFromLeader from a follower = %v", err)
+	}
+
+	// A leader on another epoch stops the follower
+	other := NewPlinkoPIRServer(make([]uint64, 16), 16, 4, 4, 3)
+	other.dir = rs.dir
+	other.EnableReplication("")
+	ts := httptest.NewServer(other.Handler())
+	defer ts.Close()
+	if _, err := rs.followers[0].SyncFromLeader(ctx, Follower{Leader: ts.URL, Replica: "x"}); err != ErrEpochChanged {
+		t.Errorf("SyncFromLeader across epochs = %v, want ErrEpochChanged", err)
+	}
+}
+
+func TestRouterPinsVersion(t *testing.T) {
+	rs := newReplicaSet(t, 2)
+	ctx := context.Background()
+	router := NewRouter(rs.urls[0], testReplicationToken, nil)
+	front := router.Handler()
+
+	query := func(epoch, block string) (*httptest.ResponseRecorder, SetParityQueryResponse) {
+		req := httptest.NewRequest(http.MethodPost, APIPrefix+"/query/setparity", strings.NewReader(`{"indices":[3,12]}`))
+		if epoch != "" {
+			req.Header.Set(EpochHeader, epoch)
+		}
+		if block != "" {
+			req.Header.Set(BlockHeader, block)
+		}
+		rec := httptest.NewRecorder()
+		front.ServeHTTP(rec, req)
+		var resp SetParityQueryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	if rec, _ := query("", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("query before Refresh: status %d", rec.Code)
+	}
+
+	for i, f := range rs.followers {
+		if _, err := f.SyncFromLeader(ctx, rs.follower(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := router.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if urls, block := router.Replicas(2, nil); len(urls) != 3 || block != 0 {
+		t.Fatalf("Replicas at block 0 = %v, %d", urls, block)
+	}
+	for i := 0; i < 3; i++ {
+		if rec, resp := query("2", "0"); rec.Code != http.StatusOK || resp.Parity != 103^112 || rec.Header().Get(BlockHeader) != "0" {
+			t.Fatalf("pinned query: status %d, parity %d, block %q", rec.Code, resp.Parity, rec.Header().Get(BlockHeader))
+		}
+	}
+
+	// Block 1 reaches the leader and one follower
+	writeDelta(t, rs.dir, 1, [][3]uint64{{3, 103, 7}})
+	rs.leader.SyncDeltas()
+	rs.followers[0].SyncFromLeader(ctx, rs.follower(0))
+	router.Refresh(ctx)
+	if urls, block := router.Replicas(2, nil); len(urls) != 2 || block != 1 || urls[0] != rs.urls[0] {
+		t.Fatalf("Replicas at the head = %v, %d", urls, block)
+	}
+	for i := 0; i < 4; i++ {
+		if rec, resp := query("", ""); rec.Code != http.StatusOK || resp.Parity != 7^112 {
+			t.Fatalf("unpinned query: status %d, parity %d", rec.Code, resp.Parity)
+		}
+		// Only the lagging follower still serves block 0
+		if rec, resp := query("2", "0"); rec.Code != http.StatusOK || resp.Parity != 103^112 {
+			t.Fatalf("query pinned to block 0: status %d, parity %d", rec.Code, resp.Parity)
+		}
+	}
+	if rec, _ := query("2", "5"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("query pinned to a future block: status %d", rec.Code)
+	}
+	if rec, _ := query("1", "0"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("query pinned to an old epoch: status %d", rec.Code)
+	}
+	if rec, _ := query("two", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed epoch: status %d", rec.Code)
+	}
+
+	// A replica that moved past its acknowledged block is skipped
+	rs.followers[1].SyncFromLeader(ctx, Follower{Leader: rs.urls[0], Replica: "http://elsewhere", Token: testReplicationToken})
+	if rec, _ := query("2", "0"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("query pinned to a block no replica holds any more: status %d", rec.Code)
+	}
+}
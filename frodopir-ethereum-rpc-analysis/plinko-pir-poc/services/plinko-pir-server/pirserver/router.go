@@ -0,0 +1,222 @@
+package pirserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Query router
+//
+// A Router is the query endpoint in front of a table's replicas
+// (replication.go). It polls the leader's replication status and forwards
+// each request to a replica whose acknowledged version is the one the
+// client asked for: the epoch in the request's X-Plinko-Epoch header and
+// the block in X-Plinko-Block (plinko-client sends both with hint-based
+// queries). Without the headers it picks a replica at the leader's epoch
+// and the highest block acknowledged there. Replicas move on between
+// acknowledgements, so an answer stamped with another block is dropped and
+// the next replica tried. When no replica has the version the client gets
+// a retryable 503 version_unavailable: its hints are behind every replica
+// (sync deltas first) or ahead of them.
+
+// DefaultReplicaMaxAge is how long a follower's last acknowledgement is
+// trusted; followers acknowledge on every poll
+const DefaultReplicaMaxAge = 10 * time.Second
+
+// Router forwards queries to replicas at the requested database version
+type Router struct {
+	leader string
+	token  string
+	client *http.Client
+
+	MaxAge  time.Duration // Followers not heard from for this long are skipped
+	MaxBody int64         // Request body limit
+
+	mu     sync.RWMutex
+	status *ReplicationStatus // Last status read from the leader
+	next   atomic.Uint64      // Round-robin position
+}
+
+// NewRouter creates a router for the table the leader serves at leader. A
+// nil client uses http.DefaultClient. Call Refresh (or Run) before serving.
+func NewRouter(leader, token string, client *http.Client) *Router {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Router{
+		leader:  leader,
+		token:   token,
+		client:  client,
+		MaxAge:  DefaultReplicaMaxAge,
+		MaxBody: DefaultLimits().MaxBodyBytes,
+	}
+}
+
+// Refresh reads the leader's replication status
+func (rt *Router) Refresh(ctx context.Context) error {
+	var status ReplicationStatus
+	if err := replicationCall(ctx, rt.client, rt.token, http.MethodGet, rt.leader+APIPrefix+"/replication/status", nil, &status); err != nil {
+		return err
+	}
+	rt.mu.Lock()
+	rt.status = &status
+	rt.mu.Unlock()
+	return nil
+}
+
+// Run calls Refresh every interval until ctx is cancelled, reporting
+// failures to onError (when not nil); the last good status stays in use
+func (rt *Router) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := rt.Refresh(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Replicas returns the URLs of the replicas serving epoch at block,
+// starting with the leader when it is one. With block nil it uses the
+// highest block acknowledged at epoch, and returns it.
+func (rt *Router) Replicas(epoch uint64, block *uint64) ([]string, uint64) {
+	rt.mu.RLock()
+	status := rt.status
+	rt.mu.RUnlock()
+	if status == nil {
+		return nil, 0
+	}
+
+	type replica struct {
+		url          string
+		epoch, block uint64
+	}
+	all := []replica{{rt.leader, status.Epoch, status.LastBlock}}
+	for _, st := range status.Replicas {
+		if time.Since(st.AckedAt) <= rt.MaxAge {
+			all = append(all, replica{st.Replica, st.Epoch, st.LastBlock})
+		}
+	}
+
+	var want uint64
+	if block != nil {
+		want = *block
+	} else {
+		for _, r := range all {
+			if r.epoch == epoch && r.block > want {
+				want = r.block
+			}
+		}
+	}
+	var urls []string
+	for _, r := range all {
+		if r.epoch == epoch && r.block == want {
+			urls = append(urls, r.url)
+		}
+	}
+	return urls, want
+}
+
+// Handler returns the router with CORS middleware; preflight requests are
+// answered without reaching a replica
+func (rt *Router) Handler() http.Handler {
+	return corsMiddleware(rt.serve)
+}
+
+// serve forwards one request to a replica at the requested version
+func (rt *Router) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rt.MaxBody))
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, APIError{
+			Code:    ErrCodeBodyTooLarge,
+			Message: "Request body exceeds " + strconv.FormatInt(rt.MaxBody, 10) + " bytes",
+		})
+		return
+	}
+
+	rt.mu.RLock()
+	status := rt.status
+	rt.mu.RUnlock()
+	if status == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, APIError{
+			Code:      ErrCodeVersionUnavailable,
+			Message:   "Router has not reached the leader yet",
+			Retryable: true,
+		})
+		return
+	}
+	epoch, block, err := requestedVersion(r, status.Epoch)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "X-Plinko-Epoch and X-Plinko-Block must be non-negative integers",
+		})
+		return
+	}
+
+	urls, want := rt.Replicas(epoch, block)
+	start := int(rt.next.Add(1))
+	for i := range urls {
+		resp, err := rt.forward(r, urls[(start+i)%len(urls)], body)
+		if err != nil {
+			continue
+		}
+		if got := resp.Header.Get(BlockHeader); resp.StatusCode == http.StatusOK && got != "" && got != strconv.FormatUint(want, 10) {
+			resp.Body.Close() // Moved past the acknowledged block
+			continue
+		}
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		return
+	}
+
+	writeAPIError(w, http.StatusServiceUnavailable, APIError{
+		Code:      ErrCodeVersionUnavailable,
+		Message:   "No replica serves the requested database version; sync deltas and retry",
+		Retryable: true,
+	})
+}
+
+// forward sends r with body to the replica at base
+func (rt *Router) forward(r *http.Request, base string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, base+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	return rt.client.Do(req)
+}
+
+// requestedVersion reads the epoch and block headers of a request; the
+// epoch defaults to def and a missing block is nil
+func requestedVersion(r *http.Request, def uint64) (epoch uint64, block *uint64, err error) {
+	epoch = def
+	if v := r.Header.Get(EpochHeader); v != "" {
+		if epoch, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, nil, err
+		}
+	}
+	if v := r.Header.Get(BlockHeader); v != "" {
+		b, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		block = &b
+	}
+	return epoch, block, nil
+}
@@ -0,0 +1,512 @@
+package pirserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Database configuration
+	DBEntrySize   = 8
+	DBEntryLength = 1 // DBEntrySize / 8
+
+	HintHeaderSize = 32 // [DBSize][ChunkSize][SetSize][Epoch]
+)
+
+type DBEntry [DBEntryLength]uint64
+
+type PlinkoPIRServer struct {
+	database  []uint64     // In-memory database
+	dbSize    uint64       // Number of database entries
+	chunkSize uint64       // Plinko PIR chunk size
+	setSize   uint64       // Plinko PIR set size
+	epoch     uint64       // Database version from the hint.bin header
+	mu        sync.RWMutex // Guards database against ApplyUpdates
+
+	log     *PrivacyLogger // Query logging (never sees query contents)
+	metrics *ServerMetrics // Prometheus metrics (fixed labels only)
+	limits  Limits         // Request size/rate/timeout limits
+	limiter *RateLimiter   // Per-IP and global token buckets
+	streams *RateLimiter   // The same for /db/stream requests
+
+	name      string       // Table name (LoadTable)
+	dir       string       // Table directory with deltas/ and epoch.json
+	lastBlock uint64       // Last delta block applied, guarded by mu
+	lwe       *lweSnapshot // Set by EnableLWE, guarded by mu
+	merkle    *merkleTree  // Set by EnableMerkle, guarded by mu
+
+	shard Shard  // Part of the table held (LoadShard); zero for all of it
+	base  uint64 // Table index of database[0]
+
+	repl   *replication // Set by EnableReplication on a leader
+	stream *dbStream    // Epoch's hint.bin, set by LoadTable
+
+	tablesMu sync.RWMutex
+	tables   map[string]*mountedTable // Served under /tables/<name>/
+}
+
+// Query request/response types
+type PlaintextQueryRequest struct {
+	Index uint64 `json:"index"`
+}
+
+type PlaintextQueryResponse struct {
+	Value           uint64 `json:"value"`
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+type FullSetQueryRequest struct {
+	PRFKey []byte `json:"prf_key"` // 16-byte PRF key
+}
+
+type FullSetQueryResponse struct {
+	Value           uint64 `json:"value"`
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+type SetParityQueryRequest struct {
+	Indices []uint64 `json:"indices"` // Set of database indices
+}
+
+type SetParityQueryResponse struct {
+	Parity          uint64 `json:"parity"`
+	ServerTimeNanos uint64 `json:"server_time_nanos"`
+}
+
+// CORS middleware to enable cross-origin requests from the browser
+func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, "+ProtocolVersionHeader+", "+EpochHeader+", "+BlockHeader)
+		w.Header().Set("Access-Control-Expose-Headers", ProtocolVersionHeader+", "+EpochHeader+", "+BlockHeader+", Deprecation, Link")
+		w.Header().Set("Access-Control-Max-Age", "3600")
+
+		// Handle preflight OPTIONS request
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Call the next handler
+		next(w, r)
+	}
+}
+
+// route pairs a URL path with its handler
+type route struct {
+	path    string
+	handler http.HandlerFunc
+}
+
+// routes lists every HTTP endpoint served by the PIR server. The /v1/ tree
+// is the supported API; the unversioned query routes are deprecated aliases
+// kept for existing clients.
+func (s *PlinkoPIRServer) routes() []route {
+	plaintext := s.queryRoute(QueryKindPlaintext, s.plaintextQueryHandler)
+	fullSet := s.queryRoute(QueryKindFullSet, s.fullSetQueryHandler)
+	setParity := s.queryRoute(QueryKindSetParity, s.setParityQueryHandler)
+	punctured := s.queryRoute(QueryKindPunctured, s.puncturedQueryHandler)
+	batch := s.queryRoute(QueryKindBatch, s.batchQueryHandler)
+	dpf := s.queryRoute(QueryKindDPF, s.dpfQueryHandler)
+	lwe := s.queryRoute(QueryKindLWE, s.lweQueryHandler)
+	dpfVerified := s.queryRoute(QueryKindDPFVerified, s.dpfVerifiedQueryHandler)
+
+	return []route{
+		// Versioned API
+		{APIPrefix + "/health", s.healthHandler},
+		{APIPrefix + "/params", s.paramsHandler},
+		{APIPrefix + "/tables", s.tablesListHandler},
+		{APIPrefix + "/query/plaintext", plaintext},
+		{APIPrefix + "/query/fullset", fullSet},
+		{APIPrefix + "/query/setparity", setParity},
+		{APIPrefix + "/query/punctured", punctured},
+		{APIPrefix + "/query/punctured/batch", batch},
+		{APIPrefix + "/query/dpf", dpf},
+		{APIPrefix + "/query/dpf/verified", dpfVerified},
+		{APIPrefix + "/query/lwe", lwe},
+		{APIPrefix + "/db/manifest", s.dbManifestHandler},
+		{APIPrefix + "/db/stream", s.dbStreamHandler},
+		{APIPrefix + "/replication/log", s.replicationLogHandler},
+		{APIPrefix + "/replication/ack", s.replicationAckHandler},
+		{APIPrefix + "/replication/status", s.replicationStatusHandler},
+
+		// Operations (unversioned by convention)
+		{"/health", s.healthHandler},
+		{"/metrics", s.metricsHandler},
+
+		// Deprecated aliases
+		{"/query/plaintext", deprecatedAlias(APIPrefix+"/query/plaintext", plaintext)},
+		{"/query/fullset", deprecatedAlias(APIPrefix+"/query/fullset", fullSet)},
+		{"/query/setparity", deprecatedAlias(APIPrefix+"/query/setparity", setParity)},
+
+		// Everything else
+		{"/", s.notFoundHandler},
+	}
+}
+
+// queryRoute wraps a query handler with metrics, rate and size limits
+func (s *PlinkoPIRServer) queryRoute(kind QueryKind, h http.HandlerFunc) http.HandlerFunc {
+	return s.metrics.instrument(kind, s.guard(kind, h))
+}
+
+// NewPlinkoPIRServer creates a server over an in-memory database of
+// chunkSize*setSize entries. Queries are not logged and the default limits
+// apply until SetLogger/SetLimits are called.
+func NewPlinkoPIRServer(database []uint64, dbSize, chunkSize, setSize, epoch uint64) *PlinkoPIRServer {
+	s := &PlinkoPIRServer{
+		database:  database,
+		dbSize:    dbSize,
+		chunkSize: chunkSize,
+		setSize:   setSize,
+		epoch:     epoch,
+		log:       NewPrivacyLogger(io.Discard, LogLevelQuiet),
+		metrics:   NewServerMetrics(uint64(len(database)/DBEntryLength), chunkSize, setSize),
+	}
+	s.SetLimits(DefaultLimits())
+	return s
+}
+
+// LoadHintFile creates a server from a hint.bin file
+func LoadHintFile(path string) (*PlinkoPIRServer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read hint.bin: %w", err)
+	}
+
+	// Read metadata header
+	if len(data) < HintHeaderSize {
+		return nil, fmt.Errorf("invalid hint.bin: too small for header")
+	}
+
+	dbSize := binary.LittleEndian.Uint64(data[0:8])
+	chunkSize := binary.LittleEndian.Uint64(data[8:16])
+	setSize := binary.LittleEndian.Uint64(data[16:24])
+	epoch := binary.LittleEndian.Uint64(data[24:32])
+
+	// Extract database (skip 32-byte header)
+	dbBytes := data[HintHeaderSize:]
+	dbEntries := len(dbBytes) / DBEntrySize
+
+	database := make([]uint64, dbEntries)
+	for i := 0; i < dbEntries; i++ {
+		database[i] = binary.LittleEndian.Uint64(dbBytes[i*DBEntrySize : (i+1)*DBEntrySize])
+	}
+
+	return NewPlinkoPIRServer(database, dbSize, chunkSize, setSize, epoch), nil
+}
+
+// SetLogger replaces the query logger
+func (s *PlinkoPIRServer) SetLogger(l *PrivacyLogger) {
+	s.log = l
+}
+
+// SetLimits replaces the request limits and resets the rate limiter
+func (s *PlinkoPIRServer) SetLimits(limits Limits) {
+	s.limits = limits
+	s.limiter = NewRateLimiter(limits)
+	s.streams = NewRateLimiter(limits.streamLimits())
+}
+
+// Handler returns every route with CORS and protocol version middleware
+func (s *PlinkoPIRServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.path, corsMiddleware(s.versioned(rt.handler)))
+	}
+	mux.HandleFunc(TablesPrefix, s.tablesHandler)
+	if s.name != "" {
+		// The table's own name leads back to the root routes
+		prefix := TablesPrefix + s.name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+	}
+	return mux
+}
+
+// Database parameters
+func (s *PlinkoPIRServer) DBSize() uint64    { return s.dbSize }
+func (s *PlinkoPIRServer) ChunkSize() uint64 { return s.chunkSize }
+func (s *PlinkoPIRServer) SetSize() uint64   { return s.setSize }
+func (s *PlinkoPIRServer) Epoch() uint64     { return s.epoch }
+
+// MaxIndices returns the SetParity index limit in effect
+func (s *PlinkoPIRServer) MaxIndices() int {
+	return s.limits.maxIndices(s.setSize)
+}
+
+// EntryUpdate sets one database entry to a new value
+type EntryUpdate struct {
+	Index uint64
+	Value uint64
+}
+
+// ApplyUpdates changes database entries in place to their values as of
+// block, which becomes LastBlock, as applying the block's delta file would.
+// Queries running at the same time see the database either before or after
+// the whole batch. A shard skips updates to entries it does not hold.
+func (s *PlinkoPIRServer) ApplyUpdates(block uint64, updates []EntryUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.paddedSize()
+	for _, u := range updates {
+		if u.Index >= n {
+			return fmt.Errorf("update index %d outside database of %d entries", u.Index, n)
+		}
+	}
+	for _, u := range updates {
+		if i, ok := s.local(u.Index); ok {
+			s.database[i] = u.Value
+		}
+	}
+	s.lastBlock = block
+	return nil
+}
+
+// DBAccess safely accesses database entry by index (callers hold s.mu)
+func (s *PlinkoPIRServer) DBAccess(id uint64) DBEntry {
+	if i, ok := s.local(id); ok {
+		return DBEntry{s.database[i]}
+	}
+	// Return zero for out-of-bounds (and, on a shard, other shards' entries)
+	return DBEntry{0}
+}
+
+// local returns the position in s.database of table entry id, if s holds it
+func (s *PlinkoPIRServer) local(id uint64) (uint64, bool) {
+	if id < s.base || id-s.base >= uint64(len(s.database)/DBEntryLength) {
+		return 0, false
+	}
+	return (id - s.base) * DBEntryLength, true
+}
+
+// paddedSize is the number of entries in the whole table, of which a
+// shard holds only part
+func (s *PlinkoPIRServer) paddedSize() uint64 {
+	if s.shard.Count == 0 {
+		return uint64(len(s.database) / DBEntryLength)
+	}
+	return s.chunkSize * s.setSize
+}
+
+// healthHandler returns server health status
+func (s *PlinkoPIRServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "healthy",
+		"service":          "plinko-pir-server",
+		"protocol_version": ProtocolVersion,
+		"epoch":            s.epoch,
+		"db_size":          s.dbSize,
+		"chunk_size":       s.chunkSize,
+		"set_size":         s.setSize,
+	})
+}
+
+// plaintextQueryHandler handles direct database lookups (for testing)
+// ⚠️  Privacy: Does NOT log the queried index
+func (s *PlinkoPIRServer) plaintextQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		s.methodNotAllowed(w, QueryKindPlaintext, "GET, POST")
+		return
+	}
+
+	req, ok := s.plaintextRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Execute query
+	startTime := time.Now()
+	s.mu.RLock()
+	entry := s.DBAccess(req.Index)
+	block := s.lastBlock
+	s.mu.RUnlock()
+	elapsed := time.Since(startTime)
+
+	// ⚠️  PRIVACY: Never log the queried index!
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindPlaintext,
+		Entries: 1,
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	// Return response
+	resp := PlaintextQueryResponse{
+		Value:           entry[0],
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	}
+
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// plaintextRequest reads the index from a POST body or a GET ?index=
+// parameter. On failure it sends the rejection and returns false.
+func (s *PlinkoPIRServer) plaintextRequest(w http.ResponseWriter, r *http.Request) (PlaintextQueryRequest, bool) {
+	var req PlaintextQueryRequest
+
+	if r.Method == http.MethodPost {
+		if !s.decodeJSON(w, r, QueryKindPlaintext, &req) {
+			return req, false
+		}
+	} else {
+		// GET request: parse index from query parameter
+		indexStr := r.URL.Query().Get("index")
+		if indexStr == "" {
+			s.rejectAPI(w, QueryKindPlaintext, http.StatusBadRequest, APIError{
+				Code:    ErrCodeMissingParameter,
+				Message: "Missing index parameter",
+			})
+			return req, false
+		}
+		index, err := strconv.ParseUint(indexStr, 10, 64)
+		if err != nil {
+			s.rejectAPI(w, QueryKindPlaintext, http.StatusBadRequest, APIError{
+				Code:    ErrCodeInvalidIndex,
+				Message: "Index must be a non-negative integer",
+			})
+			return req, false
+		}
+		req.Index = index
+	}
+	return req, true
+}
+
+// fullSetQueryHandler handles Plinko PIR FullSet queries
+// ⚠️  Privacy: Never logs the PRF key or the returned parity
+func (s *PlinkoPIRServer) fullSetQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindFullSet, "POST")
+		return
+	}
+
+	var req FullSetQueryRequest
+	if !s.decodeJSON(w, r, QueryKindFullSet, &req) {
+		return
+	}
+
+	// Validate PRF key
+	if len(req.PRFKey) != 16 {
+		s.rejectAPI(w, QueryKindFullSet, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidPRFKey,
+			Message: "PRF key must be 16 bytes",
+		})
+		return
+	}
+
+	// Execute Plinko PIR FullSet query
+	startTime := time.Now()
+	parity, block := s.HandleFullSetQuery(req.PRFKey)
+	elapsed := time.Since(startTime)
+
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindFullSet,
+		Entries: int(s.setSize),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	resp := FullSetQueryResponse{
+		Value:           parity[0],
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	}
+
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleFullSetQuery implements Plinko PIR FullSet query. It also returns
+// the last delta block the parity reflects.
+func (s *PlinkoPIRServer) HandleFullSetQuery(prfKeyBytes []byte) (DBEntry, uint64) {
+	// Convert PRF key
+	var prfKey PrfKey128
+	copy(prfKey[:], prfKeyBytes)
+
+	// Expand PRF key to set of indices
+	prSet := NewPRSet(prfKey)
+	expandedSet := prSet.Expand(s.setSize, s.chunkSize)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Compute XOR parity over the set
+	var parity DBEntry
+	for _, id := range expandedSet {
+		entry := s.DBAccess(id)
+		parity[0] ^= entry[0]
+	}
+
+	return parity, s.lastBlock
+}
+
+// setParityQueryHandler handles SetParity queries (simplified Plinko PIR)
+// ⚠️  Privacy: Does not log which indices were queried
+func (s *PlinkoPIRServer) setParityQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, QueryKindSetParity, "POST")
+		return
+	}
+
+	var req SetParityQueryRequest
+	if !s.decodeJSON(w, r, QueryKindSetParity, &req) {
+		return
+	}
+
+	// One index per chunk is all a Plinko query ever needs
+	if max := s.limits.maxIndices(s.setSize); len(req.Indices) > max {
+		s.rejectAPI(w, QueryKindSetParity, http.StatusBadRequest, APIError{
+			Code:    ErrCodeTooManyIndices,
+			Message: fmt.Sprintf("At most %d indices per query", max),
+		})
+		return
+	}
+
+	// Execute query
+	startTime := time.Now()
+	parity, block := s.HandleSetParityQuery(req.Indices)
+	elapsed := time.Since(startTime)
+
+	// Log query completion (count only, never the indices!)
+	s.log.Query(QueryEvent{
+		Kind:    QueryKindSetParity,
+		Entries: len(req.Indices),
+		Elapsed: elapsed,
+		Status:  http.StatusOK,
+	})
+
+	resp := SetParityQueryResponse{
+		Parity:          parity[0],
+		ServerTimeNanos: uint64(elapsed.Nanoseconds()),
+	}
+
+	stampBlock(w, block)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleSetParityQuery computes XOR parity over a set of indices and
+// returns it with the last delta block it reflects
+func (s *PlinkoPIRServer) HandleSetParityQuery(indices []uint64) (DBEntry, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var parity DBEntry
+	for _, index := range indices {
+		entry := s.DBAccess(index)
+		parity[0] ^= entry[0]
+	}
+	return parity, s.lastBlock
+}
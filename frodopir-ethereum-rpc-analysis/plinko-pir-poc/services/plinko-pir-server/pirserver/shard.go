@@ -0,0 +1,111 @@
+package pirserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sharded serving
+//
+// A table too large for one process is split by chunk: shard i of N holds
+// chunks [setSize*i/N, setSize*(i+1)/N) of hint.bin and answers every query
+// as if the other chunks were zero. Each answer is then a partial parity,
+// and the XOR of all shards' answers is the answer of an unsharded server.
+// A Coordinator (coordinator.go) fans queries out to the shards and merges
+// them. Shards follow the full delta feed and apply the entries they hold,
+// so they advance block by block together.
+
+// Shard selects one of Count even chunk ranges of a table. The zero Shard
+// is the whole table.
+type Shard struct {
+	Index uint64
+	Count uint64
+}
+
+// ParseShard parses a SHARD value of the form "i/N" (0 <= i < N)
+func ParseShard(s string) (Shard, error) {
+	i, n, ok := strings.Cut(s, "/")
+	index, err1 := strconv.ParseUint(strings.TrimSpace(i), 10, 64)
+	count, err2 := strconv.ParseUint(strings.TrimSpace(n), 10, 64)
+	if !ok || err1 != nil || err2 != nil || count == 0 || index >= count {
+		return Shard{}, fmt.Errorf("shard %q: want i/N with 0 <= i < N", s)
+	}
+	return Shard{Index: index, Count: count}, nil
+}
+
+func (sh Shard) String() string {
+	return fmt.Sprintf("%d/%d", sh.Index, sh.Count)
+}
+
+// Chunks returns the shard's chunk range [first, end) in a table of
+// setSize chunks
+func (sh Shard) Chunks(setSize uint64) (first, end uint64) {
+	if sh.Count == 0 {
+		return 0, setSize
+	}
+	return setSize * sh.Index / sh.Count, setSize * (sh.Index + 1) / sh.Count
+}
+
+// ShardInfo describes the shard a server holds in /v1/params
+type ShardInfo struct {
+	Index      uint64 `json:"index"`
+	Count      uint64 `json:"count"`
+	FirstChunk uint64 `json:"first_chunk"`
+	EndChunk   uint64 `json:"end_chunk"` // Exclusive
+}
+
+// shardInfo returns s's shard, or nil when it holds the whole table
+func (s *PlinkoPIRServer) shardInfo() *ShardInfo {
+	if s.shard.Count == 0 {
+		return nil
+	}
+	first, end := s.shard.Chunks(s.setSize)
+	return &ShardInfo{Index: s.shard.Index, Count: s.shard.Count, FirstChunk: first, EndChunk: end}
+}
+
+// Shard returns the shard s holds (zero for the whole table)
+func (s *PlinkoPIRServer) Shard() Shard { return s.shard }
+
+// LoadShard creates a server holding only shard's chunks of a hint.bin
+// file. Only those chunks are read into memory.
+func LoadShard(path string, shard Shard) (*PlinkoPIRServer, error) {
+	if shard.Count == 0 || shard.Index >= shard.Count {
+		return nil, fmt.Errorf("invalid shard %s", shard)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read hint.bin: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, HintHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("invalid hint.bin: too small for header")
+	}
+	dbSize := binary.LittleEndian.Uint64(header[0:8])
+	chunkSize := binary.LittleEndian.Uint64(header[8:16])
+	setSize := binary.LittleEndian.Uint64(header[16:24])
+	epoch := binary.LittleEndian.Uint64(header[24:32])
+	if shard.Count > setSize {
+		return nil, fmt.Errorf("%d shards for %d chunks", shard.Count, setSize)
+	}
+
+	first, end := shard.Chunks(setSize)
+	dbBytes := make([]byte, (end-first)*chunkSize*DBEntrySize)
+	if _, err := f.ReadAt(dbBytes, HintHeaderSize+int64(first*chunkSize*DBEntrySize)); err != nil {
+		return nil, errors.New("invalid hint.bin: shorter than chunkSize*setSize entries")
+	}
+	database := make([]uint64, len(dbBytes)/DBEntrySize)
+	for i := range database {
+		database[i] = binary.LittleEndian.Uint64(dbBytes[i*DBEntrySize:])
+	}
+
+	s := NewPlinkoPIRServer(database, dbSize, chunkSize, setSize, epoch)
+	s.shard = shard
+	s.base = first * chunkSize
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package pirserver
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Database stream for client-side hint construction
+//
+// Piano-style clients build their primary and backup hints by reading the
+// database once, chunk by chunk. A table loaded with LoadTable serves its
+// epoch's hint.bin for that:
+//
+//	GET /v1/db/manifest                          parameters, start block, chunk digests
+//	GET /v1/db/stream?epoch=E&from=C&chunks=K    raw entries of chunks C..C+K-1
+//
+// The stream is the hint.bin the table was loaded from, held open, so it
+// stays at the table's epoch even after plinko-hint-generator renames a new
+// hint.bin into place; the database it describes is the one at the
+// manifest's block, before that epoch's deltas. A stream that breaks off
+// (WRITE_TIMEOUT, a dropped connection) is resumed with from= at the first
+// chunk not yet received, and each chunk is checked against the SHA-256
+// digest in the manifest. A request for another epoch gets 409
+// epoch_mismatch: the client has to start over from the new manifest.
+//
+// A stream response is far larger than a query answer, so it is written
+// under Limits.StreamWriteTimeout rather than the server's WriteTimeout,
+// and stream requests have their own rate limit (Limits.Stream*): a 429
+// rate_limited carries Retry-After like query rejections.
+
+// dbStream is an open hint.bin and its chunk digests
+type dbStream struct {
+	f          *os.File
+	startBlock uint64 // Block the hint.bin reflects
+
+	once    sync.Once
+	digests [][]byte // SHA-256 of each chunk's entries, computed on first use
+	err     error
+}
+
+// DBManifest describes the database stream of a table
+type DBManifest struct {
+	Epoch      uint64   `json:"epoch"`
+	DBSize     uint64   `json:"db_size"`
+	ChunkSize  uint64   `json:"chunk_size"`
+	SetSize    uint64   `json:"set_size"`
+	EntrySize  int      `json:"entry_size"`
+	StartBlock uint64   `json:"start_block"` // Apply deltas after this block
+	Chunks     [][]byte `json:"chunks"`      // SHA-256 of each chunk, base64 in JSON
+}
+
+// openDBStream opens the hint.bin at path for streaming, checking that it
+// is still the epoch that was loaded from it
+func openDBStream(path string, epoch, startBlock uint64) (*dbStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, HintHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid hint.bin: too small for header")
+	}
+	if got := binary.LittleEndian.Uint64(header[24:32]); got != epoch {
+		f.Close()
+		return nil, fmt.Errorf("hint.bin moved from epoch %d to %d while loading", epoch, got)
+	}
+	return &dbStream{f: f, startBlock: startBlock}, nil
+}
+
+// chunkDigests hashes every chunk of the stream once
+func (s *PlinkoPIRServer) chunkDigests() ([][]byte, error) {
+	st := s.stream
+	st.once.Do(func() {
+		size := int64(s.chunkSize * DBEntrySize)
+		r := io.NewSectionReader(st.f, HintHeaderSize, size*int64(s.setSize))
+		buf := make([]byte, size)
+		for c := uint64(0); c < s.setSize; c++ {
+			if _, err := io.ReadFull(r, buf); err != nil {
+				st.err = fmt.Errorf("read chunk %d: %w", c, err)
+				return
+			}
+			sum := sha256.Sum256(buf)
+			st.digests = append(st.digests, sum[:])
+		}
+	})
+	return st.digests, st.err
+}
+
+// streamAllowed checks that the table has a stream and the request is a
+// GET. On failure it sends the error response and returns false.
+func (s *PlinkoPIRServer) streamAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if s.stream == nil {
+		writeAPIError(w, http.StatusNotFound, APIError{
+			Code:    ErrCodeNotFound,
+			Message: "Database stream needs a table loaded from hint.bin",
+		})
+		return false
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: "Method not allowed; use GET",
+		})
+		return false
+	}
+	return true
+}
+
+// dbManifestHandler serves the stream's DBManifest
+func (s *PlinkoPIRServer) dbManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.streamAllowed(w, r) {
+		return
+	}
+	digests, err := s.chunkDigests()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, APIError{
+			Code:    ErrCodeInternal,
+			Message: "Database file could not be read",
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DBManifest{
+		Epoch:      s.epoch,
+		DBSize:     s.dbSize,
+		ChunkSize:  s.chunkSize,
+		SetSize:    s.setSize,
+		EntrySize:  DBEntrySize,
+		StartBlock: s.stream.startBlock,
+		Chunks:     digests,
+	})
+}
+
+// dbStreamHandler writes the entries of the requested chunk range
+func (s *PlinkoPIRServer) dbStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.streamAllowed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	epoch, err := strconv.ParseUint(q.Get("epoch"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeMissingParameter,
+			Message: "epoch must be the manifest's epoch",
+		})
+		return
+	}
+	if epoch != s.epoch {
+		writeAPIError(w, http.StatusConflict, APIError{
+			Code:    ErrCodeEpochMismatch,
+			Message: "Server streams another epoch; fetch " + APIPrefix + "/db/manifest again",
+		})
+		return
+	}
+	from, chunks := uint64(0), s.setSize
+	if v := q.Get("from"); v != "" {
+		from, err = strconv.ParseUint(v, 10, 64)
+	}
+	if v := q.Get("chunks"); err == nil && v != "" {
+		chunks, err = strconv.ParseUint(v, 10, 64)
+	}
+	if err != nil || from >= s.setSize || chunks == 0 {
+		writeAPIError(w, http.StatusBadRequest, APIError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "from must be a chunk number below set_size and chunks positive",
+		})
+		return
+	}
+	if chunks > s.setSize-from {
+		chunks = s.setSize - from
+	}
+
+	if s.streams != nil {
+		if ok, wait, global := s.streams.Allow(clientIP(r), 1); !ok {
+			setRetryAfter(w, wait)
+			msg := "Too many database streams from this client"
+			if global {
+				msg = "Server is at its database stream rate limit"
+			}
+			writeAPIError(w, http.StatusTooManyRequests, APIError{
+				Code:      ErrCodeRateLimited,
+				Message:   msg,
+				Retryable: true,
+			})
+			return
+		}
+	}
+
+	// Replaces the server's WriteTimeout for this response; a writer without
+	// deadlines (tests) just keeps the server's
+	var deadline time.Time
+	if s.limits.StreamWriteTimeout > 0 {
+		deadline = time.Now().Add(s.limits.StreamWriteTimeout)
+	}
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+
+	size := int64(s.chunkSize * DBEntrySize)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size*int64(chunks), 10))
+	body := io.NewSectionReader(s.stream.f, HintHeaderSize+size*int64(from), size*int64(chunks))
+	io.Copy(w, body) // A client cut off here resumes from its last whole chunk
+}
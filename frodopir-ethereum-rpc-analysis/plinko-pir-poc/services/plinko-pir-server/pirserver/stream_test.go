@@ -0,0 +1,192 @@
+package pirserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHintFile writes a hint.bin of chunkSize*setSize entries at epoch and
+// returns its entries
+func writeHintFile(t *testing.T, dir string, chunkSize, setSize, epoch, seed uint64) []byte {
+	t.Helper()
+	buf := binary.LittleEndian.AppendUint64(nil, chunkSize*setSize)
+	buf = binary.LittleEndian.AppendUint64(buf, chunkSize)
+	buf = binary.LittleEndian.AppendUint64(buf, setSize)
+	buf = binary.LittleEndian.AppendUint64(buf, epoch)
+	for i := uint64(0); i < chunkSize*setSize; i++ {
+		buf = binary.LittleEndian.AppendUint64(buf, seed+i*0x9e37)
+	}
+	// Renamed into place, as plinko-hint-generator does
+	tmp := filepath.Join(dir, ".tmp-hint.bin")
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, TableHintFile)); err != nil {
+		t.Fatal(err)
+	}
+	return buf[HintHeaderSize:]
+}
+
+func TestDBStream(t *testing.T) {
+	const chunkSize, setSize = 8, 6
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	entries := writeHintFile(t, dir, chunkSize, setSize, 4, 1000)
+	os.WriteFile(filepath.Join(dir, "epoch.json"), []byte(`{"epoch": 4, "last_block": 90}`), 0644)
+	s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	var m DBManifest
+	if rec := get("/v1/db/manifest"); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &m) != nil {
+		t.Fatalf("manifest: status %d, %s", rec.Code, rec.Body)
+	}
+	if m.Epoch != 4 || m.StartBlock != 90 || m.ChunkSize != chunkSize || m.SetSize != setSize || len(m.Chunks) != setSize {
+		t.Fatalf("manifest %+v", m)
+	}
+	size := chunkSize * DBEntrySize
+	for c, digest := range m.Chunks {
+		if sum := sha256.Sum256(entries[c*size : (c+1)*size]); !bytes.Equal(digest, sum[:]) {
+			t.Errorf("chunk %d digest %x, want %x", c, digest, sum)
+		}
+	}
+
+	// Deltas change the served database, not the stream
+	writeDelta(t, dir, 91, [][3]uint64{{0, s.database[0], 7}})
+	if n, err := s.SyncDeltas(); n != 1 || err != nil {
+		t.Fatalf("SyncDeltas = %d, %v", n, err)
+	}
+	if rec := get("/v1/db/stream?epoch=4"); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), entries) {
+		t.Fatalf("whole stream: status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := get("/v1/db/stream?epoch=4&from=2&chunks=3"); !bytes.Equal(rec.Body.Bytes(), entries[2*size:5*size]) {
+		t.Errorf("chunks 2-4: %d bytes", rec.Body.Len())
+	}
+	if rec := get("/v1/db/stream?epoch=4&from=5&chunks=10"); !bytes.Equal(rec.Body.Bytes(), entries[5*size:]) {
+		t.Errorf("range past the end: %d bytes", rec.Body.Len())
+	}
+
+	// The next epoch's hint.bin does not change an open stream
+	writeHintFile(t, dir, chunkSize, setSize, 5, 2000)
+	if rec := get("/v1/db/stream?epoch=4&from=1&chunks=1"); !bytes.Equal(rec.Body.Bytes(), entries[size:2*size]) {
+		t.Errorf("stream after a new hint.bin: %x", rec.Body.Bytes())
+	}
+
+	for target, want := range map[string]struct {
+		status int
+		code   string
+	}{
+		"/v1/db/stream":                     {http.StatusBadRequest, ErrCodeMissingParameter},
+		"/v1/db/stream?epoch=5":             {http.StatusConflict, ErrCodeEpochMismatch},
+		"/v1/db/stream?epoch=4&from=6":      {http.StatusBadRequest, ErrCodeInvalidRequest},
+		"/v1/db/stream?epoch=4&chunks=0":    {http.StatusBadRequest, ErrCodeInvalidRequest},
+		"/v1/db/stream?epoch=4&from=second": {http.StatusBadRequest, ErrCodeInvalidRequest},
+	} {
+		rec := get(target)
+		var body ErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != want.status || body.Error.Code != want.code {
+			t.Errorf("%s: status %d, %q; want %d, %q", target, rec.Code, body.Error.Code, want.status, want.code)
+		}
+	}
+
+	// Servers not loaded from a table directory have no stream
+	rec := httptest.NewRecorder()
+	NewPlinkoPIRServer(make([]uint64, 16), 16, 4, 4, 0).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/db/manifest", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("manifest without a table: status %d", rec.Code)
+	}
+}
+
+func TestDBStreamOutlastsWriteTimeout(t *testing.T) {
+	// 16 MB: more than the socket buffers hold while the client stalls
+	const chunkSize, setSize = 1 << 14, 128
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	writeHintFile(t, dir, chunkSize, setSize, 0, 1)
+	s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultLimits()
+	limits.WriteTimeout = 50 * time.Millisecond
+	s.SetLimits(limits)
+	ts := httptest.NewUnstartedServer(s.Handler())
+	limits.ApplyTimeouts(ts.Config)
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/db/stream?epoch=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(4 * limits.WriteTimeout)
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil || n != chunkSize*setSize*DBEntrySize {
+		t.Fatalf("read %d of %d bytes: %v", n, chunkSize*setSize*DBEntrySize, err)
+	}
+}
+
+func TestDBStreamRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "deltas"), 0755)
+	writeHintFile(t, dir, 4, 4, 0, 1)
+	s, err := LoadTable(TableConfig{Name: DefaultTableName, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultLimits()
+	limits.StreamPerIPRate, limits.StreamPerIPBurst = 0.001, 2
+	s.SetLimits(limits)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// Rejected requests stream nothing and cost nothing
+	for i := 0; i < 3; i++ {
+		if rec := get("/v1/db/stream?epoch=9"); rec.Code != http.StatusConflict {
+			t.Fatalf("wrong epoch: status %d", rec.Code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if rec := get("/v1/db/stream?epoch=0"); rec.Code != http.StatusOK {
+			t.Fatalf("stream %d: status %d", i, rec.Code)
+		}
+	}
+	rec := get("/v1/db/stream?epoch=0")
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusTooManyRequests || body.Error.Code != ErrCodeRateLimited || !body.Error.Retryable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third stream: status %d, %+v, Retry-After %q", rec.Code, body.Error, rec.Header().Get("Retry-After"))
+	}
+
+	// Queries draw on their own buckets
+	if rec := get("/v1/params"); rec.Code != http.StatusOK {
+		t.Errorf("params after the stream limit: status %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/query/plaintext", strings.NewReader(`{"index": 1}`))
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("query after the stream limit: status %d, %s", rec.Code, rec.Body)
+	}
+}
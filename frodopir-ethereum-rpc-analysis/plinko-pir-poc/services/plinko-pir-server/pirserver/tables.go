@@ -0,0 +1,296 @@
+package pirserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mountedTable is a table server with its routes under /tables/<name>
+type mountedTable struct {
+	server  *PlinkoPIRServer
+	handler http.Handler
+}
+
+// Named tables
+//
+// One server answers queries against several PIR tables: the account
+// database (eth-balances) and whatever else db-generator wrote under
+// tables/ (token-balances). Each is a PlinkoPIRServer of its own with its
+// own parameters, epoch and delta feed, served under /tables/<name>/ with
+// the full API: a client for a table is an ordinary client whose server URL
+// ends in /tables/<name>, and its hints come from the same path on the CDN.
+// The first table is also served at the root, so existing clients keep
+// working.
+//
+// A table is a directory laid out like /data: hint.bin, epoch.json once it
+// has one, and deltas/delta-NNNNNN.bin.
+
+const (
+	TablesPrefix     = "/tables/" // URL prefix of named tables
+	DefaultTableName = "eth-balances"
+	TableHintFile    = "hint.bin"
+)
+
+// TableConfig is one table to load
+type TableConfig struct {
+	Name        string
+	Dir         string
+	Shard       Shard // Load only this shard of the table; zero loads all of it
+	MerkleNodes int   // Merkle tree nodes kept for verified queries (0 = DefaultMerkleNodes)
+}
+
+// ParseTableConfig parses a TABLES value: comma-separated name=dir pairs,
+// the first of which is served at the root as well
+func ParseTableConfig(s string) ([]TableConfig, error) {
+	var configs []TableConfig
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, dir, ok := strings.Cut(item, "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("table %q: want name=dir", item)
+		}
+		if err := validTableName(name); err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("table %q listed twice", name)
+		}
+		seen[name] = true
+		configs = append(configs, TableConfig{Name: name, Dir: dir})
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no tables configured")
+	}
+	return configs, nil
+}
+
+// DiscoverTables returns dataDir as eth-balances followed by every
+// dataDir/tables/<name>/ that has a hint.bin, sorted by name
+func DiscoverTables(dataDir string) ([]TableConfig, error) {
+	configs := []TableConfig{{Name: DefaultTableName, Dir: dataDir}}
+	matches, err := filepath.Glob(filepath.Join(dataDir, "tables", "*", TableHintFile))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	for _, m := range matches {
+		dir := filepath.Dir(m)
+		if name := filepath.Base(dir); validTableName(name) == nil && name != DefaultTableName {
+			configs = append(configs, TableConfig{Name: name, Dir: dir})
+		}
+	}
+	return configs, nil
+}
+
+func validTableName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/?#=,") {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	return nil
+}
+
+// LoadTable loads cfg.Dir/hint.bin. The table's delta feed starts after the
+// last block in its epoch.json when that matches the hint's epoch, and at
+// block 1 otherwise. Tables that publish lwe-hint.bin answer LWE queries,
+// and tables whose merkle-root.json is for the loaded epoch answer verified
+// DPF queries, unless only a shard is loaded.
+func LoadTable(cfg TableConfig) (*PlinkoPIRServer, error) {
+	if err := validTableName(cfg.Name); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cfg.Dir, TableHintFile)
+	var s *PlinkoPIRServer
+	var err error
+	if cfg.Shard.Count > 0 {
+		s, err = LoadShard(path, cfg.Shard)
+	} else {
+		s, err = LoadHintFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", cfg.Name, err)
+	}
+	s.name, s.dir = cfg.Name, cfg.Dir
+	info, err := readTableEpoch(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", cfg.Name, err)
+	}
+	if info != nil && info.Epoch == s.epoch {
+		s.lastBlock = info.LastBlock
+	}
+	if s.stream, err = openDBStream(path, s.epoch, s.lastBlock); err != nil {
+		return nil, fmt.Errorf("table %s: %w", cfg.Name, err)
+	}
+	if cfg.Shard.Count == 0 && lweHintPublished(cfg.Dir) {
+		s.EnableLWE()
+	}
+	if cfg.Shard.Count == 0 {
+		published, err := readMerkleRoot(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", cfg.Name, err)
+		}
+		// An older root means the hint generator has not caught up yet
+		if published != nil && published.Epoch == s.epoch {
+			nodes := cfg.MerkleNodes
+			if nodes <= 0 {
+				nodes = DefaultMerkleNodes
+			}
+			if root := s.enableMerkle(nodes); !bytes.Equal(root, published.Root) {
+				return nil, fmt.Errorf("table %s: %s does not match hint.bin at epoch %d", cfg.Name, MerkleRootFile, s.epoch)
+			}
+		}
+	}
+	return s, nil
+}
+
+// tableEpoch is a table's epoch.json
+type tableEpoch struct {
+	Epoch     uint64 `json:"epoch"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// readTableEpoch reads dir/epoch.json, or returns nil if there is none
+func readTableEpoch(dir string) (*tableEpoch, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "epoch.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info tableEpoch
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("epoch.json: %w", err)
+	}
+	return &info, nil
+}
+
+// Name returns the table name given to LoadTable, or "" for a server built
+// from a bare hint.bin
+func (s *PlinkoPIRServer) Name() string { return s.name }
+
+// MountTable serves table under /tables/<name>/, also from handlers
+// already returned by Handler. The table shares the server's query logger
+// and rate limiter, so the limits cover queries to every table together;
+// call it after SetLogger and SetLimits.
+func (s *PlinkoPIRServer) MountTable(name string, table *PlinkoPIRServer) error {
+	if err := validTableName(name); err != nil {
+		return err
+	}
+	s.tablesMu.Lock()
+	defer s.tablesMu.Unlock()
+	if _, ok := s.tables[name]; ok || name == s.name {
+		return fmt.Errorf("table %q already mounted", name)
+	}
+	if s.tables == nil {
+		s.tables = make(map[string]*mountedTable)
+	}
+	table.log = s.log
+	table.limits = s.limits
+	table.limiter = s.limiter
+	table.streams = s.streams
+	s.tables[name] = &mountedTable{
+		server:  table,
+		handler: http.StripPrefix(TablesPrefix+name, table.Handler()),
+	}
+	return nil
+}
+
+// Table returns the table named name (s itself for its own name), or nil
+func (s *PlinkoPIRServer) Table(name string) *PlinkoPIRServer {
+	if name != "" && name == s.name {
+		return s
+	}
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+	if t, ok := s.tables[name]; ok {
+		return t.server
+	}
+	return nil
+}
+
+// Tables returns the names of every table served under /tables/, sorted:
+// the mounted tables and s's own name if it has one
+func (s *PlinkoPIRServer) Tables() []string {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+	names := make([]string, 0, len(s.tables)+1)
+	if s.name != "" {
+		names = append(names, s.name)
+	}
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TableInfo describes one table in the /v1/tables response
+type TableInfo struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"` // URL prefix of the table's API
+	Epoch      uint64 `json:"epoch"`
+	DBSize     uint64 `json:"db_size"`
+	PaddedSize uint64 `json:"padded_size"`
+	ChunkSize  uint64 `json:"chunk_size"`
+	SetSize    uint64 `json:"set_size"`
+	LastBlock  uint64 `json:"last_block"` // Last delta block applied
+}
+
+// TablesResponse lists every table the server answers for
+type TablesResponse struct {
+	Tables []TableInfo `json:"tables"`
+}
+
+// tablesListHandler describes every table, so a client can pick one by
+// name and build hints for it
+func (s *PlinkoPIRServer) tablesListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, APIError{
+			Code:    ErrCodeMethodNotAllowed,
+			Message: "Method not allowed; use GET",
+		})
+		return
+	}
+
+	resp := TablesResponse{Tables: []TableInfo{}}
+	for _, name := range s.Tables() {
+		t := s.Table(name)
+		resp.Tables = append(resp.Tables, TableInfo{
+			Name:       name,
+			Path:       TablesPrefix + name,
+			Epoch:      t.epoch,
+			DBSize:     t.dbSize,
+			PaddedSize: t.paddedSize(),
+			ChunkSize:  t.chunkSize,
+			SetSize:    t.setSize,
+			LastBlock:  t.LastBlock(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// tablesHandler routes /tables/<name>/... to the table's own API
+func (s *PlinkoPIRServer) tablesHandler(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, TablesPrefix), "/")
+	s.tablesMu.RLock()
+	t := s.tables[name]
+	s.tablesMu.RUnlock()
+	if t == nil {
+		corsMiddleware(s.versioned(s.notFoundHandler))(w, r)
+		return
+	}
+	t.handler.ServeHTTP(w, r)
+}
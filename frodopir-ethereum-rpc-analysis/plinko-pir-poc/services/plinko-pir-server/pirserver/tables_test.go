@@ -0,0 +1,142 @@
+package pirserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMountTable(t *testing.T) {
+	s := newTestServer(&bytes.Buffer{})
+	table := NewPlinkoPIRServer([]uint64{10, 20, 30, 40, 0, 0, 0, 0}, 4, 4, 2, 0)
+	if err := s.MountTable("token-balances", table); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MountTable("token-balances", table); err == nil {
+		t.Error("mounting a table twice succeeded")
+	}
+	if err := s.MountTable("a/b", table); err == nil {
+		t.Error("mounting a table with a slash in its name succeeded")
+	}
+	mux := s.Handler()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	var params ParamsResponse
+	json.Unmarshal(do(http.MethodGet, "/v1/params", "").Body.Bytes(), &params)
+	if len(params.Tables) != 1 || params.Tables[0] != "token-balances" {
+		t.Errorf("tables = %v", params.Tables)
+	}
+	rec := do(http.MethodGet, "/tables/token-balances/v1/params", "")
+	params = ParamsResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &params)
+	if rec.Code != http.StatusOK || params.DBSize != 4 || params.ChunkSize != 4 || len(params.Tables) != 0 {
+		t.Errorf("table params: %d %+v", rec.Code, params)
+	}
+
+	// Set parity over the table's entries, not the account database's
+	rec = do(http.MethodPost, "/tables/token-balances/v1/query/setparity", `{"indices":[1,2]}`)
+	var resp SetParityQueryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Parity != 20^30 {
+		t.Errorf("table query: %d, parity %d, want %d", rec.Code, resp.Parity, 20^30)
+	}
+	if rec := do(http.MethodGet, "/tables/nonces/v1/params", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown table: status %d, want 404", rec.Code)
+	}
+}
+
+func TestNamedTables(t *testing.T) {
+	dir := t.TempDir()
+	writeHint := func(dir string, entries []uint64, chunkSize, setSize, epoch uint64) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, "deltas"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		buf := binary.LittleEndian.AppendUint64(nil, uint64(len(entries)))
+		buf = binary.LittleEndian.AppendUint64(buf, chunkSize)
+		buf = binary.LittleEndian.AppendUint64(buf, setSize)
+		buf = binary.LittleEndian.AppendUint64(buf, epoch)
+		for _, v := range entries {
+			buf = binary.LittleEndian.AppendUint64(buf, v)
+		}
+		if err := os.WriteFile(filepath.Join(dir, TableHintFile), buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeHint(dir, []uint64{1, 2, 3, 4}, 2, 2, 0)
+	writeHint(filepath.Join(dir, "tables", "nonces"), []uint64{7, 8, 0, 0}, 2, 2, 0)
+	writeHint(filepath.Join(dir, "tables", "token-balances"), []uint64{10, 20, 30, 40, 50, 60, 0, 0}, 4, 2, 0)
+	os.MkdirAll(filepath.Join(dir, "tables", "empty"), 0755)
+
+	configs, err := DiscoverTables(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TableConfig{
+		{Name: DefaultTableName, Dir: dir},
+		{Name: "nonces", Dir: filepath.Join(dir, "tables", "nonces")},
+		{Name: "token-balances", Dir: filepath.Join(dir, "tables", "token-balances")},
+	}
+	if len(configs) != len(want) || configs[0] != want[0] || configs[1] != want[1] || configs[2] != want[2] {
+		t.Fatalf("DiscoverTables = %+v, want %+v", configs, want)
+	}
+
+	s, err := LoadTable(configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range configs[1:] {
+		table, err := LoadTable(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.MountTable(cfg.Name, table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MountTable(DefaultTableName, s); err == nil {
+		t.Error("mounting a table under the server's own name succeeded")
+	}
+	mux := s.Handler()
+
+	var list TablesResponse
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tables", nil))
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Tables) != 3 || list.Tables[0].Name != DefaultTableName || list.Tables[2].Name != "token-balances" ||
+		list.Tables[2].DBSize != 8 || list.Tables[2].ChunkSize != 4 || list.Tables[1].Path != "/tables/nonces" {
+		t.Fatalf("tables = %+v", list.Tables)
+	}
+
+	// Every table, the root one included, answers under its name
+	for name, want := range map[string]uint64{DefaultTableName: 2 ^ 3, "nonces": 8 ^ 0, "token-balances": 20 ^ 30} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tables/"+name+"/v1/query/setparity", strings.NewReader(`{"indices":[1,2]}`)))
+		var resp SetParityQueryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || resp.Parity != want {
+			t.Errorf("%s: status %d, parity %d, want %d", name, rec.Code, resp.Parity, want)
+		}
+	}
+
+	configs, err = ParseTableConfig("eth-balances=/data, nonces=/data/tables/nonces")
+	if err != nil || len(configs) != 2 || configs[1] != (TableConfig{Name: "nonces", Dir: "/data/tables/nonces"}) {
+		t.Errorf("ParseTableConfig = %+v, %v", configs, err)
+	}
+	for _, bad := range []string{"", "nonces", "a/b=/data", "x=/a,x=/b"} {
+		if _, err := ParseTableConfig(bad); err == nil {
+			t.Errorf("ParseTableConfig(%q) succeeded", bad)
+		}
+	}
+}
@@ -2,24 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"log"
-	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+
+	"plinko-update-service/plinko"
+	"plinko-update-service/updater"
 )
 
 const (
 	// Database configuration
-	DBSize        = 8388608 // 2^23 accounts
-	DBEntrySize   = 8
-	DBEntryLength = 1 // DBEntrySize / 8
+	DBSize = 8388608 // 2^23 accounts
 
 	// Plinko configuration
 	CacheEnabled = true // Enable 79x speedup
@@ -30,78 +30,120 @@ const (
 	BlockProcessDelay = 100 * time.Millisecond
 
 	// Output configuration
-	DeltaDir  = "/data/deltas"
-	HintPath  = "/data/hint.bin"
-	HealthPort = "3001"
+	DataDir       = "/data"
+	DeltaDir      = "/data/deltas"
+	HintPath      = "/data/hint.bin"
+	AdditionsPath = "/data/address-additions.bin"            // Accounts added this epoch
+	TokenTableDir = "/data/tables/" + updater.TokenTableName // Optional (db-generator TOKENS)
+	HealthPort    = "3001"
+
+	// Shutdown configuration
+	ShutdownDrainTimeout = 10 * time.Second // Max time to finish in-flight HTTP requests
 
 	// Simulation (for PoC - in production, detect actual changes)
-	SimulateChanges       = true
-	ChangesPerBlock       = 2000 // Simulated account changes per block
+	SimulateChanges     = true
+	ChangesPerBlock     = 2000 // Simulated account changes per block
+	NewAccountsPerBlock = 20   // Simulated never-seen addresses per block
 )
 
-type DBEntry [DBEntryLength]uint64
-
-type PlinkoUpdateService struct {
-	client         *ethclient.Client
-	database       []uint64 // In-memory database
-	updateManager  *PlinkoUpdateManager
-	blockHeight    uint64
-	deltasGenerated uint64
-}
-
 func main() {
 	log.Println("========================================")
 	log.Println("Plinko Update Service")
 	log.Println("========================================")
-	log.Printf("Database: %d entries (%d MB)\n", DBSize, DBSize*DBEntrySize/1024/1024)
+	log.Printf("Database: %d entries (%d MB)\n", DBSize, DBSize*plinko.DBEntrySize/1024/1024)
 	log.Printf("Cache mode: %v (speedup: 79x)\n", CacheEnabled)
-	log.Printf("Simulated changes per block: %d\n", ChangesPerBlock)
+	log.Printf("Simulated changes per block: %d (+%d new accounts)\n", ChangesPerBlock, NewAccountsPerBlock)
 	log.Println()
 
+	// Cancelled on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Wait for hint.bin to exist
 	waitForHint()
 
 	// Load hint/database
 	log.Println("Loading database from hint.bin...")
-	database, chunkSize, setSize := loadDatabase()
+	st, err := updater.LoadState(DataDir, false)
+	if err != nil {
+		log.Fatalf("Failed to load hint.bin: %v", err)
+	}
+	hdr, database := st.Header, st.Database
+	log.Printf("Hint metadata: DBSize=%d, ChunkSize=%d, SetSize=%d, Epoch=%d\n",
+		hdr.DBSize, hdr.ChunkSize, hdr.SetSize, hdr.Epoch)
 	log.Printf("Loaded %d entries (ChunkSize: %d, SetSize: %d)\n",
-		len(database)/DBEntryLength, chunkSize, setSize)
+		len(database)/plinko.DBEntryLength, hdr.ChunkSize, hdr.SetSize)
 
-	// Create Plinko update manager
-	log.Println("Initializing Plinko Update Manager...")
-	pm := NewPlinkoUpdateManager(database, chunkSize, setSize)
+	metrics := NewUpdateMetrics(uint64(len(database) / plinko.DBEntryLength))
 
 	// Enable cache mode
 	if CacheEnabled {
 		log.Println("Building update cache...")
-		cacheDuration := pm.EnableCacheMode()
+		cacheDuration := st.Manager.EnableCacheMode()
+		metrics.cacheBuild.Set(cacheDuration.Seconds())
 		log.Printf("✅ Cache mode enabled in %v\n", cacheDuration)
 		log.Printf("   Memory usage: %d MB\n", CacheSizeMB)
 		log.Println()
 	}
+	if st.Book != nil {
+		log.Printf("Account insertion: %d new accounts restored, %d free slots\n",
+			len(st.Book.Additions()), st.Book.Free())
+		metrics.ObserveAccounts(0, st.Book.Free())
+	} else {
+		log.Println("Account insertion disabled (no address-mapping.bin, or keyword layout)")
+	}
+
+	// Blocks up to epoch.json's last_block are already in this hint.bin
+	startBlock := uint64(0)
+	if info, err := updater.ReadEpochInfo(DataDir); err == nil && info.Epoch == hdr.Epoch {
+		startBlock = info.LastBlock
+		log.Printf("Resuming after block %d (epoch %d)\n", startBlock, hdr.Epoch)
+	}
 
 	// Create delta directory
 	if err := os.MkdirAll(DeltaDir, 0755); err != nil {
 		log.Fatalf("Failed to create delta directory: %v", err)
 	}
 
-	// Start health check server
-	go startHealthServer()
-
-	// Create service
-	service := &PlinkoUpdateService{
-		database:       database,
-		updateManager:  pm,
-		blockHeight:    0,
-		deltasGenerated: 0,
-	}
+	// Start health check and metrics server
+	healthServer := newHealthServer(metrics)
+	healthDone := make(chan struct{})
+	go func() {
+		defer close(healthDone)
+		runHealthServer(ctx, healthServer)
+	}()
 
 	// Connect to Ethereum
 	log.Printf("Connecting to Anvil at %s...\n", AnvilURL)
-	if err := service.connectToEthereum(); err != nil {
+	client, err := connectToEthereum()
+	if err != nil {
 		log.Fatalf("Failed to connect to Ethereum: %v", err)
 	}
-	defer service.client.Close()
+	defer client.Close()
+
+	// Create service
+	service := updater.New(client, database, st.Manager, updater.Config{
+		DeltaDir:            DeltaDir,
+		PollInterval:        BlockProcessDelay,
+		Epoch:               hdr.Epoch,
+		StartBlock:          startBlock,
+		SimulateChanges:     SimulateChanges,
+		ChangesPerBlock:     ChangesPerBlock,
+		DBSize:              DBSize,
+		NewAccountsPerBlock: NewAccountsPerBlock,
+	})
+	service.SetObserver(metrics)
+	if tokens := loadTokenTable(); tokens != nil {
+		service.EnableTokens(client, tokens)
+		metrics.ObserveTokens(0, 0, 0, tokens.Book.Free())
+	}
+	if st.Book != nil {
+		// plinko-hint-generator (HINT_MODE=watch) answers regenerate.json
+		service.EnableInsertion(st.Book, AdditionsPath, &updater.FileGrower{
+			DataDir:   DataDir,
+			CacheMode: CacheEnabled,
+		})
+	}
 
 	log.Println("✅ Connected to Anvil")
 	log.Println()
@@ -109,8 +151,31 @@ func main() {
 	log.Println("========================================")
 	log.Println()
 
-	// Monitor blocks
-	service.monitorBlocks()
+	// Monitor blocks until shutdown
+	service.Run(ctx)
+
+	<-healthDone
+	log.Printf("✅ Stopped at block %d (%d deltas generated)\n",
+		service.BlockHeight(), service.DeltasGenerated())
+}
+
+// loadTokenTable loads the token-balances table, if db-generator built one
+// and plinko-hint-generator wrote its hint.bin
+func loadTokenTable() *updater.TokenTable {
+	if _, err := os.Stat(filepath.Join(TokenTableDir, updater.HintFile)); err != nil {
+		log.Println("Token table: none")
+		return nil
+	}
+	tokens, err := updater.LoadTokenTable(TokenTableDir, CacheEnabled)
+	if err != nil {
+		log.Fatalf("Failed to load token table: %v", err)
+	}
+	if err := os.MkdirAll(tokens.DeltaDir(), 0755); err != nil {
+		log.Fatalf("Failed to create token delta directory: %v", err)
+	}
+	log.Printf("Token table: %d tokens, %d pairs (%d free slots), updating after block %d\n",
+		len(tokens.Tokens), tokens.Header.DBSize, tokens.Book.Free(), tokens.LastBlock)
+	return tokens
 }
 
 func waitForHint() {
@@ -128,203 +193,37 @@ func waitForHint() {
 	log.Fatal("Timeout waiting for hint.bin")
 }
 
-func loadDatabase() ([]uint64, uint64, uint64) {
-	data, err := os.ReadFile(HintPath)
-	if err != nil {
-		log.Fatalf("Failed to read hint.bin: %v", err)
-	}
-
-	// Read metadata header
-	if len(data) < 32 {
-		log.Fatal("Invalid hint.bin: too small for header")
-	}
-
-	dbSize := binary.LittleEndian.Uint64(data[0:8])
-	chunkSize := binary.LittleEndian.Uint64(data[8:16])
-	setSize := binary.LittleEndian.Uint64(data[16:24])
-
-	log.Printf("Hint metadata: DBSize=%d, ChunkSize=%d, SetSize=%d\n",
-		dbSize, chunkSize, setSize)
-
-	// Extract database (skip 32-byte header)
-	dbBytes := data[32:]
-	dbEntries := len(dbBytes) / DBEntrySize
-
-	database := make([]uint64, dbEntries)
-	for i := 0; i < dbEntries; i++ {
-		database[i] = binary.LittleEndian.Uint64(dbBytes[i*DBEntrySize : (i+1)*DBEntrySize])
-	}
-
-	return database, chunkSize, setSize
-}
-
-func (s *PlinkoUpdateService) connectToEthereum() error {
+func connectToEthereum() (*ethclient.Client, error) {
+	var client *ethclient.Client
 	var err error
 	// Try WebSocket first, fall back to HTTP
 	wsURL := AnvilURL
 	httpURL := "http://eth-mock:8545"
 
 	for i := 0; i < 10; i++ {
-		s.client, err = ethclient.Dial(wsURL)
+		client, err = ethclient.Dial(wsURL)
 		if err == nil {
-			return nil
+			return client, nil
 		}
 
 		log.Printf("WebSocket connection failed, trying HTTP...")
-		s.client, err = ethclient.Dial(httpURL)
+		client, err = ethclient.Dial(httpURL)
 		if err == nil {
 			log.Printf("⚠️  Using HTTP polling (WebSocket unavailable)")
-			return nil
+			return client, nil
 		}
 
 		log.Printf("Connection attempt %d/10 failed, retrying...\n", i+1)
 		time.Sleep(2 * time.Second)
 	}
 
-	return fmt.Errorf("failed to connect after 10 attempts: %w", err)
-}
-
-func (s *PlinkoUpdateService) monitorBlocks() {
-	ctx := context.Background()
-	ticker := time.NewTicker(BlockProcessDelay)
-	defer ticker.Stop()
-
-	var lastBlockNumber uint64 = 0
-
-	for range ticker.C {
-		// Get latest block number
-		blockNumber, err := s.client.BlockNumber(ctx)
-		if err != nil {
-			log.Printf("Error getting block number: %v\n", err)
-			continue
-		}
-
-		// Process new blocks
-		if blockNumber > lastBlockNumber {
-			for bn := lastBlockNumber + 1; bn <= blockNumber; bn++ {
-				if err := s.processBlock(ctx, bn); err != nil {
-					log.Printf("Error processing block %d: %v\n", bn, err)
-				}
-			}
-			lastBlockNumber = blockNumber
-		}
-	}
+	return nil, fmt.Errorf("failed to connect after 10 attempts: %w", err)
 }
 
-func (s *PlinkoUpdateService) processBlock(ctx context.Context, blockNumber uint64) error {
-	startTime := time.Now()
-
-	// Get block header
-	header, err := s.client.HeaderByNumber(ctx, big.NewInt(int64(blockNumber)))
-	if err != nil {
-		return fmt.Errorf("failed to get block header: %w", err)
-	}
-
-	// Simulate account changes (in production, detect actual changes)
-	updates := s.detectChanges(blockNumber, header)
-
-	if len(updates) == 0 {
-		// No changes detected
-		return nil
-	}
-
-	// Generate hint deltas using Plinko
-	deltas, updateDuration := s.updateManager.ApplyUpdates(updates)
-
-	// Save delta file
-	deltaPath := filepath.Join(DeltaDir, fmt.Sprintf("delta-%06d.bin", blockNumber))
-	if err := saveDelta(deltaPath, deltas); err != nil {
-		return fmt.Errorf("failed to save delta: %w", err)
-	}
-
-	s.deltasGenerated++
-
-	// Log progress
-	blockDuration := time.Since(startTime)
-	log.Printf("Block %d: %d changes, %d deltas, update: %v, total: %v\n",
-		blockNumber, len(updates), len(deltas),
-		updateDuration, blockDuration)
-
-	return nil
-}
-
-func (s *PlinkoUpdateService) detectChanges(blockNumber uint64, header *types.Header) []DBUpdate {
-	// PoC: Simulate account changes
-	// In production: parse block transactions and detect balance/state changes
-
-	if !SimulateChanges {
-		return nil
-	}
-
-	updates := make([]DBUpdate, ChangesPerBlock)
-
-	// Simulate deterministic changes based on block number
-	for i := 0; i < ChangesPerBlock; i++ {
-		index := uint64((blockNumber*ChangesPerBlock + uint64(i)) % DBSize)
-
-		// Read old value
-		oldValue := s.readDBEntry(index)
-
-		// Generate new value (simulated change)
-		newValue := DBEntry{uint64(blockNumber)*1000 + uint64(i)}
-
-		updates[i] = DBUpdate{
-			Index:    index,
-			OldValue: oldValue,
-			NewValue: newValue,
-		}
-	}
-
-	return updates
-}
-
-func (s *PlinkoUpdateService) readDBEntry(index uint64) DBEntry {
-	if index >= uint64(len(s.database)/DBEntryLength) {
-		return DBEntry{}
-	}
-	return DBEntry{s.database[index]}
-}
-
-func saveDelta(path string, deltas []HintDelta) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Write delta count
-	var header [16]byte
-	binary.LittleEndian.PutUint64(header[0:8], uint64(len(deltas)))
-	binary.LittleEndian.PutUint64(header[8:16], 0) // Reserved
-
-	if _, err := f.Write(header[:]); err != nil {
-		return err
-	}
-
-	// Write each delta
-	for _, delta := range deltas {
-		var entry [24]byte
-		binary.LittleEndian.PutUint64(entry[0:8], delta.HintSetID)
-		binary.LittleEndian.PutUint64(entry[8:16], boolToUint64(delta.IsBackupSet))
-		binary.LittleEndian.PutUint64(entry[16:24], delta.Delta[0])
-
-		if _, err := f.Write(entry[:]); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func boolToUint64(b bool) uint64 {
-	if b {
-		return 1
-	}
-	return 0
-}
-
-func startHealthServer() {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+// newHealthServer creates the health/metrics HTTP server on its own mux
+func newHealthServer(metrics *UpdateMetrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		// Check if delta directory exists
 		if _, err := os.Stat(DeltaDir); os.IsNotExist(err) {
 			http.Error(w, "Delta directory not ready", http.StatusServiceUnavailable)
@@ -334,9 +233,30 @@ func startHealthServer() {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, `{"status":"healthy","service":"plinko-update"}`)
 	})
+	mux.HandleFunc("/metrics", metrics.metricsHandler)
+
+	return &http.Server{Addr: ":" + HealthPort, Handler: mux}
+}
 
-	log.Printf("Health check server listening on :%s\n", HealthPort)
-	if err := http.ListenAndServe(":"+HealthPort, nil); err != nil {
+// runHealthServer serves until ctx is cancelled, then shuts down gracefully
+func runHealthServer(ctx context.Context, srv *http.Server) {
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Health check and metrics server listening on %s\n", srv.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
 		log.Printf("Health server error: %v\n", err)
+		return
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownDrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Health server shutdown error: %v\n", err)
 	}
+	<-errCh
 }
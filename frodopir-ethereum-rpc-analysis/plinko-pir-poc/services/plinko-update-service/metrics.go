@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus metrics in the text exposition format
+//
+// Same primitives as plinko-pir-server/metrics.go. The update service never
+// sees client queries, so these only describe blocks and deltas.
+
+// UpdateLatencyBuckets covers per-block update times from microseconds
+// (cache mode) up to seconds (iPRF mode on large blocks)
+var UpdateLatencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Inc()          { g.Add(1) }
+func (g *Gauge) Dec()          { g.Add(-1) }
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // Upper bounds, ascending
+	counts  []uint64  // counts[i] = observations <= buckets[i]
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{buckets: b, counts: make([]uint64, len(b))}
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, ub := range h.buckets {
+		if v <= ub {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveDuration records a duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, ub := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(ub), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(labels), h.count)
+}
+
+// UpdateMetrics holds every metric exported by the update service
+type UpdateMetrics struct {
+	blockHeight     Gauge
+	blocksProcessed Counter
+	deltasGenerated Counter // Delta files written
+	hintDeltas      Counter // HintDelta entries across all files
+	dbUpdates       Counter // Database entries changed
+	updateDuration  *Histogram
+	blockDuration   *Histogram
+	cacheBuild      Gauge
+	dbEntries       Gauge
+	accountsAdded   Counter // New accounts given a padding slot
+	freeSlots       Gauge
+	tokenUpdates    Counter // Token table entries changed
+	tokenPairsAdded Counter
+	tokenSkipped    Counter // New pairs dropped for lack of slots
+	tokenFreeSlots  Gauge
+}
+
+// NewUpdateMetrics creates metrics for a database with dbEntries entries
+func NewUpdateMetrics(dbEntries uint64) *UpdateMetrics {
+	m := &UpdateMetrics{
+		updateDuration: NewHistogram(UpdateLatencyBuckets),
+		blockDuration:  NewHistogram(UpdateLatencyBuckets),
+	}
+	m.dbEntries.Set(float64(dbEntries))
+	return m
+}
+
+// ObserveBlock records a processed block
+func (m *UpdateMetrics) ObserveBlock(blockNumber uint64, updates, deltas int, updateDuration, blockDuration time.Duration) {
+	m.blockHeight.Set(float64(blockNumber))
+	m.blocksProcessed.Inc()
+	m.dbUpdates.Add(uint64(updates))
+	if deltas > 0 {
+		m.deltasGenerated.Inc()
+		m.hintDeltas.Add(uint64(deltas))
+	}
+	m.updateDuration.ObserveDuration(updateDuration)
+	m.blockDuration.ObserveDuration(blockDuration)
+}
+
+// ObserveAccounts records a block's new accounts and the slots left
+func (m *UpdateMetrics) ObserveAccounts(added int, freeSlots uint64) {
+	m.accountsAdded.Add(uint64(added))
+	m.freeSlots.Set(float64(freeSlots))
+}
+
+// ObserveTokens records a block's token table changes
+func (m *UpdateMetrics) ObserveTokens(updates, added, skipped int, freeSlots uint64) {
+	m.tokenUpdates.Add(uint64(updates))
+	m.tokenPairsAdded.Add(uint64(added))
+	m.tokenSkipped.Add(uint64(skipped))
+	m.tokenFreeSlots.Set(float64(freeSlots))
+}
+
+// Write writes all metrics in Prometheus text format
+func (m *UpdateMetrics) Write(w io.Writer) {
+	writeGauge(w, "plinko_update_block_height", "Last block processed.", m.blockHeight.Value())
+	writeCounter(w, "plinko_update_blocks_processed_total", "Blocks processed.", m.blocksProcessed.Value())
+	writeCounter(w, "plinko_update_deltas_generated_total", "Delta files written.", m.deltasGenerated.Value())
+	writeCounter(w, "plinko_update_hint_deltas_total", "Hint delta entries written.", m.hintDeltas.Value())
+	writeCounter(w, "plinko_update_db_updates_total", "Database entries updated.", m.dbUpdates.Value())
+
+	fmt.Fprintln(w, "# HELP plinko_update_duration_seconds Time to apply a block's updates and compute hint deltas.")
+	fmt.Fprintln(w, "# TYPE plinko_update_duration_seconds histogram")
+	m.updateDuration.write(w, "plinko_update_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP plinko_update_block_duration_seconds Total time to process a block, including delta file write.")
+	fmt.Fprintln(w, "# TYPE plinko_update_block_duration_seconds histogram")
+	m.blockDuration.write(w, "plinko_update_block_duration_seconds", "")
+
+	writeGauge(w, "plinko_update_cache_build_seconds", "Time taken to build the index-to-hint cache.", m.cacheBuild.Value())
+	writeGauge(w, "plinko_update_db_entries", "Number of database entries loaded.", m.dbEntries.Value())
+	writeCounter(w, "plinko_update_accounts_added_total", "New accounts allocated a padding slot.", m.accountsAdded.Value())
+	writeGauge(w, "plinko_update_free_slots", "Padding slots left for new accounts.", m.freeSlots.Value())
+	writeCounter(w, "plinko_update_token_db_updates_total", "Token balance entries updated.", m.tokenUpdates.Value())
+	writeCounter(w, "plinko_update_token_pairs_added_total", "New (holder, token) pairs allocated a padding slot.", m.tokenPairsAdded.Value())
+	writeCounter(w, "plinko_update_token_pairs_skipped_total", "New (holder, token) pairs skipped for lack of padding slots.", m.tokenSkipped.Value())
+	writeGauge(w, "plinko_update_token_free_slots", "Padding slots left for new token pairs.", m.tokenFreeSlots.Value())
+}
+
+// metricsHandler serves the Prometheus scrape endpoint
+func (m *UpdateMetrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	s := fmt.Sprintf("%g", v)
+	if strings.Contains(s, "e+") {
+		s = fmt.Sprintf("%.0f", v)
+	}
+	return s
+}
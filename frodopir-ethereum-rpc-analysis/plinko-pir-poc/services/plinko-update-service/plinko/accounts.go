@@ -0,0 +1,234 @@
+package plinko
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Account insertion
+//
+// hint.bin pads the DBSize accounts to ChunkSize*SetSize entries. The padding
+// slots are zero and already covered by every client's hints, so a new
+// account can take one over with an ordinary delta (old value 0). The
+// AddressBook hands out slots in order, DBSize first, and records each
+// (address, index) pair in the 24-byte address-mapping.bin record format:
+// 20-byte address, uint32 LE index.
+
+const MappingEntrySize = 24
+
+// ErrCapacityExhausted is returned when every padding slot is taken
+var ErrCapacityExhausted = errors.New("no free database slots; hint.bin needs regenerating")
+
+// Address is a 20-byte Ethereum address
+type Address [20]byte
+
+// MappingEntry is one address-mapping.bin record
+type MappingEntry struct {
+	Address Address
+	Index   uint64
+}
+
+// AddressBook maps addresses to database indices and allocates padding
+// slots to new ones
+type AddressBook struct {
+	existing []byte // address-mapping.bin records, sorted by address
+	added    map[Address]uint64
+	addition []MappingEntry // Allocation order
+	next     uint64         // First free slot
+	capacity uint64         // ChunkSize*SetSize
+}
+
+// NewAddressBook builds a book from address-mapping.bin (mapping) for a
+// database whose slots from used up to capacity are free
+func NewAddressBook(mapping []byte, used, capacity uint64) (*AddressBook, error) {
+	if len(mapping)%MappingEntrySize != 0 {
+		return nil, fmt.Errorf("address mapping of %d bytes is not a multiple of %d", len(mapping), MappingEntrySize)
+	}
+	if used > capacity {
+		return nil, fmt.Errorf("%d used entries exceed capacity %d", used, capacity)
+	}
+	n := len(mapping) / MappingEntrySize
+	sorted := append([]byte(nil), mapping...)
+	sort.Sort(mappingRecords(sorted))
+	for i := 0; i < n; i++ {
+		rec := sorted[i*MappingEntrySize : (i+1)*MappingEntrySize]
+		if idx := uint64(binary.LittleEndian.Uint32(rec[20:])); idx >= used {
+			return nil, fmt.Errorf("address mapping points at entry %d, past the %d used entries", idx, used)
+		}
+		if i > 0 && bytes.Equal(rec[:20], sorted[(i-1)*MappingEntrySize:(i-1)*MappingEntrySize+20]) {
+			return nil, fmt.Errorf("address %x appears twice in the address mapping", rec[:20])
+		}
+	}
+	return &AddressBook{
+		existing: sorted,
+		added:    make(map[Address]uint64),
+		next:     used,
+		capacity: capacity,
+	}, nil
+}
+
+// LoadAddressBook reads address-mapping.bin from path
+func LoadAddressBook(path string, used, capacity uint64) (*AddressBook, error) {
+	mapping, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewAddressBook(mapping, used, capacity)
+}
+
+// Lookup returns the database index of addr
+func (b *AddressBook) Lookup(addr Address) (uint64, bool) {
+	if idx, ok := b.added[addr]; ok {
+		return idx, true
+	}
+	n := len(b.existing) / MappingEntrySize
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(b.existing[i*MappingEntrySize:i*MappingEntrySize+20], addr[:]) >= 0
+	})
+	if i < n {
+		rec := b.existing[i*MappingEntrySize : (i+1)*MappingEntrySize]
+		if Address(rec[:20]) == addr {
+			return uint64(binary.LittleEndian.Uint32(rec[20:])), true
+		}
+	}
+	return 0, false
+}
+
+// Allocate gives addr the next free slot. Addresses already in the book
+// keep their index.
+func (b *AddressBook) Allocate(addr Address) (index uint64, isNew bool, err error) {
+	if idx, ok := b.Lookup(addr); ok {
+		return idx, false, nil
+	}
+	if b.next >= b.capacity {
+		return 0, false, ErrCapacityExhausted
+	}
+	idx := b.next
+	b.next++
+	b.added[addr] = idx
+	b.addition = append(b.addition, MappingEntry{Address: addr, Index: idx})
+	return idx, true, nil
+}
+
+// Restore replays additions saved by an earlier run (address-additions.bin),
+// which must continue from the book's first free slot
+func (b *AddressBook) Restore(entries []MappingEntry) error {
+	for _, e := range entries {
+		if e.Index != b.next {
+			return fmt.Errorf("saved addition %x at entry %d, next free slot is %d", e.Address, e.Index, b.next)
+		}
+		if _, isNew, err := b.Allocate(e.Address); err != nil {
+			return err
+		} else if !isNew {
+			return fmt.Errorf("saved addition %x is already in the database", e.Address)
+		}
+	}
+	return nil
+}
+
+// Truncate forgets every allocation after the first n, for a block whose
+// files could not be written
+func (b *AddressBook) Truncate(n int) {
+	for _, e := range b.addition[n:] {
+		delete(b.added, e.Address)
+	}
+	b.next -= uint64(len(b.addition) - n)
+	b.addition = b.addition[:n]
+}
+
+// Additions returns every allocation since the book was created, in order
+func (b *AddressBook) Additions() []MappingEntry { return b.addition }
+
+// Used returns the number of occupied entries (the first free slot)
+func (b *AddressBook) Used() uint64 { return b.next }
+
+// Free returns the number of padding slots left
+func (b *AddressBook) Free() uint64 { return b.capacity - b.next }
+
+// mappingRecords sorts raw 24-byte records by address
+type mappingRecords []byte
+
+func (m mappingRecords) Len() int { return len(m) / MappingEntrySize }
+func (m mappingRecords) Less(i, j int) bool {
+	return bytes.Compare(m[i*MappingEntrySize:i*MappingEntrySize+20], m[j*MappingEntrySize:j*MappingEntrySize+20]) < 0
+}
+func (m mappingRecords) Swap(i, j int) {
+	var tmp [MappingEntrySize]byte
+	a, b := m[i*MappingEntrySize:(i+1)*MappingEntrySize], m[j*MappingEntrySize:(j+1)*MappingEntrySize]
+	copy(tmp[:], a)
+	copy(a, b)
+	copy(b, tmp[:])
+}
+
+// WriteMappingEntries writes entries as address-mapping.bin records
+func WriteMappingEntries(w io.Writer, entries []MappingEntry) error {
+	bw := bufio.NewWriter(w)
+	var rec [MappingEntrySize]byte
+	for _, e := range entries {
+		copy(rec[:20], e.Address[:])
+		binary.LittleEndian.PutUint32(rec[20:], uint32(e.Index))
+		if _, err := bw.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadMappingEntries reads address-mapping.bin records
+func ReadMappingEntries(r io.Reader) ([]MappingEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%MappingEntrySize != 0 {
+		return nil, fmt.Errorf("%d bytes of mapping records is not a multiple of %d", len(data), MappingEntrySize)
+	}
+	entries := make([]MappingEntry, len(data)/MappingEntrySize)
+	for i := range entries {
+		rec := data[i*MappingEntrySize:]
+		entries[i] = MappingEntry{Address: Address(rec[:20]), Index: uint64(binary.LittleEndian.Uint32(rec[20:24]))}
+	}
+	return entries, nil
+}
+
+// SaveMappingEntries writes entries to path atomically, like SaveDelta
+func SaveMappingEntries(path string, entries []MappingEntry) error {
+	return WriteFileAtomic(path, func(w io.Writer) error { return WriteMappingEntries(w, entries) })
+}
+
+// WriteFileAtomic writes path through a synced temporary file in the same
+// directory, so readers never see a partial file
+func WriteFileAtomic(path string, write func(io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,55 @@
+package plinko
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	DBEntrySize   = 8
+	DBEntryLength = 1 // DBEntrySize / 8
+
+	HintHeaderSize = 32 // [DBSize][ChunkSize][SetSize][Epoch]
+)
+
+type DBEntry [DBEntryLength]uint64
+
+// HintHeader is the metadata header of hint.bin
+type HintHeader struct {
+	DBSize    uint64
+	ChunkSize uint64
+	SetSize   uint64
+	Epoch     uint64
+}
+
+// LoadDatabase reads the padded database and its header from hint.bin
+func LoadDatabase(path string) ([]uint64, HintHeader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, HintHeader{}, err
+	}
+
+	// Read metadata header
+	if len(data) < HintHeaderSize {
+		return nil, HintHeader{}, fmt.Errorf("invalid hint.bin: too small for header")
+	}
+
+	h := HintHeader{
+		DBSize:    binary.LittleEndian.Uint64(data[0:8]),
+		ChunkSize: binary.LittleEndian.Uint64(data[8:16]),
+		SetSize:   binary.LittleEndian.Uint64(data[16:24]),
+		Epoch:     binary.LittleEndian.Uint64(data[24:32]),
+	}
+
+	// Extract database (skip 32-byte header)
+	dbBytes := data[HintHeaderSize:]
+	dbEntries := len(dbBytes) / DBEntrySize
+
+	database := make([]uint64, dbEntries)
+	for i := 0; i < dbEntries; i++ {
+		database[i] = binary.LittleEndian.Uint64(dbBytes[i*DBEntrySize : (i+1)*DBEntrySize])
+	}
+
+	return database, h, nil
+}
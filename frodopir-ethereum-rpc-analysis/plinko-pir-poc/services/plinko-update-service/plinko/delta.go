@@ -0,0 +1,57 @@
+package plinko
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Delta file format (read by clients, see plinko-client/delta.go):
+//
+//	[0:8]   Delta count
+//	[8:16]  Format version
+//	then per delta: HintSetID, IsBackupSet, Delta, Index (uint64 LE each)
+
+const (
+	DeltaFormatVersion = 1  // Header [8:16]; v1 entries carry the database index
+	DeltaEntrySize     = 32 // Bytes per v1 delta entry
+)
+
+// SaveDelta writes deltas to path atomically: the data goes to a temporary
+// file in the same directory, which is synced and then renamed into place.
+// Readers (CDN, clients) never see a partially written delta file.
+func SaveDelta(path string, deltas []HintDelta) error {
+	return WriteFileAtomic(path, func(f io.Writer) error {
+		w := bufio.NewWriter(f)
+
+		// Write delta count and format version
+		var header [16]byte
+		binary.LittleEndian.PutUint64(header[0:8], uint64(len(deltas)))
+		binary.LittleEndian.PutUint64(header[8:16], DeltaFormatVersion)
+
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+
+		// Write each delta
+		for _, delta := range deltas {
+			var entry [DeltaEntrySize]byte
+			binary.LittleEndian.PutUint64(entry[0:8], delta.HintSetID)
+			binary.LittleEndian.PutUint64(entry[8:16], boolToUint64(delta.IsBackupSet))
+			binary.LittleEndian.PutUint64(entry[16:24], delta.Delta[0])
+			binary.LittleEndian.PutUint64(entry[24:32], delta.Index)
+
+			if _, err := w.Write(entry[:]); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
+
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package plinko
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveDelta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delta-000001.bin")
+	deltas := []HintDelta{
+		{HintSetID: 3, Delta: DBEntry{0xff}, Index: 12},
+		{HintSetID: 7, IsBackupSet: true, Delta: DBEntry{1}, Index: 40},
+	}
+	if err := SaveDelta(path, deltas); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 16+len(deltas)*DeltaEntrySize {
+		t.Fatalf("delta file is %d bytes, want %d", len(data), 16+len(deltas)*DeltaEntrySize)
+	}
+	if n, v := binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:]); n != 2 || v != DeltaFormatVersion {
+		t.Errorf("header: %d deltas, version %d", n, v)
+	}
+	entry := data[16+DeltaEntrySize:]
+	if got := [4]uint64{
+		binary.LittleEndian.Uint64(entry), binary.LittleEndian.Uint64(entry[8:]),
+		binary.LittleEndian.Uint64(entry[16:]), binary.LittleEndian.Uint64(entry[24:]),
+	}; got != [4]uint64{7, 1, 1, 40} {
+		t.Errorf("second entry = %v, want [7 1 1 40]", got)
+	}
+}
+
+func TestWriteFileAtomicLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "delta-000001.bin")
+	if err := SaveDelta(path, []HintDelta{{HintSetID: 1, Delta: DBEntry{1}}}); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := os.ReadFile(path)
+
+	// A writer that stops halfway, as an interrupted write would
+	errStopped := errors.New("stopped")
+	err := WriteFileAtomic(path, func(w io.Writer) error {
+		w.Write(make([]byte, 20))
+		return errStopped
+	})
+	if !errors.Is(err, errStopped) {
+		t.Fatalf("WriteFileAtomic = %v, want the writer's error", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(old) {
+		t.Errorf("failed write changed the file to %d bytes", len(data))
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, "delta-000002.bin"), func(w io.Writer) error { return errStopped }); err == nil {
+		t.Fatal("failed write of a new file succeeded")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files after failed writes: %v, want only delta-000001.bin", names)
+	}
+}
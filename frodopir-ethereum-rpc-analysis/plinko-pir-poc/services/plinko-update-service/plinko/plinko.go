@@ -1,6 +1,7 @@
-package main
+package plinko
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -20,12 +21,14 @@ type HintDelta struct {
 	HintSetID   uint64  // Which hint set to update
 	IsBackupSet bool    // true if BackupSet, false if LocalSet
 	Delta       DBEntry // XOR delta to apply
+	Index       uint64  // Database index that changed (lets clients find every hint containing it)
 }
 
 // PlinkoUpdateManager handles incremental database updates
 type PlinkoUpdateManager struct {
 	database     []uint64 // Reference to the database
 	iprf         *IPRF    // Invertible PRF for mapping indices to hint sets
+	dbSize       uint64   // Number of database entries (iPRF domain)
 	chunkSize    uint64
 	setSize      uint64
 	indexToHint  []uint64 // Pre-computed mapping: indexToHint[i] = hint set for database index i
@@ -35,7 +38,7 @@ type PlinkoUpdateManager struct {
 // NewPlinkoUpdateManager creates a new update manager
 func NewPlinkoUpdateManager(database []uint64, chunkSize, setSize uint64) *PlinkoUpdateManager {
 	// Create iPRF for mapping database indices to hint sets
-	// Domain: n = DBSize (number of database entries)
+	// Domain: n = number of database entries
 	// Range: m = SetSize (number of chunks/hint sets)
 
 	// Create iPRF with deterministic key for testing
@@ -44,11 +47,13 @@ func NewPlinkoUpdateManager(database []uint64, chunkSize, setSize uint64) *Plink
 		key[i] = byte(i)
 	}
 
-	iprf := NewIPRF(key, DBSize, setSize)
+	dbSize := uint64(len(database) / DBEntryLength)
+	iprf := NewIPRF(key, dbSize, setSize)
 
 	return &PlinkoUpdateManager{
 		database:     database,
 		iprf:         iprf,
+		dbSize:       dbSize,
 		chunkSize:    chunkSize,
 		setSize:      setSize,
 		indexToHint:  nil,
@@ -65,10 +70,10 @@ func (pm *PlinkoUpdateManager) EnableCacheMode() time.Duration {
 	startTime := time.Now()
 
 	// Allocate cache array
-	pm.indexToHint = make([]uint64, DBSize)
+	pm.indexToHint = make([]uint64, pm.dbSize)
 
 	// Pre-compute hint mapping for all database indices
-	for i := uint64(0); i < DBSize; i++ {
+	for i := uint64(0); i < pm.dbSize; i++ {
 		pm.indexToHint[i] = pm.iprf.Forward(i)
 
 		// Progress indicator (every 1M entries)
@@ -84,24 +89,42 @@ func (pm *PlinkoUpdateManager) EnableCacheMode() time.Duration {
 // ApplyUpdates processes a batch of database updates and generates hint deltas
 //
 // Algorithm:
-// 1. For each updated database entry:
-//    a. Use iPRF to find which hint sets are affected
-//    b. Compute XOR delta: delta = old_value ⊕ new_value
-//    c. Generate HintDelta for each affected hint set
-// 2. Apply database updates
-// 3. Return hint deltas for client
+//  1. For each updated database entry:
+//     a. Use iPRF to find which hint sets are affected
+//     b. Compute XOR delta: delta = old_value ⊕ new_value
+//     c. Generate HintDelta for each affected hint set
+//  2. Apply database updates
+//  3. Return hint deltas for client
 //
 // Complexity: O(|updates|) with O(1) per update (Plinko's guarantee)
-func (pm *PlinkoUpdateManager) ApplyUpdates(updates []DBUpdate) ([]HintDelta, time.Duration) {
+//
+// Every index must be inside the padded database; otherwise nothing is
+// applied and an error is returned.
+func (pm *PlinkoUpdateManager) ApplyUpdates(updates []DBUpdate) ([]HintDelta, time.Duration, error) {
+	deltas, elapsed, err := pm.Deltas(updates)
+	if err != nil {
+		return nil, 0, err
+	}
+	pm.Apply(updates)
+	return deltas, elapsed, nil
+}
+
+// Deltas returns the hint deltas of updates without changing the database,
+// so they can be saved before Apply; it checks the indices as ApplyUpdates
+// does
+func (pm *PlinkoUpdateManager) Deltas(updates []DBUpdate) ([]HintDelta, time.Duration, error) {
 	startTime := time.Now()
 
+	for _, update := range updates {
+		if update.Index >= pm.dbSize {
+			return nil, 0, fmt.Errorf("update index %d outside database of %d entries", update.Index, pm.dbSize)
+		}
+	}
+
 	deltas := make([]HintDelta, 0, len(updates))
 
 	for _, update := range updates {
-		// Step 1: Apply database update
-		pm.applyDatabaseUpdate(update)
-
-		// Step 2: Find affected hint set
+		// Step 1: Find affected hint set
 		// Use pre-computed cache if available, otherwise compute via iPRF
 		var hintSetID uint64
 		if pm.useCacheMode {
@@ -112,34 +135,41 @@ func (pm *PlinkoUpdateManager) ApplyUpdates(updates []DBUpdate) ([]HintDelta, ti
 			hintSetID = pm.iprf.Forward(update.Index)
 		}
 
-		// Step 3: Compute XOR delta
+		// Step 2: Compute XOR delta
 		var delta DBEntry
 		for i := 0; i < DBEntryLength; i++ {
 			delta[i] = update.OldValue[i] ^ update.NewValue[i]
 		}
 
-		// Step 4: Generate hint delta
+		// Step 3: Generate hint delta
 		deltas = append(deltas, HintDelta{
 			HintSetID:   hintSetID,
 			IsBackupSet: false,
 			Delta:       delta,
+			Index:       update.Index,
 		})
 	}
 
 	elapsed := time.Since(startTime)
-	return deltas, elapsed
+	return deltas, elapsed, nil
 }
 
-// applyDatabaseUpdate updates a single database entry
+// Apply writes updates to the database, in order; Deltas or ApplyUpdates
+// must have checked their indices
+func (pm *PlinkoUpdateManager) Apply(updates []DBUpdate) {
+	for _, update := range updates {
+		pm.applyDatabaseUpdate(update)
+	}
+}
+
+// DBSize returns the number of (padded) database entries
+func (pm *PlinkoUpdateManager) DBSize() uint64 { return pm.dbSize }
+
+// applyDatabaseUpdate updates a single database entry, whose index
+// has been checked
 func (pm *PlinkoUpdateManager) applyDatabaseUpdate(update DBUpdate) {
 	// Update the database in place
 	startIdx := update.Index * DBEntryLength
-	endIdx := (update.Index + 1) * DBEntryLength
-
-	if endIdx > uint64(len(pm.database)) {
-		// Index out of bounds - skip
-		return
-	}
 
 	// Copy new value to database
 	for i := uint64(0); i < DBEntryLength; i++ {
@@ -0,0 +1,168 @@
+package plinko
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Token balances
+//
+// The token-balances table has one entry per (holder, token) pair, located
+// through token-mapping.bin: 44-byte records of holder, token and uint32 LE
+// index (db-generator's dbgen.WriteTokenMapping). Like the account database,
+// hint.bin pads it, and TokenBook hands the padding slots to pairs that
+// receive a token for the first time.
+
+const TokenMappingEntrySize = 44
+
+// TokenPair identifies a token-balances entry
+type TokenPair struct {
+	Holder Address
+	Token  Address
+}
+
+// TokenMappingEntry is one token-mapping.bin record
+type TokenMappingEntry struct {
+	TokenPair
+	Index uint64
+}
+
+// TokenBook maps (holder, token) pairs to database indices and allocates
+// padding slots to new ones. The table is small next to the account
+// database, so it is held in a map.
+type TokenBook struct {
+	index    map[TokenPair]uint64
+	addition []TokenMappingEntry // Allocation order
+	next     uint64              // First free slot
+	capacity uint64
+}
+
+// NewTokenBook builds a book from token-mapping.bin records for a table
+// whose slots from used up to capacity are free
+func NewTokenBook(entries []TokenMappingEntry, used, capacity uint64) (*TokenBook, error) {
+	if used > capacity {
+		return nil, fmt.Errorf("%d used entries exceed capacity %d", used, capacity)
+	}
+	b := &TokenBook{index: make(map[TokenPair]uint64, len(entries)), next: used, capacity: capacity}
+	for _, e := range entries {
+		if e.Index >= used {
+			return nil, fmt.Errorf("token mapping points at entry %d, past the %d used entries", e.Index, used)
+		}
+		if _, ok := b.index[e.TokenPair]; ok {
+			return nil, fmt.Errorf("pair %x/%x appears twice in the token mapping", e.Holder, e.Token)
+		}
+		b.index[e.TokenPair] = e.Index
+	}
+	return b, nil
+}
+
+// LoadTokenBook reads token-mapping.bin from path
+func LoadTokenBook(path string, used, capacity uint64) (*TokenBook, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := ReadTokenMappingEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewTokenBook(entries, used, capacity)
+}
+
+// Lookup returns the database index of p
+func (b *TokenBook) Lookup(p TokenPair) (uint64, bool) {
+	idx, ok := b.index[p]
+	return idx, ok
+}
+
+// Allocate gives p the next free slot. Pairs already in the book keep their
+// index.
+func (b *TokenBook) Allocate(p TokenPair) (index uint64, isNew bool, err error) {
+	if idx, ok := b.index[p]; ok {
+		return idx, false, nil
+	}
+	if b.next >= b.capacity {
+		return 0, false, ErrCapacityExhausted
+	}
+	idx := b.next
+	b.next++
+	b.index[p] = idx
+	b.addition = append(b.addition, TokenMappingEntry{TokenPair: p, Index: idx})
+	return idx, true, nil
+}
+
+// Restore replays additions saved by an earlier run, which must continue
+// from the book's first free slot
+func (b *TokenBook) Restore(entries []TokenMappingEntry) error {
+	for _, e := range entries {
+		if e.Index != b.next {
+			return fmt.Errorf("saved addition %x/%x at entry %d, next free slot is %d", e.Holder, e.Token, e.Index, b.next)
+		}
+		if _, isNew, err := b.Allocate(e.TokenPair); err != nil {
+			return err
+		} else if !isNew {
+			return fmt.Errorf("saved addition %x/%x is already in the table", e.Holder, e.Token)
+		}
+	}
+	return nil
+}
+
+// Truncate forgets every allocation after the first n, for a block whose
+// files could not be written
+func (b *TokenBook) Truncate(n int) {
+	for _, e := range b.addition[n:] {
+		delete(b.index, e.TokenPair)
+	}
+	b.next -= uint64(len(b.addition) - n)
+	b.addition = b.addition[:n]
+}
+
+// Additions returns every allocation since the book was created, in order
+func (b *TokenBook) Additions() []TokenMappingEntry { return b.addition }
+
+// Free returns the number of padding slots left
+func (b *TokenBook) Free() uint64 { return b.capacity - b.next }
+
+// WriteTokenMappingEntries writes entries as token-mapping.bin records
+func WriteTokenMappingEntries(w io.Writer, entries []TokenMappingEntry) error {
+	bw := bufio.NewWriter(w)
+	var rec [TokenMappingEntrySize]byte
+	for _, e := range entries {
+		copy(rec[:20], e.Holder[:])
+		copy(rec[20:40], e.Token[:])
+		binary.LittleEndian.PutUint32(rec[40:], uint32(e.Index))
+		if _, err := bw.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadTokenMappingEntries reads token-mapping.bin records
+func ReadTokenMappingEntries(r io.Reader) ([]TokenMappingEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%TokenMappingEntrySize != 0 {
+		return nil, fmt.Errorf("%d bytes of token mapping records is not a multiple of %d", len(data), TokenMappingEntrySize)
+	}
+	entries := make([]TokenMappingEntry, len(data)/TokenMappingEntrySize)
+	for i := range entries {
+		rec := data[i*TokenMappingEntrySize:]
+		entries[i] = TokenMappingEntry{
+			TokenPair: TokenPair{Holder: Address(rec[:20]), Token: Address(rec[20:40])},
+			Index:     uint64(binary.LittleEndian.Uint32(rec[40:44])),
+		}
+	}
+	return entries, nil
+}
+
+// SaveTokenMappingEntries writes entries to path atomically, like SaveDelta
+func SaveTokenMappingEntries(path string, entries []TokenMappingEntry) error {
+	return WriteFileAtomic(path, func(w io.Writer) error { return WriteTokenMappingEntries(w, entries) })
+}
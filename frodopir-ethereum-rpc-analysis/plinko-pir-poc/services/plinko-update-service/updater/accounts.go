@@ -0,0 +1,132 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"plinko-update-service/plinko"
+)
+
+// AdditionsPath is where the address-mapping records of the accounts added
+// in blockNumber are published, next to its delta file
+func AdditionsPath(dir string, blockNumber uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("additions-%06d.bin", blockNumber))
+}
+
+// detectAccounts returns the address-keyed changes in a block
+func (s *Service) detectAccounts(blockNumber uint64) []AccountChange {
+	// PoC: simulate never-seen addresses receiving funds
+	if s.book == nil || s.cfg.NewAccountsPerBlock == 0 {
+		return nil
+	}
+	changes := make([]AccountChange, s.cfg.NewAccountsPerBlock)
+	for i := range changes {
+		changes[i] = AccountChange{
+			Address: SimulatedAddress(blockNumber, i),
+			Balance: blockNumber*1000 + uint64(i),
+		}
+	}
+	return changes
+}
+
+// SimulatedAddress is the i-th new account simulated in blockNumber
+func SimulatedAddress(blockNumber uint64, i int) plinko.Address {
+	seed := []byte("plinko-new-account")
+	seed = binary.BigEndian.AppendUint64(seed, blockNumber)
+	seed = binary.BigEndian.AppendUint64(seed, uint64(i))
+	sum := sha256.Sum256(seed)
+	return plinko.Address(sum[:20])
+}
+
+// newAddresses returns the addresses in changes that are not in the book,
+// each once, in block order
+func (s *Service) newAddresses(changes []AccountChange) []plinko.Address {
+	if s.book == nil {
+		return nil
+	}
+	var out []plinko.Address
+	seen := make(map[plinko.Address]bool)
+	for _, c := range changes {
+		if _, ok := s.book.Lookup(c.Address); !ok && !seen[c.Address] {
+			seen[c.Address] = true
+			out = append(out, c.Address)
+		}
+	}
+	return out
+}
+
+// resolveAccounts turns address-keyed changes into database updates,
+// allocating slots to new accounts (the caller checked they fit)
+func (s *Service) resolveAccounts(changes []AccountChange) ([]plinko.DBUpdate, []plinko.MappingEntry, error) {
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+	updates := make([]plinko.DBUpdate, 0, len(changes))
+	var added []plinko.MappingEntry
+	pos := make(map[uint64]int, len(changes))
+	for _, c := range changes {
+		index, isNew, err := s.book.Allocate(c.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isNew {
+			added = append(added, plinko.MappingEntry{Address: c.Address, Index: index})
+		}
+		if i, ok := pos[index]; ok {
+			updates[i].NewValue = plinko.DBEntry{c.Balance} // Last change in the block wins
+			continue
+		}
+		pos[index] = len(updates)
+		updates = append(updates, plinko.DBUpdate{
+			Index:    index,
+			OldValue: s.readDBEntry(index),
+			NewValue: plinko.DBEntry{c.Balance},
+		})
+	}
+	return updates, added, nil
+}
+
+// saveAdditions publishes a block's new accounts and appends them to the
+// running additions file, which a restarted service replays. undo takes
+// them back out of the running file if the block's delta cannot be saved.
+func (s *Service) saveAdditions(blockNumber uint64, added []plinko.MappingEntry) (undo func() error, err error) {
+	if err := plinko.SaveMappingEntries(AdditionsPath(s.cfg.DeltaDir, blockNumber), added); err != nil {
+		return nil, err
+	}
+	if s.additionsPath == "" {
+		return func() error { return nil }, nil
+	}
+	return appendRecords(s.additionsPath, func(w io.Writer) error { return plinko.WriteMappingEntries(w, added) })
+}
+
+// appendRecords appends to the file at path and syncs it. On failure, or
+// when undo is called, the file is cut back to its old length.
+func appendRecords(path string, write func(io.Writer) error) (undo func() error, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	undo = func() error { return os.Truncate(path, fi.Size()) }
+	if err := write(f); err != nil {
+		f.Close()
+		return nil, errors.Join(err, undo())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, errors.Join(err, undo())
+	}
+	if err := f.Close(); err != nil {
+		return nil, errors.Join(err, undo())
+	}
+	return undo, nil
+}
@@ -0,0 +1,93 @@
+package updater
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"plinko-update-service/plinko"
+)
+
+func TestNewAddresses(t *testing.T) {
+	svc := newTestService(t, newDataDir(t, 4, 8), 0, nil)
+	a, b := SimulatedAddress(1, 0), SimulatedAddress(1, 1)
+	changes := []AccountChange{
+		{Address: testAddress(2)}, {Address: a}, {Address: b}, {Address: a}, {Address: testAddress(0)},
+	}
+	if got := svc.newAddresses(changes); !reflect.DeepEqual(got, []plinko.Address{a, b}) {
+		t.Errorf("newAddresses = %x, want %x", got, []plinko.Address{a, b})
+	}
+}
+
+func TestResolveAccounts(t *testing.T) {
+	svc := newTestService(t, newDataDir(t, 4, 8), 0, nil)
+	a := SimulatedAddress(1, 0)
+	updates, added, err := svc.resolveAccounts([]AccountChange{
+		{Address: testAddress(2), Balance: 5},
+		{Address: a, Balance: 7},
+		{Address: a, Balance: 9}, // Last change in the block wins
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []plinko.DBUpdate{
+		{Index: 2, OldValue: plinko.DBEntry{102}, NewValue: plinko.DBEntry{5}},
+		{Index: 4, OldValue: plinko.DBEntry{0}, NewValue: plinko.DBEntry{9}},
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates = %+v, want %+v", updates, want)
+	}
+	if want := []plinko.MappingEntry{{Address: a, Index: 4}}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %+v, want %+v", added, want)
+	}
+
+	// An address already allocated keeps its slot
+	if _, added, _ := svc.resolveAccounts([]AccountChange{{Address: a, Balance: 1}}); len(added) != 0 {
+		t.Errorf("second allocation of the same address added %+v", added)
+	}
+}
+
+func TestSaveAdditions(t *testing.T) {
+	dir := newDataDir(t, 4, 8)
+	svc := newTestService(t, dir, 0, nil)
+	blocks := [][]plinko.MappingEntry{
+		{{Address: SimulatedAddress(1, 0), Index: 4}, {Address: SimulatedAddress(1, 1), Index: 5}},
+		{{Address: SimulatedAddress(2, 0), Index: 6}},
+	}
+	for i, added := range blocks {
+		if _, err := svc.saveAdditions(uint64(i+1), added); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(path string) []plinko.MappingEntry {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		entries, err := plinko.ReadMappingEntries(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	// Each block's file holds its own accounts; the running file all of them
+	for i, added := range blocks {
+		if got := read(AdditionsPath(svc.cfg.DeltaDir, uint64(i+1))); !reflect.DeepEqual(got, added) {
+			t.Errorf("block %d additions = %+v, want %+v", i+1, got, added)
+		}
+	}
+	if got, want := read(svc.additionsPath), append(blocks[0], blocks[1]...); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %+v, want %+v", AdditionsFile, got, want)
+	}
+
+	// A restart replays them
+	st, err := LoadState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, ok := st.Book.Lookup(SimulatedAddress(2, 0)); !ok || idx != 6 || st.Book.Used() != 7 {
+		t.Errorf("replayed book: account at %d (%v), %d used; want 6, 7 used", idx, ok, st.Book.Used())
+	}
+}
@@ -0,0 +1,345 @@
+package updater
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"plinko-update-service/plinko"
+)
+
+// Database growth
+//
+// When a block brings more new accounts than there are padding slots, the
+// service hands the database to a Grower and waits. FileGrower does this
+// through the shared data directory, for plinko-hint-generator:
+//
+//  1. database.bin: the used entries, then a zero entry per pending account
+//  2. address-mapping.bin: the old records, every addition since the epoch
+//     began, then the pending accounts
+//  3. epoch.json: {"epoch": E+1, "last_block": B}, the last block whose
+//     changes are in the new database; clients with epoch E+1 hints sync
+//     deltas from B+1
+//  4. regenerate.json: {"epoch": E+1, "db_size": N, "last_block": B}
+//
+// The hint generator picks parameters for N entries plus headroom, writes
+// hint.bin with epoch E+1 and deletes the request. The service then loads
+// it, its additions start over, and the deferred block is processed again:
+// its new accounts are in the mapping by now, so they get ordinary deltas.
+//
+// If the service stops before the new hint.bin arrives, LoadState ignores
+// the mapping records past the old DBSize and the retried block grows
+// again. Additions that a finished grow has folded into the mapping are not
+// replayed.
+
+const (
+	HintFile              = "hint.bin"
+	DatabaseFile          = "database.bin"
+	MappingFile           = "address-mapping.bin"
+	AddressIndexFile      = "address-index.bin"
+	KeywordParamsFile     = "keyword-params.json"
+	AdditionsFile         = "address-additions.bin"
+	EpochFile             = "epoch.json"
+	RegenerateRequestFile = "regenerate.json"
+)
+
+// RegenerateRequest asks plinko-hint-generator for a hint.bin with room for
+// more accounts (mirrors hintgen.RegenerateRequest)
+type RegenerateRequest struct {
+	Epoch     uint64 `json:"epoch"`
+	DBSize    uint64 `json:"db_size"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// EpochInfo is epoch.json: the last block folded into an epoch's hint.bin
+type EpochInfo struct {
+	Epoch     uint64 `json:"epoch"`
+	LastBlock uint64 `json:"last_block"`
+}
+
+// State is a loaded hint.bin with its update manager and address book
+type State struct {
+	Header   plinko.HintHeader
+	Database []uint64
+	Manager  *plinko.PlinkoUpdateManager
+	Book     *plinko.AddressBook // nil if accounts cannot be inserted
+}
+
+// GrowRequest describes a database that has run out of padding slots
+type GrowRequest struct {
+	Database  []uint64
+	Book      *plinko.AddressBook
+	Pending   []plinko.Address // New accounts that did not fit, in block order
+	Epoch     uint64
+	LastBlock uint64 // Last block whose changes are in Database
+}
+
+// Grower replaces a full database with a re-parameterized one
+type Grower interface {
+	Grow(ctx context.Context, req GrowRequest) (*State, error)
+}
+
+// grow hands the database to the grower and continues with what it returns
+func (s *Service) grow(ctx context.Context, blockNumber uint64, pending []plinko.Address) error {
+	if s.grower == nil {
+		return fmt.Errorf("%d new accounts, %d free slots: %w", len(pending), s.book.Free(), plinko.ErrCapacityExhausted)
+	}
+	log.Printf("Block %d: %d new accounts, %d free slots; growing the database (epoch %d -> %d)\n",
+		blockNumber, len(pending), s.book.Free(), s.cfg.Epoch, s.cfg.Epoch+1)
+
+	st, err := s.grower.Grow(ctx, GrowRequest{
+		Database:  s.database,
+		Book:      s.book,
+		Pending:   pending,
+		Epoch:     s.cfg.Epoch,
+		LastBlock: blockNumber - 1,
+	})
+	if err != nil {
+		return fmt.Errorf("grow database: %w", err)
+	}
+	if st.Book == nil {
+		return errors.New("grown database has no address book")
+	}
+	if st.Book.Free() < uint64(len(pending)) {
+		return fmt.Errorf("grown database has %d free slots, need %d", st.Book.Free(), len(pending))
+	}
+
+	s.database, s.updateManager, s.book = st.Database, st.Manager, st.Book
+	s.cfg.Epoch = st.Header.Epoch
+	s.cfg.DBSize = st.Header.DBSize
+	log.Printf("✅ Database grown to %d entries (%d free slots), epoch %d\n",
+		st.Header.DBSize, st.Book.Free(), st.Header.Epoch)
+	return nil
+}
+
+// LoadState loads hint.bin from dataDir. Insertion needs address-mapping.bin
+// and is off for keyword-layout databases, whose accounts live in a cuckoo
+// table; allocations saved in address-additions.bin are replayed.
+func LoadState(dataDir string, cacheMode bool) (*State, error) {
+	database, hdr, err := plinko.LoadDatabase(filepath.Join(dataDir, HintFile))
+	if err != nil {
+		return nil, err
+	}
+	st := &State{
+		Header:   hdr,
+		Database: database,
+		Manager:  plinko.NewPlinkoUpdateManager(database, hdr.ChunkSize, hdr.SetSize),
+	}
+	if cacheMode {
+		st.Manager.EnableCacheMode()
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, KeywordParamsFile)); err == nil {
+		return st, nil
+	}
+	mapping, err := os.ReadFile(filepath.Join(dataDir, MappingFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.Book, err = plinko.NewAddressBook(mappingBelow(mapping, hdr.DBSize), hdr.DBSize, st.Manager.DBSize())
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dataDir, AdditionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	additions, err := plinko.ReadMappingEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", AdditionsFile, err)
+	}
+	for len(additions) > 0 && additions[0].Index < hdr.DBSize {
+		additions = additions[1:] // Already in address-mapping.bin
+	}
+	if err := st.Book.Restore(additions); err != nil {
+		return nil, fmt.Errorf("%s: %w", AdditionsFile, err)
+	}
+	return st, nil
+}
+
+// ReadEpochInfo reads epoch.json from dataDir
+func ReadEpochInfo(dataDir string) (EpochInfo, error) {
+	var info EpochInfo
+	data, err := os.ReadFile(filepath.Join(dataDir, EpochFile))
+	if err != nil {
+		return info, err
+	}
+	return info, json.Unmarshal(data, &info)
+}
+
+// FileGrower grows the database through the shared data directory (see
+// the package comment above)
+type FileGrower struct {
+	DataDir      string
+	CacheMode    bool
+	PollInterval time.Duration // How often to look for the new hint.bin
+
+	// Regenerate, if set, is called once the request is written, instead
+	// of waiting for another process to answer it
+	Regenerate func(ctx context.Context) error
+}
+
+// Grow implements Grower
+func (g *FileGrower) Grow(ctx context.Context, req GrowRequest) (*State, error) {
+	used := req.Book.Used()
+	dbSize := used + uint64(len(req.Pending))
+	epoch := req.Epoch + 1
+	base := used - uint64(len(req.Book.Additions())) // DBSize of the current hint.bin
+
+	// database.bin and address-mapping.bin, as db-generator would write them
+	err := plinko.WriteFileAtomic(g.path(DatabaseFile), func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		var buf [plinko.DBEntrySize]byte
+		for i := uint64(0); i < dbSize; i++ {
+			v := uint64(0)
+			if i < used {
+				v = req.Database[i*plinko.DBEntryLength]
+			}
+			binary.LittleEndian.PutUint64(buf[:], v)
+			bw.Write(buf[:])
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := os.ReadFile(g.path(MappingFile))
+	if err != nil {
+		return nil, err
+	}
+	mapping = mappingBelow(mapping, base) // Drop an earlier, unanswered grow's records
+	additions := append([]plinko.MappingEntry(nil), req.Book.Additions()...)
+	for i, addr := range req.Pending {
+		additions = append(additions, plinko.MappingEntry{Address: addr, Index: used + uint64(i)})
+	}
+	err = plinko.WriteFileAtomic(g.path(MappingFile), func(w io.Writer) error {
+		if _, err := w.Write(mapping); err != nil {
+			return err
+		}
+		return plinko.WriteMappingEntries(w, additions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// address-index.bin is db-generator's to write; clients fall back to
+	// address-mapping.bin without it
+	if err := os.Remove(g.path(AddressIndexFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := g.writeJSON(EpochFile, EpochInfo{Epoch: epoch, LastBlock: req.LastBlock}); err != nil {
+		return nil, err
+	}
+	if err := g.writeJSON(RegenerateRequestFile, RegenerateRequest{Epoch: epoch, DBSize: dbSize, LastBlock: req.LastBlock}); err != nil {
+		return nil, err
+	}
+	log.Printf("Requested hint.bin regeneration: %d entries, epoch %d (%s)\n", dbSize, epoch, g.path(RegenerateRequestFile))
+
+	if g.Regenerate != nil {
+		if err := g.Regenerate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.waitForEpoch(ctx, epoch); err != nil {
+		return nil, err
+	}
+
+	// The additions are in address-mapping.bin now
+	if err := os.Remove(g.path(AdditionsFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	st, err := LoadState(g.DataDir, g.CacheMode)
+	if err != nil {
+		return nil, err
+	}
+	if st.Header.DBSize != dbSize {
+		return nil, fmt.Errorf("regenerated hint.bin holds %d accounts, requested %d", st.Header.DBSize, dbSize)
+	}
+	return st, nil
+}
+
+// waitForEpoch polls hint.bin until its header carries epoch
+func (g *FileGrower) waitForEpoch(ctx context.Context, epoch uint64) error {
+	interval := g.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		hdr, err := readHintHeader(g.path(HintFile))
+		if err == nil && hdr.Epoch == epoch {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (g *FileGrower) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return plinko.WriteFileAtomic(g.path(name), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+func (g *FileGrower) path(name string) string {
+	return filepath.Join(g.DataDir, name)
+}
+
+// mappingBelow returns the address-mapping.bin records of entries below n.
+// Records at n and up were appended by a grow whose hint.bin has not been
+// loaded.
+func mappingBelow(mapping []byte, n uint64) []byte {
+	if len(mapping)%plinko.MappingEntrySize != 0 {
+		return mapping // NewAddressBook reports it
+	}
+	out := make([]byte, 0, len(mapping))
+	for i := 0; i < len(mapping); i += plinko.MappingEntrySize {
+		rec := mapping[i : i+plinko.MappingEntrySize]
+		if uint64(binary.LittleEndian.Uint32(rec[20:])) < n {
+			out = append(out, rec...)
+		}
+	}
+	return out
+}
+
+// readHintHeader reads just the 32-byte hint.bin header
+func readHintHeader(path string) (plinko.HintHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return plinko.HintHeader{}, err
+	}
+	defer f.Close()
+	var b [plinko.HintHeaderSize]byte
+	if _, err := io.ReadFull(f, b[:]); err != nil {
+		return plinko.HintHeader{}, err
+	}
+	return plinko.HintHeader{
+		DBSize:    binary.LittleEndian.Uint64(b[0:8]),
+		ChunkSize: binary.LittleEndian.Uint64(b[8:16]),
+		SetSize:   binary.LittleEndian.Uint64(b[16:24]),
+		Epoch:     binary.LittleEndian.Uint64(b[24:32]),
+	}, nil
+}
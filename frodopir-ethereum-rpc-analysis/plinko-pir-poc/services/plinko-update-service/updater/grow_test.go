@@ -0,0 +1,334 @@
+package updater
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"plinko-update-service/plinko"
+)
+
+const testChunkSize = 4
+
+// fakeChain serves a header for every block up to head
+type fakeChain struct{ head uint64 }
+
+func (c fakeChain) BlockNumber(context.Context) (uint64, error) { return c.head, nil }
+func (fakeChain) HeaderByNumber(_ context.Context, n *big.Int) (*types.Header, error) {
+	return &types.Header{Number: n}, nil
+}
+
+// testAddress is the i-th account of a test database
+func testAddress(i int) plinko.Address {
+	return plinko.Address{0xaa, 19: byte(i)}
+}
+
+// writeHintFile writes hint.bin with entries padded to chunkSize*setSize
+func writeHintFile(dir string, entries []uint64, chunkSize, setSize, epoch uint64) error {
+	return plinko.WriteFileAtomic(filepath.Join(dir, HintFile), func(w io.Writer) error {
+		buf := make([]byte, plinko.HintHeaderSize, plinko.HintHeaderSize+chunkSize*setSize*plinko.DBEntrySize)
+		binary.LittleEndian.PutUint64(buf[0:], uint64(len(entries)))
+		binary.LittleEndian.PutUint64(buf[8:], chunkSize)
+		binary.LittleEndian.PutUint64(buf[16:], setSize)
+		binary.LittleEndian.PutUint64(buf[24:], epoch)
+		for i := uint64(0); i < chunkSize*setSize; i++ {
+			v := uint64(0)
+			if i < uint64(len(entries)) {
+				v = entries[i]
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, v)
+		}
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// newDataDir writes an epoch 1 hint.bin of n accounts in capacity slots,
+// their address-mapping.bin and an empty delta directory
+func newDataDir(t *testing.T, n, capacity uint64) string {
+	t.Helper()
+	dir := t.TempDir()
+	entries := make([]uint64, n)
+	mapping := make([]plinko.MappingEntry, n)
+	for i := range entries {
+		entries[i] = uint64(100 + i)
+		mapping[i] = plinko.MappingEntry{Address: testAddress(i), Index: uint64(i)}
+	}
+	if err := writeHintFile(dir, entries, testChunkSize, capacity/testChunkSize, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := plinko.SaveMappingEntries(filepath.Join(dir, MappingFile), mapping); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "deltas"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// answer does what plinko-hint-generator does with regenerate.json, leaving
+// headroom free slots
+func answer(dir string, headroom uint64) func(context.Context) error {
+	return func(context.Context) error {
+		data, err := os.ReadFile(filepath.Join(dir, RegenerateRequestFile))
+		if err != nil {
+			return err
+		}
+		var req RegenerateRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, DatabaseFile))
+		if err != nil {
+			return err
+		}
+		if uint64(len(raw)) != req.DBSize*plinko.DBEntrySize {
+			return errors.New("database.bin does not match the request")
+		}
+		entries := make([]uint64, req.DBSize)
+		for i := range entries {
+			entries[i] = binary.LittleEndian.Uint64(raw[i*plinko.DBEntrySize:])
+		}
+		setSize := (req.DBSize + headroom + testChunkSize - 1) / testChunkSize
+		if err := writeHintFile(dir, entries, testChunkSize, setSize, req.Epoch); err != nil {
+			return err
+		}
+		return os.Remove(filepath.Join(dir, RegenerateRequestFile))
+	}
+}
+
+// newTestService loads dir and simulates perBlock new accounts a block
+func newTestService(t *testing.T, dir string, perBlock int, grower Grower) *Service {
+	t.Helper()
+	st, err := LoadState(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(fakeChain{}, st.Database, st.Manager, Config{
+		DeltaDir:            filepath.Join(dir, "deltas"),
+		Epoch:               st.Header.Epoch,
+		NewAccountsPerBlock: perBlock,
+	})
+	svc.EnableInsertion(st.Book, filepath.Join(dir, AdditionsFile), grower)
+	return svc
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readMapping returns dir's address-mapping.bin records
+func readMapping(t *testing.T, dir string) []plinko.MappingEntry {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, MappingFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := plinko.ReadMappingEntries(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestOutOfSlotsWithoutGrower(t *testing.T) {
+	ctx := context.Background()
+	dir := newDataDir(t, 6, 8)
+	svc := newTestService(t, dir, 2, nil)
+
+	// Block 1 takes the last two slots
+	if err := svc.ProcessBlock(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if free := svc.book.Free(); free != 0 {
+		t.Fatalf("%d free slots after block 1, want 0", free)
+	}
+
+	// Block 2 does not fit and changes nothing
+	if err := svc.ProcessBlock(ctx, 2); !errors.Is(err, plinko.ErrCapacityExhausted) {
+		t.Fatalf("block 2: %v, want ErrCapacityExhausted", err)
+	}
+	if _, ok := svc.book.Lookup(SimulatedAddress(2, 0)); ok {
+		t.Error("block 2's account was allocated")
+	}
+	for _, path := range []string{DeltaPath(svc.cfg.DeltaDir, 2), AdditionsPath(svc.cfg.DeltaDir, 2)} {
+		if exists(path) {
+			t.Errorf("%s written for a block that did not fit", filepath.Base(path))
+		}
+	}
+	if svc.BlockHeight() != 1 {
+		t.Errorf("block height %d, want 1", svc.BlockHeight())
+	}
+}
+
+func TestGrowWhenSlotsRunOut(t *testing.T) {
+	ctx := context.Background()
+	dir := newDataDir(t, 6, 8)
+	svc := newTestService(t, dir, 2, &FileGrower{DataDir: dir, PollInterval: time.Millisecond, Regenerate: answer(dir, 2)})
+
+	for b := uint64(1); b <= 2; b++ {
+		if err := svc.ProcessBlock(ctx, b); err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+	}
+	if svc.Epoch() != 2 {
+		t.Fatalf("epoch %d after growing, want 2", svc.Epoch())
+	}
+	// 6 accounts, block 1's 2 additions, block 2's 2 accounts, 2 headroom
+	if n := len(svc.Database()); n != 12 {
+		t.Errorf("grown database has %d entries, want 12", n)
+	}
+	if free := svc.book.Free(); free != 2 {
+		t.Errorf("%d free slots after growing, want 2", free)
+	}
+	for b := uint64(1); b <= 2; b++ {
+		for i := 0; i < 2; i++ {
+			want := 6 + 2*(b-1) + uint64(i)
+			if idx, ok := svc.book.Lookup(SimulatedAddress(b, i)); !ok || idx != want {
+				t.Errorf("block %d account %d at %d (%v), want %d", b, i, idx, ok, want)
+			}
+			if v := svc.Database()[want]; v != b*1000+uint64(i) {
+				t.Errorf("entry %d = %d, want %d", want, v, b*1000+uint64(i))
+			}
+		}
+		if !exists(DeltaPath(svc.cfg.DeltaDir, b)) {
+			t.Errorf("no delta file for block %d", b)
+		}
+	}
+
+	// The additions are in address-mapping.bin, so a restart starts over
+	if exists(filepath.Join(dir, AdditionsFile)) || exists(filepath.Join(dir, RegenerateRequestFile)) {
+		t.Error("additions or regenerate request left after growing")
+	}
+	info, err := ReadEpochInfo(dir)
+	if err != nil || info != (EpochInfo{Epoch: 2, LastBlock: 1}) {
+		t.Errorf("epoch.json = %+v, %v; want epoch 2 from block 1", info, err)
+	}
+	if n := len(readMapping(t, dir)); n != 10 {
+		t.Errorf("address-mapping.bin has %d records, want 10", n)
+	}
+	restarted := newTestService(t, dir, 2, nil)
+	if restarted.book.Used() != 10 || restarted.Epoch() != 2 {
+		t.Errorf("restart: %d used, epoch %d; want 10, 2", restarted.book.Used(), restarted.Epoch())
+	}
+}
+
+func TestGrowWhenEpochNeverChanges(t *testing.T) {
+	// Nothing answers the request until the deadline
+	stalled := func(t *testing.T) string {
+		dir := newDataDir(t, 6, 8)
+		svc := newTestService(t, dir, 2, &FileGrower{DataDir: dir, PollInterval: time.Millisecond})
+		if err := svc.ProcessBlock(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := svc.ProcessBlock(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("block 2: %v, want the deadline", err)
+		}
+		if svc.Epoch() != 1 || svc.book.Free() != 0 || svc.BlockHeight() != 1 {
+			t.Errorf("after giving up: epoch %d, %d free slots, height %d; want 1, 0, 1",
+				svc.Epoch(), svc.book.Free(), svc.BlockHeight())
+		}
+		if exists(DeltaPath(svc.cfg.DeltaDir, 2)) {
+			t.Error("delta file written for a block that was not processed")
+		}
+		return dir
+	}
+
+	t.Run("restart before the answer", func(t *testing.T) {
+		dir := stalled(t)
+
+		// The mapping holds the unanswered grow's records; block 1's
+		// additions are replayed and block 2 grows again
+		svc := newTestService(t, dir, 2, &FileGrower{DataDir: dir, PollInterval: time.Millisecond, Regenerate: answer(dir, 4)})
+		if svc.Epoch() != 1 || svc.book.Used() != 8 {
+			t.Fatalf("restart: epoch %d, %d used; want 1, 8", svc.Epoch(), svc.book.Used())
+		}
+		if err := svc.ProcessBlock(context.Background(), 2); err != nil {
+			t.Fatal(err)
+		}
+		if svc.Epoch() != 2 || !exists(DeltaPath(svc.cfg.DeltaDir, 2)) {
+			t.Errorf("retried block 2: epoch %d, delta file %v", svc.Epoch(), exists(DeltaPath(svc.cfg.DeltaDir, 2)))
+		}
+		if n := len(readMapping(t, dir)); n != 10 {
+			t.Errorf("address-mapping.bin has %d records after growing twice, want 10", n)
+		}
+	})
+
+	t.Run("answer while stopped", func(t *testing.T) {
+		dir := stalled(t)
+		if err := answer(dir, 4)(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// address-additions.bin is stale: its accounts are in the mapping
+		svc := newTestService(t, dir, 2, nil)
+		if svc.Epoch() != 2 || svc.book.Used() != 10 {
+			t.Fatalf("restart: epoch %d, %d used; want 2, 10", svc.Epoch(), svc.book.Used())
+		}
+		if err := svc.ProcessBlock(context.Background(), 2); err != nil {
+			t.Fatal(err)
+		}
+		if idx, ok := svc.book.Lookup(SimulatedAddress(2, 1)); !ok || idx != 9 {
+			t.Errorf("block 2 account 1 at %d (%v), want 9", idx, ok)
+		}
+	})
+}
+
+func TestGrowReplacesPartialRegenerateRequest(t *testing.T) {
+	dir := newDataDir(t, 6, 8)
+	// Left by a crash mid-write
+	if err := os.WriteFile(filepath.Join(dir, RegenerateRequestFile), []byte(`{"epoch": 2, "db_si`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tempFiles := func() []string {
+		names, _ := filepath.Glob(filepath.Join(dir, ".tmp-*"))
+		return names
+	}
+
+	var seen RegenerateRequest
+	regenerate := answer(dir, 4)
+	svc := newTestService(t, dir, 3, &FileGrower{
+		DataDir:      dir,
+		PollInterval: time.Millisecond,
+		Regenerate: func(ctx context.Context) error {
+			// The hint generator only ever sees a complete request
+			data, err := os.ReadFile(filepath.Join(dir, RegenerateRequestFile))
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &seen); err != nil {
+				return err
+			}
+			if names := tempFiles(); len(names) > 0 {
+				t.Errorf("temporary files beside the request: %v", names)
+			}
+			return regenerate(ctx)
+		},
+	})
+	if err := svc.ProcessBlock(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := (RegenerateRequest{Epoch: 2, DBSize: 9, LastBlock: 0}); seen != want {
+		t.Errorf("request %+v, want %+v", seen, want)
+	}
+	if names := tempFiles(); len(names) > 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, EpochFile)); err != nil || !strings.Contains(string(data), `"epoch": 2`) {
+		t.Errorf("epoch.json = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,311 @@
+// Package updater follows the chain and turns each new block into a delta
+// file for plinko-update-service, allocating padding slots to new accounts
+// and growing the database when they run out.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"plinko-update-service/plinko"
+)
+
+// ChainReader is the subset of ethclient.Client the update service needs.
+// Tests substitute an in-memory chain.
+type ChainReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Observer is notified after every block that produced a delta file
+type Observer interface {
+	ObserveBlock(blockNumber uint64, updates, deltas int, updateDuration, blockDuration time.Duration)
+	ObserveAccounts(added int, freeSlots uint64)                 // Only with insertion enabled
+	ObserveTokens(updates, added, skipped int, freeSlots uint64) // Only with tokens enabled
+}
+
+// Config controls block polling and change simulation
+type Config struct {
+	DeltaDir     string
+	PollInterval time.Duration
+	Epoch        uint64 // Epoch of the hint.bin the database was loaded from
+	StartBlock   uint64 // Last block already in the database; Run starts after it
+
+	// Simulation (for PoC - in production, detect actual changes)
+	SimulateChanges     bool
+	ChangesPerBlock     int
+	DBSize              uint64 // Entries that receive simulated changes (0 = whole database)
+	NewAccountsPerBlock int    // Never-seen addresses per block (needs EnableInsertion)
+}
+
+type Service struct {
+	client          ChainReader
+	database        []uint64 // In-memory database, shared with updateManager
+	updateManager   *plinko.PlinkoUpdateManager
+	book            *plinko.AddressBook // nil: no account insertion
+	grower          Grower
+	additionsPath   string // Every allocation since the epoch began (address-additions.bin)
+	logs            LogFilterer
+	tokens          *TokenTable // nil: no token table
+	observer        Observer
+	cfg             Config
+	blockHeight     atomic.Uint64
+	deltasGenerated atomic.Uint64
+}
+
+// AccountChange is a balance change of an address-keyed account, which may
+// not be in the database yet
+type AccountChange struct {
+	Address plinko.Address
+	Balance uint64
+}
+
+// New creates a service that writes deltas for database via pm
+func New(client ChainReader, database []uint64, pm *plinko.PlinkoUpdateManager, cfg Config) *Service {
+	if cfg.DBSize == 0 || cfg.DBSize > uint64(len(database)/plinko.DBEntryLength) {
+		cfg.DBSize = uint64(len(database) / plinko.DBEntryLength)
+	}
+	return &Service{
+		client:        client,
+		database:      database,
+		updateManager: pm,
+		cfg:           cfg,
+	}
+}
+
+// SetObserver registers o to be told about every processed block
+func (s *Service) SetObserver(o Observer) { s.observer = o }
+
+// EnableInsertion lets new accounts take over padding slots: book maps
+// addresses to indices, every allocation is appended to additionsPath and
+// published per block next to the delta file, and grower takes over when
+// the slots run out.
+func (s *Service) EnableInsertion(book *plinko.AddressBook, additionsPath string, grower Grower) {
+	s.book = book
+	s.additionsPath = additionsPath
+	s.grower = grower
+}
+
+// Database returns the current in-memory database; it is replaced when the
+// database grows
+func (s *Service) Database() []uint64 { return s.database }
+
+// Epoch returns the epoch of the database being updated
+func (s *Service) Epoch() uint64 { return s.cfg.Epoch }
+
+// BlockHeight returns the last block that produced a delta file
+func (s *Service) BlockHeight() uint64 { return s.blockHeight.Load() }
+
+// DeltasGenerated returns the number of delta files written
+func (s *Service) DeltasGenerated() uint64 { return s.deltasGenerated.Load() }
+
+// Run polls for new blocks until ctx is cancelled. Cancellation only
+// interrupts RPC calls, which happen before a block changes any state, so the
+// delta directory always ends on a complete block. A block that fails is
+// retried on the next poll; later blocks wait for it.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	// The token table may start earlier; ProcessBlock skips the account
+	// database until its own start
+	lastBlockNumber := s.cfg.StartBlock
+	if s.tokens != nil {
+		lastBlockNumber = min(lastBlockNumber, s.tokens.LastBlock)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Shutdown requested, stopping block monitoring")
+			return
+		case <-ticker.C:
+		}
+
+		// Get latest block number
+		blockNumber, err := s.client.BlockNumber(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Printf("Error getting block number: %v\n", err)
+			continue
+		}
+
+		// Process new blocks
+		for bn := lastBlockNumber + 1; bn <= blockNumber; bn++ {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := s.ProcessBlock(ctx, bn); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error processing block %d, will retry: %v\n", bn, err)
+				}
+				break // Retried on the next poll, or the next start
+			}
+			lastBlockNumber = bn
+		}
+	}
+}
+
+// ProcessBlock writes the delta file for blockNumber, if it changed anything,
+// and the token table's delta file if tokens are enabled. After an error it
+// can be called again for the same block; a table that already has the
+// block skips it.
+func (s *Service) ProcessBlock(ctx context.Context, blockNumber uint64) error {
+	startTime := time.Now()
+
+	// Get block header
+	header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+	if err != nil {
+		return fmt.Errorf("failed to get block header: %w", err)
+	}
+	// Token transfers are read up front as well, so no RPC call comes after
+	// the first delta file is written
+	transfers, err := s.fetchTransfers(ctx, blockNumber)
+	if err != nil {
+		return err
+	}
+
+	if blockNumber > s.cfg.StartBlock {
+		if err := s.processAccounts(ctx, blockNumber, header, startTime); err != nil {
+			return err
+		}
+		s.cfg.StartBlock = blockNumber
+	}
+	return s.processTokens(blockNumber, transfers)
+}
+
+// processAccounts writes the account database's delta file for blockNumber.
+// The database and address book only change once the block's files are
+// written; until then a failed block leaves them as they were.
+func (s *Service) processAccounts(ctx context.Context, blockNumber uint64, header *types.Header, startTime time.Time) error {
+	// Simulate account changes (in production, detect actual changes)
+	updates := s.detectChanges(blockNumber, header)
+	accounts := s.detectAccounts(blockNumber)
+
+	if len(updates) == 0 && len(accounts) == 0 {
+		// No changes detected
+		return nil
+	}
+
+	// Last point at which the block can be abandoned without losing a delta
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// New accounts take padding slots; if they do not all fit, grow the
+	// database first and process the block against the new one
+	if pending := s.newAddresses(accounts); s.book != nil && uint64(len(pending)) > s.book.Free() {
+		if err := s.grow(ctx, blockNumber, pending); err != nil {
+			return err
+		}
+		return s.processAccounts(ctx, blockNumber, header, startTime)
+	}
+	mark := 0
+	if s.book != nil {
+		mark = len(s.book.Additions())
+	}
+	rollback := func() {
+		if s.book != nil {
+			s.book.Truncate(mark)
+		}
+	}
+	accountUpdates, added, err := s.resolveAccounts(accounts)
+	if err != nil {
+		rollback()
+		return err
+	}
+	updates = append(updates, accountUpdates...)
+
+	// Generate hint deltas using Plinko
+	deltas, updateDuration, err := s.updateManager.Deltas(updates)
+	if err != nil {
+		rollback()
+		return err
+	}
+
+	// Publish the new accounts before the deltas that fill their slots
+	undo := func() error { return nil }
+	if len(added) > 0 {
+		if undo, err = s.saveAdditions(blockNumber, added); err != nil {
+			rollback()
+			return fmt.Errorf("failed to save address additions: %w", err)
+		}
+	}
+
+	// Save delta file
+	if err := plinko.SaveDelta(DeltaPath(s.cfg.DeltaDir, blockNumber), deltas); err != nil {
+		rollback()
+		return fmt.Errorf("failed to save delta: %w", errors.Join(err, undo()))
+	}
+	s.updateManager.Apply(updates)
+
+	s.deltasGenerated.Add(1)
+	s.blockHeight.Store(blockNumber)
+
+	// Log progress
+	blockDuration := time.Since(startTime)
+	if s.observer != nil {
+		s.observer.ObserveBlock(blockNumber, len(updates), len(deltas), updateDuration, blockDuration)
+		if s.book != nil {
+			s.observer.ObserveAccounts(len(added), s.book.Free())
+		}
+	}
+	log.Printf("Block %d: %d changes (%d new accounts), %d deltas, update: %v, total: %v\n",
+		blockNumber, len(updates), len(added), len(deltas),
+		updateDuration, blockDuration)
+
+	return nil
+}
+
+// DeltaPath is where the delta file for blockNumber is written
+func DeltaPath(dir string, blockNumber uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("delta-%06d.bin", blockNumber))
+}
+
+func (s *Service) detectChanges(blockNumber uint64, header *types.Header) []plinko.DBUpdate {
+	// PoC: Simulate account changes
+	// In production: parse block transactions and detect balance/state changes
+
+	if !s.cfg.SimulateChanges {
+		return nil
+	}
+
+	changes := s.cfg.ChangesPerBlock
+	updates := make([]plinko.DBUpdate, changes)
+
+	// Simulate deterministic changes based on block number
+	for i := 0; i < changes; i++ {
+		index := (blockNumber*uint64(changes) + uint64(i)) % s.cfg.DBSize
+
+		// Read old value
+		oldValue := s.readDBEntry(index)
+
+		// Generate new value (simulated change)
+		newValue := plinko.DBEntry{uint64(blockNumber)*1000 + uint64(i)}
+
+		updates[i] = plinko.DBUpdate{
+			Index:    index,
+			OldValue: oldValue,
+			NewValue: newValue,
+		}
+	}
+
+	return updates
+}
+
+func (s *Service) readDBEntry(index uint64) plinko.DBEntry {
+	if index >= uint64(len(s.database)/plinko.DBEntryLength) {
+		return plinko.DBEntry{}
+	}
+	return plinko.DBEntry{s.database[index]}
+}
@@ -0,0 +1,230 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"plinko-update-service/plinko"
+)
+
+// blockDelta makes saving blockNumber's delta file fail until the returned
+// func is called
+func blockDelta(t *testing.T, svc *Service, blockNumber uint64) (unblock func()) {
+	t.Helper()
+	path := DeltaPath(svc.cfg.DeltaDir, blockNumber)
+	if err := os.Mkdir(path, 0755); err != nil { // Nothing can be renamed over it
+		t.Fatal(err)
+	}
+	return func() {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestFailedWriteLeavesBlockForRetry(t *testing.T) {
+	ctx := context.Background()
+	dir := newDataDir(t, 6, 16)
+	svc := newTestService(t, dir, 2, nil)
+	svc.cfg.SimulateChanges, svc.cfg.ChangesPerBlock, svc.cfg.DBSize = true, 2, 6
+	if err := svc.ProcessBlock(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	unblock := blockDelta(t, svc, 2)
+	database := slices.Clone(svc.Database())
+	additions := fileSize(t, svc.additionsPath)
+	if err := svc.ProcessBlock(ctx, 2); err == nil {
+		t.Fatal("block 2 processed without its delta file")
+	}
+	if !slices.Equal(svc.Database(), database) {
+		t.Error("database changed by a block whose delta was not saved")
+	}
+	if used := svc.book.Used(); used != 8 {
+		t.Errorf("%d slots used after the failed block, want 8", used)
+	}
+	if size := fileSize(t, svc.additionsPath); size != additions {
+		t.Errorf("%s grew from %d to %d bytes for the failed block", AdditionsFile, additions, size)
+	}
+	if names, _ := filepath.Glob(filepath.Join(svc.cfg.DeltaDir, ".tmp-*")); len(names) > 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+
+	// The retry allocates the same slots, once
+	unblock()
+	if err := svc.ProcessBlock(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if idx, ok := svc.book.Lookup(SimulatedAddress(2, 1)); !ok || idx != 9 {
+		t.Errorf("block 2 account 1 at %d (%v), want 9", idx, ok)
+	}
+	if v := svc.Database()[9]; v != 2001 {
+		t.Errorf("entry 9 = %d, want 2001", v)
+	}
+	if size := fileSize(t, svc.additionsPath); size != additions+2*24 {
+		t.Errorf("%s is %d bytes after the retry, want %d", AdditionsFile, size, additions+2*24)
+	}
+
+	// A block already written is not written again
+	delta, _ := os.ReadFile(DeltaPath(svc.cfg.DeltaDir, 2))
+	if err := svc.ProcessBlock(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(DeltaPath(svc.cfg.DeltaDir, 2)); !bytes.Equal(again, delta) {
+		t.Error("processing block 2 twice rewrote its delta file")
+	}
+}
+
+func TestRunRetriesFailedBlock(t *testing.T) {
+	dir := newDataDir(t, 6, 16)
+	svc := newTestService(t, dir, 2, nil)
+	svc.client = fakeChain{head: 3}
+	svc.cfg.PollInterval = time.Millisecond
+	unblock := blockDelta(t, svc, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Block 3 waits behind block 2, however many polls pass
+	time.Sleep(50 * time.Millisecond)
+	if h := svc.BlockHeight(); h != 1 {
+		t.Fatalf("block height %d while block 2 fails, want 1", h)
+	}
+	unblock()
+	for deadline := time.Now().Add(5 * time.Second); svc.BlockHeight() != 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("block height %d after block 2 was unblocked, want 3", svc.BlockHeight())
+		}
+	}
+	for b := uint64(1); b <= 3; b++ {
+		if !exists(DeltaPath(svc.cfg.DeltaDir, b)) {
+			t.Errorf("no delta file for block %d", b)
+		}
+	}
+}
+
+// stallingChain answers like fakeChain, but the header of block stall is
+// only ever refused, once ctx is cancelled
+type stallingChain struct {
+	fakeChain
+	stall   uint64
+	reached chan struct{}
+}
+
+func (c stallingChain) HeaderByNumber(ctx context.Context, n *big.Int) (*types.Header, error) {
+	if n.Uint64() == c.stall {
+		close(c.reached)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.fakeChain.HeaderByNumber(ctx, n)
+}
+
+// cancelAt cancels the context once block has produced its delta
+type cancelAt struct {
+	block  uint64
+	cancel context.CancelFunc
+}
+
+func (o cancelAt) ObserveBlock(blockNumber uint64, _, _ int, _, _ time.Duration) {
+	if blockNumber == o.block {
+		o.cancel()
+	}
+}
+func (cancelAt) ObserveAccounts(int, uint64)         {}
+func (cancelAt) ObserveTokens(int, int, int, uint64) {}
+
+// checkDeltaDir fails unless dir holds exactly the complete delta files
+// of blocks 1 to last, and no temporary files
+func checkDeltaDir(t *testing.T, dir string, last uint64) {
+	t.Helper()
+	if names, _ := filepath.Glob(filepath.Join(dir, ".tmp-*")); len(names) > 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "delta-*.bin"))
+	if uint64(len(names)) != last {
+		t.Errorf("%d delta files, want blocks 1 to %d: %v", len(names), last, names)
+	}
+	for b := uint64(1); b <= last; b++ {
+		data, err := os.ReadFile(DeltaPath(dir, b))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(data) < 16 || uint64(len(data)) != 16+binary.LittleEndian.Uint64(data)*plinko.DeltaEntrySize {
+			t.Errorf("delta file of block %d is partial (%d bytes)", b, len(data))
+		}
+	}
+}
+
+func TestShutdownMidCycleLeavesCompleteDeltas(t *testing.T) {
+	run := func(t *testing.T, svc *Service, ctx context.Context) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			svc.Run(ctx)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after cancellation")
+		}
+	}
+
+	// Cancelled between two blocks of one poll: the rest wait for the
+	// next start
+	t.Run("between blocks", func(t *testing.T) {
+		svc := newTestService(t, newDataDir(t, 6, 64), 2, nil)
+		svc.client = fakeChain{head: 20}
+		svc.cfg.PollInterval = time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		svc.SetObserver(cancelAt{block: 3, cancel: cancel})
+		run(t, svc, ctx)
+		checkDeltaDir(t, svc.cfg.DeltaDir, 3)
+	})
+
+	// Cancelled while a block's header is being fetched
+	t.Run("during a block", func(t *testing.T) {
+		svc := newTestService(t, newDataDir(t, 6, 64), 2, nil)
+		chain := stallingChain{fakeChain: fakeChain{head: 20}, stall: 4, reached: make(chan struct{})}
+		svc.client = chain
+		svc.cfg.PollInterval = time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-chain.reached
+			cancel()
+		}()
+		run(t, svc, ctx)
+		checkDeltaDir(t, svc.cfg.DeltaDir, 3)
+		if svc.book.Used() != 12 {
+			t.Errorf("%d slots used, want block 3's 12", svc.book.Used())
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package updater
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"plinko-update-service/plinko"
+)
+
+// Token balances
+//
+// db-generator (TOKENS) builds a second table of (holder, token) balances in
+// tables/token-balances/ from the tokens' Transfer events, up to the block
+// in the table's epoch.json. The service keeps it current from the next
+// block on: per block it reads the Transfer logs, nets the amounts per pair
+// and writes the table's own delta-N.bin, one per block even when nothing
+// moved, so clients syncing the table never wait on a missing file.
+//
+// Balances are uint64, truncated like the account database; adding and
+// subtracting modulo 2^64 keeps them equal to the truncated true balance.
+// Pairs seen for the first time take padding slots (additions-N.bin, 44-byte
+// token-mapping.bin records, published before the delta). Once the padding
+// is gone new pairs are skipped and counted; the next db-generator run
+// includes them.
+
+const (
+	TokenTableName     = "token-balances"
+	TokenMappingFile   = "token-mapping.bin"
+	TokenInfoFile      = "tokens.json"
+	TokenAdditionsFile = "token-additions.bin"
+)
+
+// TransferEventTopic is topic 0 of the ERC-20 Transfer(address,address,uint256) event
+var TransferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// LogFilterer is the ethclient.Client call used to read Transfer events
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// TokenTable is a loaded token-balances table
+type TokenTable struct {
+	Dir       string
+	Tokens    []common.Address
+	Header    plinko.HintHeader
+	Database  []uint64
+	Manager   *plinko.PlinkoUpdateManager
+	Book      *plinko.TokenBook
+	LastBlock uint64 // Last block in the table's hint.bin; updates start after it
+}
+
+// tokenInfo is the part of tokens.json the service reads
+type tokenInfo struct {
+	Tokens []struct {
+		Address common.Address `json:"address"`
+	} `json:"tokens"`
+}
+
+// LoadTokenTable loads the table in dir: hint.bin, token-mapping.bin,
+// tokens.json and epoch.json, replaying the pairs saved in
+// token-additions.bin
+func LoadTokenTable(dir string, cacheMode bool) (*TokenTable, error) {
+	database, hdr, err := plinko.LoadDatabase(filepath.Join(dir, HintFile))
+	if err != nil {
+		return nil, err
+	}
+	t := &TokenTable{
+		Dir:      dir,
+		Header:   hdr,
+		Database: database,
+		Manager:  plinko.NewPlinkoUpdateManager(database, hdr.ChunkSize, hdr.SetSize),
+	}
+	if cacheMode {
+		t.Manager.EnableCacheMode()
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, TokenInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var info tokenInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%s: %w", TokenInfoFile, err)
+	}
+	for _, tok := range info.Tokens {
+		t.Tokens = append(t.Tokens, tok.Address)
+	}
+	epoch, err := ReadEpochInfo(dir)
+	if err != nil {
+		return nil, err
+	}
+	t.LastBlock = epoch.LastBlock
+
+	t.Book, err = plinko.LoadTokenBook(filepath.Join(dir, TokenMappingFile), hdr.DBSize, t.Manager.DBSize())
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, TokenAdditionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	additions, err := plinko.ReadTokenMappingEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", TokenAdditionsFile, err)
+	}
+	if err := t.Book.Restore(additions); err != nil {
+		return nil, fmt.Errorf("%s: %w", TokenAdditionsFile, err)
+	}
+	return t, nil
+}
+
+// DeltaDir is where the table's delta and additions files are written
+func (t *TokenTable) DeltaDir() string { return filepath.Join(t.Dir, "deltas") }
+
+// EnableTokens keeps t current from the Transfer events client returns
+func (s *Service) EnableTokens(client LogFilterer, t *TokenTable) {
+	s.logs = client
+	s.tokens = t
+}
+
+// fetchTransfers reads the block's Transfer events of the table's tokens,
+// or nothing if the block is already in the table
+func (s *Service) fetchTransfers(ctx context.Context, blockNumber uint64) ([]types.Log, error) {
+	if s.tokens == nil || blockNumber <= s.tokens.LastBlock || len(s.tokens.Tokens) == 0 {
+		return nil, nil
+	}
+	n := new(big.Int).SetUint64(blockNumber)
+	logs, err := s.logs.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: n,
+		ToBlock:   n,
+		Addresses: s.tokens.Tokens,
+		Topics:    [][]common.Hash{{TransferEventTopic}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token transfers: %w", err)
+	}
+	return logs, nil
+}
+
+// processTokens writes the table's delta file for blockNumber. The table
+// only changes once the files are written; until then a failed block leaves
+// it as it was.
+func (s *Service) processTokens(blockNumber uint64, logs []types.Log) error {
+	t := s.tokens
+	if t == nil || blockNumber <= t.LastBlock {
+		return nil
+	}
+	mark := len(t.Book.Additions())
+
+	// Net change per pair, modulo 2^64, in the order pairs first appear
+	var order []plinko.TokenPair
+	net := make(map[plinko.TokenPair]uint64)
+	move := func(holder common.Address, token common.Address, amount uint64) {
+		if holder == (common.Address{}) {
+			return // Mint or burn
+		}
+		p := plinko.TokenPair{Holder: plinko.Address(holder), Token: plinko.Address(token)}
+		if _, ok := net[p]; !ok {
+			order = append(order, p)
+		}
+		net[p] += amount
+	}
+	for _, l := range logs {
+		if len(l.Topics) != 3 || l.Topics[0] != TransferEventTopic || len(l.Data) != 32 {
+			continue // Not an ERC-20 Transfer (ERC-721 indexes a fourth topic)
+		}
+		amount := binary.BigEndian.Uint64(l.Data[24:]) // Value mod 2^64
+		move(common.BytesToAddress(l.Topics[1].Bytes()), l.Address, -amount)
+		move(common.BytesToAddress(l.Topics[2].Bytes()), l.Address, amount)
+	}
+
+	var updates []plinko.DBUpdate
+	var added []plinko.TokenMappingEntry
+	skipped := 0
+	for _, p := range order {
+		if net[p] == 0 {
+			continue
+		}
+		index, isNew, err := t.Book.Allocate(p)
+		if errors.Is(err, plinko.ErrCapacityExhausted) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			t.Book.Truncate(mark)
+			return err
+		}
+		if isNew {
+			added = append(added, plinko.TokenMappingEntry{TokenPair: p, Index: index})
+		}
+		old := t.Database[index*plinko.DBEntryLength]
+		updates = append(updates, plinko.DBUpdate{
+			Index:    index,
+			OldValue: plinko.DBEntry{old},
+			NewValue: plinko.DBEntry{old + net[p]},
+		})
+	}
+
+	deltas, _, err := t.Manager.Deltas(updates)
+	if err != nil {
+		t.Book.Truncate(mark)
+		return err
+	}
+	undo := func() error { return nil }
+	if len(added) > 0 {
+		if undo, err = s.saveTokenAdditions(blockNumber, added); err != nil {
+			t.Book.Truncate(mark)
+			return fmt.Errorf("failed to save token pair additions: %w", err)
+		}
+	}
+	if err := plinko.SaveDelta(DeltaPath(t.DeltaDir(), blockNumber), deltas); err != nil {
+		t.Book.Truncate(mark)
+		return fmt.Errorf("failed to save token delta: %w", errors.Join(err, undo()))
+	}
+	t.Manager.Apply(updates)
+	t.LastBlock = blockNumber
+
+	if s.observer != nil {
+		s.observer.ObserveTokens(len(updates), len(added), skipped, t.Book.Free())
+	}
+	if len(logs) > 0 {
+		log.Printf("Block %d: %d token transfers, %d balances changed (%d new pairs), %d deltas\n",
+			blockNumber, len(logs), len(updates), len(added), len(deltas))
+	}
+	if skipped > 0 {
+		log.Printf("⚠️  Block %d: %d new token pairs skipped, no free slots (rerun db-generator)\n", blockNumber, skipped)
+	}
+	return nil
+}
+
+// saveTokenAdditions publishes a block's new pairs and appends them to
+// token-additions.bin, which a restarted service replays; undo is as for
+// saveAdditions
+func (s *Service) saveTokenAdditions(blockNumber uint64, added []plinko.TokenMappingEntry) (undo func() error, err error) {
+	if err := plinko.SaveTokenMappingEntries(AdditionsPath(s.tokens.DeltaDir(), blockNumber), added); err != nil {
+		return nil, err
+	}
+	return appendRecords(filepath.Join(s.tokens.Dir, TokenAdditionsFile), func(w io.Writer) error {
+		return plinko.WriteTokenMappingEntries(w, added)
+	})
+}
@@ -70,15 +70,18 @@ export class PlinkoClient {
    *
    * Format:
    * [0:8]   Delta count (uint64)
-   * [8:16]  Reserved (uint64)
-   * Then for each delta (24 bytes):
+   * [8:16]  Format version (uint64; 0 = legacy 24-byte entries)
+   * Then for each delta (32 bytes in v1, 24 bytes in v0):
    *   [0:8]   HintSetID (uint64)
    *   [8:16]  IsBackupSet (uint64)
    *   [16:24] Delta value (uint64)
+   *   [24:32] Database index (uint64, v1 only)
    */
   parseDelta(deltaData) {
     const view = new DataView(deltaData.buffer);
     const count = Number(view.getBigUint64(0, true));
+    const version = view.getBigUint64(8, true);
+    const stride = version === 0n ? 24 : 32;
 
     const deltas = [];
     let offset = 16; // Skip header
@@ -87,9 +90,10 @@ export class PlinkoClient {
       deltas.push({
         hintSetID: Number(view.getBigUint64(offset, true)),
         isBackupSet: view.getBigUint64(offset + 8, true) !== 0n,
-        delta: view.getBigUint64(offset + 16, true)
+        delta: view.getBigUint64(offset + 16, true),
+        index: version === 0n ? null : Number(view.getBigUint64(offset + 24, true))
       });
-      offset += 24;
+      offset += stride;
     }
 
     return deltas;